@@ -1,13 +1,28 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
 	"errors"
+	"fmt"
 	"github.com/go-chi/chi/v5"
 	"github.com/golang-jwt/jwt/v4"
 	"golang.org/x/crypto/bcrypt"
+	"io"
+	"log"
+	"mime"
 	"net/http"
+	"net/url"
+	"reflect"
 	"strconv"
+	"strings"
+	"testingCourserWeb/pkg/authz"
 	"testingCourserWeb/pkg/data"
+	"testingCourserWeb/pkg/jsonpatch"
+	"testingCourserWeb/pkg/ratelimit"
+	"testingCourserWeb/pkg/repository"
+	"testingCourserWeb/pkg/signing"
+	"testingCourserWeb/pkg/validator"
 	"time"
 )
 
@@ -18,30 +33,76 @@ type Credentials struct {
 
 func (app *application) authenticate(w http.ResponseWriter, r *http.Request) {
 	var creds Credentials
+	// the client only ever hears "invalid credentials", but the audit log says why
+	failed := func(reason string, userID *int) {
+		app.audit(r, data.AuditEvent{Event: data.AuditLoginFailed, UserID: userID, Email: creds.Username, Reason: reason})
+	}
 	// read a json payload
 	err := app.readJSON(w, r, &creds)
 	if err != nil {
-		app.errorJSON(w, errors.New("unauthorized"), http.StatusUnauthorized)
+		failed("invalid request", nil)
+		app.errorJSON(w, r, newPublicError("invalid credentials"), http.StatusUnauthorized)
+		return
+	}
+	// turn away anybody trying too often before spending any time on them
+	if !app.allowLogin(w, r, creds.Username) {
 		return
 	}
 	// look up the user by email address
-	user, err := app.DB.GetUserByEmail(creds.Username)
+	user, err := app.DB.GetUserByEmail(r.Context(), creds.Username)
+	if errors.Is(err, repository.ErrNotFound) {
+		failed("unknown email", nil)
+		app.loginFailed(r, creds.Username)
+		app.errorJSON(w, r, newPublicError("invalid credentials"), http.StatusUnauthorized)
+		return
+	}
 	if err != nil {
-		app.errorJSON(w, errors.New("unauthorized"), http.StatusUnauthorized)
+		app.errorJSON(w, r, err, http.StatusInternalServerError)
 		return
 	}
 	// check password
 	err = bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(creds.Password))
 	if err != nil {
-		app.errorJSON(w, errors.New("unauthorized"), http.StatusUnauthorized)
+		failed("wrong password", &user.ID)
+		app.loginFailed(r, creds.Username)
+		app.errorJSON(w, r, newPublicError("invalid credentials"), http.StatusUnauthorized)
+		return
+	}
+	// only say an account is deactivated to somebody who knows its password
+	if user.DisabledAt != nil {
+		failed("account deactivated", &user.ID)
+		app.errorJSON(w, r, errAccountDeactivated, http.StatusForbidden)
+		return
+	}
+	// with two-factor authentication on, the password only gets as far as the second step
+	mfaEnabled, err := app.MFA.Enabled(r.Context(), user.ID)
+	if err != nil {
+		app.errorJSON(w, r, err, http.StatusInternalServerError)
+		return
+	}
+	if mfaEnabled {
+		app.challengeMFA(w, r, user)
 		return
 	}
-	// generate tokens
-	tokenPairs, err := app.generateTokenPair(user)
+	app.logIn(w, r, user, creds.Username)
+}
+
+// logIn issues tokens to a user who has passed every step of logging in to account (the email
+// they logged in with), and sends them back, with the refresh token in a cookie as well.
+func (app *application) logIn(w http.ResponseWriter, r *http.Request, user *data.User, account string) {
+	tokenPairs, err := app.generateTokenPair(r.Context(), user)
 	if err != nil {
-		app.errorJSON(w, errors.New("unauthorized"), http.StatusUnauthorized)
+		app.audit(r, data.AuditEvent{Event: data.AuditLoginFailed, UserID: &user.ID, Email: account, Reason: "could not issue tokens"})
+		app.errorJSON(w, r, newPublicError("invalid credentials"), http.StatusUnauthorized)
 		return
 	}
+	app.audit(r, data.AuditEvent{Event: data.AuditLoginSucceeded, UserID: &user.ID, Email: user.Email})
+	// failures are only forgotten once the whole login has succeeded, or somebody who knew the
+	// password could keep guessing codes
+	err = app.Logins.Succeeded(r.Context(), account)
+	if err != nil {
+		log.Printf("request %s: clearing failed logins: %s", requestIDFromContext(r.Context()), err)
+	}
 
 	http.SetCookie(w, &http.Cookie{
 		Name:     "__Host-refresh_token",
@@ -59,43 +120,59 @@ func (app *application) authenticate(w http.ResponseWriter, r *http.Request) {
 	_ = app.writeJSON(w, http.StatusOK, tokenPairs)
 }
 
+// allowLogin checks the login rate limits for a login to email, and turns the client away with a
+// 429 if it has been trying too often. If the limits can't be checked, we let the login through:
+// a broken rate limiter shouldn't stop everybody logging in.
+func (app *application) allowLogin(w http.ResponseWriter, r *http.Request, email string) bool {
+	err := app.Logins.Allow(r.Context(), app.requestIP(r), email)
+	var denied *ratelimit.Denied
+	if errors.As(err, &denied) {
+		app.audit(r, data.AuditEvent{Event: data.AuditLoginFailed, Email: email, Reason: denied.Reason})
+		w.Header().Set("Retry-After", strconv.Itoa(denied.RetryAfterSeconds()))
+		app.errorJSON(w, r, newPublicError("too many login attempts; try again later"), http.StatusTooManyRequests)
+		return false
+	}
+	if err != nil {
+		log.Printf("request %s: checking login rate limits: %s", requestIDFromContext(r.Context()), err)
+	}
+	return true
+}
+
+// loginFailed counts a failed login to email towards locking the account out.
+func (app *application) loginFailed(r *http.Request, email string) {
+	err := app.Logins.Failed(r.Context(), email)
+	if err != nil {
+		log.Printf("request %s: counting failed login: %s", requestIDFromContext(r.Context()), err)
+	}
+}
+
 func (app *application) refresh(w http.ResponseWriter, r *http.Request) {
 	err := r.ParseForm()
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
+		app.errorJSON(w, r, newPublicError("invalid form data"), http.StatusBadRequest)
 		return
 	}
 	refreshToken := r.Form.Get("refresh_token")
 	claims := &Claims{}
 
-	_, err = jwt.ParseWithClaims(refreshToken, claims, func(token *jwt.Token) (interface{}, error) {
-		return []byte(app.JWTSecret), nil
-	})
+	_, err = jwt.ParseWithClaims(refreshToken, claims, app.keyFunc)
 	if err != nil {
-		app.errorJSON(w, err, http.StatusBadRequest)
+		app.audit(r, data.AuditEvent{Event: data.AuditRefreshFailed, Reason: "invalid refresh token: " + err.Error()})
+		app.errorJSON(w, r, newPublicError("invalid refresh token"), http.StatusBadRequest)
 		return
 	}
 	if time.Unix(claims.ExpiresAt.Unix(), 0).Sub(time.Now()) > 30*time.Second {
-		app.errorJSON(w, errors.New("refresh token does not need renewed yet"), http.StatusTooEarly)
+		app.errorJSON(w, r, newPublicError("refresh token does not need renewed yet"), http.StatusTooEarly)
 		return
 	}
 
-	// get the user id from the claims
-	userID, err := strconv.Atoi(claims.Subject)
+	// rotate the refresh token
+	tokenPairs, err := app.rotateRefreshToken(r.Context(), claims)
 	if err != nil {
-		app.errorJSON(w, err, http.StatusBadRequest)
-		return
-	}
-	user, err := app.DB.GetUser(userID)
-	if err != nil {
-		app.errorJSON(w, errors.New("unknown user"), http.StatusBadRequest)
-		return
-	}
-	tokenPairs, err := app.generateTokenPair(user)
-	if err != nil {
-		app.errorJSON(w, err, http.StatusBadRequest)
+		app.refreshFailed(w, r, claims, err)
 		return
 	}
+	app.audit(r, data.AuditEvent{Event: data.AuditRefresh, UserID: subjectID(claims)})
 	http.SetCookie(w, &http.Cookie{
 		Name:     "__Host-refresh_token",
 		Path:     "/",
@@ -116,30 +193,20 @@ func (app *application) refreshUsingCookie(w http.ResponseWriter, r *http.Reques
 			claims := &Claims{}
 			refreshToken := cookie.Value
 
-			_, err := jwt.ParseWithClaims(refreshToken, claims, func(token *jwt.Token) (interface{}, error) {
-				return []byte(app.JWTSecret), nil
-			})
+			_, err := jwt.ParseWithClaims(refreshToken, claims, app.keyFunc)
 			if err != nil {
-				app.errorJSON(w, err, http.StatusBadRequest)
+				app.audit(r, data.AuditEvent{Event: data.AuditRefreshFailed, Reason: "invalid refresh token: " + err.Error()})
+				app.errorJSON(w, r, newPublicError("invalid refresh token"), http.StatusBadRequest)
 				return
 			}
 
-			// get the user id from the claims
-			userID, err := strconv.Atoi(claims.Subject)
+			// rotate the refresh token
+			tokenPairs, err := app.rotateRefreshToken(r.Context(), claims)
 			if err != nil {
-				app.errorJSON(w, err, http.StatusBadRequest)
-				return
-			}
-			user, err := app.DB.GetUser(userID)
-			if err != nil {
-				app.errorJSON(w, errors.New("unknown user"), http.StatusBadRequest)
-				return
-			}
-			tokenPairs, err := app.generateTokenPair(user)
-			if err != nil {
-				app.errorJSON(w, err, http.StatusBadRequest)
+				app.refreshFailed(w, r, claims, err)
 				return
 			}
+			app.audit(r, data.AuditEvent{Event: data.AuditRefresh, UserID: subjectID(claims)})
 			http.SetCookie(w, &http.Cookie{
 				Name:     "__Host-refresh_token",
 				Path:     "/",
@@ -157,79 +224,637 @@ func (app *application) refreshUsingCookie(w http.ResponseWriter, r *http.Reques
 			return
 		}
 	}
-	app.errorJSON(w, errors.New("unauthorized"), http.StatusUnauthorized)
+	app.errorJSON(w, r, newPublicError("a valid refresh token cookie is required"), http.StatusUnauthorized)
 }
 
+// jwks publishes the public keys in our keyring, so that other services can verify our tokens
+// without knowing any secret. Pending and retired keys are included, so that verifiers learn about
+// a new key before we sign with it, and can still verify tokens signed by the previous one.
+// Symmetric (HS256) keys are never published.
+func (app *application) jwks(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	_ = app.writeJSON(w, http.StatusOK, signing.NewJWKS(app.Keys.VerifyingKeys()...))
+}
+
+// allUsers returns a page of users. The query string may set limit, sort (any of
+// repository.SortColumns), order (asc or desc), and the after cursor from the previous
+// page, and may filter by email, is_admin and created_after.
 func (app *application) allUsers(w http.ResponseWriter, r *http.Request) {
-	users, err := app.DB.AllUsers()
+	app.listUsers(w, r, false)
+}
+
+// deletedUsers returns a page of deleted users, taking the same query string as allUsers.
+func (app *application) deletedUsers(w http.ResponseWriter, r *http.Request) {
+	app.listUsers(w, r, true)
+}
+
+// listUsers sends back a page of users, or of deleted users, as the query string asks.
+func (app *application) listUsers(w http.ResponseWriter, r *http.Request, deleted bool) {
+	opts, err := listOptionsFromQuery(r.URL.Query())
+	if err != nil {
+		app.errorJSON(w, r, err, http.StatusBadRequest)
+		return
+	}
+	opts.Deleted = deleted
+
+	users, page, err := app.DB.ListUsers(r.Context(), opts)
+	if err != nil {
+		app.dbErrorJSON(w, r, err)
+		return
+	}
+	if users == nil {
+		users = []*data.User{}
+	}
+
+	type userPage struct {
+		Data       []*data.User    `json:"data"`
+		Pagination repository.Page `json:"pagination"`
+	}
+
+	_ = app.writeJSON(w, http.StatusOK, userPage{Data: users, Pagination: page})
+}
+
+// searchUsers finds users by partial name or email, best match first. Matches are highlighted
+// with <mark> tags in each result's highlights.
+func (app *application) searchUsers(w http.ResponseWriter, r *http.Request) {
+	q := strings.TrimSpace(r.URL.Query().Get("q"))
+	if q == "" {
+		app.errorJSON(w, r, fieldErrors{"q": "is required"}, http.StatusBadRequest)
+		return
+	}
+
+	limit := repository.DefaultListLimit
+	if v := r.URL.Query().Get("limit"); v != "" {
+		var err error
+		limit, err = strconv.Atoi(v)
+		if err != nil || limit < 1 || limit > repository.MaxListLimit {
+			app.errorJSON(w, r, fieldErrors{"limit": fmt.Sprintf("must be between 1 and %d", repository.MaxListLimit)}, http.StatusBadRequest)
+			return
+		}
+	}
+
+	results, err := app.DB.SearchUsers(r.Context(), q, limit)
 	if err != nil {
-		app.errorJSON(w, err, http.StatusBadRequest)
+		app.dbErrorJSON(w, r, err)
 		return
 	}
-	_ = app.writeJSON(w, http.StatusOK, users)
+	if results == nil {
+		results = []*data.UserSearchResult{}
+	}
+
+	_ = app.writeJSON(w, http.StatusOK, results, "data")
+}
+
+// listOptionsFromQuery reads the options for listing users from a query string.
+func listOptionsFromQuery(q url.Values) (repository.ListOptions, error) {
+	opts := repository.ListOptions{
+		After: q.Get("after"),
+		Sort:  q.Get("sort"),
+		Email: q.Get("email"),
+	}
+
+	if v := q.Get("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil {
+			return opts, fieldErrors{"limit": "must be a number"}
+		}
+		opts.Limit = limit
+	}
+
+	switch q.Get("order") {
+	case "", "asc":
+	case "desc":
+		opts.Desc = true
+	default:
+		return opts, fieldErrors{"order": "must be asc or desc"}
+	}
+
+	if v := q.Get("is_admin"); v != "" {
+		isAdmin, err := strconv.ParseBool(v)
+		if err != nil {
+			return opts, fieldErrors{"is_admin": "must be true or false"}
+		}
+		opts.IsAdmin = &isAdmin
+	}
+
+	if v := q.Get("created_after"); v != "" {
+		createdAfter, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			// a plain date is fine too
+			createdAfter, err = time.Parse("2006-01-02", v)
+			if err != nil {
+				return opts, fieldErrors{"created_after": "must be a date, or an RFC 3339 timestamp"}
+			}
+		}
+		opts.CreatedAfter = &createdAfter
+	}
+
+	// the options are checked by the repository too, so these messages are our own
+	err := opts.Normalize()
+	if err != nil {
+		return opts, &publicError{message: err.Error()}
+	}
+	return opts, nil
 }
 
 func (app *application) getUser(w http.ResponseWriter, r *http.Request) {
 	userID, err := strconv.Atoi(chi.URLParam(r, "userID"))
 	if err != nil {
-		app.errorJSON(w, err, http.StatusBadRequest)
+		app.errorJSON(w, r, newPublicError("user id must be a number"), http.StatusBadRequest)
 		return
 	}
 
-	user, err := app.DB.GetUser(userID)
+	user, err := app.DB.GetUser(r.Context(), userID)
 	if err != nil {
-		app.errorJSON(w, err, http.StatusBadRequest)
+		app.dbErrorJSON(w, r, err)
+		return
+	}
+
+	tag := etag(user.Version)
+	w.Header().Set("ETag", tag)
+	if match := r.Header.Get("If-None-Match"); match != "" && etagMatches(match, tag, true) {
+		w.WriteHeader(http.StatusNotModified)
 		return
 	}
 	_ = app.writeJSON(w, http.StatusOK, user)
 }
 
+// userPayload is the body of insert and update requests. data.User never reads a password
+// from JSON, so that it can never leak one either; here we need it, to create the user.
+// New users may also be given roles.
+type userPayload struct {
+	data.User
+	Password string   `json:"password"`
+	Roles    []string `json:"roles"`
+}
+
+// validateUser checks a user we are about to insert or update, and reports the problems
+// to the client. It returns false if the request should go no further.
+func (app *application) validateUser(w http.ResponseWriter, r *http.Request, payload *userPayload, inserting bool) bool {
+	v := validator.New()
+	v.User(&payload.User)
+
+	if inserting {
+		v.Required("password", payload.Password)
+		v.Password("password", payload.Password)
+		for _, role := range payload.Roles {
+			v.Check(validator.PermittedValue(role, authz.RoleAdmin, authz.RoleSupport, authz.RoleAuditor), "roles", "unknown role "+role)
+		}
+	} else {
+		// passwords are changed through the password reset flow, not by updating the user
+		v.Check(payload.Password == "", "password", "cannot be changed by updating the user")
+		v.Check(len(payload.Roles) == 0, "roles", "cannot be changed by updating the user")
+	}
+
+	if v.Errors["email"] == "" {
+		// inserts have no id yet, and so never match an existing user
+		err := v.UniqueEmail(r.Context(), "email", payload.Email, payload.ID, app.DB.GetUserByEmail)
+		if err != nil {
+			app.dbErrorJSON(w, r, err)
+			return false
+		}
+	}
+
+	if !v.Valid() {
+		app.errorJSON(w, r, fieldErrors(v.Errors), http.StatusUnprocessableEntity)
+		return false
+	}
+	return true
+}
+
+// updateUser replaces a user with the one in the body, so every field must be sent; patchUser
+// changes only the fields it is sent.
 func (app *application) updateUser(w http.ResponseWriter, r *http.Request) {
-	var user data.User
-	err := app.readJSON(w, r, &user)
+	var payload userPayload
+	err := app.readJSON(w, r, &payload)
 	if err != nil {
-		app.errorJSON(w, err, http.StatusBadRequest)
+		app.errorJSON(w, r, err, http.StatusBadRequest)
 		return
 	}
+	user := &payload.User
 
-	err = app.DB.UpdateUser(user)
+	// without users:update, users may only update their own record, and only admins may make someone an admin
+	claims, ok := app.claimsFromContext(r.Context())
+	if !ok {
+		app.errorJSON(w, r, newPublicError("a valid bearer token is required"), http.StatusUnauthorized)
+		return
+	}
+	if !claims.Admin && fmt.Sprint(user.ID) != claims.Subject && !authz.Has(claims.Permissions, authz.UsersUpdate) {
+		app.errorJSON(w, r, newPublicError("you may not update other users"), http.StatusForbidden)
+		return
+	}
+
+	existing, err := app.DB.GetUser(r.Context(), user.ID)
 	if err != nil {
-		app.errorJSON(w, err, http.StatusBadRequest)
+		app.dbErrorJSON(w, r, err)
 		return
 	}
+	if !app.checkIfMatch(w, r, existing.Version) {
+		return
+	}
+
+	app.saveUser(w, r, claims, existing, &payload)
+}
+
+// saveUser stores payload, the new state of existing, once it has passed the checks every
+// update goes through, and sends back the user's new ETag.
+func (app *application) saveUser(w http.ResponseWriter, r *http.Request, claims *Claims, existing *data.User, payload *userPayload) {
+	user := &payload.User
+	// the repository refuses the update if somebody else got in since we read the user
+	user.Version = existing.Version
+	if !claims.Admin {
+		user.IsAdmin = existing.IsAdmin
+	}
+
+	if !app.validateUser(w, r, payload, false) {
+		return
+	}
+
+	err := app.DB.UpdateUser(r.Context(), *user)
+	if err != nil {
+		app.dbErrorJSON(w, r, err)
+		return
+	}
+	w.Header().Set("ETag", etag(user.Version+1))
 	w.WriteHeader(http.StatusNoContent)
 }
 
+// patchUser changes only the fields of a user which the request asks to, with a JSON Merge
+// Patch (RFC 7396), or a JSON Patch (RFC 6902), depending on the content type. The patch is
+// applied to the user as getUser sends them, and the result is checked like a full update.
+func (app *application) patchUser(w http.ResponseWriter, r *http.Request) {
+	userID, err := strconv.Atoi(chi.URLParam(r, "userID"))
+	if err != nil {
+		app.errorJSON(w, r, newPublicError("user id must be a number"), http.StatusBadRequest)
+		return
+	}
+
+	var apply func(doc, patch []byte) ([]byte, error)
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	switch mediaType {
+	case jsonpatch.MergePatchType:
+		apply = jsonpatch.MergePatch
+	case jsonpatch.JSONPatchType:
+		apply = jsonpatch.Apply
+	default:
+		w.Header().Set("Accept-Patch", jsonpatch.MergePatchType+", "+jsonpatch.JSONPatchType)
+		app.errorJSON(w, r, newPublicError("the body must be %s or %s", jsonpatch.MergePatchType, jsonpatch.JSONPatchType), http.StatusUnsupportedMediaType)
+		return
+	}
+
+	claims, ok := app.claimsFromContext(r.Context())
+	if !ok {
+		app.errorJSON(w, r, errBearerTokenRequired, http.StatusUnauthorized)
+		return
+	}
+
+	existing, err := app.DB.GetUser(r.Context(), userID)
+	if err != nil {
+		app.dbErrorJSON(w, r, err)
+		return
+	}
+	if !app.checkIfMatch(w, r, existing.Version) {
+		return
+	}
+
+	patch, err := io.ReadAll(http.MaxBytesReader(w, r.Body, 1024*1024))
+	if err != nil {
+		app.errorJSON(w, r, newPublicError("body must not be larger than %d bytes", 1024*1024), http.StatusRequestEntityTooLarge)
+		return
+	}
+	doc, err := json.Marshal(existing)
+	if err != nil {
+		app.errorJSON(w, r, err, http.StatusInternalServerError)
+		return
+	}
+	patched, err := apply(doc, patch)
+	if errors.Is(err, jsonpatch.ErrTestFailed) {
+		app.errorJSON(w, r, newPublicError(err.Error()), http.StatusConflict)
+		return
+	}
+	if err != nil {
+		app.errorJSON(w, r, newPublicError(err.Error()), http.StatusBadRequest)
+		return
+	}
+
+	payload, err := patchedUser(existing, patched)
+	if err != nil {
+		app.errorJSON(w, r, err, http.StatusUnprocessableEntity)
+		return
+	}
+
+	app.saveUser(w, r, claims, existing, payload)
+}
+
+// patchedUser reads the user a patch of existing produced, making sure the patch only touched
+// the fields clients may change.
+func patchedUser(existing *data.User, patched []byte) (*userPayload, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(patched, &fields); err != nil {
+		return nil, newPublicError("the patched user must be a JSON object")
+	}
+
+	// getUser sends these too, but they are changed by other means, if at all
+	readOnly := map[string]any{"id": existing.ID, "deleted_at": existing.DeletedAt, "disabled_at": existing.DisabledAt}
+
+	problems := fieldErrors{}
+	for name, value := range fields {
+		if current, ok := readOnly[name]; ok {
+			if !sameJSON(current, value) {
+				problems[name] = "cannot be changed"
+			}
+			continue
+		}
+		if !validator.PermittedValue(name, "first_name", "last_name", "email", "is_admin") {
+			problems[name] = "is not a field of a user"
+		}
+	}
+	if len(problems) > 0 {
+		return nil, problems
+	}
+
+	var payload userPayload
+	err := json.Unmarshal(patched, &payload.User)
+	var typeError *json.UnmarshalTypeError
+	if errors.As(err, &typeError) {
+		return nil, fieldErrors{typeError.Field: "has the wrong type"}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	payload.ID = existing.ID
+	return &payload, nil
+}
+
+// sameJSON reports whether v encodes to the same JSON value as raw.
+func sameJSON(v any, raw json.RawMessage) bool {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return false
+	}
+	var a, c any
+	if json.Unmarshal(b, &a) != nil || json.Unmarshal(raw, &c) != nil {
+		return false
+	}
+	return reflect.DeepEqual(a, c)
+}
+
 func (app *application) deleteUser(w http.ResponseWriter, r *http.Request) {
 	userID, err := strconv.Atoi(chi.URLParam(r, "userID"))
 	if err != nil {
-		app.errorJSON(w, err, http.StatusBadRequest)
+		app.errorJSON(w, r, newPublicError("user id must be a number"), http.StatusBadRequest)
+		return
+	}
+
+	existing, err := app.DB.GetUser(r.Context(), userID)
+	if err != nil {
+		app.dbErrorJSON(w, r, err)
+		return
+	}
+	if !app.checkIfMatch(w, r, existing.Version) {
+		return
+	}
+
+	err = app.DB.DeleteUser(r.Context(), userID, existing.Version)
+	if err != nil {
+		app.dbErrorJSON(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// deactivateUser stops a user from logging in, and logs them out everywhere.
+func (app *application) deactivateUser(w http.ResponseWriter, r *http.Request) {
+	userID, err := strconv.Atoi(chi.URLParam(r, "userID"))
+	if err != nil {
+		app.errorJSON(w, r, newPublicError("user id must be a number"), http.StatusBadRequest)
+		return
+	}
+	err = app.DB.DeactivateUser(r.Context(), userID)
+	if err != nil {
+		app.dbErrorJSON(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// restoreUser brings back a deleted or deactivated user.
+func (app *application) restoreUser(w http.ResponseWriter, r *http.Request) {
+	userID, err := strconv.Atoi(chi.URLParam(r, "userID"))
+	if err != nil {
+		app.errorJSON(w, r, newPublicError("user id must be a number"), http.StatusBadRequest)
+		return
+	}
+	err = app.DB.RestoreUser(r.Context(), userID)
+	if err != nil {
+		app.dbErrorJSON(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// historyEntry is a change to a user as userHistory sends it: the entry, and the fields it
+// changed, so that clients don't have to compare the snapshots themselves.
+type historyEntry struct {
+	*data.UserHistory
+	Changes map[string]data.FieldChange `json:"changes"`
+}
+
+// userHistory sends back every change made to a user, deleted or not, newest first.
+func (app *application) userHistory(w http.ResponseWriter, r *http.Request) {
+	userID, err := strconv.Atoi(chi.URLParam(r, "userID"))
+	if err != nil {
+		app.errorJSON(w, r, newPublicError("user id must be a number"), http.StatusBadRequest)
+		return
+	}
+
+	history, err := app.DB.GetUserHistory(r.Context(), userID)
+	if err != nil {
+		app.dbErrorJSON(w, r, err)
+		return
+	}
+
+	entries := []historyEntry{}
+	for _, h := range history {
+		entries = append(entries, historyEntry{UserHistory: h, Changes: h.Changes()})
+	}
+	_ = app.writeJSON(w, http.StatusOK, entries, "data")
+}
+
+// revertUserChange undoes one change in a user's history: the fields it changed are put back
+// the way they were before it, and everything else is left as it is now. The revert is made
+// through the repository like any other change, in one transaction, so it is in the history too.
+func (app *application) revertUserChange(w http.ResponseWriter, r *http.Request) {
+	userID, err := strconv.Atoi(chi.URLParam(r, "userID"))
+	if err != nil {
+		app.errorJSON(w, r, newPublicError("user id must be a number"), http.StatusBadRequest)
 		return
 	}
-	err = app.DB.DeleteUser(userID)
+	revision, err := strconv.Atoi(chi.URLParam(r, "revision"))
 	if err != nil {
-		app.errorJSON(w, err, http.StatusBadRequest)
+		app.errorJSON(w, r, newPublicError("revision must be a number"), http.StatusBadRequest)
+		return
+	}
+
+	entry, err := app.DB.GetUserHistoryEntry(r.Context(), userID, revision)
+	if err != nil {
+		app.dbErrorJSON(w, r, err)
+		return
+	}
+	changes := entry.Changes()
+	_, imageChanged := changes["profile_pic"]
+	switch {
+	case entry.Action == data.ActionInsert:
+		err = newPublicError("the creation of a user cannot be reverted; delete them instead")
+	case entry.Action == data.ActionResetPassword:
+		err = newPublicError("old passwords are not kept, so a password reset cannot be reverted")
+	case len(changes) == 0:
+		err = newPublicError("revision %d changed nothing, so there is nothing to revert", revision)
+	case imageChanged && entry.Before.ProfilePic == "":
+		err = newPublicError("profile images can be replaced, but not removed")
+	}
+	if err != nil {
+		app.errorJSON(w, r, err, http.StatusConflict)
+		return
+	}
+
+	// as with any other update, only admins may change is_admin
+	claims, _ := app.claimsFromContext(r.Context())
+	admin := claims != nil && claims.Admin
+
+	err = app.DB.WithTx(r.Context(), func(repo repository.DatabaseRepo) error {
+		// the newest entry is what the user looks like now, even if they have been deleted
+		history, err := repo.GetUserHistory(r.Context(), userID)
+		if err != nil {
+			return err
+		}
+		current := *history[0].After
+		target := entry.Revert(current)
+		if !admin {
+			target.IsAdmin = current.IsAdmin
+		}
+		return revertUser(r.Context(), repo, userID, current, target)
+	})
+	var public *publicError
+	if errors.As(err, &public) {
+		app.errorJSON(w, r, err, http.StatusConflict)
+		return
+	}
+	if err != nil {
+		app.dbErrorJSON(w, r, err)
 		return
 	}
 	w.WriteHeader(http.StatusNoContent)
 }
 
+// revertUser changes user id from current to target. Deleted users can't be updated, so they
+// are restored first if target says they weren't deleted, and deleted again last if it says
+// they were.
+func revertUser(ctx context.Context, repo repository.DatabaseRepo, id int, current, target data.UserSnapshot) error {
+	deleted, disabled := current.DeletedAt != nil, current.DisabledAt != nil
+	if (deleted && target.DeletedAt == nil) || (disabled && target.DisabledAt == nil) {
+		err := repo.RestoreUser(ctx, id)
+		if err != nil {
+			return err
+		}
+		deleted, disabled = false, false
+	}
+
+	fieldsChanged := current.FirstName != target.FirstName || current.LastName != target.LastName ||
+		current.Email != target.Email || current.IsAdmin != target.IsAdmin
+	imageChanged := current.ProfilePic != target.ProfilePic
+	if deleted && (fieldsChanged || imageChanged) {
+		return newPublicError("the user has been deleted since; restore them first")
+	}
+
+	if fieldsChanged {
+		err := repo.UpdateUser(ctx, data.User{
+			ID:        id,
+			FirstName: target.FirstName,
+			LastName:  target.LastName,
+			Email:     target.Email,
+			IsAdmin:   target.IsAdmin,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	if imageChanged {
+		_, err := repo.InsertUserImage(ctx, data.UserImage{UserID: id, FileName: target.ProfilePic})
+		if err != nil {
+			return err
+		}
+	}
+
+	if target.DisabledAt != nil && !disabled {
+		err := repo.DeactivateUser(ctx, id)
+		if err != nil {
+			return err
+		}
+	}
+	if target.DeletedAt != nil && !deleted {
+		return repo.DeleteUser(ctx, id, 0)
+	}
+	return nil
+}
+
+// insertUser creates a user, and gives them any roles in the payload. The user and their roles
+// are stored in one transaction, so that we never end up with a user missing a role.
 func (app *application) insertUser(w http.ResponseWriter, r *http.Request) {
-	var user data.User
-	err := app.readJSON(w, r, &user)
+	var payload userPayload
+	err := app.readJSON(w, r, &payload)
 	if err != nil {
-		app.errorJSON(w, err, http.StatusBadRequest)
+		app.errorJSON(w, r, err, http.StatusBadRequest)
+		return
+	}
+
+	// roles grant permissions, so as with is_admin, only admins may hand them out
+	if len(payload.Roles) > 0 {
+		claims, ok := app.claimsFromContext(r.Context())
+		if !ok || !claims.Admin {
+			app.errorJSON(w, r, newPublicError("only admins may assign roles"), http.StatusForbidden)
+			return
+		}
+	}
+
+	// the database picks the id
+	payload.ID = 0
+	if !app.validateUser(w, r, &payload, true) {
 		return
 	}
-	_, err = app.DB.InsertUser(user)
+
+	user := payload.User
+	user.Password = payload.Password
+	err = app.DB.WithTx(r.Context(), func(repo repository.DatabaseRepo) error {
+		id, err := repo.InsertUser(r.Context(), user)
+		if err != nil {
+			return err
+		}
+		for _, role := range payload.Roles {
+			err = repo.AssignRole(r.Context(), id, role)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
 	if err != nil {
-		app.errorJSON(w, err, http.StatusBadRequest)
+		app.dbErrorJSON(w, r, err)
 		return
 	}
 	w.WriteHeader(http.StatusNoContent)
 }
 
 func (app *application) deleteRefreshCookie(w http.ResponseWriter, r *http.Request) {
+	// revoke the refresh token on the server, so that a copy of the cookie is useless
+	var userID *int
+	if cookie, err := r.Cookie("__Host-refresh_token"); err == nil {
+		claims, _ := app.revokeRefreshToken(r.Context(), cookie.Value)
+		userID = subjectID(claims)
+	}
+	app.audit(r, data.AuditEvent{Event: data.AuditLogout, UserID: userID})
+
 	delCookie := http.Cookie{
 		Name:     "__Host-refresh_token",
 		Path:     "/",
@@ -244,3 +869,18 @@ func (app *application) deleteRefreshCookie(w http.ResponseWriter, r *http.Reque
 	http.SetCookie(w, &delCookie)
 	w.WriteHeader(http.StatusAccepted)
 }
+
+// refreshFailed reports why the refresh token in claims could not be rotated. Anything but the
+// token or the account being refused is our problem, like the database being down, and must not
+// log the client out.
+func (app *application) refreshFailed(w http.ResponseWriter, r *http.Request, claims *Claims, err error) {
+	app.audit(r, data.AuditEvent{Event: data.AuditRefreshFailed, UserID: subjectID(claims), Reason: err.Error()})
+	switch {
+	case errors.Is(err, errRefreshTokenUnknown), errors.Is(err, errRefreshTokenReused):
+		app.errorJSON(w, r, err, http.StatusUnauthorized)
+	case errors.Is(err, errAccountDeactivated):
+		app.errorJSON(w, r, err, http.StatusForbidden)
+	default:
+		app.dbErrorJSON(w, r, err)
+	}
+}
@@ -2,14 +2,24 @@ package main
 
 import (
 	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"github.com/go-chi/chi/v5"
 	"io"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"strconv"
 	"strings"
 	"testing"
+	"testingCourserWeb/pkg/authz"
 	"testingCourserWeb/pkg/data"
+	"testingCourserWeb/pkg/ratelimit"
+	"testingCourserWeb/pkg/repository"
+	"testingCourserWeb/pkg/signing"
 	"time"
 )
 
@@ -41,6 +51,60 @@ func Test_app_authenticate(t *testing.T) {
 	}
 }
 
+func Test_app_authenticate_limits(t *testing.T) {
+	db := useFreshDB(t)
+	app.Logins.PerIP = ratelimit.Limit{Every: time.Hour, Burst: 4}
+	app.Logins.Lockout = ratelimit.Lockout{After: 2, Base: time.Minute}
+
+	var tests = []struct {
+		name               string
+		email              string
+		password           string
+		expectedStatusCode int
+		expectedReason     string
+	}{
+		{"wrong password", "admin@example.com", "wrong", http.StatusUnauthorized, "wrong password"},
+		{"the same account in capitals, locking it", "Admin@Example.com", "wrong", http.StatusUnauthorized, "unknown email"},
+		{"locked", "admin@example.com", "secret", http.StatusTooManyRequests, ratelimit.ReasonAccountLocked},
+		{"another account", "nobody@example.com", "secret", http.StatusUnauthorized, "unknown email"},
+		{"too many from this address", "nobody@example.com", "secret", http.StatusTooManyRequests, ratelimit.ReasonIPLimited},
+	}
+
+	for _, e := range tests {
+		req := httptest.NewRequest("POST", "/auth", strings.NewReader(`{"email": "`+e.email+`", "password": "`+e.password+`"}`))
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(app.authenticate).ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s: expected status %d, but got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+		retryAfter, _ := strconv.Atoi(rr.Header().Get("Retry-After"))
+		if rr.Code == http.StatusTooManyRequests && retryAfter < 1 {
+			t.Errorf("%s: expected a Retry-After header, but got %q", e.name, rr.Header().Get("Retry-After"))
+		}
+		if e.name == "locked" && retryAfter > 60 {
+			t.Errorf("%s: expected to be locked out for a minute, but got %d seconds", e.name, retryAfter)
+		}
+
+		events, _, _ := db.ListAuditEvents(context.Background(), repository.AuditFilter{Limit: 1})
+		if len(events) != 1 || events[0].Reason != e.expectedReason {
+			t.Errorf("%s: expected a failed login because %q to be recorded, but got %v", e.name, e.expectedReason, events)
+		}
+	}
+
+	// a successful login forgets the failures
+	useFreshDB(t)
+	app.Logins.Lockout = ratelimit.Lockout{After: 2, Base: time.Minute}
+	for _, password := range []string{"wrong", "secret", "wrong", "secret"} {
+		req := httptest.NewRequest("POST", "/auth", strings.NewReader(`{"email": "admin@example.com", "password": "`+password+`"}`))
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(app.authenticate).ServeHTTP(rr, req)
+		if rr.Code == http.StatusTooManyRequests {
+			t.Fatal("expected a successful login to reset the failures, but the account was locked")
+		}
+	}
+}
+
 func Test_app_refresh(t *testing.T) {
 	var tests = []struct {
 		name               string
@@ -51,6 +115,8 @@ func Test_app_refresh(t *testing.T) {
 		{"valid", "", http.StatusOK, true},
 		{"valid but not yet ready to expire", "", http.StatusTooEarly, false},
 		{"expired token", expiredToken, http.StatusBadRequest, false},
+		{"already rotated token", refreshTokenWithID("rotated", 10*time.Second), http.StatusUnauthorized, false},
+		{"unknown token", refreshTokenWithID("unknown", 10*time.Second), http.StatusUnauthorized, false},
 	}
 	testUser := data.User{
 		ID:        1,
@@ -61,13 +127,15 @@ func Test_app_refresh(t *testing.T) {
 
 	oldRefreshTime := refreshTokenExpiry
 
+	useFreshDB(t)
+
 	for _, e := range tests {
 		var tkn string
 		if e.token == "" {
 			if e.resetRefreshTime {
 				refreshTokenExpiry = time.Second * 1
 			}
-			tokens, _ := app.generateTokenPair(&testUser)
+			tokens, _ := app.generateTokenPair(context.Background(), &testUser)
 			tkn = tokens.RefreshToken
 		} else {
 			tkn = e.token
@@ -100,10 +168,8 @@ func Test_app_userHandlers(t *testing.T) {
 		expectedStatus int
 	}{
 		{"allUsers", "GET", "", "", app.allUsers, http.StatusOK},
-		{"deleteUser", "DELETE", "", "1", app.deleteUser, http.StatusNoContent},
-		{"deleteUser var url param", "DELETE", "", "x", app.deleteUser, http.StatusBadRequest},
 		{"get user valid", "GET", "", "1", app.getUser, http.StatusOK},
-		{"get user invalid", "GET", "", "100", app.getUser, http.StatusBadRequest},
+		{"get user invalid", "GET", "", "100", app.getUser, http.StatusNotFound},
 		{"get user bad url param", "GET", "", "1y", app.getUser, http.StatusBadRequest},
 		{"update user valid",
 			"PATCH",
@@ -123,7 +189,7 @@ func Test_app_userHandlers(t *testing.T) {
 					"last_name": "User",
 					"email": "admin@example.com"
 			}`, "1", app.updateUser,
-			http.StatusBadRequest,
+			http.StatusNotFound,
 		},
 		{"update user invalid JSON",
 			"PATCH",
@@ -141,10 +207,33 @@ func Test_app_userHandlers(t *testing.T) {
 					"id": 1,
 					"first_name": "Jack",
 					"last_name": "User",
-					"email": "admin@example.com"
+					"email": "jack@example.com",
+					"password": "correct horse 1"
+			}`, "1", app.insertUser,
+			http.StatusNoContent,
+		},
+		{"insert user with roles",
+			"PUT",
+			`{
+					"first_name": "Jack",
+					"last_name": "User",
+					"email": "jill@example.com",
+					"password": "correct horse 1",
+					"roles": ["support", "auditor"]
 			}`, "1", app.insertUser,
 			http.StatusNoContent,
 		},
+		{"insert user duplicate email",
+			"PUT",
+			`{
+					"id": 1,
+					"first_name": "Jack",
+					"last_name": "User",
+					"email": "admin@example.com",
+					"password": "correct horse 1"
+			}`, "1", app.insertUser,
+			http.StatusUnprocessableEntity,
+		},
 		{"insert user invalid",
 			"PUT",
 			`{
@@ -166,8 +255,22 @@ func Test_app_userHandlers(t *testing.T) {
 			}`, "1", app.insertUser,
 			http.StatusBadRequest,
 		},
+		// the user is gone after this, so deletes go last
+		{"deleteUser", "DELETE", "", "1", app.deleteUser, http.StatusNoContent},
+		{"deleteUser var url param", "DELETE", "", "x", app.deleteUser, http.StatusBadRequest},
+		{"deleteUser not found", "DELETE", "", "100", app.deleteUser, http.StatusNotFound},
+		{"get deleted user", "GET", "", "1", app.getUser, http.StatusNotFound},
+		{"deletedUsers", "GET", "", "", app.deletedUsers, http.StatusOK},
+		{"restoreUser", "POST", "", "1", app.restoreUser, http.StatusNoContent},
+		{"restoreUser not deleted", "POST", "", "1", app.restoreUser, http.StatusNotFound},
+		{"restoreUser bad url param", "POST", "", "x", app.restoreUser, http.StatusBadRequest},
+		{"deactivateUser", "POST", "", "1", app.deactivateUser, http.StatusNoContent},
+		{"deactivateUser bad url param", "POST", "", "x", app.deactivateUser, http.StatusBadRequest},
+		{"deactivateUser not found", "POST", "", "100", app.deactivateUser, http.StatusNotFound},
 	}
 
+	useFreshDB(t)
+
 	for _, e := range tests {
 		var req *http.Request
 		if e.json == "" {
@@ -176,6 +279,10 @@ func Test_app_userHandlers(t *testing.T) {
 			req, _ = http.NewRequest(e.method, "/", strings.NewReader(e.json))
 		}
 
+		req = addClaimsToRequest(req, "1", true)
+		// these cases aren't about versions; Test_app_userETags is
+		req.Header.Set("If-Match", "*")
+
 		if e.paramID != "" {
 			chiCtx := chi.NewRouteContext()
 			chiCtx.URLParams.Add("userID", e.paramID)
@@ -194,7 +301,564 @@ func Test_app_userHandlers(t *testing.T) {
 
 }
 
+func Test_app_allUsers(t *testing.T) {
+	var tests = []struct {
+		name           string
+		query          string
+		expectedStatus int
+	}{
+		{"defaults", "", http.StatusOK},
+		{"sorted and filtered", "?sort=created_at&order=desc&limit=5&is_admin=true&created_after=2022-01-01&email=admin@example.com", http.StatusOK},
+		{"created after timestamp", "?created_after=2022-01-01T10:00:00Z", http.StatusOK},
+		{"limit not a number", "?limit=ten", http.StatusBadRequest},
+		{"limit too big", "?limit=1000", http.StatusBadRequest},
+		{"unknown sort", "?sort=password", http.StatusBadRequest},
+		{"bad order", "?order=up", http.StatusBadRequest},
+		{"bad is_admin", "?is_admin=maybe", http.StatusBadRequest},
+		{"bad created_after", "?created_after=yesterday", http.StatusBadRequest},
+		{"bad cursor", "?after=nonsense", http.StatusBadRequest},
+	}
+
+	for _, e := range tests {
+		req, _ := http.NewRequest("GET", "/users"+e.query, nil)
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(app.allUsers)
+
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatus {
+			t.Errorf("%s: wrong status returned; expected %d, but got %d", e.name, e.expectedStatus, rr.Code)
+		}
+
+		if rr.Code == http.StatusOK {
+			var page struct {
+				Data       []data.User `json:"data"`
+				Pagination struct {
+					Limit   int    `json:"limit"`
+					Sort    string `json:"sort"`
+					HasMore bool   `json:"has_more"`
+				} `json:"pagination"`
+			}
+			err := json.NewDecoder(rr.Body).Decode(&page)
+			if err != nil {
+				t.Errorf("%s: error decoding response: %s", e.name, err)
+			}
+			if len(page.Data) != 1 || page.Pagination.Limit == 0 || page.Pagination.Sort == "" {
+				t.Errorf("%s: unexpected page %+v", e.name, page)
+			}
+		}
+	}
+}
+
+func Test_app_searchUsers(t *testing.T) {
+	var tests = []struct {
+		name            string
+		query           string
+		expectedStatus  int
+		expectedResults int
+	}{
+		{"match", "?q=adm", http.StatusOK, 1},
+		{"no match", "?q=nobody", http.StatusOK, 0},
+		{"with limit", "?q=example&limit=5", http.StatusOK, 1},
+		{"missing q", "", http.StatusBadRequest, 0},
+		{"blank q", "?q=%20%20", http.StatusBadRequest, 0},
+		{"bad limit", "?q=adm&limit=0", http.StatusBadRequest, 0},
+	}
+
+	for _, e := range tests {
+		req, _ := http.NewRequest("GET", "/users/search"+e.query, nil)
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(app.searchUsers)
+
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatus {
+			t.Errorf("%s: wrong status returned; expected %d, but got %d", e.name, e.expectedStatus, rr.Code)
+		}
+
+		if rr.Code == http.StatusOK {
+			var body struct {
+				Data []data.UserSearchResult `json:"data"`
+			}
+			_ = json.NewDecoder(rr.Body).Decode(&body)
+			if len(body.Data) != e.expectedResults {
+				t.Errorf("%s: expected %d results, but got %d", e.name, e.expectedResults, len(body.Data))
+			}
+			if len(body.Data) > 0 && body.Data[0].Highlights["email"] == "" {
+				t.Errorf("%s: expected email to be highlighted", e.name)
+			}
+		}
+	}
+}
+
+func Test_app_userHandlers_validation(t *testing.T) {
+	var tests = []struct {
+		name           string
+		json           string
+		handler        http.HandlerFunc
+		expectedFields []string
+	}{
+		{"insert missing everything", `{}`, app.insertUser, []string{"first_name", "last_name", "email", "password"}},
+		{"insert bad values",
+			`{"first_name": "Jack", "last_name": "User", "email": "Jack <jack@example.com>", "is_admin": 2, "password": "short"}`,
+			app.insertUser, []string{"email", "is_admin", "password"}},
+		{"insert weak password",
+			`{"first_name": "Jack", "last_name": "User", "email": "jack@example.com", "password": "passwordpassword"}`,
+			app.insertUser, []string{"password"}},
+		{"insert name too long",
+			`{"first_name": "` + strings.Repeat("x", 256) + `", "last_name": "User", "email": "jack@example.com", "password": "correct horse 1"}`,
+			app.insertUser, []string{"first_name"}},
+		{"insert unknown role",
+			`{"first_name": "Jack", "last_name": "User", "email": "jack@example.com", "password": "correct horse 1", "roles": ["support", "root"]}`,
+			app.insertUser, []string{"roles"}},
+		{"update blank name",
+			`{"id": 1, "first_name": " ", "last_name": "User", "email": "admin@example.com"}`,
+			app.updateUser, []string{"first_name"}},
+		{"update password",
+			`{"id": 1, "first_name": "Admin", "last_name": "User", "email": "admin@example.com", "password": "correct horse 1"}`,
+			app.updateUser, []string{"password"}},
+		{"update roles",
+			`{"id": 1, "first_name": "Admin", "last_name": "User", "email": "admin@example.com", "roles": ["admin"]}`,
+			app.updateUser, []string{"roles"}},
+	}
+
+	for _, e := range tests {
+		req, _ := http.NewRequest("PUT", "/", strings.NewReader(e.json))
+		req = addClaimsToRequest(req, "1", true)
+		req.Header.Set("If-Match", "*")
+		rr := httptest.NewRecorder()
+
+		e.handler.ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusUnprocessableEntity {
+			t.Errorf("%s: expected status 422, but got %d", e.name, rr.Code)
+			continue
+		}
+
+		var problem Problem
+		_ = json.NewDecoder(rr.Body).Decode(&problem)
+		if len(problem.Errors) != len(e.expectedFields) {
+			t.Errorf("%s: expected errors for %v, but got %v", e.name, e.expectedFields, problem.Errors)
+		}
+		for _, field := range e.expectedFields {
+			if problem.Errors[field] == "" {
+				t.Errorf("%s: expected an error for %s, but got %v", e.name, field, problem.Errors)
+			}
+		}
+	}
+}
+
+func Test_app_updateUser_notAdmin(t *testing.T) {
+	var tests = []struct {
+		name           string
+		subject        string
+		permissions    []string
+		json           string
+		expectedStatus int
+	}{
+		{"own record", "1", nil, `{"id": 1, "first_name": "Admin", "last_name": "User", "email": "admin@example.com", "is_admin": 1}`, http.StatusNoContent},
+		{"someone else's record", "2", nil, `{"id": 1, "first_name": "Admin", "last_name": "User", "email": "admin@example.com"}`, http.StatusForbidden},
+		{"someone else's record with users:update", "2", []string{authz.UsersUpdate}, `{"id": 1, "first_name": "Admin", "last_name": "User", "email": "admin@example.com"}`, http.StatusNoContent},
+	}
+
+	useFreshDB(t)
+
+	for _, e := range tests {
+		req, _ := http.NewRequest("PATCH", "/users", strings.NewReader(e.json))
+		req = addClaimsToRequest(req, e.subject, false, e.permissions...)
+		req.Header.Set("If-Match", "*")
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(app.updateUser)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatus {
+			t.Errorf("%s: wrong status returned; expected %d, but got %d", e.name, e.expectedStatus, rr.Code)
+		}
+	}
+}
+
+func Test_app_insertUser_notAdmin(t *testing.T) {
+	var tests = []struct {
+		name           string
+		json           string
+		expectedStatus int
+	}{
+		{"no roles", `{"first_name": "Jack", "last_name": "User", "email": "jack@example.com", "password": "correct horse 1"}`, http.StatusNoContent},
+		{"with roles", `{"first_name": "Jack", "last_name": "User", "email": "jack@example.com", "password": "correct horse 1", "roles": ["support"]}`, http.StatusForbidden},
+	}
+
+	useFreshDB(t)
+
+	for _, e := range tests {
+		req, _ := http.NewRequest("PUT", "/users", strings.NewReader(e.json))
+		req = addClaimsToRequest(req, "2", false, authz.UsersCreate)
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(app.insertUser)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatus {
+			t.Errorf("%s: wrong status returned; expected %d, but got %d", e.name, e.expectedStatus, rr.Code)
+		}
+	}
+}
+
+func Test_app_insertUser_stored(t *testing.T) {
+	db := useFreshDB(t)
+
+	body := `{"first_name": "Jill", "last_name": "User", "email": "jill@example.com", "password": "correct horse 1", "roles": ["support"]}`
+	for _, expectedStatus := range []int{http.StatusNoContent, http.StatusUnprocessableEntity} {
+		req, _ := http.NewRequest("PUT", "/users", strings.NewReader(body))
+		req = addClaimsToRequest(req, "1", true)
+		rr := httptest.NewRecorder()
+
+		http.HandlerFunc(app.insertUser).ServeHTTP(rr, req)
+
+		if rr.Code != expectedStatus {
+			t.Errorf("wrong status returned; expected %d, but got %d", expectedStatus, rr.Code)
+		}
+	}
+
+	user, err := db.GetUserByEmail(context.Background(), "jill@example.com")
+	if err != nil {
+		t.Fatalf("inserted user not found: %s", err)
+	}
+	if user.Password == "correct horse 1" {
+		t.Error("password was stored in plain text")
+	}
+	if ok, _ := user.PasswordMatches("correct horse 1"); !ok {
+		t.Error("stored password does not match")
+	}
+
+	roles, _ := db.GetUserRoles(context.Background(), user.ID)
+	if len(roles) != 1 || roles[0] != authz.RoleSupport {
+		t.Errorf("expected the support role, but got %v", roles)
+	}
+
+	req, _ := http.NewRequest("GET", "/", nil)
+	chiCtx := chi.NewRouteContext()
+	chiCtx.URLParams.Add("userID", strconv.Itoa(user.ID))
+	req = req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, chiCtx))
+	rr := httptest.NewRecorder()
+
+	http.HandlerFunc(app.getUser).ServeHTTP(rr, req)
+
+	var got data.User
+	_ = json.NewDecoder(rr.Body).Decode(&got)
+	if rr.Code != http.StatusOK || got.Email != "jill@example.com" {
+		t.Errorf("expected to get the inserted user, but got %d %+v", rr.Code, got)
+	}
+}
+
+func Test_app_userETags(t *testing.T) {
+	useFreshDB(t)
+
+	// send makes a request for user 1, with the header given, and returns the response
+	send := func(handler http.HandlerFunc, method, header, value string) *httptest.ResponseRecorder {
+		var body io.Reader
+		if method == "PATCH" {
+			body = strings.NewReader(`{"id": 1, "first_name": "Admin", "last_name": "Changed", "email": "admin@example.com", "is_admin": 1}`)
+		}
+		req, _ := http.NewRequest(method, "/", body)
+		req = addClaimsToRequest(req, "1", true)
+		if header != "" {
+			req.Header.Set(header, value)
+		}
+		chiCtx := chi.NewRouteContext()
+		chiCtx.URLParams.Add("userID", "1")
+		req = req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, chiCtx))
+
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr
+	}
+
+	rr := send(app.getUser, "GET", "", "")
+	first := rr.Header().Get("ETag")
+	if rr.Code != http.StatusOK || first != `"1"` {
+		t.Fatalf("expected an ETag of \"1\", but got %d %q", rr.Code, first)
+	}
+
+	var tests = []struct {
+		name           string
+		handler        http.HandlerFunc
+		method         string
+		header         string
+		value          string
+		expectedStatus int
+	}{
+		{"get unchanged", app.getUser, "GET", "If-None-Match", first, http.StatusNotModified},
+		{"get unchanged, weak", app.getUser, "GET", "If-None-Match", `"7", W/"1"`, http.StatusNotModified},
+		{"get changed", app.getUser, "GET", "If-None-Match", `"7"`, http.StatusOK},
+		{"update without If-Match", app.updateUser, "PATCH", "", "", http.StatusPreconditionRequired},
+		{"update old version", app.updateUser, "PATCH", "If-Match", `"7"`, http.StatusPreconditionFailed},
+		{"update weak", app.updateUser, "PATCH", "If-Match", `W/"1"`, http.StatusPreconditionFailed},
+		{"update", app.updateUser, "PATCH", "If-Match", first, http.StatusNoContent},
+		{"update again with the same version", app.updateUser, "PATCH", "If-Match", first, http.StatusPreconditionFailed},
+		{"get after update", app.getUser, "GET", "If-None-Match", first, http.StatusOK},
+		{"delete without If-Match", app.deleteUser, "DELETE", "", "", http.StatusPreconditionRequired},
+		{"delete old version", app.deleteUser, "DELETE", "If-Match", first, http.StatusPreconditionFailed},
+		{"delete", app.deleteUser, "DELETE", "If-Match", `"1", "2"`, http.StatusNoContent},
+	}
+
+	for _, e := range tests {
+		rr := send(e.handler, e.method, e.header, e.value)
+		if rr.Code != e.expectedStatus {
+			t.Errorf("%s: expected status %d, but got %d", e.name, e.expectedStatus, rr.Code)
+		}
+		if e.name == "update" && rr.Header().Get("ETag") != `"2"` {
+			t.Errorf("%s: expected the new ETag \"2\", but got %q", e.name, rr.Header().Get("ETag"))
+		}
+	}
+}
+
+func Test_app_patchUser(t *testing.T) {
+	db := useFreshDB(t)
+
+	var tests = []struct {
+		name           string
+		userID         string
+		contentType    string
+		ifMatch        string
+		body           string
+		expectedStatus int
+	}{
+		{"merge patch", "1", "application/merge-patch+json", "*", `{"first_name": "Merged"}`, http.StatusNoContent},
+		{"json patch", "1", "application/json-patch+json; charset=utf-8", "*",
+			`[{"op": "test", "path": "/first_name", "value": "Merged"}, {"op": "replace", "path": "/last_name", "value": "Patched"}]`,
+			http.StatusNoContent},
+		{"json patch failed test", "1", "application/json-patch+json", "*", `[{"op": "test", "path": "/first_name", "value": "Admin"}]`, http.StatusConflict},
+		{"json patch bad path", "1", "application/json-patch+json", "*", `[{"op": "remove", "path": "/nickname"}]`, http.StatusBadRequest},
+		{"json patch not a list", "1", "application/json-patch+json", "*", `{"first_name": "Jack"}`, http.StatusBadRequest},
+		{"plain json", "1", "application/json", "*", `{"first_name": "Jack"}`, http.StatusUnsupportedMediaType},
+		{"unknown field", "1", "application/merge-patch+json", "*", `{"password": "correct horse 1"}`, http.StatusUnprocessableEntity},
+		{"read only field", "1", "application/merge-patch+json", "*", `{"id": 2}`, http.StatusUnprocessableEntity},
+		{"unchanged read only field", "1", "application/merge-patch+json", "*", `{"id": 1, "last_name": "User"}`, http.StatusNoContent},
+		{"wrong type", "1", "application/merge-patch+json", "*", `{"is_admin": "yes"}`, http.StatusUnprocessableEntity},
+		{"removes a required field", "1", "application/merge-patch+json", "*", `{"first_name": null}`, http.StatusUnprocessableEntity},
+		{"invalid email", "1", "application/merge-patch+json", "*", `{"email": "admin"}`, http.StatusUnprocessableEntity},
+		{"no If-Match", "1", "application/merge-patch+json", "", `{"first_name": "Jack"}`, http.StatusPreconditionRequired},
+		{"old version", "1", "application/merge-patch+json", `"1"`, `{"first_name": "Jack"}`, http.StatusPreconditionFailed},
+		{"user not found", "100", "application/merge-patch+json", "*", `{"first_name": "Jack"}`, http.StatusNotFound},
+	}
+
+	for _, e := range tests {
+		req, _ := http.NewRequest("PATCH", "/", strings.NewReader(e.body))
+		req = addClaimsToRequest(req, "1", true)
+		req.Header.Set("Content-Type", e.contentType)
+		if e.ifMatch != "" {
+			req.Header.Set("If-Match", e.ifMatch)
+		}
+		chiCtx := chi.NewRouteContext()
+		chiCtx.URLParams.Add("userID", e.userID)
+		req = req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, chiCtx))
+
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(app.patchUser).ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatus {
+			t.Errorf("%s: expected status %d, but got %d: %s", e.name, e.expectedStatus, rr.Code, rr.Body)
+		}
+		if rr.Code == http.StatusUnsupportedMediaType && rr.Header().Get("Accept-Patch") == "" {
+			t.Errorf("%s: expected an Accept-Patch header", e.name)
+		}
+	}
+
+	// only what was patched has changed
+	user, _ := db.GetUser(context.Background(), 1)
+	if user.FirstName != "Merged" || user.LastName != "User" || user.Email != "admin@example.com" || user.IsAdmin != 1 {
+		t.Errorf("expected only the patched fields to change, but got %+v", user)
+	}
+}
+
+func Test_app_softDelete(t *testing.T) {
+	db := useFreshDB(t)
+	ctx := context.Background()
+
+	login := func(password string) int {
+		req, _ := http.NewRequest("POST", "/auth", strings.NewReader(`{"email": "admin@example.com", "password": "`+password+`"}`))
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(app.authenticate).ServeHTTP(rr, req)
+		return rr.Code
+	}
+	refresh := func() int {
+		tokens, _ := app.generateTokenPair(ctx, &data.User{ID: 1, Email: "admin@example.com"})
+		req, _ := http.NewRequest("GET", "/", nil)
+		req.AddCookie(&http.Cookie{Name: "__Host-refresh_token", Value: tokens.RefreshToken})
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(app.refreshUsingCookie).ServeHTTP(rr, req)
+		return rr.Code
+	}
+
+	_ = db.DeactivateUser(ctx, 1)
+	if code := login("secret"); code != http.StatusForbidden {
+		t.Errorf("deactivated: expected %d logging in, but got %d", http.StatusForbidden, code)
+	}
+	if code := login("wrong"); code != http.StatusUnauthorized {
+		t.Errorf("deactivated, wrong password: expected %d logging in, but got %d", http.StatusUnauthorized, code)
+	}
+	if code := refresh(); code != http.StatusForbidden {
+		t.Errorf("deactivated: expected %d refreshing, but got %d", http.StatusForbidden, code)
+	}
+
+	_ = db.RestoreUser(ctx, 1)
+	if code := login("secret"); code != http.StatusOK {
+		t.Errorf("restored: expected %d logging in, but got %d", http.StatusOK, code)
+	}
+
+	_ = db.DeleteUser(ctx, 1, 0)
+	if code := login("secret"); code != http.StatusUnauthorized {
+		t.Errorf("deleted: expected %d logging in, but got %d", http.StatusUnauthorized, code)
+	}
+
+	req, _ := http.NewRequest("GET", "/users/deleted", nil)
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(app.deletedUsers).ServeHTTP(rr, req)
+
+	var got struct {
+		Data []map[string]any `json:"data"`
+	}
+	_ = json.NewDecoder(rr.Body).Decode(&got)
+	if rr.Code != http.StatusOK || len(got.Data) != 1 || got.Data[0]["deleted_at"] == nil {
+		t.Errorf("expected the deleted user, with deleted_at, but got %d %v", rr.Code, got.Data)
+	}
+}
+
+func Test_app_userHistory(t *testing.T) {
+	db := useFreshDB(t)
+	ctx := repository.WithActor(context.Background(), 1)
+
+	// insert, update, image, deactivate, delete: revisions 1 to 5
+	id, _ := db.InsertUser(ctx, data.User{FirstName: "Jack", LastName: "Smith", Email: "jack@smith.com", Password: "secret"})
+	_ = db.UpdateUser(ctx, data.User{ID: id, FirstName: "Changed", LastName: "Smith", Email: "jack@smith.com"})
+	_, _ = db.InsertUserImage(ctx, data.UserImage{UserID: id, FileName: "jack.jpg"})
+	_ = db.DeactivateUser(ctx, id)
+	_ = db.DeleteUser(ctx, id, 0)
+
+	withParams := func(req *http.Request, params ...string) *http.Request {
+		chiCtx := chi.NewRouteContext()
+		for i := 0; i < len(params); i += 2 {
+			chiCtx.URLParams.Add(params[i], params[i+1])
+		}
+		return req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, chiCtx))
+	}
+
+	// deleted users still have their history
+	req, _ := http.NewRequest("GET", "/", nil)
+	req = withParams(req, "userID", fmt.Sprint(id))
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(app.userHistory).ServeHTTP(rr, req)
+
+	var got struct {
+		Data []struct {
+			Revision int                         `json:"revision"`
+			Action   string                      `json:"action"`
+			ActorID  int                         `json:"actor_id"`
+			Changes  map[string]data.FieldChange `json:"changes"`
+		} `json:"data"`
+	}
+	_ = json.NewDecoder(rr.Body).Decode(&got)
+	if rr.Code != http.StatusOK || len(got.Data) != 5 {
+		t.Fatalf("expected 5 changes, but got %d: %v", rr.Code, got.Data)
+	}
+	if update := got.Data[3]; update.Action != data.ActionUpdate || update.ActorID != 1 || fmt.Sprint(update.Changes) != "map[first_name:{Jack Changed}]" {
+		t.Errorf("expected the update by user 1 of first_name, but got %+v", update)
+	}
+
+	req, _ = http.NewRequest("GET", "/", nil)
+	req = withParams(req, "userID", "100")
+	rr = httptest.NewRecorder()
+	http.HandlerFunc(app.userHistory).ServeHTTP(rr, req)
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("expected %d for a user who doesn't exist, but got %d", http.StatusNotFound, rr.Code)
+	}
+
+	var tests = []struct {
+		name           string
+		userID         string
+		revision       string
+		expectedStatus int
+	}{
+		{"insert", fmt.Sprint(id), "1", http.StatusConflict},
+		{"update of a deleted user", fmt.Sprint(id), "2", http.StatusConflict},
+		{"image with none before", fmt.Sprint(id), "3", http.StatusConflict},
+		{"delete", fmt.Sprint(id), "5", http.StatusNoContent},
+		{"update", fmt.Sprint(id), "2", http.StatusNoContent},
+		{"deactivate", fmt.Sprint(id), "4", http.StatusNoContent},
+		{"revert of a revert", fmt.Sprint(id), "8", http.StatusNoContent},
+		{"another user's revision", "1", "2", http.StatusNotFound},
+		{"no such revision", fmt.Sprint(id), "100", http.StatusNotFound},
+		{"bad revision", fmt.Sprint(id), "latest", http.StatusBadRequest},
+	}
+
+	for _, e := range tests {
+		req, _ := http.NewRequest("POST", "/", nil)
+		req = addClaimsToRequest(req, "1", true)
+		req = withParams(req, "userID", e.userID, "revision", e.revision)
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(app.revertUserChange).ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatus {
+			t.Errorf("%s: expected status %d, but got %d: %s", e.name, e.expectedStatus, rr.Code, rr.Body)
+		}
+	}
+
+	// the delete was undone, leaving the user deactivated, then the update and the deactivation,
+	// and then the update was put back again; the image was never touched
+	user, err := db.GetUser(context.Background(), id)
+	if err != nil {
+		t.Fatalf("expected the user to be restored, but got %s", err)
+	}
+	if user.FirstName != "Changed" || user.DisabledAt != nil || user.ProfilePic.FileName != "jack.jpg" {
+		t.Errorf("expected the reverts to be applied, but got %+v", user)
+	}
+	history, _ := db.GetUserHistory(context.Background(), id)
+	if len(history) != 10 || history[0].ActorID == nil || *history[0].ActorID != 1 {
+		t.Errorf("expected the reverts to be in the history, made by user 1, but got %d changes", len(history))
+	}
+}
+
+func Test_app_revertUserChange_isAdmin(t *testing.T) {
+	db := useFreshDB(t)
+	ctx := repository.WithActor(context.Background(), 1)
+
+	// made an admin, then not: revisions 2 and 3
+	id, _ := db.InsertUser(ctx, data.User{FirstName: "Jack", LastName: "Smith", Email: "jack@smith.com", Password: "secret"})
+	_ = db.UpdateUser(ctx, data.User{ID: id, FirstName: "Jack", LastName: "Smith", Email: "jack@smith.com", IsAdmin: 1})
+	_ = db.UpdateUser(ctx, data.User{ID: id, FirstName: "Jack", LastName: "Smith", Email: "jack@smith.com"})
+
+	var tests = []struct {
+		name            string
+		admin           bool
+		expectedIsAdmin int
+	}{
+		{"not an admin", false, 0},
+		{"an admin", true, 1},
+	}
+
+	for _, e := range tests {
+		req, _ := http.NewRequest("POST", "/", nil)
+		req = addClaimsToRequest(req, fmt.Sprint(id), e.admin, authz.UsersUpdate, authz.UsersDelete)
+		chiCtx := chi.NewRouteContext()
+		chiCtx.URLParams.Add("userID", fmt.Sprint(id))
+		chiCtx.URLParams.Add("revision", "3")
+		req = req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, chiCtx))
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(app.revertUserChange).ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusNoContent {
+			t.Errorf("%s: expected status %d, but got %d: %s", e.name, http.StatusNoContent, rr.Code, rr.Body)
+		}
+		if user, _ := db.GetUser(context.Background(), id); user.IsAdmin != e.expectedIsAdmin {
+			t.Errorf("%s: expected is_admin to be %d, but got %d", e.name, e.expectedIsAdmin, user.IsAdmin)
+		}
+	}
+}
+
 func Test_app_refreshUsingCookie(t *testing.T) {
+	useFreshDB(t)
+
 	testUser := data.User{
 		ID:        1,
 		FirstName: "Admin",
@@ -202,7 +866,7 @@ func Test_app_refreshUsingCookie(t *testing.T) {
 		Email:     "admin@example.com",
 	}
 
-	tokens, _ := app.generateTokenPair(&testUser)
+	tokens, _ := app.generateTokenPair(context.Background(), &testUser)
 
 	testCookie := &http.Cookie{
 		Name:     "__Host-refresh_token",
@@ -228,6 +892,18 @@ func Test_app_refreshUsingCookie(t *testing.T) {
 		Secure:   true,
 	}
 
+	rotatedCookie := &http.Cookie{
+		Name:     "__Host-refresh_token",
+		Path:     "/",
+		Value:    refreshTokenWithID("rotated", refreshTokenExpiry),
+		Expires:  time.Now().Add(refreshTokenExpiry),
+		MaxAge:   int(refreshTokenExpiry.Seconds()),
+		SameSite: http.SameSiteStrictMode,
+		Domain:   "localhost",
+		HttpOnly: true,
+		Secure:   true,
+	}
+
 	var tests = []struct {
 		name           string
 		addCookie      bool
@@ -236,6 +912,7 @@ func Test_app_refreshUsingCookie(t *testing.T) {
 	}{
 		{"valid cookie", true, testCookie, http.StatusOK},
 		{"invalid cookie", true, badCookie, http.StatusBadRequest},
+		{"rotated cookie", true, rotatedCookie, http.StatusUnauthorized},
 		{"no cookie", false, nil, http.StatusUnauthorized},
 	}
 
@@ -253,8 +930,35 @@ func Test_app_refreshUsingCookie(t *testing.T) {
 	}
 }
 
+// brokenRefreshTokens is a repository which can't read refresh tokens, as if the database were down.
+type brokenRefreshTokens struct {
+	repository.DatabaseRepo
+}
+
+func (brokenRefreshTokens) GetRefreshToken(ctx context.Context, jti string) (*data.RefreshToken, error) {
+	return nil, errors.New("connection refused")
+}
+
+func Test_app_refreshUsingCookie_databaseDown(t *testing.T) {
+	db := useFreshDB(t)
+	tokens, _ := app.generateTokenPair(context.Background(), &data.User{ID: 1, Email: "admin@example.com"})
+	app.DB = brokenRefreshTokens{db}
+	t.Cleanup(func() { app.DB = db })
+
+	req, _ := http.NewRequest("GET", "/", nil)
+	req.AddCookie(&http.Cookie{Name: "__Host-refresh_token", Value: tokens.RefreshToken})
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(app.refreshUsingCookie).ServeHTTP(rr, req)
+
+	// a 401 would log the user out, when it is not their token which is at fault
+	if rr.Code != http.StatusInternalServerError {
+		t.Errorf("expected status %d, but got %d", http.StatusInternalServerError, rr.Code)
+	}
+}
+
 func Test_app_deleteRefreshCookie(t *testing.T) {
 	req, _ := http.NewRequest("GET", "/logout", nil)
+	req.AddCookie(&http.Cookie{Name: "__Host-refresh_token", Value: refreshTokenWithID("valid", refreshTokenExpiry)})
 	rr := httptest.NewRecorder()
 	handler := http.HandlerFunc(app.deleteRefreshCookie)
 
@@ -279,3 +983,48 @@ func Test_app_deleteRefreshCookie(t *testing.T) {
 	}
 
 }
+
+func Test_app_jwks(t *testing.T) {
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+
+	var tests = []struct {
+		name         string
+		key          *signing.Key
+		expectedKeys int
+	}{
+		{"hs256 is never published", signing.NewHMACKey("", []byte(app.JWTSecret)), 0},
+		{"rs256", signing.NewRSAKey("rsa-key", rsaKey), 1},
+	}
+
+	oldKeys := app.Keys
+
+	for _, e := range tests {
+		app.Keys = signing.NewKeyring(e.key)
+		req, _ := http.NewRequest("GET", "/.well-known/jwks.json", nil)
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(app.jwks)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusOK {
+			t.Errorf("%s: wrong status: expected %d but got %d", e.name, http.StatusOK, rr.Code)
+		}
+
+		var set signing.JWKS
+		err := json.NewDecoder(rr.Body).Decode(&set)
+		if err != nil {
+			t.Errorf("%s: could not decode key set: %s", e.name, err)
+		}
+
+		if len(set.Keys) != e.expectedKeys {
+			t.Errorf("%s: expected %d keys, but got %d", e.name, e.expectedKeys, len(set.Keys))
+		}
+
+		for _, k := range set.Keys {
+			if k.KeyID != e.key.ID {
+				t.Errorf("%s: expected kid %s but got %s", e.name, e.key.ID, k.KeyID)
+			}
+		}
+	}
+
+	app.Keys = oldKeys
+}
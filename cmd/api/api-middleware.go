@@ -1,14 +1,101 @@
 package main
 
-import "net/http"
+import (
+	"context"
+	"github.com/go-chi/chi/v5"
+	"net/http"
+	"regexp"
+	"strconv"
+	"testingCourserWeb/pkg/authz"
+	"testingCourserWeb/pkg/data"
+	"testingCourserWeb/pkg/repository"
+)
+
+type contextKey string
+
+const (
+	contextClaimsKey    contextKey = "claims"
+	contextRequestIDKey contextKey = "request_id"
+	contextIPKey        contextKey = "ip"
+)
+
+// requestIDHeader is the header a request id is read from, and sent back in.
+const requestIDHeader = "X-Request-ID"
+
+var (
+	errBearerTokenRequired = newPublicError("a valid bearer token is required")
+	errPermissionDenied    = newPublicError("you do not have permission to do this")
+)
+
+// validRequestID matches the request ids we accept from clients; anything else gets replaced,
+// so that nobody can put what they like into our logs.
+var validRequestID = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+// requestID gives every request an id, which is sent back in the X-Request-ID header, included in
+// error responses, and logged with errors, so that a client's report can be matched to our logs.
+// If the client (or a proxy in front of us) sent a usable id, we keep it.
+func (app *application) requestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(requestIDHeader)
+		if !validRequestID.MatchString(id) {
+			var err error
+			id, err = randomID()
+			if err != nil {
+				id = "unknown"
+			}
+		}
+
+		w.Header().Set(requestIDHeader, id)
+		ctx := context.WithValue(r.Context(), contextRequestIDKey, id)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// requestIDFromContext returns the id requestID gave the request.
+func requestIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(contextRequestIDKey).(string)
+	return id
+}
+
+// addIPToContext puts the client's ip in the request context, for the audit log and rate limits.
+// X-Forwarded-For is only believed from the proxies we were told to trust.
+func (app *application) addIPToContext(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := context.WithValue(r.Context(), contextIPKey, app.TrustedProxies.IP(r))
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// ipFromContext returns the ip addIPToContext put in the request context, if any.
+func (app *application) ipFromContext(ctx context.Context) string {
+	ip, _ := ctx.Value(contextIPKey).(string)
+	return ip
+}
+
+// requestIP returns the ip addIPToContext found for r, or works it out if r didn't go through it.
+func (app *application) requestIP(r *http.Request) string {
+	if ip := app.ipFromContext(r.Context()); ip != "" {
+		return ip
+	}
+	return app.TrustedProxies.IP(r)
+}
+
+// claimsFromContext returns the verified claims that authRequired put in the request context.
+func (app *application) claimsFromContext(ctx context.Context) (*Claims, bool) {
+	claims, ok := ctx.Value(contextClaimsKey).(*Claims)
+	return claims, ok
+}
 
 func (app *application) enableCORS(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Access-Control-Allow-Origin", "http://localhost:8090")
+		// the browser hides ETags from scripts unless told otherwise
+		w.Header().Set("Access-Control-Expose-Headers", "ETag")
 		if r.Method == "OPTIONS" {
 			w.Header().Set("Access-Control-Allow-Credentials", "true")
 			w.Header().Set("Access-Control-Allow-Methods", "GET,POST,PUT,PATCH,DELETE,OPTIONS")
-			w.Header().Set("Access-Control-Request-Headers", "Accept, Content-Type, X-CSRF-Token, Authorization")
+			// PATCH and DELETE need If-Match, so the preflight must allow it
+			w.Header().Set("Access-Control-Allow-Headers", "Accept, Content-Type, X-CSRF-Token, Authorization, If-Match, If-None-Match")
 			return
 		} else {
 			next.ServeHTTP(w, r)
@@ -18,12 +105,56 @@ func (app *application) enableCORS(next http.Handler) http.Handler {
 
 func (app *application) authRequired(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		_, _, err := app.getTokenFromHeaderAndVerify(w, r)
+		_, claims, err := app.getTokenFromHeaderAndVerify(w, r)
 		if err != nil {
-			w.WriteHeader(http.StatusUnauthorized)
+			app.audit(r, data.AuditEvent{Event: data.AuditTokenRejected, Reason: err.Error()})
+			app.errorJSON(w, r, errBearerTokenRequired, http.StatusUnauthorized)
 			return
 		}
-		next.ServeHTTP(w, r)
+		// make the verified claims available to the handlers, and record changes as made by the user
+		ctx := context.WithValue(r.Context(), contextClaimsKey, claims)
+		if id, err := strconv.Atoi(claims.Subject); err == nil {
+			ctx = repository.WithActor(ctx, id)
+		}
+		next.ServeHTTP(w, r.WithContext(ctx))
 		return
 	})
 }
+
+// requirePermission only lets through requests from users whose token grants permission. Admins
+// (users with the admin claim) may do anything. It must run after authRequired.
+func (app *application) requirePermission(permission string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			claims, ok := app.claimsFromContext(r.Context())
+			if !ok {
+				app.errorJSON(w, r, errBearerTokenRequired, http.StatusUnauthorized)
+				return
+			}
+			if !claims.Admin && !authz.Has(claims.Permissions, permission) {
+				app.errorJSON(w, r, errPermissionDenied, http.StatusForbidden)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// requireSelfOrPermission lets through requests for the user's own record (the userID url param),
+// and otherwise works like requirePermission. It must run after authRequired.
+func (app *application) requireSelfOrPermission(permission string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			claims, ok := app.claimsFromContext(r.Context())
+			if !ok {
+				app.errorJSON(w, r, errBearerTokenRequired, http.StatusUnauthorized)
+				return
+			}
+			if chi.URLParam(r, "userID") != claims.Subject && !claims.Admin && !authz.Has(claims.Permissions, permission) {
+				app.errorJSON(w, r, errPermissionDenied, http.StatusForbidden)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
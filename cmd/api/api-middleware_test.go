@@ -1,11 +1,16 @@
 package main
 
 import (
+	"context"
 	"fmt"
+	"github.com/go-chi/chi/v5"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
+	"testingCourserWeb/pkg/authz"
 	"testingCourserWeb/pkg/data"
+	"testingCourserWeb/pkg/repository"
 )
 
 func Test_app_enableCORS(t *testing.T) {
@@ -32,11 +37,63 @@ func Test_app_enableCORS(t *testing.T) {
 		if !e.expectedHeader && rr.Header().Get("Access-Control-Allow-Credentials") != "" {
 			t.Errorf("%s: expected no header, but got one", e.name)
 		}
+
+		if allowed := rr.Header().Get("Access-Control-Allow-Headers"); e.expectedHeader && !strings.Contains(allowed, "If-Match") {
+			t.Errorf("%s: expected If-Match to be allowed, but got %q", e.name, allowed)
+		}
+	}
+}
+
+func Test_app_requestID(t *testing.T) {
+	var tests = []struct {
+		name       string
+		sent       string
+		expectSame bool
+	}{
+		{"none sent", "", false},
+		{"valid", "abc-123.def_456", true},
+		{"too long", strings.Repeat("a", 65), false},
+		{"unsafe characters", "abc\ninjected log line", false},
+	}
+
+	for _, e := range tests {
+		var fromContext string
+		nextHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			fromContext = requestIDFromContext(r.Context())
+		})
+
+		req := httptest.NewRequest("GET", "http://testing", nil)
+		if e.sent != "" {
+			req.Header.Set("X-Request-ID", e.sent)
+		}
+		rr := httptest.NewRecorder()
+		app.requestID(nextHandler).ServeHTTP(rr, req)
+
+		got := rr.Header().Get("X-Request-ID")
+		if got == "" || got != fromContext {
+			t.Errorf("%s: expected the same request id in the header and context, but got %q and %q", e.name, got, fromContext)
+		}
+		if e.expectSame && got != e.sent {
+			t.Errorf("%s: expected request id %q to be kept, but got %q", e.name, e.sent, got)
+		}
+		if !e.expectSame && got == e.sent {
+			t.Errorf("%s: expected request id %q to be replaced", e.name, e.sent)
+		}
 	}
 }
 
 func Test_app_authRequired(t *testing.T) {
-	nextHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	nextHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// authRequired must pass the verified claims on
+		claims, ok := app.claimsFromContext(r.Context())
+		if !ok || claims.Subject != "1" {
+			t.Error("claims not found in request context")
+		}
+		// and whatever the handlers change is changed by the user
+		if actor, ok := repository.ActorFrom(r.Context()); !ok || actor != 1 {
+			t.Errorf("expected the actor to be user 1, but got %d, %v", actor, ok)
+		}
+	})
 	testUser := data.User{
 		ID:        1,
 		FirstName: "Admin",
@@ -44,7 +101,7 @@ func Test_app_authRequired(t *testing.T) {
 		Email:     "admin@example.com",
 	}
 
-	tokens, _ := app.generateTokenPair(&testUser)
+	tokens, _ := app.generateTokenPair(context.Background(), &testUser)
 
 	var tests = []struct {
 		name             string
@@ -73,3 +130,66 @@ func Test_app_authRequired(t *testing.T) {
 		}
 	}
 }
+
+func Test_app_requirePermission(t *testing.T) {
+	nextHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+
+	var tests = []struct {
+		name           string
+		addClaims      bool
+		admin          bool
+		permissions    []string
+		expectedStatus int
+	}{
+		{"admin", true, true, nil, http.StatusOK},
+		{"has permission", true, false, []string{authz.UsersRead, authz.UsersDelete}, http.StatusOK},
+		{"missing permission", true, false, []string{authz.UsersRead}, http.StatusForbidden},
+		{"no claims", false, false, nil, http.StatusUnauthorized},
+	}
+
+	for _, e := range tests {
+		req, _ := http.NewRequest("DELETE", "/", nil)
+		if e.addClaims {
+			req = addClaimsToRequest(req, "1", e.admin, e.permissions...)
+		}
+		rr := httptest.NewRecorder()
+		handlerToTest := app.requirePermission(authz.UsersDelete)(nextHandler)
+		handlerToTest.ServeHTTP(rr, req)
+		if rr.Code != e.expectedStatus {
+			t.Errorf("%s: expected status %d, but got %d", e.name, e.expectedStatus, rr.Code)
+		}
+	}
+}
+
+func Test_app_requireSelfOrPermission(t *testing.T) {
+	nextHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+
+	var tests = []struct {
+		name           string
+		subject        string
+		admin          bool
+		permissions    []string
+		paramID        string
+		expectedStatus int
+	}{
+		{"own record", "2", false, nil, "2", http.StatusOK},
+		{"someone else's record", "2", false, nil, "1", http.StatusForbidden},
+		{"someone else's record with permission", "2", false, []string{authz.UsersRead}, "1", http.StatusOK},
+		{"admin", "1", true, nil, "2", http.StatusOK},
+	}
+
+	for _, e := range tests {
+		req, _ := http.NewRequest("GET", "/", nil)
+		req = addClaimsToRequest(req, e.subject, e.admin, e.permissions...)
+		chiCtx := chi.NewRouteContext()
+		chiCtx.URLParams.Add("userID", e.paramID)
+		req = req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, chiCtx))
+
+		rr := httptest.NewRecorder()
+		handlerToTest := app.requireSelfOrPermission(authz.UsersRead)(nextHandler)
+		handlerToTest.ServeHTTP(rr, req)
+		if rr.Code != e.expectedStatus {
+			t.Errorf("%s: expected status %d, but got %d", e.name, e.expectedStatus, rr.Code)
+		}
+	}
+}
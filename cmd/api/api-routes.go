@@ -4,31 +4,79 @@ import (
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
 	"net/http"
+	"testingCourserWeb/pkg/authz"
 )
 
 func (app *application) routes() http.Handler {
 	mux := chi.NewRouter()
 	//register middleware
+	mux.Use(app.requestID)
+	mux.Use(app.addIPToContext)
 	mux.Use(middleware.Recoverer)
 	mux.Use(app.enableCORS)
+	mux.NotFound(func(w http.ResponseWriter, r *http.Request) {
+		app.errorJSON(w, r, newPublicError("no such resource"), http.StatusNotFound)
+	})
+	mux.MethodNotAllowed(func(w http.ResponseWriter, r *http.Request) {
+		app.errorJSON(w, r, newPublicError("method %s is not allowed here", r.Method), http.StatusMethodNotAllowed)
+	})
+
+	// rate limits: logging in is limited by ip, and everything else by who the token is for
+	authLimit := app.rateLimit("auth", app.RateLimits.Auth, app.keyByIP)
+	usersLimit := app.rateLimit("users", app.RateLimits.Users, app.keyBySubject, app.keyByAPIKey, app.keyByIP)
+	exportLimit := app.rateLimit("export", app.RateLimits.Export, app.keyBySubject, app.keyByAPIKey, app.keyByIP)
 
 	// authentication routes - auth handler, refresh
-	mux.Post("/auth", app.authenticate)
-	mux.Post("/refresh-token", app.refresh)
+	mux.With(authLimit).Post("/auth", app.authenticate)
+	mux.With(authLimit).Post("/auth/mfa", app.authenticateMFA)
+	mux.With(authLimit).Post("/refresh-token", app.refresh)
+	mux.With(authLimit).Post("/password/forgot", app.forgotPassword)
+	mux.With(authLimit).Post("/password/reset", app.resetPassword)
+	mux.Get("/.well-known/jwks.json", app.jwks)
 	mux.Handle("/", http.StripPrefix("/", http.FileServer(http.Dir("./html"))))
 	mux.Route("/web", func(mux chi.Router) {
+		mux.Use(authLimit)
 		mux.Post("/auth", app.authenticate)
+		mux.Post("/auth/mfa", app.authenticateMFA)
 		mux.Get("/refresh-token", app.refreshUsingCookie)
 		mux.Get("/logout", app.deleteRefreshCookie)
 	})
 	//protected routes
 	mux.Route("/users", func(mux chi.Router) {
 		mux.Use(app.authRequired)
-		mux.Get("/", app.allUsers)
-		mux.Get("/{userID}", app.getUser)
-		mux.Delete("/{userID}", app.deleteUser)
-		mux.Put("/", app.insertUser)
+		mux.Use(usersLimit)
+		mux.With(app.requirePermission(authz.UsersRead)).Get("/", app.allUsers)
+		mux.With(app.requirePermission(authz.UsersRead)).Get("/search", app.searchUsers)
+		mux.With(app.requireSelfOrPermission(authz.UsersRead)).Get("/{userID}", app.getUser)
+		mux.With(app.requirePermission(authz.UsersDelete)).Delete("/{userID}", app.deleteUser)
+		// deleting is soft; whoever may delete users may also see, deactivate and restore them
+		mux.With(app.requirePermission(authz.UsersDelete)).Get("/deleted", app.deletedUsers)
+		mux.With(app.requirePermission(authz.UsersDelete)).Post("/{userID}/deactivate", app.deactivateUser)
+		mux.With(app.requirePermission(authz.UsersDelete)).Post("/{userID}/restore", app.restoreUser)
+		mux.With(app.requirePermission(authz.UsersRead)).Get("/{userID}/history", app.userHistory)
+		// a revert may change anything about a user, including whether they are deleted
+		mux.With(app.requirePermission(authz.UsersUpdate), app.requirePermission(authz.UsersDelete)).Post("/{userID}/history/{revision}/revert", app.revertUserChange)
+		mux.With(app.requirePermission(authz.UsersCreate)).Put("/", app.insertUser)
+		// users may patch their own record without users:update; updateUser checks
 		mux.Patch("/", app.updateUser)
+		mux.With(app.requireSelfOrPermission(authz.UsersUpdate)).Patch("/{userID}", app.patchUser)
+	})
+	// users set up their own two-factor authentication
+	mux.Route("/mfa", func(mux chi.Router) {
+		mux.Use(app.authRequired)
+		mux.Use(usersLimit)
+		mux.Get("/", app.getMFA)
+		mux.Post("/enroll", app.enrollMFA)
+		mux.Post("/confirm", app.confirmMFA)
+		mux.Post("/recovery-codes", app.regenerateRecoveryCodes)
+		mux.Post("/disable", app.disableMFA)
+	})
+	mux.Route("/audit", func(mux chi.Router) {
+		mux.Use(app.authRequired)
+		mux.Use(app.requirePermission(authz.AuditRead))
+		mux.Use(usersLimit)
+		mux.Get("/", app.auditEvents)
+		mux.With(exportLimit).Get("/export", app.exportAuditEvents)
 	})
 
 	return mux
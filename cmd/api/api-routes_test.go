@@ -3,6 +3,7 @@ package main
 import (
 	"github.com/go-chi/chi/v5"
 	"net/http"
+	"net/http/httptest"
 	"strings"
 	"testing"
 )
@@ -13,12 +14,30 @@ func Test_app_routes(t *testing.T) {
 		method string
 	}{
 		{"/auth", "POST"},
+		{"/auth/mfa", "POST"},
 		{"/refresh-token", "POST"},
+		{"/password/forgot", "POST"},
+		{"/password/reset", "POST"},
+		{"/.well-known/jwks.json", "GET"},
 		{"/users/", "GET"},
+		{"/users/search", "GET"},
 		{"/users/{userID}", "GET"},
 		{"/users/{userID}", "DELETE"},
+		{"/users/deleted", "GET"},
+		{"/users/{userID}/deactivate", "POST"},
+		{"/users/{userID}/restore", "POST"},
+		{"/users/{userID}/history", "GET"},
+		{"/users/{userID}/history/{revision}/revert", "POST"},
 		{"/users/", "PATCH"},
+		{"/users/{userID}", "PATCH"},
 		{"/users/", "PUT"},
+		{"/audit/", "GET"},
+		{"/audit/export", "GET"},
+		{"/mfa/", "GET"},
+		{"/mfa/enroll", "POST"},
+		{"/mfa/confirm", "POST"},
+		{"/mfa/recovery-codes", "POST"},
+		{"/mfa/disable", "POST"},
 	}
 
 	mux := app.routes()
@@ -34,6 +53,37 @@ func Test_app_routes(t *testing.T) {
 
 }
 
+func Test_app_routes_problems(t *testing.T) {
+	var tests = []struct {
+		name           string
+		method         string
+		url            string
+		expectedStatus int
+	}{
+		{"not found", "GET", "/fish", http.StatusNotFound},
+		{"method not allowed", "DELETE", "/auth", http.StatusMethodNotAllowed},
+		{"no token", "GET", "/users/", http.StatusUnauthorized},
+	}
+
+	mux := app.routes()
+
+	for _, e := range tests {
+		req := httptest.NewRequest(e.method, e.url, nil)
+		rr := httptest.NewRecorder()
+		mux.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatus {
+			t.Errorf("%s: expected status %d, but got %d", e.name, e.expectedStatus, rr.Code)
+		}
+		if rr.Header().Get("Content-Type") != "application/problem+json" {
+			t.Errorf("%s: expected a problem response, but got content type %q", e.name, rr.Header().Get("Content-Type"))
+		}
+		if rr.Header().Get("X-Request-ID") == "" {
+			t.Errorf("%s: expected an X-Request-ID header", e.name)
+		}
+	}
+}
+
 func routeExists(testRoute, testMethod string, chiRoutes chi.Routes) bool {
 	found := false
 	_ = chi.Walk(chiRoutes, func(method string, route string, handler http.Handler,
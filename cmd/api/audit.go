@@ -0,0 +1,175 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"testingCourserWeb/pkg/data"
+	"testingCourserWeb/pkg/repository"
+	"time"
+)
+
+// audit records an authentication event in the security audit log, along with where the request
+// came from. A request should not fail because we could not record it, so errors are only logged.
+func (app *application) audit(r *http.Request, e data.AuditEvent) {
+	e.IP = app.requestIP(r)
+	e.UserAgent = r.UserAgent()
+	e.RequestID = requestIDFromContext(r.Context())
+
+	_, err := app.DB.InsertAuditEvent(r.Context(), e)
+	if err != nil {
+		log.Printf("request %s: could not record %s event: %s", e.RequestID, e.Event, err)
+	}
+}
+
+// subjectID returns the user id in claims, if there is one.
+func subjectID(claims *Claims) *int {
+	if claims == nil {
+		return nil
+	}
+	id, err := strconv.Atoi(claims.Subject)
+	if err != nil {
+		return nil
+	}
+	return &id
+}
+
+// auditEvents sends back a page of the audit log, newest first. The query string may filter by
+// event, user_id, email, ip, and since and until (RFC 3339 timestamps), and may set limit, and
+// before, the next_before of the previous page.
+func (app *application) auditEvents(w http.ResponseWriter, r *http.Request) {
+	f, err := auditFilterFromQuery(r.URL.Query())
+	if err != nil {
+		app.errorJSON(w, r, err, http.StatusBadRequest)
+		return
+	}
+
+	events, page, err := app.DB.ListAuditEvents(r.Context(), f)
+	if err != nil {
+		app.dbErrorJSON(w, r, err)
+		return
+	}
+	if events == nil {
+		events = []*data.AuditEvent{}
+	}
+
+	type auditPage struct {
+		Data       []*data.AuditEvent   `json:"data"`
+		Pagination repository.AuditPage `json:"pagination"`
+	}
+
+	_ = app.writeJSON(w, http.StatusOK, auditPage{Data: events, Pagination: page})
+}
+
+// exportAuditEvents streams every audit event the query string picks as NDJSON, one event per
+// line, newest first. It takes the same filters as auditEvents, but reads the whole log, a page
+// at a time, rather than one page of it.
+func (app *application) exportAuditEvents(w http.ResponseWriter, r *http.Request) {
+	f, err := auditFilterFromQuery(r.URL.Query())
+	if err != nil {
+		app.errorJSON(w, r, err, http.StatusBadRequest)
+		return
+	}
+	f.Limit = repository.MaxAuditLimit
+
+	// fetch the first page before sending anything, so that we can still send an error
+	events, page, err := app.DB.ListAuditEvents(r.Context(), f)
+	if err != nil {
+		app.dbErrorJSON(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.Header().Set("Content-Disposition", `attachment; filename="audit.ndjson"`)
+	w.WriteHeader(http.StatusOK)
+
+	enc := json.NewEncoder(w)
+	flusher, _ := w.(http.Flusher)
+	for {
+		for _, e := range events {
+			if err := enc.Encode(e); err != nil {
+				// the client has gone away
+				return
+			}
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+		if !page.HasMore {
+			return
+		}
+
+		f.Before = page.NextBefore
+		events, page, err = app.DB.ListAuditEvents(r.Context(), f)
+		if err != nil {
+			// too late to tell the client; the export just stops short
+			log.Printf("request %s: exporting audit events: %s", requestIDFromContext(r.Context()), err)
+			return
+		}
+	}
+}
+
+// auditFilterFromQuery reads the filter for the audit log from a query string.
+func auditFilterFromQuery(q url.Values) (repository.AuditFilter, error) {
+	f := repository.AuditFilter{
+		Event: q.Get("event"),
+		Email: q.Get("email"),
+		IP:    q.Get("ip"),
+	}
+
+	var err error
+	if f.Limit, err = queryInt(q, "limit"); err != nil {
+		return f, err
+	}
+	if f.Before, err = queryInt(q, "before"); err != nil {
+		return f, err
+	}
+	if v := q.Get("user_id"); v != "" {
+		id, err := queryInt(q, "user_id")
+		if err != nil {
+			return f, err
+		}
+		f.UserID = &id
+	}
+	if f.Since, err = queryTime(q, "since"); err != nil {
+		return f, err
+	}
+	if f.Until, err = queryTime(q, "until"); err != nil {
+		return f, err
+	}
+
+	// the filter is checked by the repository too, so these messages are our own
+	err = f.Normalize()
+	if err != nil {
+		return f, &publicError{message: err.Error()}
+	}
+	return f, nil
+}
+
+// queryInt reads the number in a query parameter; a missing parameter is 0.
+func queryInt(q url.Values, name string) (int, error) {
+	v := q.Get(name)
+	if v == "" {
+		return 0, nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		return 0, fieldErrors{name: "must be a number"}
+	}
+	return n, nil
+}
+
+// queryTime reads the RFC 3339 timestamp in a query parameter; a missing parameter is nil.
+func queryTime(q url.Values, name string) (*time.Time, error) {
+	v := q.Get(name)
+	if v == "" {
+		return nil, nil
+	}
+	t, err := time.Parse(time.RFC3339, v)
+	if err != nil {
+		return nil, fieldErrors{name: "must be an RFC 3339 timestamp"}
+	}
+	return &t, nil
+}
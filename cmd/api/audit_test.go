@@ -0,0 +1,162 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"testingCourserWeb/pkg/clientip"
+	"testingCourserWeb/pkg/data"
+	"testingCourserWeb/pkg/repository"
+	"time"
+)
+
+func Test_app_audit_authentication(t *testing.T) {
+	db := useFreshDB(t)
+	ctx := context.Background()
+	mux := app.routes()
+	// the requests come through proxies we trust, so the address they forwarded is recorded
+	app.TrustedProxies, _ = clientip.ParseProxies("192.0.2.1,192.168.0.0/16")
+	t.Cleanup(func() { app.TrustedProxies = nil })
+
+	login := func(password string) {
+		req := httptest.NewRequest("POST", "/auth", strings.NewReader(`{"email": "admin@example.com", "password": "`+password+`"}`))
+		req.Header.Set("User-Agent", "audit-test")
+		req.Header.Set("X-Forwarded-For", "10.0.0.1, 192.168.0.1")
+		mux.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	login("wrong")
+	login("secret")
+
+	tokens, _ := app.generateTokenPair(ctx, &data.User{ID: 1, Email: "admin@example.com"})
+	req := httptest.NewRequest("GET", "/web/refresh-token", nil)
+	req.AddCookie(&http.Cookie{Name: "__Host-refresh_token", Value: tokens.RefreshToken})
+	mux.ServeHTTP(httptest.NewRecorder(), req)
+
+	req = httptest.NewRequest("GET", "/users/", nil)
+	req.Header.Set("Authorization", "Basic YWRtaW46c2VjcmV0")
+	mux.ServeHTTP(httptest.NewRecorder(), req)
+
+	req = httptest.NewRequest("GET", "/web/logout", nil)
+	req.AddCookie(&http.Cookie{Name: "__Host-refresh_token", Value: tokens.RefreshToken})
+	mux.ServeHTTP(httptest.NewRecorder(), req)
+
+	events, _, _ := db.ListAuditEvents(ctx, repository.AuditFilter{})
+	var got []string
+	for i := len(events) - 1; i >= 0; i-- {
+		got = append(got, events[i].Event+" "+events[i].Reason)
+	}
+	expected := []string{
+		"login_failed wrong password",
+		"login_succeeded ",
+		"refresh ",
+		"token_rejected unauthorized: no Bearer",
+		"logout ",
+	}
+	if strings.Join(got, "; ") != strings.Join(expected, "; ") {
+		t.Fatalf("expected events %q, but got %q", expected, got)
+	}
+
+	if e := events[len(events)-2]; e.UserID == nil || *e.UserID != 1 || e.Email != "admin@example.com" || e.IP != "10.0.0.1" ||
+		e.UserAgent != "audit-test" || e.RequestID == "" {
+		t.Errorf("expected the login to record who and where, but got %+v", e)
+	}
+	if logout := events[0]; logout.UserID == nil || *logout.UserID != 1 || logout.IP != "192.0.2.1" {
+		t.Errorf("expected the logout to record who and where, but got %+v", logout)
+	}
+}
+
+func Test_app_auditEvents(t *testing.T) {
+	db := useFreshDB(t)
+	ctx := context.Background()
+	for _, event := range []string{data.AuditLoginFailed, data.AuditLoginSucceeded, data.AuditLogout} {
+		_, _ = db.InsertAuditEvent(ctx, data.AuditEvent{Event: event, IP: "10.0.0.1"})
+	}
+
+	var tests = []struct {
+		name           string
+		query          string
+		expectedStatus int
+		expectedEvents int
+		expectedMore   bool
+	}{
+		{"everything", "", http.StatusOK, 3, false},
+		{"a page", "?limit=2", http.StatusOK, 2, true},
+		{"the next page", "?limit=2&before=2", http.StatusOK, 1, false},
+		{"by event", "?event=logout", http.StatusOK, 1, false},
+		{"by user", "?user_id=1", http.StatusOK, 0, false},
+		{"since", "?since=" + time.Now().Add(time.Hour).Format(time.RFC3339), http.StatusOK, 0, false},
+		{"unknown event", "?event=login", http.StatusBadRequest, 0, false},
+		{"bad limit", "?limit=ten", http.StatusBadRequest, 0, false},
+		{"limit too big", "?limit=1001", http.StatusBadRequest, 0, false},
+		{"bad user", "?user_id=me", http.StatusBadRequest, 0, false},
+		{"bad time", "?until=yesterday", http.StatusBadRequest, 0, false},
+	}
+
+	for _, e := range tests {
+		req, _ := http.NewRequest("GET", "/audit/"+e.query, nil)
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(app.auditEvents).ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatus {
+			t.Errorf("%s: expected status %d, but got %d: %s", e.name, e.expectedStatus, rr.Code, rr.Body)
+			continue
+		}
+		if rr.Code != http.StatusOK {
+			continue
+		}
+
+		var got struct {
+			Data       []data.AuditEvent    `json:"data"`
+			Pagination repository.AuditPage `json:"pagination"`
+		}
+		_ = json.NewDecoder(rr.Body).Decode(&got)
+		if len(got.Data) != e.expectedEvents || got.Pagination.HasMore != e.expectedMore {
+			t.Errorf("%s: expected %d events, and more %t, but got %d and %+v", e.name, e.expectedEvents, e.expectedMore, len(got.Data), got.Pagination)
+		}
+	}
+}
+
+func Test_app_exportAuditEvents(t *testing.T) {
+	db := useFreshDB(t)
+	ctx := context.Background()
+	// more than one page
+	for i := 0; i < repository.MaxAuditLimit+5; i++ {
+		_, _ = db.InsertAuditEvent(ctx, data.AuditEvent{Event: data.AuditLoginFailed, IP: "10.0.0.1"})
+	}
+	_, _ = db.InsertAuditEvent(ctx, data.AuditEvent{Event: data.AuditLogout, IP: "10.0.0.2"})
+
+	req, _ := http.NewRequest("GET", "/audit/export?event=login_failed", nil)
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(app.exportAuditEvents).ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK || rr.Header().Get("Content-Type") != "application/x-ndjson" {
+		t.Fatalf("expected an NDJSON export, but got %d %q", rr.Code, rr.Header().Get("Content-Type"))
+	}
+
+	lines, last := 0, 0
+	scanner := bufio.NewScanner(rr.Body)
+	for scanner.Scan() {
+		var e data.AuditEvent
+		err := json.Unmarshal(scanner.Bytes(), &e)
+		if err != nil || e.Event != data.AuditLoginFailed || (last != 0 && e.ID >= last) {
+			t.Fatalf("line %d: expected a failed login, older than the last, but got %s, %v", lines+1, scanner.Text(), err)
+		}
+		last = e.ID
+		lines++
+	}
+	if lines != repository.MaxAuditLimit+5 {
+		t.Errorf("expected %d events, but got %d", repository.MaxAuditLimit+5, lines)
+	}
+
+	req, _ = http.NewRequest("GET", "/audit/export?since=today", nil)
+	rr = httptest.NewRecorder()
+	http.HandlerFunc(app.exportAuditEvents).ServeHTTP(rr, req)
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("expected status %d for a bad filter, but got %d", http.StatusBadRequest, rr.Code)
+	}
+}
@@ -1,25 +1,39 @@
 package main
 
 import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"github.com/golang-jwt/jwt/v4"
 	"net/http"
+	"strconv"
 	"strings"
 	"testingCourserWeb/pkg/data"
+	"testingCourserWeb/pkg/repository"
 	"time"
 )
 
 var jwtTokenExpiry = time.Minute * 15
 var refreshTokenExpiry = time.Hour * 24
 
+var (
+	errRefreshTokenUnknown = newPublicError("unknown refresh token")
+	errRefreshTokenReused  = newPublicError("refresh token has already been used")
+	errAccountDeactivated  = newPublicError("this account has been deactivated")
+)
+
 type TokenPairs struct {
 	Token        string `json:"access_token"`
 	RefreshToken string `json:"refresh_token"`
 }
 
 type Claims struct {
-	UserName string `json:"name"`
+	UserName    string   `json:"name"`
+	Admin       bool     `json:"admin"`
+	Roles       []string `json:"roles,omitempty"`
+	Permissions []string `json:"perms,omitempty"`
 	jwt.RegisteredClaims
 }
 
@@ -53,15 +67,9 @@ func (app *application) getTokenFromHeaderAndVerify(w http.ResponseWriter, r *ht
 	// declare an empty claims variable
 	claims := &Claims{}
 
-	//parse the token with our claims, using our secret (from the receiver)
+	//parse the token with our claims, using our signing key (from the receiver)
 
-	_, err := jwt.ParseWithClaims(token, claims, func(token *jwt.Token) (interface{}, error) {
-		//validate the signing algorithm
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
-		}
-		return []byte(app.JWTSecret), nil
-	})
+	_, err := jwt.ParseWithClaims(token, claims, app.keyFunc)
 
 	//check for an error; note that this catches expired tokens as well.
 	if err != nil {
@@ -74,17 +82,50 @@ func (app *application) getTokenFromHeaderAndVerify(w http.ResponseWriter, r *ht
 	if claims.Issuer != app.Domain {
 		return "", nil, errors.New("incorrect issuer")
 	}
+	// and that it is an access token, and not, say, an mfa challenge
+	if !claims.VerifyAudience(app.Domain, true) {
+		return "", nil, errors.New("incorrect audience")
+	}
 
 	//valid token
 	return token, claims, nil
 
 }
 
-func (app *application) generateTokenPair(user *data.User) (TokenPairs, error) {
-	// create the token
-	token := jwt.New(jwt.SigningMethodHS256)
+// keyFunc returns the key to verify a token with. The kid header picks the key from our keyring
+// (tokens issued before we had key ids are checked against the active key), and the token must be
+// signed with that key's algorithm, so that nobody can, say, sign an HS256 token using our public
+// RSA key as the secret.
+func (app *application) keyFunc(token *jwt.Token) (interface{}, error) {
+	key := app.Keys.Active()
+	if kid, ok := token.Header["kid"].(string); ok {
+		key, ok = app.Keys.Lookup(kid)
+		if !ok {
+			return nil, fmt.Errorf("unknown signing key: %s", kid)
+		}
+	}
+
+	//validate the signing algorithm
+	if token.Method.Alg() != key.Algorithm() {
+		return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+	}
+	return key.VerifyingKey(), nil
+}
+
+// generateTokenPair issues an access token and a refresh token for user, starting a new refresh token family.
+func (app *application) generateTokenPair(ctx context.Context, user *data.User) (TokenPairs, error) {
+	familyID, err := randomID()
+	if err != nil {
+		return TokenPairs{}, err
+	}
+	return app.generateTokenPairInFamily(ctx, app.DB, user, familyID)
+}
+
+// generateTokenPairInFamily issues an access token and a refresh token for user. The refresh token
+// is stored in repo as part of the given family, so that it can be rotated and revoked.
+func (app *application) generateTokenPairInFamily(ctx context.Context, repo repository.DatabaseRepo, user *data.User, familyID string) (TokenPairs, error) {
 	// set claims
-	claims := token.Claims.(jwt.MapClaims)
+	claims := jwt.MapClaims{}
 	claims["name"] = fmt.Sprintf("%s %s", user.FirstName, user.LastName)
 	claims["sub"] = fmt.Sprint(user.ID)
 	claims["aud"] = app.Domain
@@ -95,27 +136,146 @@ func (app *application) generateTokenPair(user *data.User) (TokenPairs, error) {
 		claims["admin"] = false
 	}
 
+	// embed the user's roles, and the permissions they grant
+	roles, err := repo.GetUserRoles(ctx, user.ID)
+	if err != nil {
+		return TokenPairs{}, err
+	}
+	permissions, err := repo.GetUserPermissions(ctx, user.ID)
+	if err != nil {
+		return TokenPairs{}, err
+	}
+	claims["roles"] = roles
+	claims["perms"] = permissions
+
 	// set the expiry
 	claims["exp"] = time.Now().Add(jwtTokenExpiry).Unix()
 	// create the signed token
-	signedAccessToken, err := token.SignedString([]byte(app.JWTSecret))
+	signedAccessToken, err := app.Keys.Active().Sign(claims)
+	if err != nil {
+		return TokenPairs{}, err
+	}
+
+	// every refresh token gets a unique id, so we can look it up later
+	jti, err := randomID()
 	if err != nil {
 		return TokenPairs{}, err
 	}
+	refreshExpiry := time.Now().Add(refreshTokenExpiry)
+
 	// create teh refresh token
-	refreshToken := jwt.New(jwt.SigningMethodHS256)
-	refreshTokenClaims := refreshToken.Claims.(jwt.MapClaims)
+	refreshTokenClaims := jwt.MapClaims{}
 	refreshTokenClaims["sub"] = fmt.Sprint(user.ID)
+	refreshTokenClaims["jti"] = jti
 	// set expiry; must be longer that hwt expiry
-	refreshTokenClaims["exp"] = time.Now().Add(refreshTokenExpiry).Unix()
+	refreshTokenClaims["exp"] = refreshExpiry.Unix()
 	//create signed refresh token
-	signedRefreshToken, err := refreshToken.SignedString([]byte(app.JWTSecret))
+	signedRefreshToken, err := app.Keys.Active().Sign(refreshTokenClaims)
 	if err != nil {
 		return TokenPairs{}, err
 	}
+
+	// store the refresh token, so that it can be rotated and revoked
+	_, err = repo.InsertRefreshToken(ctx, data.RefreshToken{
+		UserID:    user.ID,
+		FamilyID:  familyID,
+		JTI:       jti,
+		ExpiresAt: refreshExpiry,
+	})
+	if err != nil {
+		return TokenPairs{}, err
+	}
+
 	var tokenPairs = TokenPairs{
 		Token:        signedAccessToken,
 		RefreshToken: signedRefreshToken,
 	}
 	return tokenPairs, nil
 }
+
+// rotateRefreshToken checks a parsed refresh token against the database, revokes it, and issues a new
+// token pair in the same family. If the token has already been rotated or revoked, someone is replaying
+// it (most likely because it was stolen), so we revoke every token in the family.
+func (app *application) rotateRefreshToken(ctx context.Context, claims *Claims) (TokenPairs, error) {
+	stored, err := app.DB.GetRefreshToken(ctx, claims.ID)
+	if errors.Is(err, repository.ErrNotFound) {
+		return TokenPairs{}, errRefreshTokenUnknown
+	}
+	if err != nil {
+		return TokenPairs{}, err
+	}
+
+	if stored.Revoked {
+		_ = app.DB.RevokeRefreshTokenFamily(ctx, stored.FamilyID)
+		return TokenPairs{}, errRefreshTokenReused
+	}
+
+	if stored.IsExpired() || fmt.Sprint(stored.UserID) != claims.Subject {
+		return TokenPairs{}, errRefreshTokenUnknown
+	}
+
+	// get the user id from the claims
+	userID, err := strconv.Atoi(claims.Subject)
+	if err != nil {
+		return TokenPairs{}, errRefreshTokenUnknown
+	}
+	user, err := app.DB.GetUser(ctx, userID)
+	if errors.Is(err, repository.ErrNotFound) {
+		return TokenPairs{}, errRefreshTokenUnknown
+	}
+	if err != nil {
+		return TokenPairs{}, err
+	}
+	// deactivating a user revokes their tokens, but a token may have been issued since
+	if user.DisabledAt != nil {
+		return TokenPairs{}, errAccountDeactivated
+	}
+
+	// the presented token can never be used again. Only one refresh can revoke it, so if another
+	// one racing with this one got there first, the token is being replayed after all.
+	var tokens TokenPairs
+	err = app.DB.WithTx(ctx, func(repo repository.DatabaseRepo) error {
+		revoked, err := repo.RevokeRefreshToken(ctx, stored.JTI)
+		if err != nil {
+			return err
+		}
+		if revoked == 0 {
+			return errRefreshTokenReused
+		}
+		tokens, err = app.generateTokenPairInFamily(ctx, repo, user, stored.FamilyID)
+		return err
+	})
+	if errors.Is(err, errRefreshTokenReused) {
+		_ = app.DB.RevokeRefreshTokenFamily(ctx, stored.FamilyID)
+	}
+	if err != nil {
+		return TokenPairs{}, err
+	}
+	return tokens, nil
+}
+
+// revokeRefreshToken revokes the family of a refresh token, if we can parse it and know about it.
+// It returns the token's claims, if it could be parsed, so that the caller knows whose it was.
+func (app *application) revokeRefreshToken(ctx context.Context, refreshToken string) (*Claims, error) {
+	claims := &Claims{}
+	_, err := jwt.ParseWithClaims(refreshToken, claims, app.keyFunc)
+	if err != nil {
+		return nil, err
+	}
+
+	stored, err := app.DB.GetRefreshToken(ctx, claims.ID)
+	if err != nil {
+		return claims, err
+	}
+	return claims, app.DB.RevokeRefreshTokenFamily(ctx, stored.FamilyID)
+}
+
+// randomID returns a random, url safe identifier, used for refresh token ids and families.
+func randomID() (string, error) {
+	b := make([]byte, 16)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -1,11 +1,18 @@
 package main
 
 import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
 	"fmt"
+	"github.com/golang-jwt/jwt/v4"
 	"net/http"
 	"net/http/httptest"
 	"testing"
 	"testingCourserWeb/pkg/data"
+	"testingCourserWeb/pkg/signing"
+	"time"
 )
 
 func Test_app_getTokenFromHeaderAndVerify(t *testing.T) {
@@ -16,7 +23,7 @@ func Test_app_getTokenFromHeaderAndVerify(t *testing.T) {
 		Email:     "admin@example.com",
 	}
 
-	tokens, _ := app.generateTokenPair(&testUser)
+	tokens, _ := app.generateTokenPair(context.Background(), &testUser)
 
 	var tests = []struct {
 		name          string
@@ -39,7 +46,7 @@ func Test_app_getTokenFromHeaderAndVerify(t *testing.T) {
 
 		if e.issuer != app.Domain {
 			app.Domain = e.issuer
-			tokens, _ = app.generateTokenPair(&testUser)
+			tokens, _ = app.generateTokenPair(context.Background(), &testUser)
 		}
 
 		req, _ := http.NewRequest("GET", "/", nil)
@@ -62,3 +69,169 @@ func Test_app_getTokenFromHeaderAndVerify(t *testing.T) {
 	}
 
 }
+
+func Test_app_getTokenFromHeaderAndVerify_signingKeys(t *testing.T) {
+	testUser := data.User{
+		ID:        1,
+		FirstName: "Admin",
+		LastName:  "User",
+		Email:     "admin@example.com",
+	}
+
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	otherRSAKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	_, edKey, _ := ed25519.GenerateKey(rand.Reader)
+
+	claims := jwt.MapClaims{
+		"sub": "1",
+		"iss": app.Domain,
+		"aud": app.Domain,
+		"exp": time.Now().Add(time.Minute).Unix(),
+	}
+
+	// a token signed with HS256, using the public RSA key as the secret
+	confused := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	confused.Header["kid"] = signing.NewRSAKey("", rsaKey).ID
+	confusedToken, _ := confused.SignedString(rsaKey.PublicKey.N.Bytes())
+
+	// a token signed by somebody else's key
+	foreignToken, _ := signing.NewRSAKey("", otherRSAKey).Sign(claims)
+
+	// a token signed by somebody else's key, claiming to be ours
+	impostorToken, _ := signing.NewRSAKey(signing.NewRSAKey("", rsaKey).ID, otherRSAKey).Sign(claims)
+
+	var tests = []struct {
+		name          string
+		key           *signing.Key
+		token         string
+		errorExpected bool
+	}{
+		{"hs256", signing.NewHMACKey("", []byte(app.JWTSecret)), "", false},
+		{"rs256", signing.NewRSAKey("", rsaKey), "", false},
+		{"eddsa", signing.NewEdDSAKey("", edKey), "", false},
+		{"algorithm confusion", signing.NewRSAKey("", rsaKey), confusedToken, true},
+		{"unknown kid", signing.NewRSAKey("", rsaKey), foreignToken, true},
+		{"wrong key for kid", signing.NewRSAKey("", rsaKey), impostorToken, true},
+	}
+
+	oldKeys := app.Keys
+
+	for _, e := range tests {
+		app.Keys = signing.NewKeyring(e.key)
+
+		token := e.token
+		if token == "" {
+			tokens, _ := app.generateTokenPair(context.Background(), &testUser)
+			token = tokens.Token
+		}
+
+		req, _ := http.NewRequest("GET", "/", nil)
+		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
+
+		rr := httptest.NewRecorder()
+		_, _, err := app.getTokenFromHeaderAndVerify(rr, req)
+		if err != nil && !e.errorExpected {
+			t.Errorf("%s did not expect error, but got one - %s", e.name, err.Error())
+		}
+
+		if err == nil && e.errorExpected {
+			t.Errorf("%s expected error, but did not get one", e.name)
+		}
+	}
+
+	app.Keys = oldKeys
+}
+
+func Test_app_getTokenFromHeaderAndVerify_keyRotation(t *testing.T) {
+	testUser := data.User{
+		ID:        1,
+		FirstName: "Admin",
+		LastName:  "User",
+		Email:     "admin@example.com",
+	}
+
+	oldKeys := app.Keys
+	defer func() {
+		app.Keys = oldKeys
+	}()
+
+	verify := func(token string) error {
+		req, _ := http.NewRequest("GET", "/", nil)
+		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
+		_, _, err := app.getTokenFromHeaderAndVerify(httptest.NewRecorder(), req)
+		return err
+	}
+
+	// start out with a single active key
+	keyringFile := &signing.KeyringFile{}
+	first, _ := keyringFile.Generate(signing.AlgRS256)
+	app.Keys, _ = keyringFile.Keyring()
+	oldTokens, _ := app.generateTokenPair(context.Background(), &testUser)
+
+	// generate a new key, and promote it
+	second, _ := keyringFile.Generate(signing.AlgEdDSA)
+	err := keyringFile.Promote(second.ID, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	app.Keys, _ = keyringFile.Keyring()
+	if app.Keys.Active().ID != second.ID {
+		t.Errorf("expected %s to be the active key, but got %s", second.ID, app.Keys.Active().ID)
+	}
+
+	newTokens, _ := app.generateTokenPair(context.Background(), &testUser)
+	if err := verify(newTokens.Token); err != nil {
+		t.Errorf("token signed by the new key should verify, but got %s", err)
+	}
+	if err := verify(oldTokens.Token); err != nil {
+		t.Errorf("token signed by the retired key should still verify, but got %s", err)
+	}
+
+	// end the overlap for the first key
+	_ = keyringFile.Retire(first.ID, 0)
+	app.Keys, _ = keyringFile.Keyring()
+	if err := verify(oldTokens.Token); err == nil {
+		t.Error("token signed by an expired key should not verify")
+	}
+}
+
+func Test_app_generateTokenPair_permissions(t *testing.T) {
+	db := useFreshDB(t)
+	jackID, err := db.InsertUser(context.Background(), data.User{FirstName: "Jack", LastName: "Smith", Email: "jack@example.com", Password: "secret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var tests = []struct {
+		name          string
+		user          data.User
+		expectedRoles int
+		expectedPerms int
+	}{
+		{"admin", data.User{ID: 1, FirstName: "Admin", LastName: "User", IsAdmin: 1}, 1, 5},
+		{"no roles", data.User{ID: jackID, FirstName: "Jack", LastName: "Smith"}, 0, 0},
+	}
+
+	for _, e := range tests {
+		tokens, err := app.generateTokenPair(context.Background(), &e.user)
+		if err != nil {
+			t.Fatalf("%s: error generating tokens: %s", e.name, err)
+		}
+
+		claims := &Claims{}
+		_, err = jwt.ParseWithClaims(tokens.Token, claims, app.keyFunc)
+		if err != nil {
+			t.Fatalf("%s: error parsing token: %s", e.name, err)
+		}
+
+		if len(claims.Roles) != e.expectedRoles {
+			t.Errorf("%s: expected %d roles, but got %d", e.name, e.expectedRoles, len(claims.Roles))
+		}
+		if len(claims.Permissions) != e.expectedPerms {
+			t.Errorf("%s: expected %d permissions, but got %d", e.name, e.expectedPerms, len(claims.Permissions))
+		}
+		if claims.Admin != (e.user.IsAdmin == 1) {
+			t.Errorf("%s: wrong admin claim: %t", e.name, claims.Admin)
+		}
+	}
+}
@@ -5,33 +5,112 @@ import (
 	"fmt"
 	"log"
 	"net/http"
+	"os"
+	"testingCourserWeb/pkg/clientip"
+	"testingCourserWeb/pkg/mailer"
+	"testingCourserWeb/pkg/mfa"
+	"testingCourserWeb/pkg/passwordreset"
+	"testingCourserWeb/pkg/ratelimit"
+	"testingCourserWeb/pkg/ratelimitstore"
 	"testingCourserWeb/pkg/repository"
 	"testingCourserWeb/pkg/repository/dbrepo"
+	"testingCourserWeb/pkg/signing"
+	"time"
 )
 
 const port = 8090
 
 type application struct {
-	DSN       string
-	DB        repository.DatabaseRepo
-	Domain    string
-	JWTSecret string
+	DSN            string
+	DBDriver       string
+	DB             repository.DatabaseRepo
+	Domain         string
+	JWTSecret      string
+	Keys           *signing.Keyring
+	Logins         *ratelimit.Logins
+	ResetLimits    *ratelimit.Resets
+	RateLimits     rateLimits
+	MFA            *mfa.Manager
+	PasswordResets *passwordreset.Manager
+	TrustedProxies clientip.Proxies
 }
 
 func main() {
 	var app application
+	var jwtAlg, jwtKeyFile, jwtKeyID, jwtKeyring string
+	var autoMigrate bool
+	var rateLimitStore string
+	var resetSecret, resetURL string
+	var smtpAddr, smtpFrom, smtpUser, smtpPassword string
 	flag.StringVar(&app.Domain, "domain", "example.com", "Domain for application, e.g. company.com")
-	flag.StringVar(&app.DSN, "dsn", "host=localhost port=5433 user=postgres password=postgres dbname=users sslmode=disable timezone=UTC connect_timeout=5", "Postgres connection")
+	flag.StringVar(&app.DBDriver, "db-driver", dbrepo.Postgres, "database: postgres|sqlite")
+	flag.StringVar(&app.DSN, "dsn", "", "Postgres connection, or SQLite database file (defaults to a local database for -db-driver)")
 	flag.StringVar(&app.JWTSecret, "jwt-secret", "asdf123sadafasdf123123sadfasdf12312asdfasdf123123asdfasdf", "signing secret")
+	flag.StringVar(&jwtAlg, "jwt-alg", signing.AlgHS256, "JWT signing algorithm: HS256|RS256|EdDSA")
+	flag.StringVar(&jwtKeyFile, "jwt-key-file", "", "PEM encoded private key, for RS256 and EdDSA")
+	flag.StringVar(&jwtKeyID, "jwt-kid", "", "key id to put in the kid header (derived from the key if empty)")
+	flag.BoolVar(&autoMigrate, "migrate", true, "apply pending database migrations on startup; with -migrate=false, run them with the cli instead")
+	flag.StringVar(&jwtKeyring, "jwt-keyring", "", "keyring file managed with the cli; overrides the other jwt flags")
+	flag.StringVar(&rateLimitStore, "rate-limit-store", ratelimitstore.Memory, "where to keep rate limits: memory|database (postgres only, shared by every instance)")
+	flag.TextVar(&app.RateLimits.Auth, "rate-limit-auth", defaultAuthRateLimit, "requests per ip to log in and refresh tokens, like 60/1m, or off")
+	flag.TextVar(&app.RateLimits.Users, "rate-limit-users", defaultUsersRateLimit, "requests per client to /users and /audit, like 600/1m, or off")
+	flag.TextVar(&app.RateLimits.Export, "rate-limit-export", defaultExportRateLimit, "audit log exports per client, like 10/1h, or off")
+	flag.TextVar(&app.TrustedProxies, "trusted-proxies", clientip.Proxies{}, "comma separated addresses and networks of proxies trusted to set X-Forwarded-For, like 10.0.0.0/8")
+	flag.StringVar(&resetSecret, "reset-secret", "8sadf7as9df87asdf98a7sdf98a7sdf98a7sdf", "secret signing password reset tokens; must be the same as the web app's")
+	flag.StringVar(&resetURL, "reset-url", "http://localhost:8080/password/reset", "page password reset links go to, where users choose a new password")
+	flag.StringVar(&smtpAddr, "smtp-addr", "", "SMTP server to send mail through, as host:port (mail is only logged if empty)")
+	flag.StringVar(&smtpFrom, "smtp-from", "noreply@example.com", "address mail is sent from")
+	flag.StringVar(&smtpUser, "smtp-user", "", "SMTP username, if the server needs one")
+	flag.StringVar(&smtpPassword, "smtp-password", "", "SMTP password")
 	flag.Parse()
 
+	if app.DSN == "" {
+		app.DSN = defaultDSN[app.DBDriver]
+	}
+
+	if jwtKeyring != "" {
+		keys, err := signing.LoadKeyring(jwtKeyring)
+		if err != nil {
+			log.Fatal(err)
+		}
+		app.Keys = keys
+		go app.watchKeyring(jwtKeyring, time.Minute)
+	} else {
+		signingKey, err := loadSigningKey(jwtAlg, jwtKeyID, jwtKeyFile, app.JWTSecret)
+		if err != nil {
+			log.Fatal(err)
+		}
+		app.Keys = signing.NewKeyring(signingKey)
+	}
+	log.Printf("Signing tokens with %s key %s\n", app.Keys.Active().Algorithm(), app.Keys.Active().ID)
+
 	conn, err := app.connectToDB()
 	if err != nil {
 		log.Fatal(err)
 	}
 	defer conn.Close()
 
-	app.DB = &dbrepo.PostgresDBRepo{DB: conn}
+	if autoMigrate {
+		err = migrate(conn, app.DBDriver)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	app.DB, err = dbrepo.New(app.DBDriver, conn)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	store, err := ratelimitstore.Open(rateLimitStore, app.DB, app.DBDriver)
+	if err != nil {
+		log.Fatal(err)
+	}
+	app.Logins = ratelimit.NewLogins(store, app.DB)
+	app.ResetLimits = ratelimit.NewResets(store)
+	app.RateLimits.Store = store
+	app.MFA = mfa.NewManager(app.DB, app.Domain)
+	app.PasswordResets = passwordreset.NewManager(app.DB, mailer.New(smtpAddr, smtpFrom, smtpUser, smtpPassword), []byte(resetSecret), resetURL)
 
 	log.Printf("Starting API on port %d\n", port)
 	err = http.ListenAndServe(fmt.Sprintf(":%d", port), app.routes())
@@ -41,3 +120,46 @@ func main() {
 	}
 
 }
+
+// loadSigningKey builds the key we sign tokens with; HS256 uses the jwt secret, and the
+// asymmetric algorithms read a private key from keyFile.
+func loadSigningKey(alg, kid, keyFile, secret string) (*signing.Key, error) {
+	if alg == signing.AlgHS256 {
+		return signing.NewKey(alg, kid, []byte(secret))
+	}
+
+	pemBytes, err := os.ReadFile(keyFile)
+	if err != nil {
+		return nil, err
+	}
+	return signing.NewKey(alg, kid, pemBytes)
+}
+
+// watchKeyring reloads the keyring whenever the file changes, so that keys can be
+// generated, promoted and retired with the cli while the api keeps running.
+func (app *application) watchKeyring(path string, interval time.Duration) {
+	var lastModified time.Time
+	if info, err := os.Stat(path); err == nil {
+		lastModified = info.ModTime()
+	}
+
+	for range time.Tick(interval) {
+		info, err := os.Stat(path)
+		if err != nil {
+			log.Println("Error checking keyring:", err)
+			continue
+		}
+		if !info.ModTime().After(lastModified) {
+			continue
+		}
+
+		keys, err := signing.LoadKeyring(path)
+		if err != nil {
+			log.Println("Error reloading keyring:", err)
+			continue
+		}
+		app.Keys.Replace(keys)
+		lastModified = info.ModTime()
+		log.Printf("Reloaded keyring; signing tokens with key %s\n", keys.Active().ID)
+	}
+}
@@ -0,0 +1,306 @@
+package main
+
+import (
+	"errors"
+	"github.com/golang-jwt/jwt/v4"
+	"net/http"
+	"strconv"
+	"testingCourserWeb/pkg/data"
+	"testingCourserWeb/pkg/mfa"
+	"time"
+)
+
+// mfaTokenExpiry is how long a user has to give their code, once they have given their password.
+var mfaTokenExpiry = time.Minute * 5
+
+// mfaRequiredProblem is the type of the problem authenticate sends to users with two-factor
+// authentication on, which clients can tell apart from other 401s by it.
+const mfaRequiredProblem = "/problems/mfa-required"
+
+// mfaChallenge is what authenticate sends, instead of tokens, to a user with two-factor
+// authentication on: a problem, with the token as an extension member. To finish logging in,
+// the client posts the token back to /auth/mfa, along with a code from the user's app, or one
+// of their recovery codes.
+type mfaChallenge struct {
+	Problem
+	MFAToken  string `json:"mfa_token"`
+	ExpiresIn int    `json:"expires_in"`
+}
+
+// mfaCredentials are what the second step of a login is sent.
+type mfaCredentials struct {
+	MFAToken string `json:"mfa_token"`
+	Code     string `json:"code"`
+}
+
+// mfaCode is what the endpoints which need a code to change a user's two-factor authentication
+// are sent.
+type mfaCode struct {
+	Code string `json:"code"`
+}
+
+// mfaStatus is how a user's two-factor authentication is sent back.
+type mfaStatus struct {
+	Enabled           bool       `json:"enabled"`
+	EnabledAt         *time.Time `json:"enabled_at,omitempty"`
+	RecoveryCodesLeft int        `json:"recovery_codes_left"`
+}
+
+// recoveryCodes are sent back whenever a user is given new recovery codes, which is the only time
+// they can be.
+type recoveryCodes struct {
+	RecoveryCodes []string `json:"recovery_codes"`
+}
+
+// mfaAudience is the audience of mfa tokens, so that they can't be used as access tokens.
+func (app *application) mfaAudience() string {
+	return app.Domain + "/auth/mfa"
+}
+
+// challengeMFA sends a user who has given the right password an mfa token, with which they can
+// give their code, and finish logging in.
+func (app *application) challengeMFA(w http.ResponseWriter, r *http.Request, user *data.User) {
+	claims := jwt.MapClaims{}
+	claims["sub"] = strconv.Itoa(user.ID)
+	claims["iss"] = app.Domain
+	claims["aud"] = app.mfaAudience()
+	claims["exp"] = time.Now().Add(mfaTokenExpiry).Unix()
+	token, err := app.Keys.Active().Sign(claims)
+	if err != nil {
+		app.errorJSON(w, r, err, http.StatusInternalServerError)
+		return
+	}
+
+	app.audit(r, data.AuditEvent{Event: data.AuditMFARequired, UserID: &user.ID, Email: user.Email})
+	problem := app.problem(r, newPublicError("Send a code from your authenticator app, or a recovery code, along with mfa_token, to /auth/mfa."), http.StatusUnauthorized)
+	problem.Type = mfaRequiredProblem
+	problem.Title = "Two-factor authentication required"
+	writeProblem(w, http.StatusUnauthorized, mfaChallenge{
+		Problem:   problem,
+		MFAToken:  token,
+		ExpiresIn: int(mfaTokenExpiry.Seconds()),
+	})
+}
+
+// parseMFAToken checks an mfa token, and returns the id of the user it was issued to.
+func (app *application) parseMFAToken(token string) (int, error) {
+	claims := &jwt.RegisteredClaims{}
+	_, err := jwt.ParseWithClaims(token, claims, app.keyFunc)
+	if err != nil {
+		return 0, err
+	}
+	if claims.Issuer != app.Domain || !claims.VerifyAudience(app.mfaAudience(), true) {
+		return 0, errors.New("not an mfa token")
+	}
+	return strconv.Atoi(claims.Subject)
+}
+
+// authenticateMFA is the second step of logging in with two-factor authentication: given the
+// token from the first step, and a code, it issues tokens, just as authenticate would have.
+// Wrong codes count towards locking the account out, like wrong passwords.
+func (app *application) authenticateMFA(w http.ResponseWriter, r *http.Request) {
+	var creds mfaCredentials
+	err := app.readJSON(w, r, &creds)
+	if err != nil {
+		app.audit(r, data.AuditEvent{Event: data.AuditLoginFailed, Reason: "invalid mfa request"})
+		app.errorJSON(w, r, newPublicError("invalid credentials"), http.StatusUnauthorized)
+		return
+	}
+
+	userID, err := app.parseMFAToken(creds.MFAToken)
+	if err != nil {
+		app.audit(r, data.AuditEvent{Event: data.AuditLoginFailed, Reason: "invalid mfa token: " + err.Error()})
+		app.errorJSON(w, r, newPublicError("invalid or expired mfa token; log in again"), http.StatusUnauthorized)
+		return
+	}
+	user, err := app.DB.GetUser(r.Context(), userID)
+	if err != nil {
+		app.audit(r, data.AuditEvent{Event: data.AuditLoginFailed, UserID: &userID, Reason: "unknown user"})
+		app.errorJSON(w, r, newPublicError("invalid or expired mfa token; log in again"), http.StatusUnauthorized)
+		return
+	}
+	failed := func(reason string) {
+		app.audit(r, data.AuditEvent{Event: data.AuditLoginFailed, UserID: &user.ID, Email: user.Email, Reason: reason})
+	}
+
+	if !app.allowLogin(w, r, user.Email) {
+		return
+	}
+	// the user may have been deactivated since they gave their password
+	if user.DisabledAt != nil {
+		failed("account deactivated")
+		app.errorJSON(w, r, errAccountDeactivated, http.StatusForbidden)
+		return
+	}
+
+	method, err := app.MFA.Verify(r.Context(), user.ID, creds.Code)
+	switch {
+	case errors.Is(err, mfa.ErrInvalidCode):
+		failed("wrong mfa code")
+		app.loginFailed(r, user.Email)
+		app.errorJSON(w, r, newPublicError("invalid code"), http.StatusUnauthorized)
+		return
+	case errors.Is(err, mfa.ErrNotEnrolled):
+		// two-factor authentication was turned off since the first step
+		failed("mfa not enabled")
+		app.errorJSON(w, r, newPublicError("invalid or expired mfa token; log in again"), http.StatusUnauthorized)
+		return
+	case err != nil:
+		app.errorJSON(w, r, err, http.StatusInternalServerError)
+		return
+	}
+	if method == mfa.MethodRecoveryCode {
+		app.audit(r, data.AuditEvent{Event: data.AuditRecoveryCodeUsed, UserID: &user.ID, Email: user.Email})
+	}
+
+	app.logIn(w, r, user, user.Email)
+}
+
+// mfaUser returns the user whose token the request has; they may only change their own two-factor
+// authentication.
+func (app *application) mfaUser(w http.ResponseWriter, r *http.Request) (*data.User, bool) {
+	claims, _ := app.claimsFromContext(r.Context())
+	id := subjectID(claims)
+	if id == nil {
+		app.errorJSON(w, r, newPublicError("a valid bearer token is required"), http.StatusUnauthorized)
+		return nil, false
+	}
+
+	user, err := app.DB.GetUser(r.Context(), *id)
+	if err != nil {
+		app.dbErrorJSON(w, r, err)
+		return nil, false
+	}
+	return user, true
+}
+
+// mfaErrorJSON reports an error from app.MFA, with the status that fits it.
+func (app *application) mfaErrorJSON(w http.ResponseWriter, r *http.Request, err error) {
+	switch {
+	case errors.Is(err, mfa.ErrInvalidCode):
+		app.errorJSON(w, r, fieldErrors{"code": "is wrong, or has already been used"}, http.StatusUnprocessableEntity)
+	case errors.Is(err, mfa.ErrNotEnrolled), errors.Is(err, mfa.ErrAlreadyEnabled):
+		app.errorJSON(w, r, newPublicError(err.Error()), http.StatusConflict)
+	default:
+		app.errorJSON(w, r, err, http.StatusInternalServerError)
+	}
+}
+
+// getMFA says whether the user has two-factor authentication on, and how many recovery codes
+// they have left.
+func (app *application) getMFA(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.mfaUser(w, r)
+	if !ok {
+		return
+	}
+
+	status, err := app.MFA.Status(r.Context(), user.ID)
+	if err != nil {
+		app.errorJSON(w, r, err, http.StatusInternalServerError)
+		return
+	}
+	_ = app.writeJSON(w, http.StatusOK, mfaStatus{
+		Enabled:           status.Enabled(),
+		EnabledAt:         status.EnabledAt,
+		RecoveryCodesLeft: status.RecoveryCodesLeft,
+	})
+}
+
+// enrollMFA starts setting up two-factor authentication, and sends back the secret, and the URI
+// to show as a QR code. Nothing changes until the user confirms it with a code.
+func (app *application) enrollMFA(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.mfaUser(w, r)
+	if !ok {
+		return
+	}
+
+	enrollment, err := app.MFA.Enroll(r.Context(), user.ID, user.Email)
+	if err != nil {
+		app.mfaErrorJSON(w, r, err)
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	_ = app.writeJSON(w, http.StatusOK, enrollment)
+}
+
+// confirmMFA turns on two-factor authentication, given a code from the app it was set up in,
+// and sends back the user's recovery codes.
+func (app *application) confirmMFA(w http.ResponseWriter, r *http.Request) {
+	user, code, ok := app.mfaUserAndCode(w, r)
+	if !ok {
+		return
+	}
+
+	codes, err := app.MFA.Confirm(r.Context(), user.ID, code)
+	if err != nil {
+		app.mfaCodeFailed(w, r, user, err)
+		return
+	}
+	app.audit(r, data.AuditEvent{Event: data.AuditMFAEnabled, UserID: &user.ID, Email: user.Email})
+	w.Header().Set("Cache-Control", "no-store")
+	_ = app.writeJSON(w, http.StatusOK, recoveryCodes{RecoveryCodes: codes})
+}
+
+// regenerateRecoveryCodes gives the user new recovery codes, in place of their old ones, given
+// a code.
+func (app *application) regenerateRecoveryCodes(w http.ResponseWriter, r *http.Request) {
+	user, code, ok := app.mfaUserAndCode(w, r)
+	if !ok {
+		return
+	}
+
+	codes, err := app.MFA.RegenerateRecoveryCodes(r.Context(), user.ID, code)
+	if err != nil {
+		app.mfaCodeFailed(w, r, user, err)
+		return
+	}
+	app.audit(r, data.AuditEvent{Event: data.AuditRecoveryCodesRegenerated, UserID: &user.ID, Email: user.Email})
+	w.Header().Set("Cache-Control", "no-store")
+	_ = app.writeJSON(w, http.StatusOK, recoveryCodes{RecoveryCodes: codes})
+}
+
+// disableMFA turns off two-factor authentication, given a code.
+func (app *application) disableMFA(w http.ResponseWriter, r *http.Request) {
+	user, code, ok := app.mfaUserAndCode(w, r)
+	if !ok {
+		return
+	}
+
+	err := app.MFA.Disable(r.Context(), user.ID, code)
+	if err != nil {
+		app.mfaCodeFailed(w, r, user, err)
+		return
+	}
+	app.audit(r, data.AuditEvent{Event: data.AuditMFADisabled, UserID: &user.ID, Email: user.Email})
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// mfaUserAndCode returns the user, and the code they sent, for the endpoints which need one to
+// change two-factor authentication, or to turn it on. A code is as good as a password here, so it
+// is guarded like one: the login rate limits and lockout apply, and mfaCodeFailed counts wrong
+// codes as failed logins.
+func (app *application) mfaUserAndCode(w http.ResponseWriter, r *http.Request) (*data.User, string, bool) {
+	user, ok := app.mfaUser(w, r)
+	if !ok {
+		return nil, "", false
+	}
+	var payload mfaCode
+	err := app.readJSON(w, r, &payload)
+	if err != nil {
+		app.errorJSON(w, r, err, http.StatusBadRequest)
+		return nil, "", false
+	}
+	if !app.allowLogin(w, r, user.Email) {
+		return nil, "", false
+	}
+	return user, payload.Code, true
+}
+
+// mfaCodeFailed reports an error from checking a code sent to one of the endpoints which need one.
+func (app *application) mfaCodeFailed(w http.ResponseWriter, r *http.Request, user *data.User, err error) {
+	if errors.Is(err, mfa.ErrInvalidCode) {
+		app.audit(r, data.AuditEvent{Event: data.AuditLoginFailed, UserID: &user.ID, Email: user.Email, Reason: "wrong mfa code"})
+		app.loginFailed(r, user.Email)
+	}
+	app.mfaErrorJSON(w, r, err)
+}
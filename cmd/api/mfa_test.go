@@ -0,0 +1,236 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/golang-jwt/jwt/v4"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"testingCourserWeb/pkg/data"
+	"testingCourserWeb/pkg/mfa"
+	"testingCourserWeb/pkg/ratelimit"
+	"testingCourserWeb/pkg/repository"
+	"time"
+)
+
+// enableMFA turns on two-factor authentication for user 1, and returns their secret, their
+// recovery codes, and the step of the code it was confirmed with, which can't be used again.
+func enableMFA(t *testing.T) (string, []string, int64) {
+	t.Helper()
+	ctx := context.Background()
+
+	enrollment, err := app.MFA.Enroll(ctx, 1, "admin@example.com")
+	if err != nil {
+		t.Fatalf("unexpected error enrolling: %s", err)
+	}
+	step := mfa.Step(time.Now())
+	code, _ := mfa.Code(enrollment.Secret, step)
+	codes, err := app.MFA.Confirm(ctx, 1, code)
+	if err != nil {
+		t.Fatalf("unexpected error confirming: %s", err)
+	}
+	return enrollment.Secret, codes, step
+}
+
+func Test_app_authenticateMFA(t *testing.T) {
+	db := useFreshDB(t)
+	app.Logins.Lockout = ratelimit.Lockout{After: 3, Base: time.Minute}
+	secret, codes, step := enableMFA(t)
+	code := func(step int64) string {
+		code, _ := mfa.Code(secret, step)
+		return code
+	}
+	mux := app.routes()
+
+	// the right password only gets a challenge
+	req := httptest.NewRequest("POST", "/auth", strings.NewReader(`{"email": "admin@example.com", "password": "secret"}`))
+	rr := httptest.NewRecorder()
+	mux.ServeHTTP(rr, req)
+
+	var challenge mfaChallenge
+	_ = json.NewDecoder(rr.Body).Decode(&challenge)
+	if rr.Code != http.StatusUnauthorized || challenge.Type != mfaRequiredProblem || challenge.Status != http.StatusUnauthorized || challenge.MFAToken == "" || challenge.ExpiresIn != 300 {
+		t.Fatalf("expected an mfa challenge, but got %d: %+v", rr.Code, challenge)
+	}
+	if rr.Header().Get("Content-Type") != "application/problem+json" {
+		t.Errorf("expected the challenge to be a problem, but got %s", rr.Header().Get("Content-Type"))
+	}
+	if len(rr.Result().Cookies()) != 0 {
+		t.Errorf("expected no refresh cookie before the second step, but got %v", rr.Result().Cookies())
+	}
+
+	// the challenge is no good as an access token
+	req = httptest.NewRequest("GET", "/users/", nil)
+	req.Header.Set("Authorization", "Bearer "+challenge.MFAToken)
+	rr = httptest.NewRecorder()
+	mux.ServeHTTP(rr, req)
+	if rr.Code != http.StatusUnauthorized {
+		t.Errorf("expected an mfa token to be refused as an access token, but got %d", rr.Code)
+	}
+
+	tokens, _ := app.generateTokenPair(context.Background(), &data.User{ID: 1, Email: "admin@example.com"})
+	expired, _ := app.Keys.Active().Sign(jwt.MapClaims{
+		"sub": "1",
+		"iss": app.Domain,
+		"aud": app.mfaAudience(),
+		"exp": time.Now().Add(-time.Minute).Unix(),
+	})
+
+	var tests = []struct {
+		name               string
+		token              string
+		code               string
+		expectedStatusCode int
+		expectedReason     string
+	}{
+		{"an access token", tokens.Token, code(step), http.StatusUnauthorized, "invalid mfa token: not an mfa token"},
+		{"an expired token", expired, code(step), http.StatusUnauthorized, ""},
+		{"no code", challenge.MFAToken, "", http.StatusUnauthorized, "wrong mfa code"},
+		{"the code which confirmed mfa", challenge.MFAToken, code(step), http.StatusUnauthorized, "wrong mfa code"},
+		{"the next code", challenge.MFAToken, code(step + 1), http.StatusOK, ""},
+		{"the same code again", challenge.MFAToken, code(step + 1), http.StatusUnauthorized, "wrong mfa code"},
+		{"a recovery code", challenge.MFAToken, codes[0], http.StatusOK, ""},
+		// the failures were forgotten when the login succeeded, so this is the first of three
+		{"the same recovery code again", challenge.MFAToken, codes[0], http.StatusUnauthorized, "wrong mfa code"},
+		{"a wrong code", challenge.MFAToken, "000000", http.StatusUnauthorized, "wrong mfa code"},
+		{"a wrong code, locking the account", challenge.MFAToken, "000001", http.StatusUnauthorized, "wrong mfa code"},
+		{"locked", challenge.MFAToken, codes[1], http.StatusTooManyRequests, ratelimit.ReasonAccountLocked},
+	}
+
+	for _, e := range tests {
+		body, _ := json.Marshal(mfaCredentials{MFAToken: e.token, Code: e.code})
+		req := httptest.NewRequest("POST", "/auth/mfa", strings.NewReader(string(body)))
+		rr := httptest.NewRecorder()
+		mux.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s: expected status %d, but got %d: %s", e.name, e.expectedStatusCode, rr.Code, rr.Body)
+		}
+
+		events, _, _ := db.ListAuditEvents(context.Background(), repository.AuditFilter{Limit: 1})
+		if len(events) != 1 {
+			t.Fatalf("%s: expected an audit event, but got none", e.name)
+		}
+		if rr.Code == http.StatusOK {
+			var tokens TokenPairs
+			_ = json.NewDecoder(rr.Body).Decode(&tokens)
+			if tokens.Token == "" || tokens.RefreshToken == "" || len(rr.Result().Cookies()) != 1 {
+				t.Errorf("%s: expected tokens, and a refresh cookie, but got %+v", e.name, tokens)
+			}
+			if events[0].Event != data.AuditLoginSucceeded {
+				t.Errorf("%s: expected a successful login to be recorded, but got %s", e.name, events[0].Event)
+			}
+		} else if e.expectedReason != "" && events[0].Reason != e.expectedReason {
+			t.Errorf("%s: expected a failed login because %q to be recorded, but got %q", e.name, e.expectedReason, events[0].Reason)
+		}
+	}
+
+	events, _, _ := db.ListAuditEvents(context.Background(), repository.AuditFilter{Event: data.AuditRecoveryCodeUsed})
+	if len(events) != 1 {
+		t.Errorf("expected the recovery code to be recorded as used once, but got %d events", len(events))
+	}
+}
+
+func Test_app_mfa(t *testing.T) {
+	db := useFreshDB(t)
+
+	// post sends body to one of the /mfa handlers as user 1
+	post := func(handler http.HandlerFunc, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/mfa/", strings.NewReader(body))
+		req = addClaimsToRequest(req, "1", false)
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr
+	}
+	status := func() mfaStatus {
+		var status mfaStatus
+		rr := post(app.getMFA, "")
+		_ = json.NewDecoder(rr.Body).Decode(&status)
+		return status
+	}
+
+	if s := status(); s.Enabled || s.EnabledAt != nil || s.RecoveryCodesLeft != 0 {
+		t.Errorf("expected mfa to be off, but got %+v", s)
+	}
+
+	rr := post(app.confirmMFA, `{"code": "123456"}`)
+	if rr.Code != http.StatusConflict {
+		t.Errorf("expected confirming before enrolling to conflict, but got %d", rr.Code)
+	}
+
+	rr = post(app.enrollMFA, "")
+	var enrollment mfa.Enrollment
+	_ = json.NewDecoder(rr.Body).Decode(&enrollment)
+	if rr.Code != http.StatusOK || enrollment.Secret == "" || !strings.HasPrefix(enrollment.URI, "otpauth://totp/example.com:admin@example.com?") {
+		t.Fatalf("expected an enrollment, but got %d: %+v", rr.Code, enrollment)
+	}
+	if rr.Header().Get("Cache-Control") != "no-store" {
+		t.Error("expected the secret not to be cached")
+	}
+	if status().Enabled {
+		t.Error("expected mfa to be off until confirmed")
+	}
+
+	step := mfa.Step(time.Now())
+	code := func(step int64) string {
+		code, _ := mfa.Code(enrollment.Secret, step)
+		return code
+	}
+
+	// a body of "recovery code" sends one of the recovery codes we were last given
+	var codes recoveryCodes
+	var tests = []struct {
+		name               string
+		handler            http.HandlerFunc
+		body               string
+		expectedStatusCode int
+		expectedEnabled    bool
+	}{
+		{"confirm with a wrong code", app.confirmMFA, `{"code": "000000"}`, http.StatusUnprocessableEntity, false},
+		{"confirm with bad json", app.confirmMFA, `{"code": 123456}`, http.StatusBadRequest, false},
+		{"confirm", app.confirmMFA, `{"code": "` + code(step) + `"}`, http.StatusOK, true},
+		{"confirm again", app.confirmMFA, `{"code": "` + code(step+1) + `"}`, http.StatusConflict, true},
+		{"enroll again", app.enrollMFA, "", http.StatusConflict, true},
+		{"new recovery codes with a used code", app.regenerateRecoveryCodes, `{"code": "` + code(step) + `"}`, http.StatusUnprocessableEntity, true},
+		{"new recovery codes", app.regenerateRecoveryCodes, `{"code": "` + code(step+1) + `"}`, http.StatusOK, true},
+		{"disable with a wrong code", app.disableMFA, `{"code": "000000"}`, http.StatusUnprocessableEntity, true},
+		{"disable", app.disableMFA, "recovery code", http.StatusNoContent, false},
+		{"disable again", app.disableMFA, "recovery code", http.StatusConflict, false},
+	}
+
+	for _, e := range tests {
+		body := e.body
+		if body == "recovery code" {
+			body = `{"code": "` + codes.RecoveryCodes[1] + `"}`
+		}
+		rr := post(e.handler, body)
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s: expected status %d, but got %d: %s", e.name, e.expectedStatusCode, rr.Code, rr.Body)
+		}
+		if rr.Code == http.StatusOK && strings.Contains(rr.Body.String(), "recovery_codes") {
+			_ = json.NewDecoder(rr.Body).Decode(&codes)
+			if len(codes.RecoveryCodes) != mfa.RecoveryCodes {
+				t.Errorf("%s: expected %d recovery codes, but got %v", e.name, mfa.RecoveryCodes, codes.RecoveryCodes)
+			}
+		}
+		if s := status(); s.Enabled != e.expectedEnabled {
+			t.Errorf("%s: expected mfa enabled to be %v, but got %+v", e.name, e.expectedEnabled, s)
+		}
+	}
+
+	// every wrong code counts against the account, confirming included
+	events, _, _ := db.ListAuditEvents(context.Background(), repository.AuditFilter{Event: data.AuditLoginFailed})
+	if len(events) != 3 {
+		t.Errorf("expected 3 wrong codes to be recorded as failed logins, but got %d", len(events))
+	}
+
+	// without a token, there is nobody to set mfa up for
+	req := httptest.NewRequest("POST", "/mfa/enroll", nil)
+	rr = httptest.NewRecorder()
+	http.HandlerFunc(app.enrollMFA).ServeHTTP(rr, req)
+	if rr.Code != http.StatusUnauthorized {
+		t.Errorf("expected enrolling without a token to be unauthorized, but got %d", rr.Code)
+	}
+}
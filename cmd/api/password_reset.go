@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"testingCourserWeb/pkg/data"
+	"testingCourserWeb/pkg/passwordreset"
+	"testingCourserWeb/pkg/ratelimit"
+	"testingCourserWeb/pkg/validator"
+)
+
+// forgotPasswordPayload is what a user who has forgotten their password sends, to be emailed a
+// link to reset it.
+type forgotPasswordPayload struct {
+	Email string `json:"email"`
+}
+
+// resetPasswordPayload is what a user sends to reset their password, with the token from the link
+// we emailed them.
+type resetPasswordPayload struct {
+	Token    string `json:"token"`
+	Password string `json:"password"`
+}
+
+// forgotPassword emails the user with the email sent a link to reset their password. It answers
+// 202 whether or not there is such a user, and whether or not the email could be sent, so that
+// it can't be used to find out who has an account; the audit log says what happened. The link is
+// sent after we have answered, so that the response takes as long either way. Asking for links is
+// rate limited, so that it can't be used to flood an inbox, but apart from logging in, so that it
+// can't be used to lock anybody out either.
+func (app *application) forgotPassword(w http.ResponseWriter, r *http.Request) {
+	var payload forgotPasswordPayload
+	err := app.readJSON(w, r, &payload)
+	if err != nil {
+		app.errorJSON(w, r, err, http.StatusBadRequest)
+		return
+	}
+	v := validator.New()
+	v.Required("email", payload.Email)
+	v.Email("email", payload.Email)
+	if !v.Valid() {
+		app.errorJSON(w, r, fieldErrors(v.Errors), http.StatusUnprocessableEntity)
+		return
+	}
+
+	if !app.allowResetRequest(w, r, payload.Email) {
+		return
+	}
+
+	// the request is over by the time the link has been sent, so audit a copy of it
+	r = r.Clone(r.Context())
+	app.PasswordResets.RequestLater(r.Context(), payload.Email, func(ctx context.Context, user *data.User, err error) {
+		r := r.WithContext(ctx)
+		switch {
+		case err != nil:
+			log.Printf("request %s: requesting password reset: %s", requestIDFromContext(ctx), err)
+			app.audit(r, data.AuditEvent{Event: data.AuditPasswordResetFailed, Email: payload.Email, Reason: err.Error()})
+		case user == nil:
+			app.audit(r, data.AuditEvent{Event: data.AuditPasswordResetRequested, Email: payload.Email, Reason: "no active user"})
+		default:
+			app.audit(r, data.AuditEvent{Event: data.AuditPasswordResetRequested, UserID: &user.ID, Email: user.Email})
+		}
+	})
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// allowResetRequest checks the limits on asking for reset links for email, and turns the client
+// away with a 429 if it has been asking too often. Like allowLogin, it lets the request through if
+// the limits can't be checked.
+func (app *application) allowResetRequest(w http.ResponseWriter, r *http.Request, email string) bool {
+	err := app.ResetLimits.Allow(r.Context(), app.requestIP(r), email)
+	var denied *ratelimit.Denied
+	if errors.As(err, &denied) {
+		app.audit(r, data.AuditEvent{Event: data.AuditPasswordResetFailed, Email: email, Reason: denied.Reason})
+		w.Header().Set("Retry-After", strconv.Itoa(denied.RetryAfterSeconds()))
+		app.errorJSON(w, r, newPublicError("too many password reset requests; try again later"), http.StatusTooManyRequests)
+		return false
+	}
+	if err != nil {
+		log.Printf("request %s: checking password reset rate limits: %s", requestIDFromContext(r.Context()), err)
+	}
+	return true
+}
+
+// resetPassword sets a new password for the user a reset token was issued to. Each token works
+// once, before it expires.
+func (app *application) resetPassword(w http.ResponseWriter, r *http.Request) {
+	var payload resetPasswordPayload
+	err := app.readJSON(w, r, &payload)
+	if err != nil {
+		app.errorJSON(w, r, err, http.StatusBadRequest)
+		return
+	}
+	// the password is checked first, so that a bad one doesn't use the token up
+	v := validator.New()
+	v.Required("token", payload.Token)
+	v.Required("password", payload.Password)
+	v.Password("password", payload.Password)
+	if !v.Valid() {
+		app.errorJSON(w, r, fieldErrors(v.Errors), http.StatusUnprocessableEntity)
+		return
+	}
+
+	userID, err := app.PasswordResets.Reset(r.Context(), payload.Token, payload.Password)
+	if errors.Is(err, passwordreset.ErrInvalidToken) {
+		app.audit(r, data.AuditEvent{Event: data.AuditPasswordResetFailed, Reason: err.Error()})
+		app.errorJSON(w, r, fieldErrors{"token": "is invalid, has expired or has already been used"}, http.StatusUnprocessableEntity)
+		return
+	}
+	if err != nil {
+		app.errorJSON(w, r, err, http.StatusInternalServerError)
+		return
+	}
+
+	app.audit(r, data.AuditEvent{Event: data.AuditPasswordReset, UserID: &userID})
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"testingCourserWeb/pkg/data"
+	"testingCourserWeb/pkg/mailer"
+	"testingCourserWeb/pkg/ratelimit"
+	"testingCourserWeb/pkg/repository"
+	"time"
+)
+
+// resetToken returns the token in the link of the last password reset email sent, if there was one.
+func resetToken(outbox *mailer.Outbox) string {
+	m, _ := outbox.Last()
+	for _, line := range strings.Split(m.Body, "\n") {
+		if u, err := url.Parse(line); err == nil && u.Query().Has("token") {
+			return u.Query().Get("token")
+		}
+	}
+	return ""
+}
+
+func Test_app_passwordReset(t *testing.T) {
+	db := useFreshDB(t)
+	outbox := app.PasswordResets.Mailer.(*mailer.Outbox)
+	mux := app.routes()
+
+	post := func(target, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", target, strings.NewReader(body))
+		rr := httptest.NewRecorder()
+		mux.ServeHTTP(rr, req)
+		return rr
+	}
+	lastEvent := func() *data.AuditEvent {
+		events, _, _ := db.ListAuditEvents(context.Background(), repository.AuditFilter{Limit: 1})
+		if len(events) == 0 {
+			return &data.AuditEvent{}
+		}
+		return events[0]
+	}
+
+	var forgotTests = []struct {
+		name               string
+		body               string
+		expectedStatusCode int
+		expectedMail       bool
+	}{
+		{"bad json", `{"email": 1}`, http.StatusBadRequest, false},
+		{"invalid email", `{"email": "admin"}`, http.StatusUnprocessableEntity, false},
+		{"unknown email", `{"email": "nobody@example.com"}`, http.StatusAccepted, false},
+		{"user", `{"email": "admin@example.com"}`, http.StatusAccepted, true},
+	}
+
+	for _, e := range forgotTests {
+		sent := len(outbox.Messages())
+		rr := post("/password/forgot", e.body)
+		app.PasswordResets.Wait()
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s: expected status %d, but got %d: %s", e.name, e.expectedStatusCode, rr.Code, rr.Body)
+		}
+		if mailed := len(outbox.Messages()) > sent; mailed != e.expectedMail {
+			t.Errorf("%s: expected mail sent to be %v", e.name, e.expectedMail)
+		}
+		if rr.Code == http.StatusAccepted {
+			event := lastEvent()
+			if event.Event != data.AuditPasswordResetRequested || (event.UserID != nil) != e.expectedMail {
+				t.Errorf("%s: expected a reset request to be recorded, but got %+v", e.name, event)
+			}
+		}
+	}
+
+	token := resetToken(outbox)
+	if token == "" {
+		t.Fatal("expected a reset link to have been sent")
+	}
+
+	// someone who knew the old password logged in before the reset
+	stolen, _ := app.generateTokenPair(context.Background(), &data.User{ID: 1, Email: "admin@example.com"})
+
+	var resetTests = []struct {
+		name               string
+		token              string
+		password           string
+		expectedStatusCode int
+	}{
+		{"no token", "", "new password 1", http.StatusUnprocessableEntity},
+		{"weak password", token, "short", http.StatusUnprocessableEntity},
+		{"made up token", "made.up", "new password 1", http.StatusUnprocessableEntity},
+		{"valid", token, "new password 1", http.StatusNoContent},
+		{"the same token again", token, "new password 2", http.StatusUnprocessableEntity},
+	}
+
+	for _, e := range resetTests {
+		rr := post("/password/reset", `{"token": "`+e.token+`", "password": "`+e.password+`"}`)
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s: expected status %d, but got %d: %s", e.name, e.expectedStatusCode, rr.Code, rr.Body)
+		}
+		if rr.Code == http.StatusNoContent && lastEvent().Event != data.AuditPasswordReset {
+			t.Errorf("%s: expected the reset to be recorded, but got %+v", e.name, lastEvent())
+		}
+	}
+
+	// the session they got with the old password is over
+	req := httptest.NewRequest("GET", "/web/refresh-token", nil)
+	req.AddCookie(&http.Cookie{Name: "__Host-refresh_token", Value: stolen.RefreshToken})
+	rr := httptest.NewRecorder()
+	mux.ServeHTTP(rr, req)
+	if rr.Code != http.StatusUnauthorized {
+		t.Errorf("expected a refresh token from before the reset to be refused, but got %d", rr.Code)
+	}
+
+	// the new password works, and the old one doesn't
+	for password, expectedStatusCode := range map[string]int{"secret": http.StatusUnauthorized, "new password 1": http.StatusOK} {
+		rr := post("/auth", `{"email": "admin@example.com", "password": "`+password+`"}`)
+		if rr.Code != expectedStatusCode {
+			t.Errorf("%s: expected logging in to give %d, but got %d", password, expectedStatusCode, rr.Code)
+		}
+	}
+}
+
+func Test_app_forgotPassword_limits(t *testing.T) {
+	useFreshDB(t)
+	app.Logins.PerAccount = ratelimit.Limit{Every: time.Hour, Burst: 1}
+	resetLimits := app.ResetLimits
+	app.ResetLimits = &ratelimit.Resets{Store: ratelimit.NewMemoryStore(), PerAccount: ratelimit.Limit{Every: time.Hour, Burst: 2}}
+	t.Cleanup(func() { app.ResetLimits = resetLimits })
+	mux := app.routes()
+
+	// asking for links to somebody's account is limited, but never locks them out of it
+	for i, expectedStatusCode := range []int{http.StatusAccepted, http.StatusAccepted, http.StatusTooManyRequests} {
+		req := httptest.NewRequest("POST", "/password/forgot", strings.NewReader(`{"email": "admin@example.com"}`))
+		rr := httptest.NewRecorder()
+		mux.ServeHTTP(rr, req)
+		if rr.Code != expectedStatusCode {
+			t.Errorf("request %d: expected status %d, but got %d", i, expectedStatusCode, rr.Code)
+		}
+	}
+	app.PasswordResets.Wait()
+
+	req := httptest.NewRequest("POST", "/auth", strings.NewReader(`{"email": "admin@example.com", "password": "secret"}`))
+	rr := httptest.NewRecorder()
+	mux.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Errorf("expected to still be able to log in, but got %d", rr.Code)
+	}
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"testingCourserWeb/pkg/ratelimit"
+	"time"
+)
+
+// apiKeyHeader is the header clients may identify themselves with, for rate limiting.
+const apiKeyHeader = "X-API-Key"
+
+// rateLimits are the rate limits for the API's routes, which are kept in Store. Auth is per ip,
+// for the routes which log in and refresh tokens; Users is per client, for the /users and /audit
+// routes; and Export, also per client, is for exporting the audit log, which is much more work.
+type rateLimits struct {
+	Store  ratelimit.WindowStore
+	Auth   ratelimit.Window
+	Users  ratelimit.Window
+	Export ratelimit.Window
+}
+
+// The rate limits the API starts with, unless flags say otherwise.
+var (
+	defaultAuthRateLimit   = ratelimit.Window{Limit: 60, Period: time.Minute}
+	defaultUsersRateLimit  = ratelimit.Window{Limit: 600, Period: time.Minute}
+	defaultExportRateLimit = ratelimit.Window{Limit: 10, Period: time.Hour}
+)
+
+// keyFunc picks out who a request is from, for rate limiting, or returns "" if it can't tell.
+type keyFunc func(r *http.Request) string
+
+// keyBySubject keys requests by the subject of their verified token, so it must run after
+// authRequired.
+func (app *application) keyBySubject(r *http.Request) string {
+	claims, ok := app.claimsFromContext(r.Context())
+	if !ok || claims.Subject == "" {
+		return ""
+	}
+	return "sub:" + claims.Subject
+}
+
+// keyByAPIKey keys requests by the X-API-Key header, which a gateway in front of us may have
+// checked. We don't check API keys ourselves, so a client could send a new one with every request
+// to dodge its limit; don't key by API key alone where that matters. The key is hashed, so that
+// it isn't stored anywhere.
+func (app *application) keyByAPIKey(r *http.Request) string {
+	key := r.Header.Get(apiKeyHeader)
+	if key == "" {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(key))
+	return "key:" + hex.EncodeToString(sum[:16])
+}
+
+// keyByIP keys requests by the ip they came from, which is only taken from X-Forwarded-For when a
+// trusted proxy set it, so that a client can't get a new bucket by sending a new address.
+func (app *application) keyByIP(r *http.Request) string {
+	return "ip:" + app.requestIP(r)
+}
+
+// rateLimit limits requests to window per client, where keys pick out the client: the first key
+// which can tell who a request is from is used. Each policy, named by name, counts separately.
+// Responses say how much of the limit is left in RateLimit-Limit, RateLimit-Remaining,
+// RateLimit-Reset and RateLimit-Policy headers, and requests over the limit get a 429 with
+// Retry-After. If the limit can't be checked, requests are let through.
+func (app *application) rateLimit(name string, window ratelimit.Window, keys ...keyFunc) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if window.Unlimited() {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var key string
+			for _, k := range keys {
+				if key = k(r); key != "" {
+					break
+				}
+			}
+			if key == "" {
+				key = app.keyByIP(r)
+			}
+
+			res, err := window.Hit(r.Context(), app.RateLimits.Store, "api:"+name+":"+key, time.Now())
+			if err != nil {
+				log.Printf("request %s: checking rate limit %s: %s", requestIDFromContext(r.Context()), name, err)
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			reset := strconv.Itoa(seconds(res.Reset))
+			w.Header().Set("RateLimit-Limit", strconv.Itoa(window.Limit))
+			w.Header().Set("RateLimit-Remaining", strconv.Itoa(res.Remaining))
+			w.Header().Set("RateLimit-Reset", reset)
+			w.Header().Set("RateLimit-Policy", fmt.Sprintf("%d;w=%d", window.Limit, seconds(window.Period)))
+			if !res.Allowed {
+				w.Header().Set("Retry-After", reset)
+				app.errorJSON(w, r, newPublicError("too many requests; try again later"), http.StatusTooManyRequests)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// seconds returns d in whole seconds, rounded up, for a header.
+func seconds(d time.Duration) int {
+	s := int(d / time.Second)
+	if d%time.Second > 0 {
+		s++
+	}
+	return s
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"testingCourserWeb/pkg/clientip"
+	"testingCourserWeb/pkg/data"
+	"testingCourserWeb/pkg/ratelimit"
+	"time"
+)
+
+func Test_app_rateLimit(t *testing.T) {
+	nextHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	window := ratelimit.Window{Limit: 2, Period: time.Hour}
+	handlerToTest := app.rateLimit("test", window, app.keyBySubject, app.keyByAPIKey, app.keyByIP)
+
+	var tests = []struct {
+		name              string
+		subject           string
+		apiKey            string
+		ip                string
+		expectedStatus    int
+		expectedRemaining string
+	}{
+		{"user 1", "1", "", "10.0.0.1", http.StatusOK, "1"},
+		{"user 1, from somewhere else", "1", "", "10.0.0.2", http.StatusOK, "0"},
+		{"user 1, over the limit", "1", "key", "10.0.0.1", http.StatusTooManyRequests, "0"},
+		{"user 2, from the same address", "2", "", "10.0.0.1", http.StatusOK, "1"},
+		{"an api key", "", "key", "10.0.0.1", http.StatusOK, "1"},
+		{"another api key", "", "another", "10.0.0.1", http.StatusOK, "1"},
+		{"nobody", "", "", "10.0.0.1", http.StatusOK, "1"},
+		{"nobody again", "", "", "10.0.0.1", http.StatusOK, "0"},
+		{"nobody, over the limit", "", "", "10.0.0.1", http.StatusTooManyRequests, "0"},
+	}
+
+	app.RateLimits.Store = ratelimit.NewMemoryStore()
+	for _, e := range tests {
+		req := httptest.NewRequest("GET", "/users/", nil)
+		req.RemoteAddr = e.ip + ":1234"
+		if e.apiKey != "" {
+			req.Header.Set(apiKeyHeader, e.apiKey)
+		}
+		if e.subject != "" {
+			req = addClaimsToRequest(req, e.subject, false)
+		}
+		rr := httptest.NewRecorder()
+		handlerToTest(nextHandler).ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatus {
+			t.Errorf("%s: expected status %d, but got %d", e.name, e.expectedStatus, rr.Code)
+		}
+		if got := rr.Header().Get("RateLimit-Remaining"); got != e.expectedRemaining {
+			t.Errorf("%s: expected %s remaining, but got %q", e.name, e.expectedRemaining, got)
+		}
+		if rr.Header().Get("RateLimit-Limit") != "2" || rr.Header().Get("RateLimit-Policy") != "2;w=3600" || rr.Header().Get("RateLimit-Reset") == "" {
+			t.Errorf("%s: expected RateLimit headers, but got %v", e.name, rr.Header())
+		}
+		if rr.Code == http.StatusTooManyRequests && rr.Header().Get("Retry-After") == "" {
+			t.Errorf("%s: expected a Retry-After header", e.name)
+		}
+	}
+
+	// without a limit, there are no headers either
+	rr := httptest.NewRecorder()
+	app.rateLimit("off", ratelimit.Window{}, app.keyByIP)(nextHandler).ServeHTTP(rr, httptest.NewRequest("GET", "/", nil))
+	if rr.Header().Get("RateLimit-Limit") != "" {
+		t.Errorf("expected no RateLimit headers without a limit, but got %v", rr.Header())
+	}
+}
+
+func Test_app_rateLimit_forwardedFor(t *testing.T) {
+	t.Cleanup(func() { app.TrustedProxies = nil })
+	nextHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	window := ratelimit.Window{Limit: 2, Period: time.Hour}
+
+	var tests = []struct {
+		name           string
+		trustedProxies string
+		expectedStatus []int
+	}{
+		// the client makes up a new address every time, but it's all one peer
+		{"untrusted peer", "", []int{http.StatusOK, http.StatusOK, http.StatusTooManyRequests}},
+		// a proxy we trust passes on where each request came from
+		{"trusted proxy", "192.0.2.1", []int{http.StatusOK, http.StatusOK, http.StatusOK}},
+	}
+
+	for _, e := range tests {
+		app.TrustedProxies, _ = clientip.ParseProxies(e.trustedProxies)
+		app.RateLimits.Store = ratelimit.NewMemoryStore()
+		handlerToTest := app.addIPToContext(app.rateLimit("test", window, app.keyByIP)(nextHandler))
+
+		for i, expected := range e.expectedStatus {
+			req := httptest.NewRequest("GET", "/auth", nil)
+			req.Header.Set("X-Forwarded-For", fmt.Sprintf("203.0.113.%d", i))
+			rr := httptest.NewRecorder()
+			handlerToTest.ServeHTTP(rr, req)
+			if rr.Code != expected {
+				t.Errorf("%s: request %d: expected status %d, but got %d", e.name, i, expected, rr.Code)
+			}
+		}
+	}
+}
+
+func Test_app_routes_rateLimits(t *testing.T) {
+	limits := app.RateLimits
+	t.Cleanup(func() {
+		app.RateLimits = limits
+	})
+	app.RateLimits = rateLimits{
+		Store: ratelimit.NewMemoryStore(),
+		Auth:  ratelimit.Window{Limit: 1, Period: time.Hour},
+		Users: ratelimit.Window{Limit: 1, Period: time.Hour},
+	}
+	mux := app.routes()
+
+	var tests = []struct {
+		name           string
+		method         string
+		url            string
+		expectedStatus int
+	}{
+		{"log in", "POST", "/auth", http.StatusUnauthorized},
+		{"log in again", "POST", "/auth", http.StatusTooManyRequests},
+		// logging in and refreshing share a limit
+		{"refresh", "GET", "/web/refresh-token", http.StatusTooManyRequests},
+		{"keys aren't limited", "GET", "/.well-known/jwks.json", http.StatusOK},
+	}
+
+	for _, e := range tests {
+		req := httptest.NewRequest(e.method, e.url, strings.NewReader(`{}`))
+		rr := httptest.NewRecorder()
+		mux.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatus {
+			t.Errorf("%s: expected status %d, but got %d", e.name, e.expectedStatus, rr.Code)
+		}
+	}
+
+	// users are limited by who they are, once they're authenticated
+	tokens, _ := app.generateTokenPair(context.Background(), &data.User{ID: 1, Email: "admin@example.com"})
+	for i, expectedStatus := range []int{http.StatusOK, http.StatusTooManyRequests} {
+		req := httptest.NewRequest("GET", "/users/", nil)
+		req.Header.Set("Authorization", "Bearer "+tokens.Token)
+		rr := httptest.NewRecorder()
+		mux.ServeHTTP(rr, req)
+
+		if rr.Code != expectedStatus {
+			t.Errorf("request %d for users: expected status %d, but got %d", i+1, expectedStatus, rr.Code)
+		}
+	}
+}
@@ -1,9 +1,21 @@
 package main
 
 import (
+	"context"
+	"github.com/golang-jwt/jwt/v4"
+	"log"
+	"net/http"
 	"os"
+	"strconv"
 	"testing"
+	"testingCourserWeb/pkg/mailer"
+	"testingCourserWeb/pkg/mfa"
+	"testingCourserWeb/pkg/passwordreset"
+	"testingCourserWeb/pkg/ratelimit"
+	"testingCourserWeb/pkg/repository"
 	"testingCourserWeb/pkg/repository/dbrepo"
+	"testingCourserWeb/pkg/signing"
+	"time"
 )
 
 var app application
@@ -11,8 +23,85 @@ var app application
 var expiredToken = "eyJhbGciOiJIUzI1NiIsInR5cCI6IkpXVCJ9.eyJhZG1pbiI6dHJ1ZSwiYXVkIjoiZXhhbXBsZS5jb20iLCJleHAiOjE2NzM4MDE5OTUsImlzcyI6ImV4YW1w\nbGUuY29tIiwibmFtZSI6IkpvaG4gRG9lIiwic3ViIjoiMSJ9.xyA4lFlOnfOhvMTRSGLCwafC3f1-hGkLFxGR2FWMzSw"
 
 func TestMain(m *testing.M) {
-	app.DB = &dbrepo.TestDBRepo{}
+	app.DB = newTestDB()
+	app.Logins = newTestLogins(app.DB)
+	app.ResetLimits = &ratelimit.Resets{Store: ratelimit.NewMemoryStore()}
+	app.RateLimits = rateLimits{Store: ratelimit.NewMemoryStore()}
 	app.Domain = "example.com"
+	app.MFA = mfa.NewManager(app.DB, app.Domain)
+	app.PasswordResets = newTestPasswordResets(app.DB)
 	app.JWTSecret = "asdf123sadafasdf123123sadfasdf12312asdfasdf123123asdfasdf"
+	app.Keys = signing.NewKeyring(signing.NewHMACKey("", []byte(app.JWTSecret)))
 	os.Exit(m.Run())
 }
+
+// newTestDB returns an in-memory repository holding the fixtures in testdata.
+func newTestDB() *dbrepo.MemoryDBRepo {
+	fixtures, err := dbrepo.ReadFixtures("./testdata/fixtures.json")
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	db := dbrepo.NewMemoryDBRepo()
+	err = db.Seed(*fixtures)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return db
+}
+
+// newTestLogins returns a login guard for db without any limits, so that tests can log in as
+// often as they like; tests of the limits set their own.
+func newTestLogins(db repository.DatabaseRepo) *ratelimit.Logins {
+	return &ratelimit.Logins{Store: ratelimit.NewMemoryStore(), Failures: db}
+}
+
+// newTestPasswordResets returns a password reset manager for db, which puts the mail it sends in
+// an outbox, for tests to read.
+func newTestPasswordResets(db repository.DatabaseRepo) *passwordreset.Manager {
+	return passwordreset.NewManager(db, &mailer.Outbox{}, []byte("secret"), "http://localhost:8080/password/reset")
+}
+
+// useFreshDB gives a test a repository of its own, holding just the fixtures, so that it can
+// change whatever it likes, and a login guard, mfa manager and password reset manager using it;
+// the shared ones are put back when the test is done.
+func useFreshDB(t *testing.T) *dbrepo.MemoryDBRepo {
+	shared, sharedLogins, sharedMFA, sharedResets := app.DB, app.Logins, app.MFA, app.PasswordResets
+	db := newTestDB()
+	app.DB, app.Logins, app.MFA, app.PasswordResets = db, newTestLogins(db), mfa.NewManager(db, app.Domain), newTestPasswordResets(db)
+	t.Cleanup(func() {
+		app.DB, app.Logins, app.MFA, app.PasswordResets = shared, sharedLogins, sharedMFA, sharedResets
+	})
+	return db
+}
+
+// refreshTokenWithID returns a signed refresh token for user 1 with the given jti, so that
+// we can refresh with the tokens in the fixtures, or ones we never issued.
+func refreshTokenWithID(jti string, expiry time.Duration) string {
+	claims := &Claims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject:   "1",
+			ID:        jti,
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(expiry)),
+		},
+	}
+	token, _ := app.Keys.Active().Sign(claims)
+	return token
+}
+
+// addClaimsToRequest puts verified claims, and the actor, in the request context, the way
+// authRequired does.
+func addClaimsToRequest(req *http.Request, subject string, admin bool, permissions ...string) *http.Request {
+	claims := &Claims{
+		Admin:       admin,
+		Permissions: permissions,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject: subject,
+		},
+	}
+	ctx := context.WithValue(req.Context(), contextClaimsKey, claims)
+	if id, err := strconv.Atoi(subject); err == nil {
+		ctx = repository.WithActor(ctx, id)
+	}
+	return req.WithContext(ctx)
+}
@@ -3,8 +3,14 @@ package main
 import (
 	"encoding/json"
 	"errors"
+	"fmt"
 	"io"
+	"log"
 	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+	"testingCourserWeb/pkg/repository"
 )
 
 func (app *application) writeJSON(w http.ResponseWriter, status int, data interface{}, wrap ...string) error {
@@ -42,21 +48,156 @@ func (app *application) writeJSON(w http.ResponseWriter, status int, data interf
 	return nil
 }
 
-func (app *application) errorJSON(w http.ResponseWriter, err error, status ...int) {
+// Problem is an RFC 7807 problem details object, which is how we report every error.
+type Problem struct {
+	Type      string            `json:"type"`
+	Title     string            `json:"title"`
+	Status    int               `json:"status"`
+	Detail    string            `json:"detail,omitempty"`
+	Instance  string            `json:"instance,omitempty"`
+	Errors    map[string]string `json:"errors,omitempty"`
+	RequestID string            `json:"request_id,omitempty"`
+}
+
+// publicError is an error whose message is safe to show to the client.
+type publicError struct {
+	message string
+}
+
+func (e *publicError) Error() string {
+	return e.message
+}
+
+// newPublicError returns an error which errorJSON shows to the client as is.
+func newPublicError(format string, a ...any) error {
+	return &publicError{message: fmt.Sprintf(format, a...)}
+}
+
+// fieldErrors is a problem with one or more fields of a request, keyed by field name.
+type fieldErrors map[string]string
+
+func (e fieldErrors) Error() string {
+	var fields []string
+	for field, message := range e {
+		fields = append(fields, field+": "+message)
+	}
+	sort.Strings(fields)
+	return "invalid fields: " + strings.Join(fields, "; ")
+}
+
+// errorJSON sends err to the client as an application/problem+json response. The client only
+// ever sees the messages of public and field errors; anything else might give away details of
+// our internals, so it is logged along with the request id, and left out of the response.
+func (app *application) errorJSON(w http.ResponseWriter, r *http.Request, err error, status ...int) {
 	statusCode := http.StatusBadRequest
 	if len(status) > 0 {
 		statusCode = status[0]
 	}
+	writeProblem(w, statusCode, app.problem(r, err, statusCode))
+}
+
+// problem describes err, for a response to r with statusCode, the way errorJSON sends it.
+// Problems of our own types start from one of these, and add their own members.
+func (app *application) problem(r *http.Request, err error, statusCode int) Problem {
+	problem := Problem{
+		Type:      "about:blank",
+		Title:     http.StatusText(statusCode),
+		Status:    statusCode,
+		Instance:  r.URL.Path,
+		RequestID: requestIDFromContext(r.Context()),
+	}
 
-	type jsonError struct {
-		Message string `json:"message"`
+	var fields fieldErrors
+	var public *publicError
+	switch {
+	case errors.As(err, &fields):
+		problem.Detail = "One or more fields are invalid."
+		problem.Errors = fields
+	case errors.As(err, &public):
+		problem.Detail = public.message
+	default:
+		log.Printf("request %s: %s %s: %d: %s", problem.RequestID, r.Method, r.URL.Path, statusCode, err)
+		if statusCode >= http.StatusInternalServerError {
+			problem.Detail = "Something went wrong on our side. If it keeps happening, let us know, quoting the request id."
+		}
 	}
+	return problem
+}
 
-	theError := jsonError{
-		Message: err.Error(),
+// writeProblem sends problem, which is a Problem, or a type embedding one, as an
+// application/problem+json response.
+func writeProblem(w http.ResponseWriter, statusCode int, problem any) {
+	out, err := json.Marshal(problem)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
 	}
 
-	_ = app.writeJSON(w, statusCode, theError, "error")
+	w.Header().Set("Content-Type", "application/problem+json")
+	w.WriteHeader(statusCode)
+	_, _ = w.Write(out)
+}
+
+// dbErrorJSON reports an error from the repository, with the status that fits it. Anything
+// the repository does not recognise is our problem, not the client's.
+func (app *application) dbErrorJSON(w http.ResponseWriter, r *http.Request, err error) {
+	switch {
+	case errors.Is(err, repository.ErrNotFound):
+		app.errorJSON(w, r, newPublicError("no such resource"), http.StatusNotFound)
+	case errors.Is(err, repository.ErrDuplicateEmail):
+		app.errorJSON(w, r, fieldErrors{"email": "is already in use"}, http.StatusUnprocessableEntity)
+	case errors.Is(err, repository.ErrConflict):
+		app.errorJSON(w, r, newPublicError("the change conflicts with the current state of the resource"), http.StatusConflict)
+	case errors.Is(err, repository.ErrVersionMismatch):
+		app.errorJSON(w, r, errResourceChanged, http.StatusPreconditionFailed)
+	default:
+		app.errorJSON(w, r, err, http.StatusInternalServerError)
+	}
+}
+
+// errResourceChanged is sent when If-Match names a version of a resource which is not the current one.
+var errResourceChanged = newPublicError("the resource has changed since you fetched it; fetch it again, and retry with its new ETag")
+
+// etag returns the ETag for version of a resource.
+func etag(version int) string {
+	return fmt.Sprintf(`"%d"`, version)
+}
+
+// etagMatches reports whether header, the value of an If-Match or If-None-Match header, is *
+// or lists tag. If-Match only matches strong ETags; If-None-Match also matches weak ones.
+func etagMatches(header, tag string, weak bool) bool {
+	for _, t := range strings.Split(header, ",") {
+		t = strings.TrimSpace(t)
+		if t == "*" {
+			return true
+		}
+		if strings.HasPrefix(t, "W/") {
+			if !weak {
+				continue
+			}
+			t = strings.TrimPrefix(t, "W/")
+		}
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// checkIfMatch makes sure a request which changes a resource says, with If-Match, which version
+// of the resource it is changing, so that it can't overwrite changes it has never seen. It
+// reports any problem to the client, and returns false if the request should go no further.
+func (app *application) checkIfMatch(w http.ResponseWriter, r *http.Request, version int) bool {
+	match := r.Header.Get("If-Match")
+	if match == "" {
+		app.errorJSON(w, r, newPublicError("an If-Match header with the resource's ETag is required"), http.StatusPreconditionRequired)
+		return false
+	}
+	if !etagMatches(match, etag(version), false) {
+		app.errorJSON(w, r, errResourceChanged, http.StatusPreconditionFailed)
+		return false
+	}
+	return true
 }
 
 func (app *application) readJSON(w http.ResponseWriter, r *http.Request, data interface{}) error {
@@ -65,17 +206,57 @@ func (app *application) readJSON(w http.ResponseWriter, r *http.Request, data in
 	dec := json.NewDecoder(r.Body)
 	dec.DisallowUnknownFields()
 
-	// attempt to decode the data
+	// attempt to decode the data; the errors from encoding/json mention Go types, so we
+	// turn the ones a client can cause into something that makes sense to them
 	err := dec.Decode(data)
 	if err != nil {
+		var syntaxError *json.SyntaxError
+		var typeError *json.UnmarshalTypeError
+		var maxBytesError *http.MaxBytesError
+
+		switch {
+		case errors.As(err, &syntaxError):
+			return newPublicError("body contains badly formed JSON (at character %d)", syntaxError.Offset)
+		case errors.Is(err, io.ErrUnexpectedEOF):
+			return newPublicError("body contains badly formed JSON")
+		case errors.As(err, &typeError):
+			if typeError.Field != "" {
+				return fieldErrors{typeError.Field: "must be a JSON " + jsonType(typeError.Type.Kind())}
+			}
+			return newPublicError("body contains the wrong JSON type (at character %d)", typeError.Offset)
+		case errors.Is(err, io.EOF):
+			return newPublicError("body must not be empty")
+		case strings.HasPrefix(err.Error(), "json: unknown field "):
+			field := strings.Trim(strings.TrimPrefix(err.Error(), "json: unknown field "), `"`)
+			return fieldErrors{field: "unknown field"}
+		case errors.As(err, &maxBytesError):
+			return newPublicError("body must not be larger than %d bytes", maxBytesError.Limit)
+		}
 		return err
 	}
 
 	// make sure only one JSON value in payload
 	err = dec.Decode(&struct{}{})
 	if err != io.EOF {
-		return errors.New("body must only contain a single JSON value")
+		return newPublicError("body must only contain a single JSON value")
 	}
 
 	return nil
 }
+
+// jsonType names the JSON type a Go value of kind k is decoded from.
+func jsonType(k reflect.Kind) string {
+	switch k {
+	case reflect.Bool:
+		return "boolean"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return "number"
+	case reflect.String:
+		return "string"
+	case reflect.Slice, reflect.Array:
+		return "array"
+	}
+	return "object"
+}
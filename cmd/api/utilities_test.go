@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"testingCourserWeb/pkg/repository"
+)
+
+func Test_app_errorJSON(t *testing.T) {
+	var tests = []struct {
+		name           string
+		err            error
+		status         int
+		expectedDetail string
+		expectedErrors map[string]string
+	}{
+		{"public", newPublicError("limit must be %d or less", 100), http.StatusBadRequest, "limit must be 100 or less", nil},
+		{"wrapped public", fmt.Errorf("parsing: %w", newPublicError("bad cursor")), http.StatusBadRequest, "bad cursor", nil},
+		{"fields", fieldErrors{"email": "is required"}, http.StatusUnprocessableEntity, "One or more fields are invalid.", map[string]string{"email": "is required"}},
+		{"internal client error", errors.New("sql: no rows in result set"), http.StatusBadRequest, "", nil},
+		{"internal server error", errors.New("pq: connection refused"), http.StatusInternalServerError, "Something went wrong on our side. If it keeps happening, let us know, quoting the request id.", nil},
+	}
+
+	for _, e := range tests {
+		req, _ := http.NewRequest("GET", "/users/1", nil)
+		req = req.WithContext(context.WithValue(req.Context(), contextRequestIDKey, "request-1"))
+		rr := httptest.NewRecorder()
+
+		app.errorJSON(rr, req, e.err, e.status)
+
+		if rr.Code != e.status {
+			t.Errorf("%s: expected status %d, but got %d", e.name, e.status, rr.Code)
+		}
+		if rr.Header().Get("Content-Type") != "application/problem+json" {
+			t.Errorf("%s: wrong content type %s", e.name, rr.Header().Get("Content-Type"))
+		}
+
+		body := rr.Body.String()
+		var problem Problem
+		err := json.Unmarshal([]byte(body), &problem)
+		if err != nil {
+			t.Fatalf("%s: error decoding problem: %s", e.name, err)
+		}
+
+		if problem.Status != e.status || problem.Title != http.StatusText(e.status) || problem.Type != "about:blank" {
+			t.Errorf("%s: unexpected problem %+v", e.name, problem)
+		}
+		if problem.Instance != "/users/1" || problem.RequestID != "request-1" {
+			t.Errorf("%s: expected instance and request id to be set, but got %+v", e.name, problem)
+		}
+		if problem.Detail != e.expectedDetail {
+			t.Errorf("%s: expected detail %q, but got %q", e.name, e.expectedDetail, problem.Detail)
+		}
+		if len(problem.Errors) != len(e.expectedErrors) {
+			t.Errorf("%s: expected field errors %v, but got %v", e.name, e.expectedErrors, problem.Errors)
+		}
+		if strings.Contains(body, "sql:") || strings.Contains(body, "pq:") {
+			t.Errorf("%s: internal error leaked into response: %s", e.name, body)
+		}
+	}
+}
+
+func Test_app_readJSON(t *testing.T) {
+	var tests = []struct {
+		name          string
+		body          string
+		expectedField string
+		expectPublic  bool
+	}{
+		{"badly formed", `{"email": }`, "", true},
+		{"truncated", `{"email": "a@b.com"`, "", true},
+		{"wrong type", `{"email": 1}`, "email", false},
+		{"unknown field", `{"foo": "bar"}`, "foo", false},
+		{"empty", ``, "", true},
+		{"two values", `{"email": "a@b.com"}{"email": "c@d.com"}`, "", true},
+	}
+
+	for _, e := range tests {
+		var creds Credentials
+		req, _ := http.NewRequest("POST", "/", strings.NewReader(e.body))
+		err := app.readJSON(httptest.NewRecorder(), req, &creds)
+
+		var fields fieldErrors
+		var public *publicError
+		if e.expectedField != "" {
+			if !errors.As(err, &fields) || fields[e.expectedField] == "" {
+				t.Errorf("%s: expected a field error for %s, but got %v", e.name, e.expectedField, err)
+			}
+		}
+		if e.expectPublic && !errors.As(err, &public) {
+			t.Errorf("%s: expected a public error, but got %v", e.name, err)
+		}
+	}
+}
+
+func Test_app_dbErrorJSON(t *testing.T) {
+	var tests = []struct {
+		name           string
+		err            error
+		expectedStatus int
+	}{
+		{"not found", repository.ErrNotFound, http.StatusNotFound},
+		{"wrapped not found", fmt.Errorf("%w: unknown role", repository.ErrNotFound), http.StatusNotFound},
+		{"duplicate email", repository.ErrDuplicateEmail, http.StatusUnprocessableEntity},
+		{"conflict", repository.ErrConflict, http.StatusConflict},
+		{"anything else", errors.New("connection reset by peer"), http.StatusInternalServerError},
+	}
+
+	for _, e := range tests {
+		req, _ := http.NewRequest("GET", "/users/1", nil)
+		rr := httptest.NewRecorder()
+
+		app.dbErrorJSON(rr, req, e.err)
+
+		if rr.Code != e.expectedStatus {
+			t.Errorf("%s: expected status %d, but got %d", e.name, e.expectedStatus, rr.Code)
+		}
+	}
+}
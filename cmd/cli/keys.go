@@ -0,0 +1,112 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"testingCourserWeb/pkg/signing"
+	"text/tabwriter"
+	"time"
+)
+
+// generateKey adds a new key to the keyring. It is pending, unless it is the first key.
+func (app *application) generateKey() error {
+	return app.updateKeyring(func(f *signing.KeyringFile) error {
+		entry, err := f.Generate(app.Alg)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Generated %s key %s (%s)\n", entry.Algorithm, entry.ID, entry.State)
+		return nil
+	})
+}
+
+// promoteKey makes a key active, and retires the key that was active.
+func (app *application) promoteKey() error {
+	if app.KeyID == "" {
+		return errors.New("promote requires -kid")
+	}
+	return app.updateKeyring(func(f *signing.KeyringFile) error {
+		err := f.Promote(app.KeyID, app.Overlap)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Promoted key %s\n", app.KeyID)
+		return nil
+	})
+}
+
+// retireKey retires a key that is not active.
+func (app *application) retireKey() error {
+	if app.KeyID == "" {
+		return errors.New("retire requires -kid")
+	}
+	return app.updateKeyring(func(f *signing.KeyringFile) error {
+		err := f.Retire(app.KeyID, app.Overlap)
+		if err != nil {
+			return err
+		}
+		for _, entry := range f.Keys {
+			if entry.ID == app.KeyID {
+				fmt.Printf("Retired key %s; it verifies tokens until %s\n", app.KeyID, entry.VerifyUntil.Format(time.RFC3339))
+			}
+		}
+		return nil
+	})
+}
+
+// pruneKeys removes retired keys that no longer verify anything.
+func (app *application) pruneKeys() error {
+	return app.updateKeyring(func(f *signing.KeyringFile) error {
+		for _, kid := range f.Prune() {
+			fmt.Printf("Pruned key %s\n", kid)
+		}
+		return nil
+	})
+}
+
+// listKeys prints every key in the keyring.
+func (app *application) listKeys() error {
+	if app.Keyring == "" {
+		return errors.New("keys requires -keyring")
+	}
+	f, err := signing.ReadKeyringFile(app.Keyring)
+	if err != nil {
+		return err
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "KID\tALG\tSTATE\tCREATED\tVERIFIES UNTIL")
+	for _, entry := range f.Keys {
+		until := "-"
+		if entry.VerifyUntil != nil {
+			until = entry.VerifyUntil.Format(time.RFC3339)
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", entry.ID, entry.Algorithm, entry.State, entry.CreatedAt.Format(time.RFC3339), until)
+	}
+	return tw.Flush()
+}
+
+// updateKeyring reads the keyring file, applies fn, makes sure the result is still a usable
+// keyring, and writes it back.
+func (app *application) updateKeyring(fn func(f *signing.KeyringFile) error) error {
+	if app.Keyring == "" {
+		return fmt.Errorf("%s requires -keyring", app.Action)
+	}
+
+	f, err := signing.ReadKeyringFile(app.Keyring)
+	if err != nil {
+		return err
+	}
+
+	err = fn(f)
+	if err != nil {
+		return err
+	}
+
+	_, err = f.Keyring()
+	if err != nil {
+		return err
+	}
+	return f.Write(app.Keyring)
+}
@@ -3,56 +3,96 @@ package main
 import (
 	"flag"
 	"fmt"
-	"github.com/golang-jwt/jwt/v4"
 	"log"
+	"testingCourserWeb/pkg/migrations"
+	"testingCourserWeb/pkg/repository/dbrepo"
 	"time"
 )
 
 type application struct {
-	JWTSecret string
-	Action    string
+	JWTSecret     string
+	Action        string
+	Keyring       string
+	Alg           string
+	KeyID         string
+	Overlap       time.Duration
+	DBDriver      string
+	DSN           string
+	Steps         int
+	Name          string
+	MigrationsDir string
 }
 
 // This is used to generate a token, so that we can test our api. Run this with go run ./cmd/cli and copy
 // the token that is printed out.
 // go run ./cmd/cli -action=valid     // will produce a valid token
 // go run ./cmd/cli -action=expired   // will produce an expired token
+//
+// It also manages the keyring the api signs tokens with (see the -jwt-keyring flag on the api). To rotate
+// keys without downtime, generate a new key, wait for it to be picked up (the api reloads the keyring every
+// minute, and verifiers cache our JWKS for five minutes), promote it, and prune the old key once its
+// overlap is over:
+// go run ./cmd/cli -action=keygen -keyring=keyring.json -alg=RS256   // adds a pending key (active if it's the first)
+// go run ./cmd/cli -action=promote -keyring=keyring.json -kid=<kid>   // signs with kid, retires the current key
+// go run ./cmd/cli -action=retire -keyring=keyring.json -kid=<kid>    // retires a key, e.g. with -overlap=0 if leaked
+// go run ./cmd/cli -action=prune -keyring=keyring.json                // removes retired keys past their overlap
+// go run ./cmd/cli -action=keys -keyring=keyring.json                 // lists the keys
+//
+// And it migrates the database (see pkg/migrations). The api and web app apply pending migrations when
+// they start too, unless they are run with -migrate=false:
+// go run ./cmd/cli -action=migrate-up                       // applies every pending migration
+// go run ./cmd/cli -action=migrate-down -steps=1            // rolls back the latest migration
+// go run ./cmd/cli -action=migrate-status                   // lists migrations, and when they were applied
+// go run ./cmd/cli -action=migrate-create -name=add_phone   // writes empty up and down files for a new migration
+// A fresh development database also wants sql/seed.sql, which adds the admin user. Add -db-driver=sqlite to work
+// with a SQLite database file instead of Postgres.
 
 func main() {
 	var app application
 	flag.StringVar(&app.JWTSecret, "jwt-secret", "asdf123sadafasdf123123sadfasdf12312asdfasdf123123asdfasdf", "secret")
-	flag.StringVar(&app.Action, "action", "valid", "action: valid|expired")
+	flag.StringVar(&app.Action, "action", "valid", "action: valid|expired|keygen|promote|retire|prune|keys|migrate-up|migrate-down|migrate-status|migrate-create")
+	flag.StringVar(&app.Keyring, "keyring", "", "keyring file; tokens are signed with its active key if set")
+	flag.StringVar(&app.Alg, "alg", "RS256", "algorithm for new keys: HS256|RS256|EdDSA")
+	flag.StringVar(&app.KeyID, "kid", "", "id of the key to promote or retire")
+	flag.DurationVar(&app.Overlap, "overlap", 25*time.Hour, "how long a retired key keeps verifying; at least the refresh token lifetime")
+	flag.StringVar(&app.DBDriver, "db-driver", dbrepo.Postgres, "database for the migrate actions: postgres|sqlite")
+	flag.StringVar(&app.DSN, "dsn", "", "Postgres connection, or SQLite database file, for the migrate actions (defaults to a local database for -db-driver)")
+	flag.IntVar(&app.Steps, "steps", 1, "how many migrations migrate-down rolls back")
+	flag.StringVar(&app.Name, "name", "", "name of the migration to create, e.g. add_phone")
+	flag.StringVar(&app.MigrationsDir, "migrations-dir", migrations.Dir, "where migrate-create writes new migrations")
 	flag.Parse()
 
-	// generate a token
-	token := jwt.New(jwt.SigningMethodHS256)
-
-	// set claims
-	claims := token.Claims.(jwt.MapClaims)
-	claims["name"] = "John Doe"
-	claims["sub"] = "1"
-	claims["admin"] = true
-	claims["aud"] = "example.com"
-	claims["iss"] = "example.com"
-	// leave this to 3 days, for easy manual testing
-	if app.Action == "valid" {
-		expires := time.Now().UTC().Add(time.Hour * 72)
-		claims["exp"] = expires.Unix()
-	} else {
-		expires := time.Now().UTC().Add(time.Hour * 100 * -1)
-		claims["exp"] = expires.Unix()
+	if app.DSN == "" {
+		app.DSN = defaultDSN[app.DBDriver]
 	}
 
-	// create the token as a slice of bytes
-	if app.Action == "valid" {
-		fmt.Println("VALID Token:")
-	} else {
-		fmt.Println("EXPIRED Token:")
+	var err error
+	switch app.Action {
+	case "valid", "expired":
+		err = app.printToken()
+	case "keygen":
+		err = app.generateKey()
+	case "promote":
+		err = app.promoteKey()
+	case "retire":
+		err = app.retireKey()
+	case "prune":
+		err = app.pruneKeys()
+	case "keys":
+		err = app.listKeys()
+	case "migrate-up":
+		err = app.migrateUp()
+	case "migrate-down":
+		err = app.migrateDown()
+	case "migrate-status":
+		err = app.migrateStatus()
+	case "migrate-create":
+		err = app.migrateCreate()
+	default:
+		err = fmt.Errorf("unknown action: %s", app.Action)
 	}
-	signedAccessToken, err := token.SignedString([]byte(app.JWTSecret))
+
 	if err != nil {
 		log.Fatal(err)
 	}
-	// print to console
-	fmt.Println(string(signedAccessToken))
 }
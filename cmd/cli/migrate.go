@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testingCourserWeb/pkg/migrations"
+	"testingCourserWeb/pkg/repository/dbrepo"
+	"text/tabwriter"
+	"time"
+)
+
+// defaultDSN is what the migrate actions connect to with each database driver, if -dsn isn't set.
+var defaultDSN = map[string]string{
+	dbrepo.Postgres: "host=localhost port=5433 user=postgres password=postgres dbname=users sslmode=disable timezone=UTC connect_timeout=5",
+	dbrepo.SQLite:   "users.db",
+}
+
+// migrateUp applies every pending migration.
+func (app *application) migrateUp() error {
+	return app.withMigrator(func(m *migrations.Migrator) error {
+		done, err := m.Up(context.Background())
+		for _, migration := range done {
+			fmt.Printf("Applied %04d_%s\n", migration.Version, migration.Name)
+		}
+		if err == nil && len(done) == 0 {
+			fmt.Println("Nothing to apply; the database is up to date")
+		}
+		return err
+	})
+}
+
+// migrateDown rolls back the most recent -steps migrations.
+func (app *application) migrateDown() error {
+	if app.Steps < 1 {
+		return errors.New("migrate-down requires -steps of at least 1")
+	}
+	return app.withMigrator(func(m *migrations.Migrator) error {
+		done, err := m.Down(context.Background(), app.Steps)
+		for _, migration := range done {
+			fmt.Printf("Rolled back %04d_%s\n", migration.Version, migration.Name)
+		}
+		return err
+	})
+}
+
+// migrateStatus prints every migration, and when it was applied.
+func (app *application) migrateStatus() error {
+	return app.withMigrator(func(m *migrations.Migrator) error {
+		statuses, err := m.Status(context.Background())
+		if err != nil {
+			return err
+		}
+
+		tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "VERSION\tNAME\tAPPLIED")
+		for _, s := range statuses {
+			applied := "pending"
+			if s.AppliedAt != nil {
+				applied = s.AppliedAt.Format(time.RFC3339)
+			}
+			fmt.Fprintf(tw, "%04d\t%s\t%s\n", s.Version, s.Name, applied)
+		}
+		return tw.Flush()
+	})
+}
+
+// migrateCreate writes the files for a new migration, for every dialect; it needs no database.
+func (app *application) migrateCreate() error {
+	if app.Name == "" {
+		return errors.New("migrate-create requires -name")
+	}
+	for _, dialect := range migrations.Dialects {
+		up, down, err := migrations.Create(filepath.Join(app.MigrationsDir, dialect), app.Name)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Created %s and %s\n", up, down)
+	}
+	return nil
+}
+
+// withMigrator connects to the database, and runs fn with a migrator for it.
+func (app *application) withMigrator(fn func(m *migrations.Migrator) error) error {
+	db, err := dbrepo.Open(app.DBDriver, app.DSN)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	m, err := migrations.New(db, app.DBDriver)
+	if err != nil {
+		return err
+	}
+	return fn(m)
+}
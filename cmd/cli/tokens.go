@@ -0,0 +1,51 @@
+package main
+
+import (
+	"fmt"
+	"github.com/golang-jwt/jwt/v4"
+	"testingCourserWeb/pkg/signing"
+	"time"
+)
+
+// printToken prints a valid or expired access token for user 1.
+func (app *application) printToken() error {
+	// set claims
+	claims := jwt.MapClaims{}
+	claims["name"] = "John Doe"
+	claims["sub"] = "1"
+	claims["admin"] = true
+	claims["aud"] = "example.com"
+	claims["iss"] = "example.com"
+	// leave this to 3 days, for easy manual testing
+	if app.Action == "valid" {
+		expires := time.Now().UTC().Add(time.Hour * 72)
+		claims["exp"] = expires.Unix()
+	} else {
+		expires := time.Now().UTC().Add(time.Hour * 100 * -1)
+		claims["exp"] = expires.Unix()
+	}
+
+	// sign with the active key from the keyring, or the secret if we don't have one
+	key := signing.NewHMACKey("", []byte(app.JWTSecret))
+	if app.Keyring != "" {
+		keys, err := signing.LoadKeyring(app.Keyring)
+		if err != nil {
+			return err
+		}
+		key = keys.Active()
+	}
+
+	// create the token as a slice of bytes
+	if app.Action == "valid" {
+		fmt.Println("VALID Token:")
+	} else {
+		fmt.Println("EXPIRED Token:")
+	}
+	signedAccessToken, err := key.Sign(claims)
+	if err != nil {
+		return err
+	}
+	// print to console
+	fmt.Println(signedAccessToken)
+	return nil
+}
@@ -1,14 +1,21 @@
 package main
 
 import (
+	"context"
 	"database/sql"
-	_ "github.com/jackc/pgconn"
-	_ "github.com/jackc/pgx/v4/stdlib"
 	"log"
+	"testingCourserWeb/pkg/migrations"
+	"testingCourserWeb/pkg/repository/dbrepo"
 )
 
-func openDB(dsn string) (*sql.DB, error) {
-	db, err := sql.Open("pgx", dsn)
+// defaultDSN is what we connect to with each database driver, if -dsn isn't set.
+var defaultDSN = map[string]string{
+	dbrepo.Postgres: "host=localhost port=5433 user=postgres password=postgres dbname=users sslmode=disable timezone=UTC connect_timeout=5",
+	dbrepo.SQLite:   "users.db",
+}
+
+func openDB(driver, dsn string) (*sql.DB, error) {
+	db, err := dbrepo.Open(driver, dsn)
 	if err != nil {
 		return nil, err
 	}
@@ -20,10 +27,24 @@ func openDB(dsn string) (*sql.DB, error) {
 }
 
 func (app *application) connectToDB() (*sql.DB, error) {
-	connection, err := openDB(app.DSN)
+	connection, err := openDB(app.DBDriver, app.DSN)
 	if err != nil {
 		return nil, err
 	}
-	log.Println("Connected to Postgres database!")
+	log.Printf("Connected to %s database!\n", app.DBDriver)
 	return connection, nil
 }
+
+// migrate applies any migrations the database is missing.
+func migrate(db *sql.DB, driver string) error {
+	m, err := migrations.New(db, driver)
+	if err != nil {
+		return err
+	}
+
+	done, err := m.Up(context.Background())
+	for _, migration := range done {
+		log.Printf("Applied migration %04d_%s\n", migration.Version, migration.Name)
+	}
+	return err
+}
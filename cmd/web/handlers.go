@@ -1,6 +1,8 @@
 package main
 
 import (
+	// forms.go has a type called errors
+	stderrors "errors"
 	"fmt"
 	"html/template"
 	"io"
@@ -9,7 +11,12 @@ import (
 	"os"
 	"path"
 	"path/filepath"
+	"strconv"
+	"strings"
 	"testingCourserWeb/pkg/data"
+	"testingCourserWeb/pkg/ratelimit"
+	"testingCourserWeb/pkg/repository"
+	"testingCourserWeb/pkg/validator"
 	"time"
 )
 
@@ -66,7 +73,9 @@ func (app *application) Login(w http.ResponseWriter, r *http.Request) {
 	//validate data
 	form := NewForm(r.PostForm)
 	form.Required("email", "password")
+	form.Check(validator.IsEmail(r.PostForm.Get("email")), "email", "Invalid email address")
 	if !form.Valid() {
+		app.audit(r, data.AuditEvent{Event: data.AuditLoginFailed, Email: r.PostForm.Get("email"), Reason: "invalid form"})
 		//redirect to the login page with error message
 		app.Session.Put(r.Context(), "error", "Invalid Login credentials")
 		http.Redirect(w, r, "/", http.StatusSeeOther)
@@ -76,8 +85,20 @@ func (app *application) Login(w http.ResponseWriter, r *http.Request) {
 	email := r.Form.Get("email")
 	password := r.Form.Get("password")
 
-	user, err := app.DB.GetUserByEmail(email)
+	// turn away anybody trying too often before spending any time on them
+	if !app.allowLogin(w, r, email) {
+		return
+	}
+
+	user, err := app.DB.GetUserByEmail(r.Context(), email)
 	if err != nil {
+		reason := "unknown email"
+		if stderrors.Is(err, repository.ErrNotFound) {
+			app.loginFailed(r, email)
+		} else {
+			reason = err.Error()
+		}
+		app.audit(r, data.AuditEvent{Event: data.AuditLoginFailed, Email: email, Reason: reason})
 		//redirect to the login page with error
 		app.Session.Put(r.Context(), "error", "Invalid login!")
 		http.Redirect(w, r, "/", http.StatusSeeOther)
@@ -86,7 +107,21 @@ func (app *application) Login(w http.ResponseWriter, r *http.Request) {
 
 	// authenticate the user
 	// if not authenticated then redirect with error
-	if !app.authenticate(r, user, password) {
+	if err := app.authenticate(user, password); err != nil {
+		app.audit(r, data.AuditEvent{Event: data.AuditLoginFailed, UserID: &user.ID, Email: email, Reason: err.Error()})
+		// a deactivated user knew their password, so only wrong ones count towards a lockout
+		if err == errWrongPassword {
+			app.loginFailed(r, email)
+		}
+		app.Session.Put(r.Context(), "error", "Invalid login!")
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+
+	// with two-factor authentication on, the password only gets the user as far as the second step
+	mfaEnabled, err := app.MFA.Enabled(r.Context(), user.ID)
+	if err != nil {
+		log.Println(err)
 		app.Session.Put(r.Context(), "error", "Invalid login!")
 		http.Redirect(w, r, "/", http.StatusSeeOther)
 		return
@@ -95,6 +130,22 @@ func (app *application) Login(w http.ResponseWriter, r *http.Request) {
 	// prevent fixation attack
 	_ = app.Session.RenewToken(r.Context())
 
+	if mfaEnabled {
+		app.challengeMFA(w, r, user)
+		return
+	}
+	app.logIn(w, r, user, email)
+}
+
+// logIn logs in a user who has got through every step of logging in to account, the email they
+// logged in with, and sends them to their profile.
+func (app *application) logIn(w http.ResponseWriter, r *http.Request, user *data.User, account string) {
+	app.Session.Put(r.Context(), "user", user)
+	app.audit(r, data.AuditEvent{Event: data.AuditLoginSucceeded, UserID: &user.ID, Email: user.Email})
+	if err := app.Logins.Succeeded(r.Context(), account); err != nil {
+		log.Println("clearing failed logins:", err)
+	}
+
 	// store success message in session
 
 	//redirect to some other page
@@ -102,18 +153,131 @@ func (app *application) Login(w http.ResponseWriter, r *http.Request) {
 	http.Redirect(w, r, "/user/profile", http.StatusSeeOther)
 }
 
-func (app *application) authenticate(r *http.Request, user *data.User, password string) bool {
+// The reasons authenticate gives for refusing a login, which go in the audit log; the user is
+// only ever told their login was invalid.
+var (
+	errWrongPassword      = fmt.Errorf("wrong password")
+	errAccountDeactivated = fmt.Errorf("account deactivated")
+)
+
+// authenticate checks that password is user's, and that they may log in.
+func (app *application) authenticate(user *data.User, password string) error {
 	if valid, err := user.PasswordMatches(password); err != nil || !valid {
+		return errWrongPassword
+	}
+	// deactivated users keep their password, but may not log in with it
+	if user.DisabledAt != nil {
+		return errAccountDeactivated
+	}
+	return nil
+}
+
+// allowLogin turns the client away with a 429 if it, or the account email, has been trying to log
+// in too often. If the limits can't be checked, the login goes ahead, rather than stop everybody
+// logging in.
+func (app *application) allowLogin(w http.ResponseWriter, r *http.Request, email string) bool {
+	err := app.Logins.Allow(r.Context(), app.ipFromContext(r.Context()), email)
+	var denied *ratelimit.Denied
+	if stderrors.As(err, &denied) {
+		app.audit(r, data.AuditEvent{Event: data.AuditLoginFailed, Email: email, Reason: denied.Reason})
+		w.Header().Set("Retry-After", strconv.Itoa(denied.RetryAfterSeconds()))
+		http.Error(w, "too many login attempts; try again later", http.StatusTooManyRequests)
 		return false
+	} else if err != nil {
+		log.Println("checking login rate limits:", err)
 	}
-	app.Session.Put(r.Context(), "user", user)
 	return true
 }
 
+// loginFailed counts a failed login to email towards locking the account out.
+func (app *application) loginFailed(r *http.Request, email string) {
+	err := app.Logins.Failed(r.Context(), email)
+	if err != nil {
+		log.Println("counting failed login:", err)
+	}
+}
+
+// audit records an authentication event in the security audit log, along with where the request
+// came from. A login should not fail because we could not record it, so errors are only logged.
+func (app *application) audit(r *http.Request, e data.AuditEvent) {
+	e.IP = app.ipFromContext(r.Context())
+	e.UserAgent = r.UserAgent()
+
+	_, err := app.DB.InsertAuditEvent(r.Context(), e)
+	if err != nil {
+		log.Printf("could not record %s event: %s", e.Event, err)
+	}
+}
+
 func (app *application) Profile(w http.ResponseWriter, r *http.Request) {
 	_ = app.render(w, r, "profile.page.gohtml", &TemplateData{})
 }
 
+// adminUserRow is one row of the admin users table. The name and email are HTML, since
+// search results have their matches highlighted.
+type adminUserRow struct {
+	ID        int
+	FirstName template.HTML
+	LastName  template.HTML
+	Email     template.HTML
+	IsAdmin   int
+}
+
+// AdminUsers lists users, for staff with the users:read permission. With a q in the query
+// string it shows the users matching that search instead, best match first.
+func (app *application) AdminUsers(w http.ResponseWriter, r *http.Request) {
+	var rows []adminUserRow
+	q := strings.TrimSpace(r.URL.Query().Get("q"))
+
+	if q != "" {
+		results, err := app.DB.SearchUsers(r.Context(), q, repository.MaxListLimit)
+		if err != nil {
+			log.Println(err)
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		for _, result := range results {
+			rows = append(rows, adminUserRow{
+				ID:        result.ID,
+				FirstName: highlighted(result.Highlights, "first_name", result.FirstName),
+				LastName:  highlighted(result.Highlights, "last_name", result.LastName),
+				Email:     highlighted(result.Highlights, "email", result.Email),
+				IsAdmin:   result.IsAdmin,
+			})
+		}
+	} else {
+		users, err := app.DB.AllUsers(r.Context())
+		if err != nil {
+			log.Println(err)
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		for _, user := range users {
+			rows = append(rows, adminUserRow{
+				ID:        user.ID,
+				FirstName: highlighted(nil, "first_name", user.FirstName),
+				LastName:  highlighted(nil, "last_name", user.LastName),
+				Email:     highlighted(nil, "email", user.Email),
+				IsAdmin:   user.IsAdmin,
+			})
+		}
+	}
+
+	var td = make(map[string]any)
+	td["users"] = rows
+	td["q"] = q
+	_ = app.render(w, r, "admin-users.page.gohtml", &TemplateData{Data: td})
+}
+
+// highlighted returns the highlighted form of field if there is one, and value escaped if not.
+func highlighted(highlights map[string]string, field, value string) template.HTML {
+	if h, ok := highlights[field]; ok {
+		// highlights have already been escaped, with only <mark> tags added
+		return template.HTML(h)
+	}
+	return template.HTML(template.HTMLEscapeString(value))
+}
+
 func (app *application) UploadProfilePic(w http.ResponseWriter, r *http.Request) {
 	// call a function that extracts a file from upload (request)
 	files, err := app.UploadFiles(r, uploadPath)
@@ -131,13 +295,13 @@ func (app *application) UploadProfilePic(w http.ResponseWriter, r *http.Request)
 	}
 
 	//insert the user image into user_images
-	_, err = app.DB.InsertUserImage(i)
+	_, err = app.DB.InsertUserImage(r.Context(), i)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 	//refresh the session variable "user"
-	updatedUser, err := app.DB.GetUser(user.ID)
+	updatedUser, err := app.DB.GetUser(r.Context(), user.ID)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
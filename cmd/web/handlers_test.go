@@ -18,6 +18,9 @@ import (
 	"sync"
 	"testing"
 	"testingCourserWeb/pkg/data"
+	"testingCourserWeb/pkg/ratelimit"
+	"testingCourserWeb/pkg/repository"
+	"time"
 )
 
 func Test_application_handlers(t *testing.T) {
@@ -33,6 +36,11 @@ func Test_application_handlers(t *testing.T) {
 			http.StatusNotFound},
 		{"profile", "/user/profile", http.StatusOK, "/",
 			http.StatusTemporaryRedirect},
+		{"admin users", "/admin/users", http.StatusOK, "/",
+			http.StatusTemporaryRedirect},
+		{"forgot password", "/password/forgot", http.StatusOK, "/password/forgot", http.StatusOK},
+		{"reset password without a token", "/password/reset", http.StatusOK, "/password/forgot",
+			http.StatusSeeOther},
 	}
 
 	routes := app.routes()
@@ -112,6 +120,38 @@ func TestAppHome(t *testing.T) {
 	}
 }
 
+func Test_app_AdminUsers(t *testing.T) {
+	var tests = []struct {
+		name         string
+		query        string
+		expectedHTML string
+	}{
+		{"list", "", "<h1 class=\"mt-3\">Users</h1>"},
+		{"search", "?q=adm", "<mark>Adm</mark>in"},
+		{"search without results", "?q=nobody", "No users found"},
+	}
+
+	for _, e := range tests {
+		req, _ := http.NewRequest("GET", "/admin/users"+e.query, nil)
+		req = addContextAddSessionToRequest(req, app)
+		app.Session.Put(req.Context(), "user", data.User{ID: 1})
+
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(app.AdminUsers)
+
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusOK {
+			t.Errorf("%s: wrong status code; expected 200 but got %d", e.name, rr.Code)
+		}
+
+		body, _ := io.ReadAll(rr.Body)
+		if !strings.Contains(string(body), e.expectedHTML) {
+			t.Errorf("%s: did not find %s in response body", e.name, e.expectedHTML)
+		}
+	}
+}
+
 func getCtx(req *http.Request) context.Context {
 	ctx := context.WithValue(req.Context(), contextUserKey, "unknown")
 	return ctx
@@ -148,6 +188,15 @@ func Test_app_login(t *testing.T) {
 			expectedStatusCode: http.StatusSeeOther,
 			expectedLoc:        "/",
 		},
+		{
+			name: "invalid email",
+			postedData: url.Values{
+				"email":    {"admin"},
+				"password": {"secret"},
+			},
+			expectedStatusCode: http.StatusSeeOther,
+			expectedLoc:        "/",
+		},
 		{
 			name: "user not found",
 			postedData: url.Values{
@@ -192,6 +241,105 @@ func Test_app_login(t *testing.T) {
 
 }
 
+func Test_app_login_deactivated(t *testing.T) {
+	db := useFreshDB(t)
+	_ = db.DeactivateUser(context.Background(), 1)
+
+	postedData := url.Values{
+		"email":    {"admin@example.com"},
+		"password": {"secret"},
+	}
+	req, _ := http.NewRequest("POST", "/login", strings.NewReader(postedData.Encode()))
+	req = addContextAddSessionToRequest(req, app)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(app.Login).ServeHTTP(rr, req)
+
+	if loc, _ := rr.Result().Location(); rr.Code != http.StatusSeeOther || loc == nil || loc.String() != "/" {
+		t.Errorf("expected to be sent back to the login page, but got %d %v", rr.Code, loc)
+	}
+	if app.Session.Exists(req.Context(), "user") {
+		t.Error("expected a deactivated user not to be logged in")
+	}
+}
+
+func Test_app_login_audit(t *testing.T) {
+	db := useFreshDB(t)
+	_ = db.DeactivateUser(context.Background(), 1)
+
+	var tests = []struct {
+		name           string
+		email          string
+		password       string
+		expectedEvent  string
+		expectedReason string
+		expectedUser   bool
+	}{
+		{"invalid form", "admin", "secret", data.AuditLoginFailed, "invalid form", false},
+		{"unknown email", "jack@example.com", "secret", data.AuditLoginFailed, "unknown email", false},
+		{"deactivated", "admin@example.com", "secret", data.AuditLoginFailed, "account deactivated", true},
+		{"restored, wrong password", "admin@example.com", "wrong", data.AuditLoginFailed, "wrong password", true},
+		{"restored", "admin@example.com", "secret", data.AuditLoginSucceeded, "", true},
+	}
+
+	for _, e := range tests {
+		if e.name == "restored, wrong password" {
+			_ = db.RestoreUser(context.Background(), 1)
+		}
+
+		postedData := url.Values{"email": {e.email}, "password": {e.password}}
+		req, _ := http.NewRequest("POST", "/login", strings.NewReader(postedData.Encode()))
+		req = addContextAddSessionToRequest(req, app)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.Header.Set("User-Agent", "audit-test")
+		http.HandlerFunc(app.Login).ServeHTTP(httptest.NewRecorder(), req)
+
+		events, _, _ := db.ListAuditEvents(context.Background(), repository.AuditFilter{Limit: 1})
+		if len(events) != 1 {
+			t.Fatalf("%s: expected an event to be recorded", e.name)
+		}
+		got := events[0]
+		if got.Event != e.expectedEvent || got.Reason != e.expectedReason || (got.UserID != nil) != e.expectedUser ||
+			got.Email != e.email || got.IP != "unknown" || got.UserAgent != "audit-test" {
+			t.Errorf("%s: expected a %s event, because %q, but got %+v", e.name, e.expectedEvent, e.expectedReason, got)
+		}
+	}
+}
+
+func Test_app_login_limits(t *testing.T) {
+	useFreshDB(t)
+	app.Logins.Lockout = ratelimit.Lockout{After: 2, Base: time.Minute}
+
+	var tests = []struct {
+		name               string
+		password           string
+		expectedStatusCode int
+	}{
+		{"wrong password", "wrong", http.StatusSeeOther},
+		{"wrong again, locking the account", "wrong", http.StatusSeeOther},
+		{"locked", "secret", http.StatusTooManyRequests},
+	}
+
+	for _, e := range tests {
+		postedData := url.Values{"email": {"admin@example.com"}, "password": {e.password}}
+		req, _ := http.NewRequest("POST", "/login", strings.NewReader(postedData.Encode()))
+		req = addContextAddSessionToRequest(req, app)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(app.Login).ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s: expected status %d, but got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+		if rr.Code == http.StatusTooManyRequests && rr.Header().Get("Retry-After") == "" {
+			t.Errorf("%s: expected a Retry-After header", e.name)
+		}
+		if rr.Code == http.StatusTooManyRequests && app.Session.Exists(req.Context(), "user") {
+			t.Errorf("%s: expected not to be logged in", e.name)
+		}
+	}
+}
+
 func Test_app_UploadFiles(t *testing.T) {
 	// set up pipes
 	pr, pw := io.Pipe()
@@ -251,6 +399,7 @@ func simulatePNGUpload(fileToUpload string, writer *multipart.Writer, t *testing
 }
 
 func Test_app_uploadProfilePic(t *testing.T) {
+	db := useFreshDB(t)
 	uploadPath = "./testdata/uploads"
 	filePath := "./testdata/img.png"
 	//specify a field name for the form
@@ -290,6 +439,11 @@ func Test_app_uploadProfilePic(t *testing.T) {
 		t.Errorf("wrong status code")
 	}
 
+	user, _ := db.GetUser(req.Context(), 1)
+	if user.ProfilePic.FileName != "img.png" {
+		t.Errorf("expected the profile pic to be img.png, but got %q", user.ProfilePic.FileName)
+	}
+
 	_ = os.Remove("./testdata/uploads/img.png")
 
 }
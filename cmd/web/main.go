@@ -6,32 +6,83 @@ import (
 	"github.com/alexedwards/scs/v2"
 	"log"
 	"net/http"
+	"testingCourserWeb/pkg/clientip"
 	"testingCourserWeb/pkg/data"
+	"testingCourserWeb/pkg/mailer"
+	"testingCourserWeb/pkg/mfa"
+	"testingCourserWeb/pkg/passwordreset"
+	"testingCourserWeb/pkg/ratelimit"
+	"testingCourserWeb/pkg/ratelimitstore"
 	"testingCourserWeb/pkg/repository"
 	"testingCourserWeb/pkg/repository/dbrepo"
 )
 
 type application struct {
-	DSN     string
-	DB      repository.DatabaseRepo
-	Session *scs.SessionManager
+	DSN            string
+	DBDriver       string
+	Domain         string
+	DB             repository.DatabaseRepo
+	Session        *scs.SessionManager
+	Logins         *ratelimit.Logins
+	ResetLimits    *ratelimit.Resets
+	MFA            *mfa.Manager
+	PasswordResets *passwordreset.Manager
+	TrustedProxies clientip.Proxies
 }
 
 func main() {
 	gob.Register(data.User{})
 	// set up an app config
 	app := application{}
+	var autoMigrate bool
+	var rateLimitStore string
+	var resetSecret, resetURL string
+	var smtpAddr, smtpFrom, smtpUser, smtpPassword string
 
-	flag.StringVar(&app.DSN, "dsn", "host=localhost port=5433 user=postgres password=postgres dbname=users sslmode=disable timezone=UTC connect_timeout=5", "Postgres connection")
+	flag.StringVar(&app.DBDriver, "db-driver", dbrepo.Postgres, "database: postgres|sqlite")
+	flag.StringVar(&app.DSN, "dsn", "", "Postgres connection, or SQLite database file (defaults to a local database for -db-driver)")
+	flag.StringVar(&app.Domain, "domain", "example.com", "Domain for application, e.g. company.com; shown in authenticator apps")
+	flag.BoolVar(&autoMigrate, "migrate", true, "apply pending database migrations on startup; with -migrate=false, run them with the cli instead")
+	flag.StringVar(&rateLimitStore, "rate-limit-store", ratelimitstore.Memory, "where to keep rate limits: memory|database (postgres only, shared by every instance)")
+	flag.TextVar(&app.TrustedProxies, "trusted-proxies", clientip.Proxies{}, "comma separated addresses and networks of proxies trusted to set X-Forwarded-For, like 10.0.0.0/8")
+	flag.StringVar(&resetSecret, "reset-secret", "8sadf7as9df87asdf98a7sdf98a7sdf98a7sdf", "secret signing password reset tokens; must be the same as the api's")
+	flag.StringVar(&resetURL, "reset-url", "http://localhost:8080/password/reset", "this app's password reset page, as users reach it, for the links we email")
+	flag.StringVar(&smtpAddr, "smtp-addr", "", "SMTP server to send mail through, as host:port (mail is only logged if empty)")
+	flag.StringVar(&smtpFrom, "smtp-from", "noreply@example.com", "address mail is sent from")
+	flag.StringVar(&smtpUser, "smtp-user", "", "SMTP username, if the server needs one")
+	flag.StringVar(&smtpPassword, "smtp-password", "", "SMTP password")
 	flag.Parse()
 
+	if app.DSN == "" {
+		app.DSN = defaultDSN[app.DBDriver]
+	}
+
 	conn, err := app.connectToDB()
 	if err != nil {
 		log.Fatal(err)
 	}
 	defer conn.Close()
 
-	app.DB = &dbrepo.PostgresDBRepo{DB: conn}
+	if autoMigrate {
+		err = migrate(conn, app.DBDriver)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	app.DB, err = dbrepo.New(app.DBDriver, conn)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	store, err := ratelimitstore.Open(rateLimitStore, app.DB, app.DBDriver)
+	if err != nil {
+		log.Fatal(err)
+	}
+	app.Logins = ratelimit.NewLogins(store, app.DB)
+	app.ResetLimits = ratelimit.NewResets(store)
+	app.MFA = mfa.NewManager(app.DB, app.Domain)
+	app.PasswordResets = passwordreset.NewManager(app.DB, mailer.New(smtpAddr, smtpFrom, smtpUser, smtpPassword), []byte(resetSecret), resetURL)
 	//get a session manager
 	app.Session = getSession()
 
@@ -0,0 +1,279 @@
+package main
+
+import (
+	"html/template"
+	"log"
+	"net/http"
+	"testingCourserWeb/pkg/data"
+	"testingCourserWeb/pkg/mfa"
+	"time"
+)
+
+// mfaLoginExpiry is how long a user has to give their code, once they have given their password.
+var mfaLoginExpiry = time.Minute * 5
+
+// challengeMFA remembers, in the session, that user has given the right password, and sends them
+// on to give their code.
+func (app *application) challengeMFA(w http.ResponseWriter, r *http.Request, user *data.User) {
+	app.audit(r, data.AuditEvent{Event: data.AuditMFARequired, UserID: &user.ID, Email: user.Email})
+	app.Session.Put(r.Context(), "mfa_user_id", user.ID)
+	app.Session.Put(r.Context(), "mfa_expires", time.Now().Add(mfaLoginExpiry).Unix())
+	http.Redirect(w, r, "/login/mfa", http.StatusSeeOther)
+}
+
+// pendingMFA returns the id of the user part way through logging in, who has given their
+// password but not yet their code, if their time to give it has not run out.
+func (app *application) pendingMFA(r *http.Request) (int, bool) {
+	id := app.Session.GetInt(r.Context(), "mfa_user_id")
+	expires := app.Session.GetInt64(r.Context(), "mfa_expires")
+	if id == 0 || time.Now().Unix() > expires {
+		return 0, false
+	}
+	return id, true
+}
+
+// loginExpired sends a user whose login has gone stale back to the login page.
+func (app *application) loginExpired(w http.ResponseWriter, r *http.Request) {
+	app.Session.Remove(r.Context(), "mfa_user_id")
+	app.Session.Remove(r.Context(), "mfa_expires")
+	app.Session.Put(r.Context(), "error", "Your login has expired; log in again")
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+// LoginMFAPage asks a user who has given their password for a code.
+func (app *application) LoginMFAPage(w http.ResponseWriter, r *http.Request) {
+	if _, ok := app.pendingMFA(r); !ok {
+		app.loginExpired(w, r)
+		return
+	}
+	_ = app.render(w, r, "mfa.page.gohtml", &TemplateData{})
+}
+
+// LoginMFA is the second step of logging in with two-factor authentication: given a code from
+// the user's app, or one of their recovery codes, it logs in the user who gave their password.
+// Wrong codes count towards locking the account out, like wrong passwords.
+func (app *application) LoginMFA(w http.ResponseWriter, r *http.Request) {
+	id, ok := app.pendingMFA(r)
+	if !ok {
+		app.audit(r, data.AuditEvent{Event: data.AuditLoginFailed, Reason: "no pending mfa login"})
+		app.loginExpired(w, r)
+		return
+	}
+	err := r.ParseForm()
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+
+	user, err := app.DB.GetUser(r.Context(), id)
+	if err != nil {
+		app.audit(r, data.AuditEvent{Event: data.AuditLoginFailed, UserID: &id, Reason: "unknown user"})
+		app.loginExpired(w, r)
+		return
+	}
+	failed := func(reason string) {
+		app.audit(r, data.AuditEvent{Event: data.AuditLoginFailed, UserID: &user.ID, Email: user.Email, Reason: reason})
+	}
+
+	if !app.allowLogin(w, r, user.Email) {
+		return
+	}
+	// the user may have been deactivated since they gave their password
+	if user.DisabledAt != nil {
+		failed(errAccountDeactivated.Error())
+		app.loginExpired(w, r)
+		return
+	}
+
+	method, err := app.MFA.Verify(r.Context(), user.ID, r.PostForm.Get("code"))
+	switch {
+	case err == mfa.ErrInvalidCode:
+		failed("wrong mfa code")
+		app.loginFailed(r, user.Email)
+		app.Session.Put(r.Context(), "error", "Invalid code!")
+		http.Redirect(w, r, "/login/mfa", http.StatusSeeOther)
+		return
+	case err == mfa.ErrNotEnrolled:
+		// two-factor authentication was turned off since the first step
+		failed("mfa not enabled")
+		app.loginExpired(w, r)
+		return
+	case err != nil:
+		log.Println(err)
+		app.Session.Put(r.Context(), "error", "Invalid code!")
+		http.Redirect(w, r, "/login/mfa", http.StatusSeeOther)
+		return
+	}
+	if method == mfa.MethodRecoveryCode {
+		app.audit(r, data.AuditEvent{Event: data.AuditRecoveryCodeUsed, UserID: &user.ID, Email: user.Email})
+	}
+
+	app.Session.Remove(r.Context(), "mfa_user_id")
+	app.Session.Remove(r.Context(), "mfa_expires")
+	app.logIn(w, r, user, user.Email)
+}
+
+// MFASettings shows the user their two-factor authentication: whether it is on, and how many
+// recovery codes they have left, or, while they are setting it up, the secret to put in their app.
+func (app *application) MFASettings(w http.ResponseWriter, r *http.Request) {
+	user := app.Session.Get(r.Context(), "user").(data.User)
+
+	status, err := app.MFA.Status(r.Context(), user.ID)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+
+	var td = make(map[string]any)
+	td["mfa"] = status
+	if status.Secret != "" && !status.Enabled() {
+		td["secret"] = status.Secret
+		// html/template would throw away an otpauth: link, but this one is ours, and escaped
+		td["uri"] = template.URL(mfa.ProvisioningURI(app.MFA.Issuer, user.Email, status.Secret))
+		w.Header().Set("Cache-Control", "no-store")
+	}
+	_ = app.render(w, r, "mfa-setup.page.gohtml", &TemplateData{Data: td})
+}
+
+// EnrollMFA starts setting up two-factor authentication, with a new secret, which the user then
+// confirms with a code from their app.
+func (app *application) EnrollMFA(w http.ResponseWriter, r *http.Request) {
+	user := app.Session.Get(r.Context(), "user").(data.User)
+
+	_, err := app.MFA.Enroll(r.Context(), user.ID, user.Email)
+	if err != nil {
+		app.mfaFailed(r, err)
+	}
+	http.Redirect(w, r, "/user/mfa", http.StatusSeeOther)
+}
+
+// ConfirmMFA turns on two-factor authentication, given a code from the app it was set up in, and
+// shows the user their recovery codes.
+func (app *application) ConfirmMFA(w http.ResponseWriter, r *http.Request) {
+	user, code, ok := app.mfaUserAndCode(w, r)
+	if !ok {
+		return
+	}
+
+	codes, err := app.MFA.Confirm(r.Context(), user.ID, code)
+	if err != nil {
+		app.mfaCodeFailed(r, user, err)
+		http.Redirect(w, r, "/user/mfa", http.StatusSeeOther)
+		return
+	}
+	app.audit(r, data.AuditEvent{Event: data.AuditMFAEnabled, UserID: &user.ID, Email: user.Email})
+	app.Session.Put(r.Context(), "flash", "Two-factor authentication is on")
+	app.renderRecoveryCodes(w, r, codes)
+}
+
+// RegenerateRecoveryCodes gives the user new recovery codes, in place of their old ones, given
+// a code, and shows them.
+func (app *application) RegenerateRecoveryCodes(w http.ResponseWriter, r *http.Request) {
+	user, code, ok := app.mfaUserAndCode(w, r)
+	if !ok {
+		return
+	}
+
+	codes, err := app.MFA.RegenerateRecoveryCodes(r.Context(), user.ID, code)
+	if err != nil {
+		app.mfaCodeFailed(r, user, err)
+		http.Redirect(w, r, "/user/mfa", http.StatusSeeOther)
+		return
+	}
+	app.audit(r, data.AuditEvent{Event: data.AuditRecoveryCodesRegenerated, UserID: &user.ID, Email: user.Email})
+	app.renderRecoveryCodes(w, r, codes)
+}
+
+// DisableMFA turns off two-factor authentication, given a code.
+func (app *application) DisableMFA(w http.ResponseWriter, r *http.Request) {
+	user, code, ok := app.mfaUserAndCode(w, r)
+	if !ok {
+		return
+	}
+
+	err := app.MFA.Disable(r.Context(), user.ID, code)
+	if err != nil {
+		app.mfaCodeFailed(r, user, err)
+	} else {
+		app.audit(r, data.AuditEvent{Event: data.AuditMFADisabled, UserID: &user.ID, Email: user.Email})
+		app.Session.Put(r.Context(), "flash", "Two-factor authentication is off")
+	}
+	http.Redirect(w, r, "/user/mfa", http.StatusSeeOther)
+}
+
+// renderRecoveryCodes shows the user their new recovery codes, which is the only time they can be.
+func (app *application) renderRecoveryCodes(w http.ResponseWriter, r *http.Request, codes []string) {
+	status, err := app.MFA.Status(r.Context(), app.Session.Get(r.Context(), "user").(data.User).ID)
+	if err != nil {
+		// the codes have been changed all the same, so they must be shown
+		log.Println(err)
+		status = &data.UserMFA{}
+	}
+
+	var td = make(map[string]any)
+	td["mfa"] = status
+	td["recovery_codes"] = codes
+	w.Header().Set("Cache-Control", "no-store")
+	_ = app.render(w, r, "mfa-setup.page.gohtml", &TemplateData{Data: td})
+}
+
+// mfaCode returns the code posted to one of the pages changing two-factor authentication; if
+// there isn't one, it sends the user back with an error.
+func (app *application) mfaCode(w http.ResponseWriter, r *http.Request) (string, bool) {
+	err := r.ParseForm()
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return "", false
+	}
+	form := NewForm(r.PostForm)
+	form.Required("code")
+	if !form.Valid() {
+		app.Session.Put(r.Context(), "error", "Enter a code")
+		http.Redirect(w, r, "/user/mfa", http.StatusSeeOther)
+		return "", false
+	}
+	return r.PostForm.Get("code"), true
+}
+
+// mfaUserAndCode returns the user, and the code they posted, for the pages which need one to
+// change two-factor authentication, or to turn it on. A code is as good as a password here, so it is
+// guarded like one: the login rate limits and lockout apply, and mfaCodeFailed counts wrong codes
+// as failed logins.
+func (app *application) mfaUserAndCode(w http.ResponseWriter, r *http.Request) (*data.User, string, bool) {
+	user := app.Session.Get(r.Context(), "user").(data.User)
+	code, ok := app.mfaCode(w, r)
+	if !ok {
+		return nil, "", false
+	}
+	if !app.allowLogin(w, r, user.Email) {
+		return nil, "", false
+	}
+	return &user, code, true
+}
+
+// mfaCodeFailed reports an error from checking a code posted to one of the pages which need one.
+func (app *application) mfaCodeFailed(r *http.Request, user *data.User, err error) {
+	if err == mfa.ErrInvalidCode {
+		app.audit(r, data.AuditEvent{Event: data.AuditLoginFailed, UserID: &user.ID, Email: user.Email, Reason: "wrong mfa code"})
+		app.loginFailed(r, user.Email)
+	}
+	app.mfaFailed(r, err)
+}
+
+// mfaFailed tells the user why their two-factor authentication could not be changed.
+func (app *application) mfaFailed(r *http.Request, err error) {
+	switch err {
+	case mfa.ErrInvalidCode:
+		app.Session.Put(r.Context(), "error", "That code is wrong, or has already been used")
+	case mfa.ErrNotEnrolled:
+		app.Session.Put(r.Context(), "error", "Set up two-factor authentication first")
+	case mfa.ErrAlreadyEnabled:
+		app.Session.Put(r.Context(), "error", "Two-factor authentication is already on")
+	default:
+		log.Println(err)
+		app.Session.Put(r.Context(), "error", "Could not change two-factor authentication")
+	}
+}
@@ -0,0 +1,233 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"testingCourserWeb/pkg/data"
+	"testingCourserWeb/pkg/mfa"
+	"testingCourserWeb/pkg/ratelimit"
+	"testingCourserWeb/pkg/repository"
+	"time"
+)
+
+// enableMFA turns on two-factor authentication for user 1, and returns their secret, their
+// recovery codes, and the step of the code it was confirmed with, which can't be used again.
+func enableMFA(t *testing.T) (string, []string, int64) {
+	t.Helper()
+	ctx := context.Background()
+
+	enrollment, err := app.MFA.Enroll(ctx, 1, "admin@example.com")
+	if err != nil {
+		t.Fatalf("unexpected error enrolling: %s", err)
+	}
+	step := mfa.Step(time.Now())
+	code, _ := mfa.Code(enrollment.Secret, step)
+	codes, err := app.MFA.Confirm(ctx, 1, code)
+	if err != nil {
+		t.Fatalf("unexpected error confirming: %s", err)
+	}
+	return enrollment.Secret, codes, step
+}
+
+// postForm posts values to handler, in the session in ctx, and returns the response and the
+// context of the request, which has the session as the handler left it.
+func postForm(ctx context.Context, handler http.HandlerFunc, target string, values url.Values) (*httptest.ResponseRecorder, context.Context) {
+	req, _ := http.NewRequest("POST", target, strings.NewReader(values.Encode()))
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	return rr, req.Context()
+}
+
+func Test_app_login_mfa(t *testing.T) {
+	db := useFreshDB(t)
+	app.Logins.Lockout = ratelimit.Lockout{After: 3, Base: time.Minute}
+	secret, codes, step := enableMFA(t)
+	code := func(step int64) string {
+		code, _ := mfa.Code(secret, step)
+		return code
+	}
+
+	// logIn gives the right password, and returns the session it leaves, part way through logging in
+	logIn := func() context.Context {
+		req, _ := http.NewRequest("GET", "/", nil)
+		req = addContextAddSessionToRequest(req, app)
+		rr, ctx := postForm(req.Context(), app.Login, "/login", url.Values{"email": {"admin@example.com"}, "password": {"secret"}})
+		if loc, _ := rr.Result().Location(); rr.Code != http.StatusSeeOther || loc == nil || loc.String() != "/login/mfa" {
+			t.Fatalf("expected to be sent on to give a code, but got %d %v", rr.Code, loc)
+		}
+		if app.Session.Exists(ctx, "user") {
+			t.Fatal("expected not to be logged in before giving a code")
+		}
+		return ctx
+	}
+
+	// the page asking for a code is only for users who have given their password
+	ctx := logIn()
+	req, _ := http.NewRequest("GET", "/login/mfa", nil)
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(app.LoginMFAPage).ServeHTTP(rr, req.WithContext(ctx))
+	if rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), `action="/login/mfa"`) {
+		t.Errorf("expected to be asked for a code, but got %d", rr.Code)
+	}
+	req = addContextAddSessionToRequest(req, app)
+	rr = httptest.NewRecorder()
+	http.HandlerFunc(app.LoginMFAPage).ServeHTTP(rr, req)
+	if loc, _ := rr.Result().Location(); loc == nil || loc.String() != "/" {
+		t.Errorf("expected to be sent back to log in without a password, but got %d %v", rr.Code, loc)
+	}
+
+	var tests = []struct {
+		name           string
+		loggedIn       bool
+		expired        bool
+		code           string
+		expectedLoc    string
+		expectedReason string
+	}{
+		{"no password", false, false, code(step + 1), "/", "no pending mfa login"},
+		{"expired", true, true, code(step + 1), "/", "no pending mfa login"},
+		{"no code", true, false, "", "/login/mfa", "wrong mfa code"},
+		{"the code which confirmed mfa", true, false, code(step), "/login/mfa", "wrong mfa code"},
+		{"the next code", true, false, code(step + 1), "/user/profile", ""},
+		{"the same code again", true, false, code(step + 1), "/login/mfa", "wrong mfa code"},
+		{"a recovery code", true, false, codes[0], "/user/profile", ""},
+		{"the same recovery code again", true, false, codes[0], "/login/mfa", "wrong mfa code"},
+	}
+
+	for _, e := range tests {
+		req, _ := http.NewRequest("GET", "/", nil)
+		ctx := addContextAddSessionToRequest(req, app).Context()
+		if e.loggedIn {
+			ctx = logIn()
+		}
+		if e.expired {
+			app.Session.Put(ctx, "mfa_expires", time.Now().Add(-time.Second).Unix())
+		}
+
+		rr, ctx := postForm(ctx, app.LoginMFA, "/login/mfa", url.Values{"code": {e.code}})
+		if loc, _ := rr.Result().Location(); rr.Code != http.StatusSeeOther || loc == nil || loc.String() != e.expectedLoc {
+			t.Errorf("%s: expected to be sent to %s, but got %d %v", e.name, e.expectedLoc, rr.Code, loc)
+		}
+		if loggedIn := app.Session.Exists(ctx, "user"); loggedIn != (e.expectedLoc == "/user/profile") {
+			t.Errorf("%s: expected logged in to be %v", e.name, !loggedIn)
+		}
+
+		events, _, _ := db.ListAuditEvents(context.Background(), repository.AuditFilter{Limit: 1})
+		if len(events) != 1 {
+			t.Fatalf("%s: expected an audit event, but got none", e.name)
+		}
+		if e.expectedReason == "" && events[0].Event != data.AuditLoginSucceeded {
+			t.Errorf("%s: expected a successful login to be recorded, but got %s", e.name, events[0].Event)
+		} else if e.expectedReason != "" && events[0].Reason != e.expectedReason {
+			t.Errorf("%s: expected a failed login because %q to be recorded, but got %q", e.name, e.expectedReason, events[0].Reason)
+		}
+	}
+
+	// wrong codes count towards locking the account, like wrong passwords; the last one left a
+	// failure, so two more lock it, and then even a right code is turned away
+	ctx = logIn()
+	for _, code := range []string{"000000", "000001"} {
+		postForm(ctx, app.LoginMFA, "/login/mfa", url.Values{"code": {code}})
+	}
+	rr, ctx = postForm(ctx, app.LoginMFA, "/login/mfa", url.Values{"code": {codes[1]}})
+	if rr.Code != http.StatusTooManyRequests || app.Session.Exists(ctx, "user") {
+		t.Errorf("expected the account to be locked, but got %d", rr.Code)
+	}
+}
+
+func Test_app_mfa_settings(t *testing.T) {
+	db := useFreshDB(t)
+
+	req, _ := http.NewRequest("GET", "/user/mfa", nil)
+	req = addContextAddSessionToRequest(req, app)
+	ctx := req.Context()
+	app.Session.Put(ctx, "user", data.User{ID: 1, Email: "admin@example.com"})
+
+	// page renders the settings page, and returns its body
+	page := func() string {
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(app.MFASettings).ServeHTTP(rr, req.WithContext(ctx))
+		if rr.Code != http.StatusOK {
+			t.Fatalf("expected the settings page, but got %d", rr.Code)
+		}
+		return rr.Body.String()
+	}
+
+	if body := page(); !strings.Contains(body, `action="/user/mfa/enroll"`) {
+		t.Errorf("expected to be offered to set up mfa, but got %s", body)
+	}
+
+	rr, _ := postForm(ctx, app.EnrollMFA, "/user/mfa/enroll", nil)
+	if loc, _ := rr.Result().Location(); rr.Code != http.StatusSeeOther || loc == nil || loc.String() != "/user/mfa" {
+		t.Errorf("expected to be sent back to the settings, but got %d %v", rr.Code, loc)
+	}
+	status, err := db.GetUserMFA(context.Background(), 1)
+	if err != nil || status.Enabled() {
+		t.Fatalf("expected mfa to be set up, but not on, but got %+v, %v", status, err)
+	}
+	if body := page(); !strings.Contains(body, status.Secret) || !strings.Contains(body, `href="otpauth://totp/example.com:admin@example.com?`) {
+		t.Errorf("expected the secret to be shown, but got %s", body)
+	}
+
+	step := mfa.Step(time.Now())
+	code := func(step int64) string {
+		code, _ := mfa.Code(status.Secret, step)
+		return code
+	}
+
+	// a code of "recovery code" sends one of the recovery codes we were last shown
+	var codes []string
+	var tests = []struct {
+		name               string
+		handler            http.HandlerFunc
+		code               string
+		expectedStatusCode int
+		expectedEnabled    bool
+	}{
+		{"confirm without a code", app.ConfirmMFA, "", http.StatusSeeOther, false},
+		{"confirm with a wrong code", app.ConfirmMFA, "000000", http.StatusSeeOther, false},
+		{"confirm", app.ConfirmMFA, code(step), http.StatusOK, true},
+		{"enroll again", app.EnrollMFA, "", http.StatusSeeOther, true},
+		{"new recovery codes with a used code", app.RegenerateRecoveryCodes, code(step), http.StatusSeeOther, true},
+		{"new recovery codes", app.RegenerateRecoveryCodes, code(step + 1), http.StatusOK, true},
+		{"disable with a wrong code", app.DisableMFA, "000000", http.StatusSeeOther, true},
+		{"disable", app.DisableMFA, "recovery code", http.StatusSeeOther, false},
+	}
+
+	for _, e := range tests {
+		code := e.code
+		if code == "recovery code" {
+			code = codes[1]
+		}
+		rr, _ := postForm(ctx, e.handler, "/user/mfa", url.Values{"code": {code}})
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s: expected status %d, but got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+		if rr.Code == http.StatusOK {
+			codes = nil
+			for _, line := range strings.Split(rr.Body.String(), "\n") {
+				line = strings.TrimSpace(line)
+				if strings.HasPrefix(line, "<li>") {
+					codes = append(codes, strings.TrimSuffix(strings.TrimPrefix(line, "<li>"), "</li>"))
+				}
+			}
+			if len(codes) != mfa.RecoveryCodes {
+				t.Errorf("%s: expected %d recovery codes to be shown, but got %v", e.name, mfa.RecoveryCodes, codes)
+			}
+		}
+		if enabled, _ := app.MFA.Enabled(context.Background(), 1); enabled != e.expectedEnabled {
+			t.Errorf("%s: expected mfa enabled to be %v", e.name, e.expectedEnabled)
+		}
+	}
+	// every wrong code counts against the account, confirming included
+	events, _, _ := db.ListAuditEvents(context.Background(), repository.AuditFilter{Event: data.AuditLoginFailed})
+	if len(events) != 3 {
+		t.Errorf("expected 3 wrong codes to be recorded as failed logins, but got %d", len(events))
+	}
+}
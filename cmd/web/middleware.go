@@ -2,9 +2,13 @@ package main
 
 import (
 	"context"
-	"fmt"
-	"net"
+	// forms.go has a type called errors
+	stderrors "errors"
+	"log"
 	"net/http"
+	"testingCourserWeb/pkg/authz"
+	"testingCourserWeb/pkg/data"
+	"testingCourserWeb/pkg/repository"
 )
 
 type contextKey string
@@ -15,50 +19,66 @@ func (app *application) ipFromContext(ctx context.Context) string {
 	return ctx.Value(contextUserKey).(string)
 }
 
+// addIPToContext puts the ip the request came from in the context. X-Forwarded-For is only
+// believed from the proxies we were told to trust, since logins are limited per ip.
 func (app *application) addIPToContext(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		var ctx = context.Background()
-		// get the ip (as accurately as possible)
-		ip, err := getIP(r)
-		if err != nil {
-			ip, _, _ = net.SplitHostPort(r.RemoteAddr)
-			if len(ip) == 0 {
-				ip = "unknown"
-			}
-			ctx = context.WithValue(r.Context(), contextUserKey, ip)
-		} else {
-			ctx = context.WithValue(r.Context(), contextUserKey, ip)
-		}
+		ctx := context.WithValue(r.Context(), contextUserKey, app.TrustedProxies.IP(r))
 		next.ServeHTTP(w, r.WithContext(ctx))
 	})
 }
 
-func getIP(r *http.Request) (string, error) {
-	ip, _, err := net.SplitHostPort(r.RemoteAddr)
-	if err != nil {
-		return "unknown", err
-	}
-	userIP := net.ParseIP(ip)
-	if userIP == nil {
-		return "", fmt.Errorf("user ip: %q is not IP:port", r.RemoteAddr)
-	}
-	forward := r.Header.Get("X-Forwarded-For")
-	if len(forward) > 0 {
-		ip = forward
-	}
-
-	if len(ip) == 0 {
-		ip = "forward"
-	}
-	return ip, nil
-}
-
 func (app *application) auth(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		if !app.Session.Exists(r.Context(), "user") {
 			app.Session.Put(r.Context(), "error", "log in first!")
 			http.Redirect(w, r, "/", http.StatusTemporaryRedirect)
+			return
+		}
+		if user, ok := app.Session.Get(r.Context(), "user").(data.User); ok {
+			// the session only has the user as they were when they logged in, so look them up
+			// again: deactivating or deleting them ends their session there and then
+			current, err := app.DB.GetUser(r.Context(), user.ID)
+			if stderrors.Is(err, repository.ErrNotFound) || (err == nil && current.DisabledAt != nil) {
+				_ = app.Session.Destroy(r.Context())
+				app.Session.Put(r.Context(), "error", "Your account is no longer active")
+				http.Redirect(w, r, "/", http.StatusSeeOther)
+				return
+			}
+			if err != nil {
+				log.Println(err)
+				http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+				return
+			}
+
+			// whatever the user changes is recorded as changed by them
+			r = r.WithContext(repository.WithActor(r.Context(), user.ID))
 		}
 		next.ServeHTTP(w, r)
 	})
 }
+
+// requirePermission only lets through logged in users who have permission through one of their roles.
+// Admins may do anything. It must run after auth.
+func (app *application) requirePermission(permission string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			user, ok := app.Session.Get(r.Context(), "user").(data.User)
+			if !ok {
+				app.Session.Put(r.Context(), "error", "log in first!")
+				http.Redirect(w, r, "/", http.StatusTemporaryRedirect)
+				return
+			}
+
+			if user.IsAdmin != 1 {
+				// look the permissions up every time, so that changes to roles apply straight away
+				permissions, err := app.DB.GetUserPermissions(r.Context(), user.ID)
+				if err != nil || !authz.Has(permissions, permission) {
+					http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+					return
+				}
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
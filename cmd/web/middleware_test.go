@@ -6,38 +6,46 @@ import (
 	"net/http/httptest"
 	"strings"
 	"testing"
+	"testingCourserWeb/pkg/clientip"
 )
 
 func Test_application_addIpToContext(t *testing.T) {
 	tests := []struct {
-		headerName   string
-		headerValue  string
-		addr         string
-		emptyAddress bool
+		headerName     string
+		headerValue    string
+		addr           string
+		emptyAddress   bool
+		trustedProxies string
+		expectedIP     string
 	}{
-		{"", "", "", false},
-		{"", "", "", true},
-		{"X-Forwarded-For", "192.3.2.1", "", false},
-		{"", "", "hello:world", false},
+		{"", "", "", false, "", "192.0.2.1"},
+		{"", "", "", true, "", "unknown"},
+		{"X-Forwarded-For", "192.3.2.1", "", false, "", "192.0.2.1"},
+		{"X-Forwarded-For", "192.3.2.1", "", false, "192.0.2.1", "192.3.2.1"},
+		{"", "", "hello:world", false, "", "unknown"},
 	}
-
-	//create a dummy handler  that we'll use to check the context
-	nextHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		//make sure that the value exists in the context
-		val := r.Context().Value(contextUserKey)
-		if val == nil {
-			t.Error(contextUserKey, "not present")
-		}
-		//make sure we got a string back
-		ip, ok := val.(string)
-		if !ok {
-			t.Error("not string")
-		}
-		t.Log(ip)
-	})
+	t.Cleanup(func() { app.TrustedProxies = nil })
 
 	for _, e := range tests {
+		//create a dummy handler  that we'll use to check the context
+		nextHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			//make sure that the value exists in the context
+			val := r.Context().Value(contextUserKey)
+			if val == nil {
+				t.Error(contextUserKey, "not present")
+			}
+			//make sure we got a string back
+			ip, ok := val.(string)
+			if !ok {
+				t.Error("not string")
+			}
+			if ip != e.expectedIP {
+				t.Errorf("expected ip %s, but got %s", e.expectedIP, ip)
+			}
+		})
+
 		//create the handler to test
+		app.TrustedProxies, _ = clientip.ParseProxies(e.trustedProxies)
 		handlerToTest := app.addIPToContext(nextHandler)
 		req := httptest.NewRequest("GET", "http://testing", nil)
 		if e.emptyAddress {
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"testingCourserWeb/pkg/data"
+	"testingCourserWeb/pkg/passwordreset"
+	"testingCourserWeb/pkg/ratelimit"
+	"testingCourserWeb/pkg/validator"
+)
+
+// ForgotPasswordPage asks a user who has forgotten their password for their email.
+func (app *application) ForgotPasswordPage(w http.ResponseWriter, r *http.Request) {
+	_ = app.render(w, r, "forgot-password.page.gohtml", &TemplateData{})
+}
+
+// ForgotPassword emails the user with the email posted a link to reset their password. It says
+// the same whether or not there is such a user, and whether or not the email could be sent, so
+// that it can't be used to find out who has an account; the audit log says what happened. The
+// link is sent after we have answered, so that the answer takes as long either way. Asking for
+// links has limits of its own, apart from logging in, so that it can't flood an inbox, nor lock
+// anybody out.
+func (app *application) ForgotPassword(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	form := NewForm(r.PostForm)
+	form.Required("email")
+	form.Check(validator.IsEmail(r.PostForm.Get("email")), "email", "Invalid email address")
+	if !form.Valid() {
+		app.Session.Put(r.Context(), "error", "Enter the email address you log in with")
+		http.Redirect(w, r, "/password/forgot", http.StatusSeeOther)
+		return
+	}
+
+	email := r.PostForm.Get("email")
+	if !app.allowResetRequest(w, r, email) {
+		return
+	}
+
+	// the request is over by the time the link has been sent, so audit a copy of it
+	sent := r.Clone(r.Context())
+	app.PasswordResets.RequestLater(r.Context(), email, func(ctx context.Context, user *data.User, err error) {
+		r := sent.WithContext(ctx)
+		switch {
+		case err != nil:
+			log.Println("requesting password reset:", err)
+			app.audit(r, data.AuditEvent{Event: data.AuditPasswordResetFailed, Email: email, Reason: err.Error()})
+		case user == nil:
+			app.audit(r, data.AuditEvent{Event: data.AuditPasswordResetRequested, Email: email, Reason: "no active user"})
+		default:
+			app.audit(r, data.AuditEvent{Event: data.AuditPasswordResetRequested, UserID: &user.ID, Email: user.Email})
+		}
+	})
+
+	app.Session.Put(r.Context(), "flash", "If that email has an account, we have sent it a link to reset the password")
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+// allowResetRequest turns the client away with a 429 if it has been asking for reset links for
+// email too often. Like allowLogin, it lets the request through if the limits can't be checked.
+func (app *application) allowResetRequest(w http.ResponseWriter, r *http.Request, email string) bool {
+	err := app.ResetLimits.Allow(r.Context(), app.ipFromContext(r.Context()), email)
+	if denied, ok := err.(*ratelimit.Denied); ok {
+		app.audit(r, data.AuditEvent{Event: data.AuditPasswordResetFailed, Email: email, Reason: denied.Reason})
+		w.Header().Set("Retry-After", strconv.Itoa(denied.RetryAfterSeconds()))
+		http.Error(w, "too many password reset requests; try again later", http.StatusTooManyRequests)
+		return false
+	} else if err != nil {
+		log.Println("checking password reset rate limits:", err)
+	}
+	return true
+}
+
+// ResetPasswordPage asks a user who has followed the link we emailed them for a new password.
+func (app *application) ResetPasswordPage(w http.ResponseWriter, r *http.Request) {
+	token := r.URL.Query().Get("token")
+	if token == "" {
+		http.Redirect(w, r, "/password/forgot", http.StatusSeeOther)
+		return
+	}
+
+	var td = make(map[string]any)
+	td["token"] = token
+	_ = app.render(w, r, "reset-password.page.gohtml", &TemplateData{Data: td})
+}
+
+// ResetPassword sets the new password posted for the user a reset token was issued to. Each token
+// works once, before it expires.
+func (app *application) ResetPassword(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	token := r.PostForm.Get("token")
+	password := r.PostForm.Get("password")
+
+	// the password is checked first, so that a bad one doesn't use the token up
+	v := validator.New()
+	v.Password("password", password)
+	v.Check(password == r.PostForm.Get("confirm_password"), "password", "must be the same both times")
+	if !v.Valid() {
+		app.Session.Put(r.Context(), "error", "Your new password "+v.Errors["password"])
+		http.Redirect(w, r, "/password/reset?token="+url.QueryEscape(token), http.StatusSeeOther)
+		return
+	}
+
+	userID, err := app.PasswordResets.Reset(r.Context(), token, password)
+	if err == passwordreset.ErrInvalidToken {
+		app.audit(r, data.AuditEvent{Event: data.AuditPasswordResetFailed, Reason: err.Error()})
+		app.Session.Put(r.Context(), "error", "That link has expired, or has already been used; ask for another")
+		http.Redirect(w, r, "/password/forgot", http.StatusSeeOther)
+		return
+	}
+	if err != nil {
+		log.Println(err)
+		app.Session.Put(r.Context(), "error", "Could not reset your password")
+		http.Redirect(w, r, "/password/reset?token="+url.QueryEscape(token), http.StatusSeeOther)
+		return
+	}
+
+	app.audit(r, data.AuditEvent{Event: data.AuditPasswordReset, UserID: &userID})
+	app.Session.Put(r.Context(), "flash", "Your password has been reset; log in with your new one")
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"testingCourserWeb/pkg/data"
+	"testingCourserWeb/pkg/mailer"
+	"testingCourserWeb/pkg/repository"
+)
+
+// resetToken returns the token in the link of the last password reset email sent, if there was one.
+func resetToken(outbox *mailer.Outbox) string {
+	m, _ := outbox.Last()
+	for _, line := range strings.Split(m.Body, "\n") {
+		if u, err := url.Parse(line); err == nil && u.Query().Has("token") {
+			return u.Query().Get("token")
+		}
+	}
+	return ""
+}
+
+func Test_app_passwordReset(t *testing.T) {
+	db := useFreshDB(t)
+	outbox := app.PasswordResets.Mailer.(*mailer.Outbox)
+
+	// post posts values to handler, in a new session, and returns where it was sent, and the
+	// session it left
+	post := func(handler http.HandlerFunc, target string, values url.Values) (string, context.Context) {
+		req, _ := http.NewRequest("GET", "/", nil)
+		req = addContextAddSessionToRequest(req, app)
+		rr, ctx := postForm(req.Context(), handler, target, values)
+		loc, _ := rr.Result().Location()
+		if rr.Code != http.StatusSeeOther || loc == nil {
+			t.Fatalf("expected to be redirected, but got %d", rr.Code)
+		}
+		return loc.String(), ctx
+	}
+	lastEvent := func() *data.AuditEvent {
+		events, _, _ := db.ListAuditEvents(context.Background(), repository.AuditFilter{Limit: 1})
+		if len(events) == 0 {
+			return &data.AuditEvent{}
+		}
+		return events[0]
+	}
+
+	var forgotTests = []struct {
+		name         string
+		email        string
+		expectedLoc  string
+		expectedMail bool
+	}{
+		{"no email", "", "/password/forgot", false},
+		{"invalid email", "admin", "/password/forgot", false},
+		{"unknown email", "nobody@example.com", "/", false},
+		{"user", "admin@example.com", "/", true},
+	}
+
+	for _, e := range forgotTests {
+		sent := len(outbox.Messages())
+		loc, ctx := post(app.ForgotPassword, "/password/forgot", url.Values{"email": {e.email}})
+		app.PasswordResets.Wait()
+		if loc != e.expectedLoc {
+			t.Errorf("%s: expected to be sent to %s, but got %s", e.name, e.expectedLoc, loc)
+		}
+		if mailed := len(outbox.Messages()) > sent; mailed != e.expectedMail {
+			t.Errorf("%s: expected mail sent to be %v", e.name, e.expectedMail)
+		}
+		if loc == "/" {
+			// unknown emails are told the same as known ones
+			if !strings.HasPrefix(app.Session.GetString(ctx, "flash"), "If that email has an account") {
+				t.Errorf("%s: expected to be told a link may have been sent", e.name)
+			}
+			event := lastEvent()
+			if event.Event != data.AuditPasswordResetRequested || (event.UserID != nil) != e.expectedMail {
+				t.Errorf("%s: expected a reset request to be recorded, but got %+v", e.name, event)
+			}
+		}
+	}
+
+	token := resetToken(outbox)
+	if token == "" {
+		t.Fatal("expected a reset link to have been sent")
+	}
+
+	// the link in the email shows a form posting the token back
+	req, _ := http.NewRequest("GET", "/password/reset?token="+url.QueryEscape(token), nil)
+	req = addContextAddSessionToRequest(req, app)
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(app.ResetPasswordPage).ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), `value="`+token+`"`) {
+		t.Errorf("expected a form with the token, but got %d: %s", rr.Code, rr.Body)
+	}
+
+	var resetTests = []struct {
+		name        string
+		token       string
+		password    string
+		confirm     string
+		expectedLoc string
+	}{
+		{"no token", "", "new password 1", "new password 1", "/password/forgot"},
+		{"weak password", token, "short", "short", "/password/reset?token=" + url.QueryEscape(token)},
+		{"different passwords", token, "new password 1", "new password 2", "/password/reset?token=" + url.QueryEscape(token)},
+		{"made up token", "made.up", "new password 1", "new password 1", "/password/forgot"},
+		{"valid", token, "new password 1", "new password 1", "/"},
+		{"the same token again", token, "new password 2", "new password 2", "/password/forgot"},
+	}
+
+	for _, e := range resetTests {
+		loc, _ := post(app.ResetPassword, "/password/reset", url.Values{"token": {e.token}, "password": {e.password}, "confirm_password": {e.confirm}})
+		if loc != e.expectedLoc {
+			t.Errorf("%s: expected to be sent to %s, but got %s", e.name, e.expectedLoc, loc)
+		}
+		if loc == "/" && lastEvent().Event != data.AuditPasswordReset {
+			t.Errorf("%s: expected the reset to be recorded, but got %+v", e.name, lastEvent())
+		}
+	}
+
+	// the new password works, and the old one doesn't
+	for password, expectedLoc := range map[string]string{"secret": "/", "new password 1": "/user/profile"} {
+		loc, _ := post(app.Login, "/login", url.Values{"email": {"admin@example.com"}, "password": {password}})
+		if loc != expectedLoc {
+			t.Errorf("%s: expected logging in to send us to %s, but got %s", password, expectedLoc, loc)
+		}
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
 	"net/http"
+	"testingCourserWeb/pkg/authz"
 )
 
 func (app *application) routes() http.Handler {
@@ -15,11 +16,28 @@ func (app *application) routes() http.Handler {
 	//register routes
 	mux.Get("/", app.Home)
 	mux.Post("/login", app.Login)
+	mux.Get("/login/mfa", app.LoginMFAPage)
+	mux.Post("/login/mfa", app.LoginMFA)
+	mux.Get("/password/forgot", app.ForgotPasswordPage)
+	mux.Post("/password/forgot", app.ForgotPassword)
+	mux.Get("/password/reset", app.ResetPasswordPage)
+	mux.Post("/password/reset", app.ResetPassword)
 
 	mux.Route("/user", func(mux chi.Router) {
 		mux.Use(app.auth)
 		mux.Get("/profile", app.Profile)
 		mux.Post("/upload-profile-pic", app.UploadProfilePic)
+		mux.Get("/mfa", app.MFASettings)
+		mux.Post("/mfa/enroll", app.EnrollMFA)
+		mux.Post("/mfa/confirm", app.ConfirmMFA)
+		mux.Post("/mfa/recovery-codes", app.RegenerateRecoveryCodes)
+		mux.Post("/mfa/disable", app.DisableMFA)
+	})
+
+	mux.Route("/admin", func(mux chi.Router) {
+		mux.Use(app.auth)
+		mux.Use(app.requirePermission(authz.UsersRead))
+		mux.Get("/users", app.AdminUsers)
 	})
 	//static assets
 	fileServer := http.FileServer(http.Dir("./static"))
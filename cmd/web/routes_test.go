@@ -1,12 +1,15 @@
 package main
 
 import (
+	"context"
 	"github.com/go-chi/chi/v5"
 	"net/http"
 	"net/http/httptest"
 	"strings"
 	"testing"
+	"testingCourserWeb/pkg/authz"
 	"testingCourserWeb/pkg/data"
+	"testingCourserWeb/pkg/repository/dbrepo"
 )
 
 func Test_application_routes(t *testing.T) {
@@ -16,7 +19,19 @@ func Test_application_routes(t *testing.T) {
 	}{
 		{"/", "GET"},
 		{"/login", "POST"},
+		{"/login/mfa", "GET"},
+		{"/login/mfa", "POST"},
+		{"/password/forgot", "GET"},
+		{"/password/forgot", "POST"},
+		{"/password/reset", "GET"},
+		{"/password/reset", "POST"},
 		{"/user/profile", "GET"},
+		{"/user/mfa", "GET"},
+		{"/user/mfa/enroll", "POST"},
+		{"/user/mfa/confirm", "POST"},
+		{"/user/mfa/recovery-codes", "POST"},
+		{"/user/mfa/disable", "POST"},
+		{"/admin/users", "GET"},
 		{"/static/*", "GET"},
 	}
 
@@ -50,14 +65,22 @@ func Test_app_auth(t *testing.T) {
 
 	})
 	var tests = []struct {
-		name   string
-		isAuth bool
+		name               string
+		isAuth             bool
+		change             func(db *dbrepo.MemoryDBRepo)
+		expectedStatusCode int
 	}{
-		{"logged in", true},
-		{"not logged in", false},
+		{"logged in", true, nil, http.StatusOK},
+		{"not logged in", false, nil, http.StatusTemporaryRedirect},
+		{"deactivated since logging in", true, func(db *dbrepo.MemoryDBRepo) { _ = db.DeactivateUser(context.Background(), 1) }, http.StatusSeeOther},
+		{"deleted since logging in", true, func(db *dbrepo.MemoryDBRepo) { _ = db.DeleteUser(context.Background(), 1, 0) }, http.StatusSeeOther},
 	}
 
 	for _, e := range tests {
+		db := useFreshDB(t)
+		if e.change != nil {
+			e.change(db)
+		}
 		handlerToTest := app.auth(nextHandler)
 		req := httptest.NewRequest("GET", "http://testing", nil)
 		req = addContextAddSessionToRequest(req, app)
@@ -67,14 +90,43 @@ func Test_app_auth(t *testing.T) {
 		rr := httptest.NewRecorder()
 		handlerToTest.ServeHTTP(rr, req)
 
-		if e.isAuth && rr.Code != http.StatusOK {
-			t.Errorf("%s expected status code of 200 but got %d", e.name, rr.Code)
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s: expected status code %d, but got %d", e.name, e.expectedStatusCode, rr.Code)
 		}
-
-		if !e.isAuth && rr.Code != http.StatusTemporaryRedirect {
-			t.Errorf("%s: expected status code 307, but got %d", e.name, rr.Code)
+		if e.change != nil && app.Session.Exists(req.Context(), "user") {
+			t.Errorf("%s: expected the session to be ended", e.name)
 		}
+	}
+
+}
 
+func Test_app_requirePermission(t *testing.T) {
+	nextHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+	})
+	var tests = []struct {
+		name               string
+		user               *data.User
+		expectedStatusCode int
+	}{
+		{"not logged in", nil, http.StatusTemporaryRedirect},
+		{"admin", &data.User{ID: 2, IsAdmin: 1}, http.StatusOK},
+		{"has permission", &data.User{ID: 1}, http.StatusOK},
+		{"no permission", &data.User{ID: 2}, http.StatusForbidden},
 	}
 
+	for _, e := range tests {
+		handlerToTest := app.requirePermission(authz.UsersRead)(nextHandler)
+		req := httptest.NewRequest("GET", "http://testing", nil)
+		req = addContextAddSessionToRequest(req, app)
+		if e.user != nil {
+			app.Session.Put(req.Context(), "user", *e.user)
+		}
+		rr := httptest.NewRecorder()
+		handlerToTest.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s: expected status code %d, but got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+	}
 }
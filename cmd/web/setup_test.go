@@ -1,8 +1,14 @@
 package main
 
 import (
+	"log"
 	"os"
 	"testing"
+	"testingCourserWeb/pkg/mailer"
+	"testingCourserWeb/pkg/mfa"
+	"testingCourserWeb/pkg/passwordreset"
+	"testingCourserWeb/pkg/ratelimit"
+	"testingCourserWeb/pkg/repository"
 	"testingCourserWeb/pkg/repository/dbrepo"
 )
 
@@ -12,7 +18,51 @@ func TestMain(m *testing.M) {
 	pathToTemplates = "./../../templates/"
 	app.Session = getSession()
 
-	app.DB = &dbrepo.TestDBRepo{}
+	app.DB = newTestDB()
+	app.Logins = newTestLogins(app.DB)
+	app.ResetLimits = &ratelimit.Resets{Store: ratelimit.NewMemoryStore()}
+	app.MFA = mfa.NewManager(app.DB, "example.com")
+	app.PasswordResets = newTestPasswordResets(app.DB)
 
 	os.Exit(m.Run())
 }
+
+// newTestDB returns an in-memory repository holding the fixtures in testdata.
+func newTestDB() *dbrepo.MemoryDBRepo {
+	fixtures, err := dbrepo.ReadFixtures("./testdata/fixtures.json")
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	db := dbrepo.NewMemoryDBRepo()
+	err = db.Seed(*fixtures)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return db
+}
+
+// newTestLogins returns a login guard for db without any limits, so that tests can log in as
+// often as they like; tests of the limits set their own.
+func newTestLogins(db repository.DatabaseRepo) *ratelimit.Logins {
+	return &ratelimit.Logins{Store: ratelimit.NewMemoryStore(), Failures: db}
+}
+
+// newTestPasswordResets returns a password reset manager for db, which puts the mail it sends in
+// an outbox, for tests to read.
+func newTestPasswordResets(db repository.DatabaseRepo) *passwordreset.Manager {
+	return passwordreset.NewManager(db, &mailer.Outbox{}, []byte("secret"), "http://localhost:8080/password/reset")
+}
+
+// useFreshDB gives a test a repository of its own, holding just the fixtures, so that it can
+// change whatever it likes, and a login guard, two-factor authentication and password resets
+// using it; the shared ones are put back when the test is done.
+func useFreshDB(t *testing.T) *dbrepo.MemoryDBRepo {
+	shared, sharedLogins, sharedMFA, sharedResets := app.DB, app.Logins, app.MFA, app.PasswordResets
+	db := newTestDB()
+	app.DB, app.Logins, app.MFA, app.PasswordResets = db, newTestLogins(db), mfa.NewManager(db, "example.com"), newTestPasswordResets(db)
+	t.Cleanup(func() {
+		app.DB, app.Logins, app.MFA, app.PasswordResets = shared, sharedLogins, sharedMFA, sharedResets
+	})
+	return db
+}
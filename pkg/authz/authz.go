@@ -0,0 +1,28 @@
+package authz
+
+// The permissions we check for. Roles, and the permissions each role grants, live in the
+// roles, permissions and role_permissions tables; users get roles through user_roles.
+const (
+	UsersRead   = "users:read"
+	UsersCreate = "users:create"
+	UsersUpdate = "users:update"
+	UsersDelete = "users:delete"
+	AuditRead   = "audit:read"
+)
+
+// The roles we ship with.
+const (
+	RoleAdmin   = "admin"
+	RoleSupport = "support"
+	RoleAuditor = "auditor"
+)
+
+// Has returns true if want is one of the granted permissions.
+func Has(granted []string, want string) bool {
+	for _, p := range granted {
+		if p == want {
+			return true
+		}
+	}
+	return false
+}
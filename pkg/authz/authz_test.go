@@ -0,0 +1,22 @@
+package authz
+
+import "testing"
+
+func TestHas(t *testing.T) {
+	var tests = []struct {
+		name     string
+		granted  []string
+		want     string
+		expected bool
+	}{
+		{"granted", []string{UsersRead, UsersUpdate}, UsersUpdate, true},
+		{"not granted", []string{UsersRead}, UsersDelete, false},
+		{"nothing granted", nil, UsersRead, false},
+	}
+
+	for _, e := range tests {
+		if Has(e.granted, e.want) != e.expected {
+			t.Errorf("%s: expected %t, but got %t", e.name, e.expected, !e.expected)
+		}
+	}
+}
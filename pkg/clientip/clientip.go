@@ -0,0 +1,105 @@
+// Package clientip works out which address a request came from. X-Forwarded-For is only
+// believed when it was set by a proxy we trust; anyone else can put what they like in it.
+package clientip
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// Proxies are the proxies trusted to set X-Forwarded-For, as networks. The zero Proxies trusts
+// nobody, so the address is always the peer's.
+type Proxies []*net.IPNet
+
+// ParseProxies reads a comma separated list of addresses and networks, like
+// 10.0.0.1,192.168.0.0/16. An empty string is no proxies.
+func ParseProxies(s string) (Proxies, error) {
+	var proxies Proxies
+	for _, entry := range strings.Split(s, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if !strings.Contains(entry, "/") {
+			ip := net.ParseIP(entry)
+			if ip == nil {
+				return nil, fmt.Errorf("trusted proxy %q is not an ip address or network", entry)
+			}
+			bits := 8 * len(ip.To4())
+			if bits == 0 {
+				bits = 8 * net.IPv6len
+			}
+			entry = fmt.Sprintf("%s/%d", entry, bits)
+		}
+		_, network, err := net.ParseCIDR(entry)
+		if err != nil {
+			return nil, fmt.Errorf("trusted proxy %q is not an ip address or network", entry)
+		}
+		proxies = append(proxies, network)
+	}
+	return proxies, nil
+}
+
+// String returns p the way ParseProxies reads it.
+func (p Proxies) String() string {
+	networks := make([]string, len(p))
+	for i, network := range p {
+		networks[i] = network.String()
+	}
+	return strings.Join(networks, ",")
+}
+
+// MarshalText and UnmarshalText let Proxies be a flag.TextVar.
+func (p Proxies) MarshalText() ([]byte, error) {
+	return []byte(p.String()), nil
+}
+
+func (p *Proxies) UnmarshalText(text []byte) error {
+	parsed, err := ParseProxies(string(text))
+	if err != nil {
+		return err
+	}
+	*p = parsed
+	return nil
+}
+
+// Trusted reports whether ip is one of the proxies.
+func (p Proxies) Trusted(ip net.IP) bool {
+	for _, network := range p {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// IP returns the address r came from, or "unknown". That is the peer's address, unless the peer
+// is a trusted proxy, in which case it is the last address in X-Forwarded-For which isn't one
+// of ours: each proxy appends the address it got the request from, so everything before the
+// first one we don't trust could have been made up by the client.
+func (p Proxies) IP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	peer := net.ParseIP(host)
+	if err != nil || peer == nil {
+		return "unknown"
+	}
+	if !p.Trusted(peer) {
+		return peer.String()
+	}
+
+	hops := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	ip := peer
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := net.ParseIP(strings.TrimSpace(hops[i]))
+		if hop == nil {
+			break
+		}
+		ip = hop
+		if !p.Trusted(ip) {
+			break
+		}
+	}
+	return ip.String()
+}
@@ -0,0 +1,69 @@
+package clientip
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestParseProxies(t *testing.T) {
+	var tests = []struct {
+		name          string
+		proxies       string
+		expected      string
+		expectedError bool
+	}{
+		{"none", "", "", false},
+		{"address", "10.0.0.1", "10.0.0.1/32", false},
+		{"network", "192.168.0.0/16", "192.168.0.0/16", false},
+		{"ipv6", "::1", "::1/128", false},
+		{"list", "10.0.0.1, 192.168.0.0/16,", "10.0.0.1/32,192.168.0.0/16", false},
+		{"not an address", "proxy.example.com", "", true},
+		{"bad network", "10.0.0.0/33", "", true},
+	}
+
+	for _, e := range tests {
+		proxies, err := ParseProxies(e.proxies)
+		if (err != nil) != e.expectedError {
+			t.Errorf("%s: expected error to be %v, but got %v", e.name, e.expectedError, err)
+		}
+		if proxies.String() != e.expected {
+			t.Errorf("%s: expected %q, but got %q", e.name, e.expected, proxies.String())
+		}
+	}
+}
+
+func TestProxies_IP(t *testing.T) {
+	proxies, _ := ParseProxies("192.0.2.1,10.0.0.0/8")
+
+	var tests = []struct {
+		name       string
+		proxies    Proxies
+		remoteAddr string
+		forwarded  []string
+		expected   string
+	}{
+		{"no proxies", nil, "192.0.2.1:1234", nil, "192.0.2.1"},
+		{"untrusted peer", nil, "192.0.2.1:1234", []string{"203.0.113.9"}, "192.0.2.1"},
+		{"trusted peer without a header", proxies, "192.0.2.1:1234", nil, "192.0.2.1"},
+		{"trusted peer", proxies, "192.0.2.1:1234", []string{"203.0.113.9"}, "203.0.113.9"},
+		{"made up hops", proxies, "192.0.2.1:1234", []string{"1.2.3.4, 203.0.113.9"}, "203.0.113.9"},
+		{"chain of proxies", proxies, "192.0.2.1:1234", []string{"203.0.113.9, 10.0.0.2"}, "203.0.113.9"},
+		{"several headers", proxies, "192.0.2.1:1234", []string{"1.2.3.4", "203.0.113.9, 10.0.0.2"}, "203.0.113.9"},
+		{"every hop trusted", proxies, "192.0.2.1:1234", []string{"10.0.0.3, 10.0.0.2"}, "10.0.0.3"},
+		{"garbage", proxies, "192.0.2.1:1234", []string{"203.0.113.9, nonsense"}, "192.0.2.1"},
+		{"untrusted ipv6 peer", proxies, "[2001:db8::1]:1234", []string{"203.0.113.9"}, "2001:db8::1"},
+		{"no port", proxies, "192.0.2.1", nil, "unknown"},
+		{"empty", proxies, "", nil, "unknown"},
+	}
+
+	for _, e := range tests {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.RemoteAddr = e.remoteAddr
+		for _, forwarded := range e.forwarded {
+			req.Header.Add("X-Forwarded-For", forwarded)
+		}
+		if ip := e.proxies.IP(req); ip != e.expected {
+			t.Errorf("%s: expected %s, but got %s", e.name, e.expected, ip)
+		}
+	}
+}
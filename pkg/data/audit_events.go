@@ -0,0 +1,57 @@
+package data
+
+import "time"
+
+// The events we keep in the security audit log.
+const (
+	AuditLoginSucceeded = "login_succeeded"
+	AuditLoginFailed    = "login_failed"
+	AuditRefresh        = "refresh"
+	AuditRefreshFailed  = "refresh_failed"
+	AuditLogout         = "logout"
+	AuditPasswordReset  = "password_reset"
+	AuditTokenRejected  = "token_rejected"
+	// somebody asked for a password reset link; it was only sent if UserID is set
+	AuditPasswordResetRequested = "password_reset_requested"
+	AuditPasswordResetFailed    = "password_reset_failed"
+	// a login with the right password, which still needs a second factor
+	AuditMFARequired              = "mfa_required"
+	AuditMFAEnabled               = "mfa_enabled"
+	AuditMFADisabled              = "mfa_disabled"
+	AuditRecoveryCodeUsed         = "recovery_code_used"
+	AuditRecoveryCodesRegenerated = "recovery_codes_regenerated"
+)
+
+// AuditEvents lists every event, for checking event names from clients.
+var AuditEvents = []string{
+	AuditLoginSucceeded,
+	AuditLoginFailed,
+	AuditRefresh,
+	AuditRefreshFailed,
+	AuditLogout,
+	AuditPasswordReset,
+	AuditTokenRejected,
+	AuditPasswordResetRequested,
+	AuditPasswordResetFailed,
+	AuditMFARequired,
+	AuditMFAEnabled,
+	AuditMFADisabled,
+	AuditRecoveryCodeUsed,
+	AuditRecoveryCodesRegenerated,
+}
+
+// AuditEvent is one entry in the security audit log: something that happened while somebody was
+// authenticating, who to, and where the request came from. UserID is nil when we don't know who
+// it was, e.g. a login with an unknown email, in which case Email says who they claimed to be.
+// Reason says why a failure failed.
+type AuditEvent struct {
+	ID        int       `json:"id"`
+	Event     string    `json:"event"`
+	UserID    *int      `json:"user_id"`
+	Email     string    `json:"email,omitempty"`
+	Reason    string    `json:"reason,omitempty"`
+	IP        string    `json:"ip"`
+	UserAgent string    `json:"user_agent"`
+	RequestID string    `json:"request_id,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
@@ -0,0 +1,18 @@
+package data
+
+import "time"
+
+// LoginFailures counts the failed logins for an account (a normalised email address, which may
+// not belong to anybody) since its last successful login. LockedUntil is set while the account
+// is locked out.
+type LoginFailures struct {
+	Account       string     `json:"account"`
+	Failures      int        `json:"failures"`
+	LastFailureAt time.Time  `json:"last_failure_at"`
+	LockedUntil   *time.Time `json:"locked_until"`
+}
+
+// IsLocked reports whether the account is locked out at now.
+func (f *LoginFailures) IsLocked(now time.Time) bool {
+	return f.LockedUntil != nil && f.LockedUntil.After(now)
+}
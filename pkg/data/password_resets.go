@@ -0,0 +1,15 @@
+package data
+
+import "time"
+
+// PasswordReset is a request to reset a user's password, made by somebody who says they have
+// forgotten it. Only the hash of its token is kept: the token itself is only ever in the link we
+// email the user. It may be used once, before ExpiresAt.
+type PasswordReset struct {
+	ID        int        `json:"id"`
+	UserID    int        `json:"user_id"`
+	TokenHash string     `json:"-"`
+	ExpiresAt time.Time  `json:"expires_at"`
+	UsedAt    *time.Time `json:"used_at"`
+	CreatedAt time.Time  `json:"created_at"`
+}
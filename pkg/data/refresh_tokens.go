@@ -0,0 +1,22 @@
+package data
+
+import "time"
+
+// RefreshToken is the type for refresh tokens we have issued. Every token belongs to
+// a family; a family is started at login, and each refresh rotates the token within
+// that family. Once a token has been rotated (or the user logs out) it is revoked.
+type RefreshToken struct {
+	ID        int       `json:"id"`
+	UserID    int       `json:"user_id"`
+	FamilyID  string    `json:"family_id"`
+	JTI       string    `json:"jti"`
+	ExpiresAt time.Time `json:"expires_at"`
+	Revoked   bool      `json:"revoked"`
+	CreatedAt time.Time `json:"-"`
+	UpdatedAt time.Time `json:"-"`
+}
+
+// IsExpired returns true if the refresh token is past its expiry time.
+func (t *RefreshToken) IsExpired() bool {
+	return time.Now().After(t.ExpiresAt)
+}
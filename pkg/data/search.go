@@ -0,0 +1,10 @@
+package data
+
+// UserSearchResult is a user found by a search. Rank says how well the user matched, higher
+// being better, and Highlights holds the fields that matched, keyed by json field name, as
+// HTML with the matching parts wrapped in <mark> tags.
+type UserSearchResult struct {
+	User
+	Rank       float64           `json:"rank"`
+	Highlights map[string]string `json:"highlights"`
+}
@@ -0,0 +1,117 @@
+package data
+
+import "time"
+
+// The changes to a user we keep a history of.
+const (
+	ActionInsert        = "insert"
+	ActionUpdate        = "update"
+	ActionDelete        = "delete"
+	ActionDeactivate    = "deactivate"
+	ActionRestore       = "restore"
+	ActionResetPassword = "reset_password"
+	ActionImage         = "image"
+)
+
+// UserSnapshot is what we record of a user before and after each change. The password is left
+// out on purpose; the history only says when it was reset.
+type UserSnapshot struct {
+	FirstName  string     `json:"first_name"`
+	LastName   string     `json:"last_name"`
+	Email      string     `json:"email"`
+	IsAdmin    int        `json:"is_admin"`
+	ProfilePic string     `json:"profile_pic"`
+	DeletedAt  *time.Time `json:"deleted_at"`
+	DisabledAt *time.Time `json:"disabled_at"`
+}
+
+// UserHistory is one change to a user: what they looked like before and after it, and who made
+// it. Before is nil for an insert. Revisions go up with every change to any user, so a user's
+// revisions are in order, but not consecutive.
+type UserHistory struct {
+	Revision int    `json:"revision"`
+	UserID   int    `json:"user_id"`
+	Action   string `json:"action"`
+	// ActorID is the user who made the change, if it was made by one
+	ActorID   *int          `json:"actor_id"`
+	Before    *UserSnapshot `json:"before"`
+	After     *UserSnapshot `json:"after"`
+	CreatedAt time.Time     `json:"created_at"`
+}
+
+// FieldChange is the value of one field before and after a change.
+type FieldChange struct {
+	From any `json:"from"`
+	To   any `json:"to"`
+}
+
+// Changes returns the fields the change touched, by their JSON names.
+func (h *UserHistory) Changes() map[string]FieldChange {
+	before, after := h.Before.fields(), h.After.fields()
+
+	changes := map[string]FieldChange{}
+	for name, to := range after {
+		if from := before[name]; from != to {
+			changes[name] = FieldChange{From: from, To: to}
+		}
+	}
+	for name, from := range before {
+		if _, ok := after[name]; !ok {
+			changes[name] = FieldChange{From: from}
+		}
+	}
+	return changes
+}
+
+// fields returns the snapshot's fields by their JSON names, with times as strings, so that
+// they can be compared with ==. A nil snapshot has no fields.
+func (s *UserSnapshot) fields() map[string]any {
+	if s == nil {
+		return nil
+	}
+	return map[string]any{
+		"first_name":  s.FirstName,
+		"last_name":   s.LastName,
+		"email":       s.Email,
+		"is_admin":    s.IsAdmin,
+		"profile_pic": s.ProfilePic,
+		"deleted_at":  formatTime(s.DeletedAt),
+		"disabled_at": formatTime(s.DisabledAt),
+	}
+}
+
+// formatTime formats t as it would be in JSON, or returns nil if there is no t.
+func formatTime(t *time.Time) any {
+	if t == nil {
+		return nil
+	}
+	return t.UTC().Format(time.RFC3339Nano)
+}
+
+// Revert returns current with every field this change touched put back the way it was before
+// it. There is nothing to put back for an insert, so current is returned as it is.
+func (h *UserHistory) Revert(current UserSnapshot) UserSnapshot {
+	if h.Before == nil {
+		return current
+	}
+
+	for name := range h.Changes() {
+		switch name {
+		case "first_name":
+			current.FirstName = h.Before.FirstName
+		case "last_name":
+			current.LastName = h.Before.LastName
+		case "email":
+			current.Email = h.Before.Email
+		case "is_admin":
+			current.IsAdmin = h.Before.IsAdmin
+		case "profile_pic":
+			current.ProfilePic = h.Before.ProfilePic
+		case "deleted_at":
+			current.DeletedAt = h.Before.DeletedAt
+		case "disabled_at":
+			current.DisabledAt = h.Before.DisabledAt
+		}
+	}
+	return current
+}
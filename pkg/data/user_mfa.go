@@ -0,0 +1,21 @@
+package data
+
+import "time"
+
+// UserMFA is a user's two-factor authentication: the TOTP secret their authenticator app shares
+// with us, and how many of their recovery codes are left. It is set up in two steps, so EnabledAt
+// is only set once the user has shown their app gives the right codes; until then, logins don't
+// ask for one. LastStep is the time step of the last code used, which can't be used again.
+type UserMFA struct {
+	UserID            int        `json:"user_id"`
+	Secret            string     `json:"-"`
+	LastStep          int64      `json:"-"`
+	EnabledAt         *time.Time `json:"enabled_at"`
+	RecoveryCodesLeft int        `json:"recovery_codes_left"`
+	CreatedAt         time.Time  `json:"created_at"`
+}
+
+// Enabled reports whether logins need a second factor.
+func (m *UserMFA) Enabled() bool {
+	return m.EnabledAt != nil
+}
@@ -17,6 +17,12 @@ type User struct {
 	CreatedAt  time.Time `json:"-"`
 	UpdatedAt  time.Time `json:"-"`
 	ProfilePic UserImage `json:"-"`
+	// Version goes up by one every time the user changes; the API sends it as an ETag
+	Version int `json:"-"`
+	// DeletedAt is set once the user has been deleted; deleted users can still be restored
+	DeletedAt *time.Time `json:"deleted_at,omitempty"`
+	// DisabledAt is set while the user is deactivated, and may not log in
+	DisabledAt *time.Time `json:"disabled_at,omitempty"`
 }
 
 // PasswordMatches uses Go's bcrypt package to compare a user supplied password
@@ -0,0 +1,132 @@
+package jsonpatch
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+// sameJSON reports whether a and b are the same JSON value, whatever their formatting.
+func sameJSON(a, b []byte) bool {
+	var va, vb any
+	if json.Unmarshal(a, &va) != nil || json.Unmarshal(b, &vb) != nil {
+		return false
+	}
+	return reflect.DeepEqual(va, vb)
+}
+
+func TestMergePatch(t *testing.T) {
+	// from the examples in RFC 7396, appendix A
+	var tests = []struct {
+		doc      string
+		patch    string
+		expected string
+	}{
+		{`{"a":"b"}`, `{"a":"c"}`, `{"a":"c"}`},
+		{`{"a":"b"}`, `{"b":"c"}`, `{"a":"b","b":"c"}`},
+		{`{"a":"b"}`, `{"a":null}`, `{}`},
+		{`{"a":"b","b":"c"}`, `{"a":null}`, `{"b":"c"}`},
+		{`{"a":["b"]}`, `{"a":"c"}`, `{"a":"c"}`},
+		{`{"a":"c"}`, `{"a":["b"]}`, `{"a":["b"]}`},
+		{`{"a":{"b":"c"}}`, `{"a":{"b":"d","c":null}}`, `{"a":{"b":"d"}}`},
+		{`{"a":[{"b":"c"}]}`, `{"a":[1]}`, `{"a":[1]}`},
+		{`["a","b"]`, `["c","d"]`, `["c","d"]`},
+		{`{"a":"b"}`, `["c"]`, `["c"]`},
+		{`{"a":"foo"}`, `null`, `null`},
+		{`{"e":null}`, `{"a":1}`, `{"e":null,"a":1}`},
+		{`[1,2]`, `{"a":"b","c":null}`, `{"a":"b"}`},
+		{`{}`, `{"a":{"bb":{"ccc":null}}}`, `{"a":{"bb":{}}}`},
+	}
+
+	for _, e := range tests {
+		got, err := MergePatch([]byte(e.doc), []byte(e.patch))
+		if err != nil {
+			t.Errorf("%s + %s: unexpected error: %s", e.doc, e.patch, err)
+			continue
+		}
+		if !sameJSON(got, []byte(e.expected)) {
+			t.Errorf("%s + %s: expected %s, but got %s", e.doc, e.patch, e.expected, got)
+		}
+	}
+
+	_, err := MergePatch([]byte(`{}`), []byte(`{"a":`))
+	if err == nil {
+		t.Error("expected an error for a patch which isn't JSON")
+	}
+}
+
+func TestApply(t *testing.T) {
+	// mostly from the examples in RFC 6902, appendix A
+	var tests = []struct {
+		name     string
+		doc      string
+		patch    string
+		expected string
+	}{
+		{"add member", `{"foo":"bar"}`, `[{"op":"add","path":"/baz","value":"qux"}]`, `{"baz":"qux","foo":"bar"}`},
+		{"add to array", `{"foo":["bar","baz"]}`, `[{"op":"add","path":"/foo/1","value":"qux"}]`, `{"foo":["bar","qux","baz"]}`},
+		{"add to end of array", `{"foo":["bar"]}`, `[{"op":"add","path":"/foo/-","value":["abc"]}]`, `{"foo":["bar",["abc"]]}`},
+		{"add null", `{"foo":"bar"}`, `[{"op":"add","path":"/baz","value":null}]`, `{"baz":null,"foo":"bar"}`},
+		{"add nested", `{"foo":{"bar":1}}`, `[{"op":"add","path":"/foo/baz","value":2}]`, `{"foo":{"bar":1,"baz":2}}`},
+		{"add whole document", `{"foo":1}`, `[{"op":"add","path":"","value":[1]}]`, `[1]`},
+		{"remove member", `{"baz":"qux","foo":"bar"}`, `[{"op":"remove","path":"/baz"}]`, `{"foo":"bar"}`},
+		{"remove from array", `{"foo":["bar","qux","baz"]}`, `[{"op":"remove","path":"/foo/1"}]`, `{"foo":["bar","baz"]}`},
+		{"replace", `{"baz":"qux","foo":"bar"}`, `[{"op":"replace","path":"/baz","value":"boo"}]`, `{"baz":"boo","foo":"bar"}`},
+		{"move member", `{"foo":{"bar":"baz","waldo":"fred"},"qux":{"corge":"grault"}}`,
+			`[{"op":"move","from":"/foo/waldo","path":"/qux/thud"}]`,
+			`{"foo":{"bar":"baz"},"qux":{"corge":"grault","thud":"fred"}}`},
+		{"move in array", `{"foo":["all","grass","cows","eat"]}`, `[{"op":"move","from":"/foo/1","path":"/foo/3"}]`, `{"foo":["all","cows","eat","grass"]}`},
+		{"copy", `{"foo":{"bar":1}}`, `[{"op":"copy","from":"/foo","path":"/baz"},{"op":"replace","path":"/baz/bar","value":2}]`, `{"foo":{"bar":1},"baz":{"bar":2}}`},
+		{"test then replace", `{"baz":"qux","foo":["a",2,"c"]}`,
+			`[{"op":"test","path":"/baz","value":"qux"},{"op":"test","path":"/foo/1","value":2},{"op":"replace","path":"/baz","value":1}]`,
+			`{"baz":1,"foo":["a",2,"c"]}`},
+		{"escaped path", `{"/":9,"~1":10}`, `[{"op":"test","path":"/~01","value":10},{"op":"remove","path":"/~1"}]`, `{"~1":10}`},
+	}
+
+	for _, e := range tests {
+		got, err := Apply([]byte(e.doc), []byte(e.patch))
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", e.name, err)
+			continue
+		}
+		if !sameJSON(got, []byte(e.expected)) {
+			t.Errorf("%s: expected %s, but got %s", e.name, e.expected, got)
+		}
+	}
+}
+
+func TestApply_errors(t *testing.T) {
+	var tests = []struct {
+		name  string
+		doc   string
+		patch string
+	}{
+		{"not an array", `{}`, `{"op":"add","path":"/a","value":1}`},
+		{"unknown op", `{}`, `[{"op":"frobnicate","path":"/a"}]`},
+		{"missing value", `{}`, `[{"op":"add","path":"/a"}]`},
+		{"bad path", `{}`, `[{"op":"add","path":"a","value":1}]`},
+		{"remove missing member", `{"foo":"bar"}`, `[{"op":"remove","path":"/baz"}]`},
+		{"replace missing member", `{"foo":"bar"}`, `[{"op":"replace","path":"/baz","value":1}]`},
+		{"add to missing parent", `{"foo":"bar"}`, `[{"op":"add","path":"/baz/bat","value":"qux"}]`},
+		{"index out of range", `{"foo":["bar"]}`, `[{"op":"add","path":"/foo/2","value":"qux"}]`},
+		{"index with leading zero", `{"foo":["bar","baz"]}`, `[{"op":"remove","path":"/foo/01"}]`},
+		{"index not a number", `{"foo":["bar"]}`, `[{"op":"add","path":"/foo/bar","value":"qux"}]`},
+		{"move into itself", `{"foo":{"bar":1}}`, `[{"op":"move","from":"/foo","path":"/foo/bar/baz"}]`},
+		{"move from missing member", `{}`, `[{"op":"move","from":"/foo","path":"/bar"}]`},
+		{"remove whole document", `{}`, `[{"op":"remove","path":""}]`},
+	}
+
+	for _, e := range tests {
+		_, err := Apply([]byte(e.doc), []byte(e.patch))
+		if err == nil {
+			t.Errorf("%s: expected an error, but got none", e.name)
+		}
+	}
+
+	// a failed test stops the patch, and says so
+	_, err := Apply([]byte(`{"baz":"qux"}`), []byte(`[{"op":"replace","path":"/baz","value":1},{"op":"test","path":"/baz","value":"qux"}]`))
+	if !errors.Is(err, ErrTestFailed) {
+		t.Errorf("expected ErrTestFailed, but got %v", err)
+	}
+}
@@ -0,0 +1,90 @@
+// Package mailer sends the emails the applications send to users, like password reset links.
+package mailer
+
+import (
+	"context"
+	"errors"
+	"log"
+	"strings"
+	"sync"
+)
+
+// Message is a plain text email to one address.
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer sends email. The applications hold one, so that how mail goes out is chosen at
+// startup: over SMTP, to the log while developing, or into an Outbox in tests.
+type Mailer interface {
+	Send(ctx context.Context, m Message) error
+}
+
+// ErrInvalidHeader is returned for a message whose address or subject has a line break in it,
+// which would let whoever chose it add headers of their own.
+var ErrInvalidHeader = errors.New("mail headers may not contain line breaks")
+
+// New returns a mailer which sends mail through the SMTP server at addr, or, if addr is empty,
+// one which only logs it.
+func New(addr, from, username, password string) Mailer {
+	if addr == "" {
+		return Log{}
+	}
+	return NewSMTP(addr, from, username, password)
+}
+
+// Log logs mail instead of sending it, for development.
+type Log struct{}
+
+// Send logs m.
+func (Log) Send(ctx context.Context, m Message) error {
+	if err := m.validate(); err != nil {
+		return err
+	}
+	log.Printf("mail to %s: %s\n%s", m.To, m.Subject, m.Body)
+	return nil
+}
+
+// Outbox keeps mail instead of sending it, so that tests can read what would have been sent.
+type Outbox struct {
+	mu       sync.Mutex
+	messages []Message
+}
+
+// Send adds m to the outbox.
+func (o *Outbox) Send(ctx context.Context, m Message) error {
+	if err := m.validate(); err != nil {
+		return err
+	}
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.messages = append(o.messages, m)
+	return nil
+}
+
+// Messages returns everything sent so far, oldest first.
+func (o *Outbox) Messages() []Message {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return append([]Message(nil), o.messages...)
+}
+
+// Last returns the last message sent, if any has been.
+func (o *Outbox) Last() (Message, bool) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if len(o.messages) == 0 {
+		return Message{}, false
+	}
+	return o.messages[len(o.messages)-1], true
+}
+
+// validate checks that m can't be used to add headers.
+func (m Message) validate() error {
+	if strings.ContainsAny(m.To, "\r\n") || strings.ContainsAny(m.Subject, "\r\n") {
+		return ErrInvalidHeader
+	}
+	return nil
+}
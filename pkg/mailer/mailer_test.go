@@ -0,0 +1,162 @@
+package mailer
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/textproto"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeSMTP is a local stand-in for an SMTP server, which accepts one message and keeps what it
+// was sent.
+type fakeSMTP struct {
+	addr     string
+	from, to string
+	data     string
+	done     chan struct{}
+}
+
+// startFakeSMTP listens on a free local port, and serves one connection.
+func startFakeSMTP(t *testing.T) *fakeSMTP {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("could not listen: %s", err)
+	}
+	t.Cleanup(func() { l.Close() })
+
+	s := &fakeSMTP{addr: l.Addr().String(), done: make(chan struct{})}
+	go func() {
+		defer close(s.done)
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		s.serve(textproto.NewConn(conn))
+	}()
+	return s
+}
+
+// serve speaks just enough SMTP for net/smtp to send a message.
+func (s *fakeSMTP) serve(c *textproto.Conn) {
+	_ = c.PrintfLine("220 localhost ready")
+	for {
+		line, err := c.ReadLine()
+		if err != nil {
+			return
+		}
+		verb := strings.ToUpper(strings.SplitN(line, " ", 2)[0])
+		switch verb {
+		case "EHLO", "HELO":
+			_ = c.PrintfLine("250 localhost")
+		case "MAIL":
+			s.from = strings.TrimPrefix(line, "MAIL FROM:")
+			_ = c.PrintfLine("250 ok")
+		case "RCPT":
+			s.to = strings.TrimPrefix(line, "RCPT TO:")
+			_ = c.PrintfLine("250 ok")
+		case "DATA":
+			_ = c.PrintfLine("354 go ahead")
+			lines, _ := c.ReadDotLines()
+			s.data = strings.Join(lines, "\n")
+			_ = c.PrintfLine("250 queued")
+		case "QUIT":
+			_ = c.PrintfLine("221 bye")
+			return
+		default:
+			_ = c.PrintfLine("502 not implemented")
+		}
+	}
+}
+
+func TestSMTP_Send(t *testing.T) {
+	server := startFakeSMTP(t)
+	m := NewSMTP(server.addr, "noreply@example.com", "", "")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	err := m.Send(ctx, Message{
+		To:      "jack@example.com",
+		Subject: "Reset your password",
+		Body:    "Follow this link:\nhttp://localhost/reset\n.\nThanks",
+	})
+	if err != nil {
+		t.Fatalf("unexpected error sending: %s", err)
+	}
+	<-server.done
+
+	if server.from != "<noreply@example.com>" || server.to != "<jack@example.com>" {
+		t.Errorf("expected mail from noreply@example.com to jack@example.com, but got %s to %s", server.from, server.to)
+	}
+	for _, expected := range []string{
+		"From: noreply@example.com\n",
+		"To: jack@example.com\n",
+		"Subject: Reset your password\n",
+		"Content-Type: text/plain; charset=utf-8\n",
+		"\n\nFollow this link:\nhttp://localhost/reset\n.\nThanks",
+	} {
+		if !strings.Contains(server.data, expected) {
+			t.Errorf("expected the message to contain %q, but got %q", expected, server.data)
+		}
+	}
+}
+
+func TestSMTP_Send_unreachable(t *testing.T) {
+	// nothing listens on a port we have just closed
+	l, _ := net.Listen("tcp", "127.0.0.1:0")
+	addr := l.Addr().String()
+	l.Close()
+
+	err := NewSMTP(addr, "noreply@example.com", "", "").Send(context.Background(), Message{To: "jack@example.com"})
+	if err == nil {
+		t.Error("expected an error sending to a server which isn't there")
+	}
+}
+
+func TestMailers_invalidHeaders(t *testing.T) {
+	var tests = []struct {
+		name    string
+		message Message
+	}{
+		{"to", Message{To: "jack@example.com\r\nBcc: jill@example.com"}},
+		{"subject", Message{To: "jack@example.com", Subject: "Hello\nBcc: jill@example.com"}},
+	}
+
+	for _, e := range tests {
+		for name, m := range map[string]Mailer{"smtp": NewSMTP("127.0.0.1:1", "noreply@example.com", "", ""), "log": Log{}, "outbox": &Outbox{}} {
+			if err := m.Send(context.Background(), e.message); !errors.Is(err, ErrInvalidHeader) {
+				t.Errorf("%s, %s: expected ErrInvalidHeader, but got %v", e.name, name, err)
+			}
+		}
+	}
+}
+
+func TestOutbox(t *testing.T) {
+	var o Outbox
+	if _, ok := o.Last(); ok {
+		t.Error("expected an empty outbox to have no last message")
+	}
+
+	for _, to := range []string{"jack@example.com", "jill@example.com"} {
+		_ = o.Send(context.Background(), Message{To: to, Subject: "Hello"})
+	}
+	if messages := o.Messages(); len(messages) != 2 || messages[0].To != "jack@example.com" {
+		t.Errorf("expected two messages, oldest first, but got %+v", messages)
+	}
+	if last, ok := o.Last(); !ok || last.To != "jill@example.com" {
+		t.Errorf("expected the last message to be to jill@example.com, but got %+v", last)
+	}
+}
+
+func TestNew(t *testing.T) {
+	if _, ok := New("", "noreply@example.com", "", "").(Log); !ok {
+		t.Error("expected mail to be logged without a server")
+	}
+	if s, ok := New("mail.example.com:587", "noreply@example.com", "user", "pass").(*SMTP); !ok || s.Auth == nil {
+		t.Errorf("expected an smtp mailer which logs in, but got %+v", s)
+	}
+}
@@ -0,0 +1,182 @@
+// Package mfa is two-factor authentication: RFC 6238 time-based one-time passwords (TOTP), from
+// an authenticator app, with recovery codes for when the app is lost.
+package mfa
+
+import (
+	"context"
+	"errors"
+	"testingCourserWeb/pkg/data"
+	"testingCourserWeb/pkg/repository"
+	"time"
+)
+
+// The errors Manager returns, which are safe to show to the user.
+var (
+	ErrNotEnrolled    = errors.New("two-factor authentication is not set up")
+	ErrAlreadyEnabled = errors.New("two-factor authentication is already on")
+	ErrInvalidCode    = errors.New("invalid two-factor authentication code")
+)
+
+// The ways a user can pass the second step of a login.
+const (
+	MethodTOTP         = "totp"
+	MethodRecoveryCode = "recovery_code"
+)
+
+// Store keeps users' secrets and recovery codes; repository.DatabaseRepo is one.
+type Store interface {
+	GetUserMFA(ctx context.Context, userID int) (*data.UserMFA, error)
+	SetMFASecret(ctx context.Context, userID int, secret string) error
+	EnableMFA(ctx context.Context, userID int, step int64, recoveryCodes []string) error
+	UseMFAStep(ctx context.Context, userID int, step int64) error
+	UseRecoveryCode(ctx context.Context, userID int, hash string) error
+	SetRecoveryCodes(ctx context.Context, userID int, hashes []string) error
+	DeleteUserMFA(ctx context.Context, userID int) error
+}
+
+// Enrollment is what a user needs to add us to their authenticator app: the provisioning URI,
+// to show as a QR code, and the secret, for apps which can't scan one.
+type Enrollment struct {
+	Secret string `json:"secret"`
+	URI    string `json:"uri"`
+}
+
+// Manager sets users up with two-factor authentication, and checks their codes. Issuer is what
+// authenticator apps show our codes as.
+type Manager struct {
+	Store  Store
+	Issuer string
+}
+
+// NewManager returns a manager keeping secrets in store.
+func NewManager(store Store, issuer string) *Manager {
+	return &Manager{Store: store, Issuer: issuer}
+}
+
+// Status returns a user's two-factor authentication, which is off if they have never set it up.
+func (m *Manager) Status(ctx context.Context, userID int) (*data.UserMFA, error) {
+	mfa, err := m.Store.GetUserMFA(ctx, userID)
+	if errors.Is(err, repository.ErrNotFound) {
+		return &data.UserMFA{UserID: userID}, nil
+	}
+	return mfa, err
+}
+
+// Enabled reports whether a user needs a code to log in.
+func (m *Manager) Enabled(ctx context.Context, userID int) (bool, error) {
+	mfa, err := m.Status(ctx, userID)
+	if err != nil {
+		return false, err
+	}
+	return mfa.Enabled(), nil
+}
+
+// Enroll starts setting up two-factor authentication for a user, whose account (their email) is
+// what authenticator apps show the code as being for. Nothing changes until Confirm, and
+// enrolling again before then starts over with a new secret.
+func (m *Manager) Enroll(ctx context.Context, userID int, account string) (*Enrollment, error) {
+	secret, err := NewSecret()
+	if err != nil {
+		return nil, err
+	}
+
+	err = m.Store.SetMFASecret(ctx, userID, secret)
+	if errors.Is(err, repository.ErrConflict) {
+		return nil, ErrAlreadyEnabled
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &Enrollment{Secret: secret, URI: ProvisioningURI(m.Issuer, account, secret)}, nil
+}
+
+// Confirm turns two-factor authentication on, once the user has shown that their app gives the
+// right codes, and returns their recovery codes. Only the hashes of the codes are kept, so this
+// is the one time they can be shown.
+func (m *Manager) Confirm(ctx context.Context, userID int, code string) ([]string, error) {
+	mfa, err := m.Status(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if mfa.Enabled() {
+		return nil, ErrAlreadyEnabled
+	}
+	if mfa.Secret == "" {
+		return nil, ErrNotEnrolled
+	}
+
+	step, ok := Validate(mfa.Secret, code, time.Now())
+	if !ok {
+		return nil, ErrInvalidCode
+	}
+
+	codes, hashes, err := NewRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+	// the secret may have changed, or been confirmed, since we read it
+	err = m.Store.EnableMFA(ctx, userID, step, hashes)
+	if errors.Is(err, repository.ErrNotFound) {
+		return nil, ErrInvalidCode
+	}
+	if err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// Verify checks the code a user gave for the second step of a login, which may come from their
+// app or be one of their recovery codes, and returns which it was. Either kind of code only works
+// once: an app's code can't be used again, nor can any code from before it.
+func (m *Manager) Verify(ctx context.Context, userID int, code string) (string, error) {
+	mfa, err := m.Status(ctx, userID)
+	if err != nil {
+		return "", err
+	}
+	if !mfa.Enabled() {
+		return "", ErrNotEnrolled
+	}
+
+	if step, ok := Validate(mfa.Secret, code, time.Now()); ok {
+		err = m.Store.UseMFAStep(ctx, userID, step)
+		if errors.Is(err, repository.ErrConflict) {
+			return "", ErrInvalidCode
+		}
+		return MethodTOTP, err
+	}
+
+	err = m.Store.UseRecoveryCode(ctx, userID, HashRecoveryCode(code))
+	if errors.Is(err, repository.ErrNotFound) {
+		return "", ErrInvalidCode
+	}
+	return MethodRecoveryCode, err
+}
+
+// RegenerateRecoveryCodes gives a user new recovery codes in place of their old ones, once they
+// have given a code, and returns them.
+func (m *Manager) RegenerateRecoveryCodes(ctx context.Context, userID int, code string) ([]string, error) {
+	_, err := m.Verify(ctx, userID, code)
+	if err != nil {
+		return nil, err
+	}
+
+	codes, hashes, err := NewRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+	err = m.Store.SetRecoveryCodes(ctx, userID, hashes)
+	if err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// Disable turns two-factor authentication off for a user, once they have given a code, and
+// forgets their secret and recovery codes.
+func (m *Manager) Disable(ctx context.Context, userID int, code string) error {
+	_, err := m.Verify(ctx, userID, code)
+	if err != nil {
+		return err
+	}
+	return m.Store.DeleteUserMFA(ctx, userID)
+}
@@ -0,0 +1,146 @@
+package mfa
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"strings"
+	"testing"
+	"testingCourserWeb/pkg/data"
+	"testingCourserWeb/pkg/repository/dbrepo"
+	"time"
+)
+
+func TestNewRecoveryCodes(t *testing.T) {
+	codes, hashes, err := NewRecoveryCodes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(codes) != RecoveryCodes || len(hashes) != RecoveryCodes {
+		t.Fatalf("expected %d codes and hashes, but got %d and %d", RecoveryCodes, len(codes), len(hashes))
+	}
+
+	format := regexp.MustCompile(`^[a-z2-7]{5}-[a-z2-7]{5}$`)
+	seen := make(map[string]bool)
+	for i, code := range codes {
+		if !format.MatchString(code) {
+			t.Errorf("expected a code like abcde-fgh23, but got %q", code)
+		}
+		if seen[code] {
+			t.Errorf("expected every code to be different, but got %s twice", code)
+		}
+		seen[code] = true
+		if HashRecoveryCode(code) != hashes[i] || hashes[i] == code {
+			t.Errorf("expected the hash of %s, but got %s", code, hashes[i])
+		}
+	}
+
+	// codes can be typed in however is easiest
+	code := codes[0]
+	for _, typed := range []string{strings.ToUpper(code), strings.ReplaceAll(code, "-", ""), code[:5] + " " + code[6:]} {
+		if HashRecoveryCode(typed) != hashes[0] {
+			t.Errorf("expected %q to hash like %q", typed, code)
+		}
+	}
+}
+
+func TestManager(t *testing.T) {
+	ctx := context.Background()
+	db := dbrepo.NewMemoryDBRepo()
+	id, _ := db.InsertUser(ctx, data.User{FirstName: "Jack", LastName: "Smith", Email: "jack@smith.com", Password: "secret"})
+	m := NewManager(db, "example.com")
+
+	if on, err := m.Enabled(ctx, id); on || err != nil {
+		t.Errorf("expected mfa to be off to start with, but got %v, %v", on, err)
+	}
+	if _, err := m.Verify(ctx, id, "123456"); !errors.Is(err, ErrNotEnrolled) {
+		t.Errorf("expected ErrNotEnrolled verifying without mfa, but got %v", err)
+	}
+	if _, err := m.Confirm(ctx, id, "123456"); !errors.Is(err, ErrNotEnrolled) {
+		t.Errorf("expected ErrNotEnrolled confirming without enrolling, but got %v", err)
+	}
+
+	enrollment, err := m.Enroll(ctx, id, "jack@smith.com")
+	if err != nil {
+		t.Fatalf("unexpected error enrolling: %s", err)
+	}
+	if enrollment.URI != ProvisioningURI("example.com", "jack@smith.com", enrollment.Secret) {
+		t.Errorf("expected the provisioning uri for the secret, but got %s", enrollment.URI)
+	}
+	if on, _ := m.Enabled(ctx, id); on {
+		t.Error("expected mfa to stay off until confirmed")
+	}
+
+	step := Step(time.Now())
+	code := func(step int64) string {
+		code, _ := Code(enrollment.Secret, step)
+		return code
+	}
+
+	if _, err = m.Confirm(ctx, id, "000000"); !errors.Is(err, ErrInvalidCode) {
+		t.Errorf("expected ErrInvalidCode confirming with a wrong code, but got %v", err)
+	}
+	codes, err := m.Confirm(ctx, id, code(step))
+	if err != nil || len(codes) != RecoveryCodes {
+		t.Fatalf("expected %d recovery codes, but got %v, %v", RecoveryCodes, codes, err)
+	}
+	if on, _ := m.Enabled(ctx, id); !on {
+		t.Error("expected mfa to be on once confirmed")
+	}
+	if _, err = m.Confirm(ctx, id, code(step+1)); !errors.Is(err, ErrAlreadyEnabled) {
+		t.Errorf("expected ErrAlreadyEnabled confirming again, but got %v", err)
+	}
+	if _, err = m.Enroll(ctx, id, "jack@smith.com"); !errors.Is(err, ErrAlreadyEnabled) {
+		t.Errorf("expected ErrAlreadyEnabled enrolling again, but got %v", err)
+	}
+
+	var tests = []struct {
+		name           string
+		code           string
+		expectedMethod string
+		expectedErr    error
+	}{
+		{"the confirming code", code(step), "", ErrInvalidCode},
+		{"the next code", code(step + 1), MethodTOTP, nil},
+		{"the same code again", code(step + 1), "", ErrInvalidCode},
+		{"a wrong code", "000000", "", ErrInvalidCode},
+		{"a recovery code", codes[0], MethodRecoveryCode, nil},
+		{"the same recovery code again", codes[0], "", ErrInvalidCode},
+		{"a recovery code, typed in upper case", strings.ToUpper(codes[1]), MethodRecoveryCode, nil},
+		{"a made up recovery code", "aaaaa-aaaaa", "", ErrInvalidCode},
+	}
+
+	for _, e := range tests {
+		method, err := m.Verify(ctx, id, e.code)
+		if method != e.expectedMethod || !errors.Is(err, e.expectedErr) {
+			t.Errorf("%s: expected %q, %v, but got %q, %v", e.name, e.expectedMethod, e.expectedErr, method, err)
+		}
+	}
+
+	status, _ := m.Status(ctx, id)
+	if status.RecoveryCodesLeft != RecoveryCodes-2 {
+		t.Errorf("expected %d recovery codes left, but got %d", RecoveryCodes-2, status.RecoveryCodesLeft)
+	}
+
+	// new recovery codes take the place of the old ones
+	if _, err = m.RegenerateRecoveryCodes(ctx, id, "000000"); !errors.Is(err, ErrInvalidCode) {
+		t.Errorf("expected ErrInvalidCode regenerating with a wrong code, but got %v", err)
+	}
+	newCodes, err := m.RegenerateRecoveryCodes(ctx, id, codes[2])
+	if err != nil || len(newCodes) != RecoveryCodes {
+		t.Fatalf("expected %d new recovery codes, but got %v, %v", RecoveryCodes, newCodes, err)
+	}
+	if _, err = m.Verify(ctx, id, codes[3]); !errors.Is(err, ErrInvalidCode) {
+		t.Errorf("expected an old recovery code to stop working, but got %v", err)
+	}
+
+	if err = m.Disable(ctx, id, "000000"); !errors.Is(err, ErrInvalidCode) {
+		t.Errorf("expected ErrInvalidCode disabling with a wrong code, but got %v", err)
+	}
+	if err = m.Disable(ctx, id, newCodes[0]); err != nil {
+		t.Fatalf("unexpected error disabling: %s", err)
+	}
+	if status, _ = m.Status(ctx, id); status.Enabled() || status.Secret != "" {
+		t.Errorf("expected mfa to be off and forgotten, but got %+v", status)
+	}
+}
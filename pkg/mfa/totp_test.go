@@ -0,0 +1,119 @@
+package mfa
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// rfcSecret is the secret of RFC 6238's SHA1 test vectors, "12345678901234567890", in base32.
+const rfcSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestCode(t *testing.T) {
+	// the RFC's codes have eight digits; ours are the last six of them
+	var tests = []struct {
+		unix     int64
+		expected string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+		{20000000000, "353130"},
+	}
+
+	for _, e := range tests {
+		code, err := Code(rfcSecret, Step(time.Unix(e.unix, 0)))
+		if err != nil || code != e.expected {
+			t.Errorf("%d: expected %s, but got %s, %v", e.unix, e.expected, code, err)
+		}
+	}
+
+	// however the secret is written down
+	code, _ := Code(strings.ToLower("GEZD GNBV GY3T QOJQ GEZD GNBV GY3T QOJQ"), Step(time.Unix(59, 0)))
+	if code != "287082" {
+		t.Errorf("expected a spaced out, lower case secret to work, but got %s", code)
+	}
+	if _, err := Code("not base32!", 1); err == nil {
+		t.Error("expected an error for an invalid secret, but didn't get one")
+	}
+}
+
+func TestValidate(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+	step := Step(now)
+	codeAt := func(step int64) string {
+		code, _ := Code(rfcSecret, step)
+		return code
+	}
+
+	var tests = []struct {
+		name         string
+		code         string
+		expectedOK   bool
+		expectedStep int64
+	}{
+		{"now", codeAt(step), true, step},
+		{"spaced out", codeAt(step)[:3] + " " + codeAt(step)[3:], true, step},
+		{"a step ago", codeAt(step - 1), true, step - 1},
+		{"a step ahead", codeAt(step + 1), true, step + 1},
+		{"two steps ago", codeAt(step - 2), false, 0},
+		{"two steps ahead", codeAt(step + 2), false, 0},
+		{"wrong", "000000", false, 0},
+		{"too short", codeAt(step)[:5], false, 0},
+		{"empty", "", false, 0},
+	}
+
+	for _, e := range tests {
+		got, ok := Validate(rfcSecret, e.code, now)
+		if ok != e.expectedOK || got != e.expectedStep {
+			t.Errorf("%s: expected %v at step %d, but got %v at step %d", e.name, e.expectedOK, e.expectedStep, ok, got)
+		}
+	}
+}
+
+func TestNewSecret(t *testing.T) {
+	secret, err := NewSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(secret) != 32 {
+		t.Errorf("expected 32 characters of base32, but got %q", secret)
+	}
+	if _, err = Code(secret, 1); err != nil {
+		t.Errorf("expected a usable secret, but got %s", err)
+	}
+	if other, _ := NewSecret(); other == secret {
+		t.Error("expected a different secret every time")
+	}
+}
+
+func TestProvisioningURI(t *testing.T) {
+	uri := ProvisioningURI("Example Co", "jack@smith.com", rfcSecret)
+
+	u, err := url.Parse(uri)
+	if err != nil {
+		t.Fatalf("unexpected error parsing %s: %s", uri, err)
+	}
+	if u.Scheme != "otpauth" || u.Host != "totp" || u.Path != "/Example Co:jack@smith.com" {
+		t.Errorf("expected an otpauth totp uri for Example Co:jack@smith.com, but got %s", uri)
+	}
+	if !strings.HasPrefix(uri, "otpauth://totp/Example%20Co:jack@smith.com?") {
+		t.Errorf("expected the label to be escaped, but got %s", uri)
+	}
+
+	q := u.Query()
+	for name, expected := range map[string]string{
+		"secret":    rfcSecret,
+		"issuer":    "Example Co",
+		"algorithm": "SHA1",
+		"digits":    "6",
+		"period":    "30",
+	} {
+		if q.Get(name) != expected {
+			t.Errorf("expected %s to be %s, but got %q", name, expected, q.Get(name))
+		}
+	}
+}
@@ -0,0 +1,150 @@
+package migrations
+
+import (
+	"embed"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+)
+
+// files holds our migrations, in a directory for each dialect. Each one is a pair of files named
+// like 0001_initial_schema.up.sql and 0001_initial_schema.down.sql; the down file may be left out
+// if a migration can't be undone. Every dialect has the same migrations, with the same numbers.
+//
+//go:embed sql/postgres/*.sql sql/sqlite/*.sql
+var files embed.FS
+
+// The SQL dialects we have migrations for.
+const (
+	Postgres = "postgres"
+	SQLite   = "sqlite"
+)
+
+// Dialects lists every dialect; new migrations are created for all of them.
+var Dialects = []string{Postgres, SQLite}
+
+// Dir is where the migrations live in the source tree, relative to the root of the module,
+// with a directory for each dialect; new migrations are created here.
+const Dir = "pkg/migrations/sql"
+
+var (
+	fileName      = regexp.MustCompile(`^(\d+)_([a-z0-9_]+)\.(up|down)\.sql$`)
+	migrationName = regexp.MustCompile(`^[a-z0-9_]+$`)
+)
+
+// Migration is one step in the evolution of the schema.
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+// Embedded returns the migrations for dialect built into the binary, oldest first.
+func Embedded(dialect string) ([]Migration, error) {
+	if !validDialect(dialect) {
+		return nil, fmt.Errorf("no migrations for %s", dialect)
+	}
+	sub, err := fs.Sub(files, "sql/"+dialect)
+	if err != nil {
+		return nil, err
+	}
+	return Load(sub)
+}
+
+// Load reads the migrations in the top directory of fsys, oldest first. Files which don't look
+// like migrations are ignored, but two migrations with the same version, or a down migration
+// without an up, are errors.
+func Load(fsys fs.FS) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := make(map[int]*Migration)
+	for _, entry := range entries {
+		match := fileName.FindStringSubmatch(entry.Name())
+		if entry.IsDir() || match == nil {
+			continue
+		}
+
+		version, _ := strconv.Atoi(match[1])
+		if version < 1 {
+			return nil, fmt.Errorf("%s: versions start at 1", entry.Name())
+		}
+
+		m, ok := byVersion[version]
+		if !ok {
+			m = &Migration{Version: version, Name: match[2]}
+			byVersion[version] = m
+		}
+		if m.Name != match[2] {
+			return nil, fmt.Errorf("migrations %s and %s have the same version", m.Name, match[2])
+		}
+
+		b, err := fs.ReadFile(fsys, entry.Name())
+		if err != nil {
+			return nil, err
+		}
+		if match[3] == "up" {
+			m.Up = string(b)
+		} else {
+			m.Down = string(b)
+		}
+	}
+
+	var migrations []Migration
+	for _, m := range byVersion {
+		if m.Up == "" {
+			return nil, fmt.Errorf("migration %d_%s has no up file, or it is empty", m.Version, m.Name)
+		}
+		migrations = append(migrations, *m)
+	}
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+	return migrations, nil
+}
+
+// Create writes empty up and down files for a new migration called name to dir, numbered one
+// after the newest migration already there, and returns their paths.
+func Create(dir, name string) (string, string, error) {
+	if !migrationName.MatchString(name) {
+		return "", "", fmt.Errorf("migration names may only contain a-z, 0-9 and _, not %q", name)
+	}
+
+	existing, err := Load(os.DirFS(dir))
+	if err != nil {
+		return "", "", err
+	}
+	version := 1
+	if len(existing) > 0 {
+		version = existing[len(existing)-1].Version + 1
+	}
+
+	base := filepath.Join(dir, fmt.Sprintf("%04d_%s", version, name))
+	up, down := base+".up.sql", base+".down.sql"
+
+	err = os.WriteFile(up, []byte(fmt.Sprintf("-- %04d %s\n", version, name)), 0644)
+	if err != nil {
+		return "", "", err
+	}
+	err = os.WriteFile(down, []byte(fmt.Sprintf("-- undoes %04d %s\n", version, name)), 0644)
+	if err != nil {
+		return "", "", err
+	}
+	return up, down, nil
+}
+
+func validDialect(dialect string) bool {
+	for _, d := range Dialects {
+		if d == dialect {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,133 @@
+package migrations
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+)
+
+func TestLoad(t *testing.T) {
+	var tests = []struct {
+		name             string
+		files            fstest.MapFS
+		expectedVersions []int
+		expectedErr      bool
+	}{
+		{"in order",
+			fstest.MapFS{
+				"0002_second.up.sql":   {Data: []byte("create table b (id integer)")},
+				"0001_first.up.sql":    {Data: []byte("create table a (id integer)")},
+				"0001_first.down.sql":  {Data: []byte("drop table a")},
+				"0010_tenth.up.sql":    {Data: []byte("create table c (id integer)")},
+				"0010_tenth.down.sql":  {Data: []byte("drop table c")},
+				"README.md":            {Data: []byte("not a migration")},
+				"0003_Not_Valid.up.sq": {Data: []byte("not a migration either")},
+			},
+			[]int{1, 2, 10}, false},
+		{"nothing", fstest.MapFS{}, nil, false},
+		{"down without up", fstest.MapFS{"0001_first.down.sql": {Data: []byte("drop table a")}}, nil, true},
+		{"empty up", fstest.MapFS{"0001_first.up.sql": {Data: []byte("")}}, nil, true},
+		{"same version",
+			fstest.MapFS{
+				"0001_first.up.sql": {Data: []byte("create table a (id integer)")},
+				"0001_other.up.sql": {Data: []byte("create table b (id integer)")},
+			},
+			nil, true},
+		{"version zero", fstest.MapFS{"0000_first.up.sql": {Data: []byte("create table a (id integer)")}}, nil, true},
+	}
+
+	for _, e := range tests {
+		migrations, err := Load(e.files)
+		if e.expectedErr {
+			if err == nil {
+				t.Errorf("%s: expected an error, but got none", e.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", e.name, err)
+			continue
+		}
+
+		if len(migrations) != len(e.expectedVersions) {
+			t.Errorf("%s: expected %d migrations, but got %d", e.name, len(e.expectedVersions), len(migrations))
+			continue
+		}
+		for i, version := range e.expectedVersions {
+			if migrations[i].Version != version {
+				t.Errorf("%s: expected migration %d to be version %d, but got %d", e.name, i, version, migrations[i].Version)
+			}
+		}
+	}
+}
+
+func TestEmbedded(t *testing.T) {
+	postgres, err := Embedded(Postgres)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(postgres) == 0 || postgres[0].Version != 1 || postgres[0].Name != "initial_schema" {
+		t.Fatalf("expected the first migration to be 0001_initial_schema, but got %v", postgres)
+	}
+
+	for _, dialect := range Dialects {
+		migrations, err := Embedded(dialect)
+		if err != nil {
+			t.Fatalf("%s: %s", dialect, err)
+		}
+
+		// every dialect has the same migrations, so that a version means the same schema everywhere
+		if len(migrations) != len(postgres) {
+			t.Errorf("%s: expected %d migrations, but got %d", dialect, len(postgres), len(migrations))
+			continue
+		}
+		for i, m := range migrations {
+			if m.Version != postgres[i].Version || m.Name != postgres[i].Name {
+				t.Errorf("%s: expected %04d_%s, but got %04d_%s", dialect, postgres[i].Version, postgres[i].Name, m.Version, m.Name)
+			}
+			if m.Down == "" {
+				t.Errorf("%s: %04d_%s: expected every migration we ship to have a down migration", dialect, m.Version, m.Name)
+			}
+		}
+	}
+
+	_, err = Embedded("oracle")
+	if err == nil {
+		t.Error("expected an error for a dialect we have no migrations for")
+	}
+}
+
+func TestCreate(t *testing.T) {
+	dir := t.TempDir()
+
+	up, down, err := Create(dir, "first")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if filepath.Base(up) != "0001_first.up.sql" || filepath.Base(down) != "0001_first.down.sql" {
+		t.Errorf("wrong file names for the first migration: %s and %s", up, down)
+	}
+
+	up, _, err = Create(dir, "add_phone")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if filepath.Base(up) != "0002_add_phone.up.sql" {
+		t.Errorf("expected the second migration to be 0002_add_phone, but got %s", up)
+	}
+
+	migrations, err := Load(os.DirFS(dir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(migrations) != 2 {
+		t.Errorf("expected 2 migrations to load, but got %d", len(migrations))
+	}
+
+	_, _, err = Create(dir, "Add Phone")
+	if err == nil {
+		t.Error("expected an error for a name with spaces and capitals")
+	}
+}
@@ -0,0 +1,8 @@
+DROP TABLE IF EXISTS public.user_roles;
+DROP TABLE IF EXISTS public.role_permissions;
+DROP TABLE IF EXISTS public.roles;
+DROP TABLE IF EXISTS public.permissions;
+DROP TABLE IF EXISTS public.refresh_tokens;
+DROP TABLE IF EXISTS public.user_images;
+DROP TABLE IF EXISTS public.users;
+DROP EXTENSION IF EXISTS pg_trgm;
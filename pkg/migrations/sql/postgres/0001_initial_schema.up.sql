@@ -0,0 +1,126 @@
+-- The schema as it was in the pg_dump we used to load with docker-compose (sql/users.sql). Everything
+-- is created only if it does not exist yet, so that databases created from any version of that dump can
+-- be migrated. The first versions had a users table without the search columns, or the unique email,
+-- so those are added to it separately; that fails if two users share an email, which must be fixed first.
+
+CREATE EXTENSION IF NOT EXISTS pg_trgm WITH SCHEMA public;
+
+CREATE TABLE IF NOT EXISTS public.users (
+    id integer GENERATED ALWAYS AS IDENTITY,
+    first_name character varying(255),
+    last_name character varying(255),
+    email character varying(255),
+    password character varying(60),
+    is_admin integer,
+    created_at timestamp without time zone,
+    updated_at timestamp without time zone,
+    CONSTRAINT users_pkey PRIMARY KEY (id)
+);
+
+ALTER TABLE public.users ADD COLUMN IF NOT EXISTS search_text text GENERATED ALWAYS AS (lower(coalesce(first_name, '') || ' ' || coalesce(last_name, '') || ' ' || coalesce(email, ''))) STORED;
+ALTER TABLE public.users ADD COLUMN IF NOT EXISTS search tsvector GENERATED ALWAYS AS (to_tsvector('simple'::regconfig, coalesce(first_name, '') || ' ' || coalesce(last_name, '') || ' ' || coalesce(email, ''))) STORED;
+
+DO $$
+BEGIN
+    IF NOT EXISTS (SELECT 1 FROM pg_constraint WHERE conname = 'users_email_key' AND conrelid = 'public.users'::regclass) THEN
+        ALTER TABLE public.users ADD CONSTRAINT users_email_key UNIQUE (email);
+    END IF;
+END
+$$;
+
+CREATE INDEX IF NOT EXISTS users_created_at_id_idx ON public.users USING btree (created_at, id);
+CREATE INDEX IF NOT EXISTS users_last_name_id_idx ON public.users USING btree (last_name, id);
+CREATE INDEX IF NOT EXISTS users_search_idx ON public.users USING gin (search);
+CREATE INDEX IF NOT EXISTS users_search_text_trgm_idx ON public.users USING gin (search_text public.gin_trgm_ops);
+
+CREATE TABLE IF NOT EXISTS public.user_images (
+    id integer GENERATED ALWAYS AS IDENTITY,
+    user_id integer,
+    file_name character varying(255),
+    created_at timestamp without time zone,
+    updated_at timestamp without time zone,
+    CONSTRAINT user_images_pkey PRIMARY KEY (id),
+    CONSTRAINT user_images_user_id_fkey FOREIGN KEY (user_id) REFERENCES public.users(id) ON UPDATE CASCADE ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS public.refresh_tokens (
+    id integer GENERATED ALWAYS AS IDENTITY,
+    user_id integer NOT NULL,
+    family_id character varying(64) NOT NULL,
+    jti character varying(64) NOT NULL,
+    expires_at timestamp without time zone NOT NULL,
+    revoked boolean DEFAULT false NOT NULL,
+    created_at timestamp without time zone,
+    updated_at timestamp without time zone,
+    CONSTRAINT refresh_tokens_pkey PRIMARY KEY (id),
+    CONSTRAINT refresh_tokens_jti_key UNIQUE (jti),
+    CONSTRAINT refresh_tokens_user_id_fkey FOREIGN KEY (user_id) REFERENCES public.users(id) ON UPDATE CASCADE ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS refresh_tokens_family_id_idx ON public.refresh_tokens USING btree (family_id);
+
+CREATE TABLE IF NOT EXISTS public.permissions (
+    id integer GENERATED ALWAYS AS IDENTITY,
+    name character varying(255) NOT NULL,
+    description character varying(255),
+    created_at timestamp without time zone,
+    updated_at timestamp without time zone,
+    CONSTRAINT permissions_pkey PRIMARY KEY (id),
+    CONSTRAINT permissions_name_key UNIQUE (name)
+);
+
+CREATE TABLE IF NOT EXISTS public.roles (
+    id integer GENERATED ALWAYS AS IDENTITY,
+    name character varying(255) NOT NULL,
+    description character varying(255),
+    created_at timestamp without time zone,
+    updated_at timestamp without time zone,
+    CONSTRAINT roles_pkey PRIMARY KEY (id),
+    CONSTRAINT roles_name_key UNIQUE (name)
+);
+
+CREATE TABLE IF NOT EXISTS public.role_permissions (
+    role_id integer NOT NULL,
+    permission_id integer NOT NULL,
+    CONSTRAINT role_permissions_pkey PRIMARY KEY (role_id, permission_id),
+    CONSTRAINT role_permissions_permission_id_fkey FOREIGN KEY (permission_id) REFERENCES public.permissions(id) ON UPDATE CASCADE ON DELETE CASCADE,
+    CONSTRAINT role_permissions_role_id_fkey FOREIGN KEY (role_id) REFERENCES public.roles(id) ON UPDATE CASCADE ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS public.user_roles (
+    user_id integer NOT NULL,
+    role_id integer NOT NULL,
+    created_at timestamp without time zone,
+    CONSTRAINT user_roles_pkey PRIMARY KEY (user_id, role_id),
+    CONSTRAINT user_roles_role_id_fkey FOREIGN KEY (role_id) REFERENCES public.roles(id) ON UPDATE CASCADE ON DELETE CASCADE,
+    CONSTRAINT user_roles_user_id_fkey FOREIGN KEY (user_id) REFERENCES public.users(id) ON UPDATE CASCADE ON DELETE CASCADE
+);
+
+-- the permissions and roles in pkg/authz
+INSERT INTO public.permissions (name, description, created_at, updated_at) VALUES
+    ('users:read', 'View users', '2022-08-19 00:00:00', '2022-08-19 00:00:00'),
+    ('users:create', 'Create users', '2022-08-19 00:00:00', '2022-08-19 00:00:00'),
+    ('users:update', 'Update users', '2022-08-19 00:00:00', '2022-08-19 00:00:00'),
+    ('users:delete', 'Delete users', '2022-08-19 00:00:00', '2022-08-19 00:00:00')
+ON CONFLICT (name) DO NOTHING;
+
+INSERT INTO public.roles (name, description, created_at, updated_at) VALUES
+    ('admin', 'Full access to everything', '2022-08-19 00:00:00', '2022-08-19 00:00:00'),
+    ('support', 'Support staff; can view and update users', '2022-08-19 00:00:00', '2022-08-19 00:00:00'),
+    ('auditor', 'Read only access', '2022-08-19 00:00:00', '2022-08-19 00:00:00')
+ON CONFLICT (name) DO NOTHING;
+
+INSERT INTO public.role_permissions (role_id, permission_id)
+SELECT r.id, p.id
+FROM (VALUES
+    ('admin', 'users:read'),
+    ('admin', 'users:create'),
+    ('admin', 'users:update'),
+    ('admin', 'users:delete'),
+    ('support', 'users:read'),
+    ('support', 'users:update'),
+    ('auditor', 'users:read')
+) AS grants (role, permission)
+    INNER JOIN public.roles r ON (r.name = grants.role)
+    INNER JOIN public.permissions p ON (p.name = grants.permission)
+ON CONFLICT DO NOTHING;
@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS public.users_deleted_at_idx;
+ALTER TABLE public.users DROP COLUMN IF EXISTS disabled_at;
+ALTER TABLE public.users DROP COLUMN IF EXISTS deleted_at;
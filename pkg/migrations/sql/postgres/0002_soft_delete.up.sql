@@ -0,0 +1,8 @@
+-- Users are no longer deleted outright. Deleting a user sets deleted_at, and deactivating one
+-- sets disabled_at; either can be undone, and dbrepo's PurgeUser removes a user for good.
+
+ALTER TABLE public.users ADD COLUMN IF NOT EXISTS deleted_at timestamp without time zone;
+ALTER TABLE public.users ADD COLUMN IF NOT EXISTS disabled_at timestamp without time zone;
+
+-- for listing deleted users; everything else only wants the ones which are not
+CREATE INDEX IF NOT EXISTS users_deleted_at_idx ON public.users USING btree (deleted_at) WHERE deleted_at IS NOT NULL;
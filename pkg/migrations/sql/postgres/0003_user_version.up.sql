@@ -0,0 +1,4 @@
+-- Every change to a user adds one to their version, which the API hands out as an ETag, so that
+-- an update based on an old copy of the user can be refused rather than overwrite newer changes.
+
+ALTER TABLE public.users ADD COLUMN IF NOT EXISTS version integer DEFAULT 1 NOT NULL;
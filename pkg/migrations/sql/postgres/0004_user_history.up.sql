@@ -0,0 +1,17 @@
+-- Every change made to a user through the repository is recorded here, with what the user looked
+-- like before and after it, so that admins can see who changed what, and revert it. actor_id is
+-- not a foreign key: the history should outlive whoever made the change.
+
+CREATE TABLE IF NOT EXISTS public.user_history (
+    id integer GENERATED ALWAYS AS IDENTITY,
+    user_id integer NOT NULL,
+    action character varying(32) NOT NULL,
+    actor_id integer,
+    before_state jsonb,
+    after_state jsonb,
+    created_at timestamp without time zone NOT NULL,
+    CONSTRAINT user_history_pkey PRIMARY KEY (id),
+    CONSTRAINT user_history_user_id_fkey FOREIGN KEY (user_id) REFERENCES public.users(id) ON UPDATE CASCADE ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS user_history_user_id_id_idx ON public.user_history USING btree (user_id, id);
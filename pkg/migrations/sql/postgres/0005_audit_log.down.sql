@@ -0,0 +1,2 @@
+DELETE FROM public.permissions WHERE name = 'audit:read';
+DROP TABLE IF EXISTS public.audit_events;
@@ -0,0 +1,35 @@
+-- The security audit log: logins, refreshes, logouts, password resets and rejected tokens, with
+-- where each request came from. user_id is not a foreign key, and purging a user leaves their
+-- events behind: the log is a record of what happened, whoever it happened to.
+
+CREATE TABLE IF NOT EXISTS public.audit_events (
+    id integer GENERATED ALWAYS AS IDENTITY,
+    event character varying(32) NOT NULL,
+    user_id integer,
+    email character varying(255) DEFAULT '' NOT NULL,
+    reason text DEFAULT '' NOT NULL,
+    ip character varying(255) DEFAULT '' NOT NULL,
+    user_agent text DEFAULT '' NOT NULL,
+    request_id character varying(64) DEFAULT '' NOT NULL,
+    created_at timestamp without time zone NOT NULL,
+    CONSTRAINT audit_events_pkey PRIMARY KEY (id)
+);
+
+CREATE INDEX IF NOT EXISTS audit_events_created_at_idx ON public.audit_events USING btree (created_at);
+CREATE INDEX IF NOT EXISTS audit_events_user_id_id_idx ON public.audit_events USING btree (user_id, id);
+CREATE INDEX IF NOT EXISTS audit_events_ip_id_idx ON public.audit_events USING btree (ip, id);
+
+-- admins and auditors may read it
+INSERT INTO public.permissions (name, description, created_at, updated_at) VALUES
+    ('audit:read', 'Read the security audit log', '2022-08-19 00:00:00', '2022-08-19 00:00:00')
+ON CONFLICT (name) DO NOTHING;
+
+INSERT INTO public.role_permissions (role_id, permission_id)
+SELECT r.id, p.id
+FROM (VALUES
+    ('admin', 'audit:read'),
+    ('auditor', 'audit:read')
+) AS grants (role, permission)
+    INNER JOIN public.roles r ON (r.name = grants.role)
+    INNER JOIN public.permissions p ON (p.name = grants.permission)
+ON CONFLICT DO NOTHING;
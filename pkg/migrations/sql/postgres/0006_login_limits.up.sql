@@ -0,0 +1,21 @@
+-- Failed logins for each account, for locking accounts out after too many, and the token buckets
+-- which rate limit logins across every API instance. An account is a normalised email address,
+-- which need not belong to a user, so neither table refers to users.
+
+CREATE TABLE IF NOT EXISTS public.login_failures (
+    account character varying(255) NOT NULL,
+    failures integer NOT NULL,
+    last_failure_at timestamp without time zone NOT NULL,
+    locked_until timestamp without time zone,
+    CONSTRAINT login_failures_pkey PRIMARY KEY (account)
+);
+
+-- tat is the bucket's theoretical arrival time, in microseconds since the epoch: the bucket is
+-- full again then, and a bucket whose tat has passed may be deleted
+CREATE TABLE IF NOT EXISTS public.rate_limit_buckets (
+    key character varying(255) NOT NULL,
+    tat bigint NOT NULL,
+    CONSTRAINT rate_limit_buckets_pkey PRIMARY KEY (key)
+);
+
+CREATE INDEX IF NOT EXISTS rate_limit_buckets_tat_idx ON public.rate_limit_buckets USING btree (tat);
@@ -0,0 +1,13 @@
+-- Request counts for the API's sliding window rate limits, in fixed windows, shared by every API
+-- instance. Times are microseconds since the epoch, like rate_limit_buckets; a window which has
+-- expired no longer counts towards anything, and may be deleted.
+
+CREATE TABLE IF NOT EXISTS public.rate_limit_windows (
+    key character varying(255) NOT NULL,
+    window_start bigint NOT NULL,
+    hits integer NOT NULL,
+    expires_at bigint NOT NULL,
+    CONSTRAINT rate_limit_windows_pkey PRIMARY KEY (key, window_start)
+);
+
+CREATE INDEX IF NOT EXISTS rate_limit_windows_expires_at_idx ON public.rate_limit_windows USING btree (expires_at);
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS public.recovery_codes;
+DROP TABLE IF EXISTS public.user_mfa;
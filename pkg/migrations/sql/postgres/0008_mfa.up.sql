@@ -0,0 +1,24 @@
+-- Two-factor authentication: each user's TOTP secret, which is only used for logins once enabled_at
+-- is set, and their recovery codes, of which we only keep sha256 hashes. last_step is the time
+-- step of the last code used, so that no code works twice.
+
+CREATE TABLE IF NOT EXISTS public.user_mfa (
+    user_id integer NOT NULL,
+    secret character varying(64) NOT NULL,
+    last_step bigint DEFAULT 0 NOT NULL,
+    enabled_at timestamp without time zone,
+    created_at timestamp without time zone NOT NULL,
+    CONSTRAINT user_mfa_pkey PRIMARY KEY (user_id),
+    CONSTRAINT user_mfa_user_id_fkey FOREIGN KEY (user_id) REFERENCES public.users(id) ON UPDATE CASCADE ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS public.recovery_codes (
+    id integer GENERATED ALWAYS AS IDENTITY,
+    user_id integer NOT NULL,
+    code_hash character varying(64) NOT NULL,
+    used_at timestamp without time zone,
+    created_at timestamp without time zone NOT NULL,
+    CONSTRAINT recovery_codes_pkey PRIMARY KEY (id),
+    CONSTRAINT recovery_codes_user_id_code_hash_key UNIQUE (user_id, code_hash),
+    CONSTRAINT recovery_codes_user_id_fkey FOREIGN KEY (user_id) REFERENCES public.user_mfa(user_id) ON UPDATE CASCADE ON DELETE CASCADE
+);
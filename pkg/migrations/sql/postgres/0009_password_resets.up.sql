@@ -0,0 +1,17 @@
+-- Password resets requested by users who have forgotten their password. We only keep the sha256
+-- hash of each token; the token itself is only ever in the link we email. A reset can be used
+-- once, before it expires.
+
+CREATE TABLE IF NOT EXISTS public.password_resets (
+    id integer GENERATED ALWAYS AS IDENTITY,
+    user_id integer NOT NULL,
+    token_hash character varying(64) NOT NULL,
+    expires_at timestamp without time zone NOT NULL,
+    used_at timestamp without time zone,
+    created_at timestamp without time zone NOT NULL,
+    CONSTRAINT password_resets_pkey PRIMARY KEY (id),
+    CONSTRAINT password_resets_token_hash_key UNIQUE (token_hash),
+    CONSTRAINT password_resets_user_id_fkey FOREIGN KEY (user_id) REFERENCES public.users(id) ON UPDATE CASCADE ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS password_resets_user_id_idx ON public.password_resets USING btree (user_id);
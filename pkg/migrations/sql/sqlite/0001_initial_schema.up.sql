@@ -0,0 +1,94 @@
+-- The same schema as the Postgres migration, for SQLite. Times are stored as text, in a format
+-- which sorts correctly (see dbrepo.sqliteTime), and search is done with like rather than
+-- full text search, so users only has search_text.
+
+CREATE TABLE users (
+    id integer PRIMARY KEY AUTOINCREMENT,
+    first_name varchar(255),
+    last_name varchar(255),
+    email varchar(255) CONSTRAINT users_email_key UNIQUE,
+    password varchar(60),
+    is_admin integer,
+    created_at timestamp,
+    updated_at timestamp,
+    search_text text GENERATED ALWAYS AS (lower(coalesce(first_name, '') || ' ' || coalesce(last_name, '') || ' ' || coalesce(email, ''))) STORED
+);
+
+CREATE INDEX users_created_at_id_idx ON users (created_at, id);
+CREATE INDEX users_last_name_id_idx ON users (last_name, id);
+
+CREATE TABLE user_images (
+    id integer PRIMARY KEY AUTOINCREMENT,
+    user_id integer REFERENCES users (id) ON UPDATE CASCADE ON DELETE CASCADE,
+    file_name varchar(255),
+    created_at timestamp,
+    updated_at timestamp
+);
+
+CREATE TABLE refresh_tokens (
+    id integer PRIMARY KEY AUTOINCREMENT,
+    user_id integer NOT NULL REFERENCES users (id) ON UPDATE CASCADE ON DELETE CASCADE,
+    family_id varchar(64) NOT NULL,
+    jti varchar(64) NOT NULL UNIQUE,
+    expires_at timestamp NOT NULL,
+    revoked boolean DEFAULT false NOT NULL,
+    created_at timestamp,
+    updated_at timestamp
+);
+
+CREATE INDEX refresh_tokens_family_id_idx ON refresh_tokens (family_id);
+
+CREATE TABLE permissions (
+    id integer PRIMARY KEY AUTOINCREMENT,
+    name varchar(255) NOT NULL UNIQUE,
+    description varchar(255),
+    created_at timestamp,
+    updated_at timestamp
+);
+
+CREATE TABLE roles (
+    id integer PRIMARY KEY AUTOINCREMENT,
+    name varchar(255) NOT NULL UNIQUE,
+    description varchar(255),
+    created_at timestamp,
+    updated_at timestamp
+);
+
+CREATE TABLE role_permissions (
+    role_id integer NOT NULL REFERENCES roles (id) ON UPDATE CASCADE ON DELETE CASCADE,
+    permission_id integer NOT NULL REFERENCES permissions (id) ON UPDATE CASCADE ON DELETE CASCADE,
+    PRIMARY KEY (role_id, permission_id)
+);
+
+CREATE TABLE user_roles (
+    user_id integer NOT NULL REFERENCES users (id) ON UPDATE CASCADE ON DELETE CASCADE,
+    role_id integer NOT NULL REFERENCES roles (id) ON UPDATE CASCADE ON DELETE CASCADE,
+    created_at timestamp,
+    PRIMARY KEY (user_id, role_id)
+);
+
+-- the permissions and roles in pkg/authz
+INSERT INTO permissions (name, description, created_at, updated_at) VALUES
+    ('users:read', 'View users', '2022-08-19 00:00:00.000000000', '2022-08-19 00:00:00.000000000'),
+    ('users:create', 'Create users', '2022-08-19 00:00:00.000000000', '2022-08-19 00:00:00.000000000'),
+    ('users:update', 'Update users', '2022-08-19 00:00:00.000000000', '2022-08-19 00:00:00.000000000'),
+    ('users:delete', 'Delete users', '2022-08-19 00:00:00.000000000', '2022-08-19 00:00:00.000000000');
+
+INSERT INTO roles (name, description, created_at, updated_at) VALUES
+    ('admin', 'Full access to everything', '2022-08-19 00:00:00.000000000', '2022-08-19 00:00:00.000000000'),
+    ('support', 'Support staff; can view and update users', '2022-08-19 00:00:00.000000000', '2022-08-19 00:00:00.000000000'),
+    ('auditor', 'Read only access', '2022-08-19 00:00:00.000000000', '2022-08-19 00:00:00.000000000');
+
+INSERT INTO role_permissions (role_id, permission_id)
+SELECT r.id, p.id
+FROM (
+    SELECT 'admin' AS role, 'users:read' AS permission
+    UNION ALL SELECT 'admin', 'users:create'
+    UNION ALL SELECT 'admin', 'users:update'
+    UNION ALL SELECT 'admin', 'users:delete'
+    UNION ALL SELECT 'support', 'users:read'
+    UNION ALL SELECT 'support', 'users:update'
+    UNION ALL SELECT 'auditor', 'users:read'
+) AS grants
+    INNER JOIN roles r ON (r.name = grants.role)
+    INNER JOIN permissions p ON (p.name = grants.permission);
@@ -0,0 +1,7 @@
+-- The same as the Postgres migration: deleted_at marks a deleted user, and disabled_at a
+-- deactivated one.
+
+ALTER TABLE users ADD COLUMN deleted_at timestamp;
+ALTER TABLE users ADD COLUMN disabled_at timestamp;
+
+CREATE INDEX users_deleted_at_idx ON users (deleted_at) WHERE deleted_at IS NOT NULL;
@@ -0,0 +1,3 @@
+-- The same as the Postgres migration: version goes up by one with every change to a user.
+
+ALTER TABLE users ADD COLUMN version integer DEFAULT 1 NOT NULL;
@@ -0,0 +1,13 @@
+-- The same as the Postgres migration, except that the snapshots are JSON in text columns.
+
+CREATE TABLE user_history (
+    id integer PRIMARY KEY AUTOINCREMENT,
+    user_id integer NOT NULL REFERENCES users (id) ON UPDATE CASCADE ON DELETE CASCADE,
+    action varchar(32) NOT NULL,
+    actor_id integer,
+    before_state text,
+    after_state text,
+    created_at timestamp NOT NULL
+);
+
+CREATE INDEX user_history_user_id_id_idx ON user_history (user_id, id);
@@ -0,0 +1,2 @@
+DELETE FROM permissions WHERE name = 'audit:read';
+DROP TABLE audit_events;
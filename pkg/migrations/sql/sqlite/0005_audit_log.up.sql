@@ -0,0 +1,29 @@
+-- The same as the Postgres migration: the audit log, and the audit:read permission.
+
+CREATE TABLE audit_events (
+    id integer PRIMARY KEY AUTOINCREMENT,
+    event varchar(32) NOT NULL,
+    user_id integer,
+    email varchar(255) DEFAULT '' NOT NULL,
+    reason text DEFAULT '' NOT NULL,
+    ip varchar(255) DEFAULT '' NOT NULL,
+    user_agent text DEFAULT '' NOT NULL,
+    request_id varchar(64) DEFAULT '' NOT NULL,
+    created_at timestamp NOT NULL
+);
+
+CREATE INDEX audit_events_created_at_idx ON audit_events (created_at);
+CREATE INDEX audit_events_user_id_id_idx ON audit_events (user_id, id);
+CREATE INDEX audit_events_ip_id_idx ON audit_events (ip, id);
+
+INSERT INTO permissions (name, description, created_at, updated_at) VALUES
+    ('audit:read', 'Read the security audit log', '2022-08-19 00:00:00.000000000', '2022-08-19 00:00:00.000000000');
+
+INSERT INTO role_permissions (role_id, permission_id)
+SELECT r.id, p.id
+FROM (
+    SELECT 'admin' AS role, 'audit:read' AS permission
+    UNION ALL SELECT 'auditor', 'audit:read'
+) AS grants
+    INNER JOIN roles r ON (r.name = grants.role)
+    INNER JOIN permissions p ON (p.name = grants.permission);
@@ -0,0 +1,10 @@
+-- Failed logins for each account, like the Postgres migration. A SQLite database only ever has
+-- one instance in front of it, which keeps its rate limit buckets in memory, so there is no
+-- rate_limit_buckets table.
+
+CREATE TABLE login_failures (
+    account varchar(255) PRIMARY KEY,
+    failures integer NOT NULL,
+    last_failure_at timestamp NOT NULL,
+    locked_until timestamp
+);
@@ -0,0 +1,3 @@
+-- Nothing to do: like rate limit buckets, the API keeps its rate limit windows in memory when it
+-- uses SQLite. The migration is here so that every dialect has the same migrations.
+SELECT 1;
@@ -0,0 +1,18 @@
+-- The same as the Postgres migration: TOTP secrets, and the hashes of recovery codes.
+
+CREATE TABLE user_mfa (
+    user_id integer PRIMARY KEY REFERENCES users (id) ON UPDATE CASCADE ON DELETE CASCADE,
+    secret varchar(64) NOT NULL,
+    last_step integer DEFAULT 0 NOT NULL,
+    enabled_at timestamp,
+    created_at timestamp NOT NULL
+);
+
+CREATE TABLE recovery_codes (
+    id integer PRIMARY KEY AUTOINCREMENT,
+    user_id integer NOT NULL REFERENCES user_mfa (user_id) ON UPDATE CASCADE ON DELETE CASCADE,
+    code_hash varchar(64) NOT NULL,
+    used_at timestamp,
+    created_at timestamp NOT NULL,
+    UNIQUE (user_id, code_hash)
+);
@@ -0,0 +1,12 @@
+-- The same as the Postgres migration: the hashes of password reset tokens.
+
+CREATE TABLE password_resets (
+    id integer PRIMARY KEY AUTOINCREMENT,
+    user_id integer NOT NULL REFERENCES users (id) ON UPDATE CASCADE ON DELETE CASCADE,
+    token_hash varchar(64) NOT NULL UNIQUE,
+    expires_at timestamp NOT NULL,
+    used_at timestamp,
+    created_at timestamp NOT NULL
+);
+
+CREATE INDEX password_resets_user_id_idx ON password_resets (user_id);
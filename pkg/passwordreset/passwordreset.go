@@ -0,0 +1,179 @@
+// Package passwordreset lets users who have forgotten their password choose a new one, by
+// following a link we email them. The link carries a signed token, which works once, and only
+// for a while; we only keep its hash.
+package passwordreset
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"sync"
+	"testingCourserWeb/pkg/data"
+	"testingCourserWeb/pkg/mailer"
+	"testingCourserWeb/pkg/repository"
+	"time"
+)
+
+// ErrInvalidToken is returned for a token which was never issued, has been used, or has expired.
+var ErrInvalidToken = errors.New("invalid or expired password reset token")
+
+// DefaultExpiry is how long a reset link works for.
+const DefaultExpiry = time.Hour
+
+// SendTimeout is how long RequestLater gives a link to be sent.
+const SendTimeout = time.Minute
+
+// Store keeps password resets, and changes passwords; repository.DatabaseRepo is one.
+type Store interface {
+	GetUserByEmail(ctx context.Context, email string) (*data.User, error)
+	InsertPasswordReset(ctx context.Context, r data.PasswordReset) (int, error)
+	WithTx(ctx context.Context, fn func(repo repository.DatabaseRepo) error) error
+}
+
+// Manager sends users links to reset their password, and resets it when they follow one.
+// Secret signs tokens; applications sharing a database must share it, so that a link sent by one
+// works in the other. Link is the page a user chooses their new password on, which the token is
+// added to, as the token query parameter.
+type Manager struct {
+	Store  Store
+	Mailer mailer.Mailer
+	Secret []byte
+	Link   string
+	Expiry time.Duration
+
+	// pending counts the requests RequestLater is still working on.
+	pending sync.WaitGroup
+}
+
+// NewManager returns a manager keeping resets in store, and emailing links to link through m,
+// which work for DefaultExpiry.
+func NewManager(store Store, m mailer.Mailer, secret []byte, link string) *Manager {
+	return &Manager{Store: store, Mailer: m, Secret: secret, Link: link, Expiry: DefaultExpiry}
+}
+
+// Request emails the user with email a link to reset their password, and returns them. If there
+// is no such user, or they have been deactivated, nothing is sent, and the user is nil; callers
+// should respond the same either way, so as not to tell anybody which emails have accounts.
+func (m *Manager) Request(ctx context.Context, email string) (*data.User, error) {
+	user, err := m.Store.GetUserByEmail(ctx, email)
+	if errors.Is(err, repository.ErrNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if user.DisabledAt != nil {
+		return nil, nil
+	}
+
+	token, err := newToken(m.Secret)
+	if err != nil {
+		return nil, err
+	}
+	_, err = m.Store.InsertPasswordReset(ctx, data.PasswordReset{
+		UserID:    user.ID,
+		TokenHash: HashToken(token),
+		ExpiresAt: time.Now().Add(m.Expiry),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	link, err := m.link(token)
+	if err != nil {
+		return nil, err
+	}
+	err = m.Mailer.Send(ctx, mailer.Message{
+		To:      user.Email,
+		Subject: "Reset your password",
+		Body: fmt.Sprintf("Somebody, hopefully you, asked to reset the password for your account. "+
+			"To choose a new one, follow this link within %d minutes:\n\n%s\n\n"+
+			"If it wasn't you, you can ignore this email; your password has not been changed.\n",
+			int(m.Expiry.Minutes()), link),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("sending password reset link: %w", err)
+	}
+	return user, nil
+}
+
+// link returns the link to Link with token.
+func (m *Manager) link(token string) (string, error) {
+	u, err := url.Parse(m.Link)
+	if err != nil {
+		return "", err
+	}
+	q := u.Query()
+	q.Set("token", token)
+	u.RawQuery = q.Encode()
+	return u.String(), nil
+}
+
+// RequestLater is Request, off the request path: it returns straight away, and calls done with
+// what Request returned once it is finished. Looking the user up, storing the reset and sending
+// the email take time, but only if the user exists, so a response which waited for them would
+// give away which emails have accounts. done gets the context Request ran with, which has ctx's
+// values but not its cancellation, since the request ctx belongs to will be over by then.
+func (m *Manager) RequestLater(ctx context.Context, email string, done func(ctx context.Context, user *data.User, err error)) {
+	ctx, cancel := context.WithTimeout(detached{ctx}, SendTimeout)
+	m.pending.Add(1)
+	go func() {
+		defer m.pending.Done()
+		defer cancel()
+		user, err := m.Request(ctx, email)
+		if done != nil {
+			done(ctx, user, err)
+		}
+	}()
+}
+
+// Wait waits for every request RequestLater has started to finish.
+func (m *Manager) Wait() {
+	m.pending.Wait()
+}
+
+// detached keeps the values of the context it wraps, but is never done.
+type detached struct {
+	context.Context
+}
+
+func (detached) Deadline() (time.Time, bool) { return time.Time{}, false }
+func (detached) Done() <-chan struct{}       { return nil }
+func (detached) Err() error                  { return nil }
+
+// Reset sets a new password for the user token was issued to, and returns their id. The token,
+// and any other the user has, is used up with the password change, in one transaction, so that a
+// failed change leaves it working. Every refresh token the user has is revoked along with it, so
+// whoever knew the old password loses any session they got with it. Passwords should be validated
+// before they get here.
+func (m *Manager) Reset(ctx context.Context, token, password string) (int, error) {
+	if !verifyToken(m.Secret, token) {
+		return 0, ErrInvalidToken
+	}
+
+	var userID int
+	err := m.Store.WithTx(ctx, func(repo repository.DatabaseRepo) error {
+		var err error
+		userID, err = repo.UsePasswordReset(ctx, HashToken(token))
+		if errors.Is(err, repository.ErrNotFound) {
+			return ErrInvalidToken
+		}
+		if err != nil {
+			return err
+		}
+		// a user deleted since they asked can't have their password reset either
+		err = repo.ResetPassword(ctx, userID, password)
+		if errors.Is(err, repository.ErrNotFound) {
+			return ErrInvalidToken
+		}
+		if err != nil {
+			return err
+		}
+		return repo.RevokeUserRefreshTokens(ctx, userID)
+	})
+	if err != nil {
+		return 0, err
+	}
+	return userID, nil
+}
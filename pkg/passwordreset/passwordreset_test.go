@@ -0,0 +1,187 @@
+package passwordreset
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"testingCourserWeb/pkg/data"
+	"testingCourserWeb/pkg/mailer"
+	"testingCourserWeb/pkg/repository/dbrepo"
+	"time"
+)
+
+func TestTokens(t *testing.T) {
+	secret := []byte("secret")
+	token, err := newToken(secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, _ := newToken(secret)
+	if token == other {
+		t.Error("expected every token to be different")
+	}
+
+	id, signature, _ := strings.Cut(token, ".")
+	var tests = []struct {
+		name     string
+		secret   []byte
+		token    string
+		expected bool
+	}{
+		{"valid", secret, token, true},
+		{"another secret", []byte("other"), token, false},
+		{"no signature", secret, id, false},
+		{"another id", secret, id + "x." + signature, false},
+		{"mangled signature", secret, id + "." + signature[1:], false},
+		{"empty", secret, "", false},
+	}
+
+	for _, e := range tests {
+		if verifyToken(e.secret, e.token) != e.expected {
+			t.Errorf("%s: expected verified to be %v", e.name, e.expected)
+		}
+	}
+
+	if hash := HashToken(token); len(hash) != 64 || hash == HashToken(other) || strings.Contains(hash, id) {
+		t.Errorf("expected a sha256 hash of the token, but got %s", hash)
+	}
+}
+
+// tokenFrom returns the token in the link of a reset email.
+func tokenFrom(t *testing.T, m mailer.Message) string {
+	t.Helper()
+	for _, line := range strings.Split(m.Body, "\n") {
+		if strings.HasPrefix(line, "http://localhost/password/reset?") {
+			u, _ := url.Parse(line)
+			return u.Query().Get("token")
+		}
+	}
+	t.Fatalf("no link in %q", m.Body)
+	return ""
+}
+
+func TestManager(t *testing.T) {
+	ctx := context.Background()
+	db := dbrepo.NewMemoryDBRepo()
+	id, _ := db.InsertUser(ctx, data.User{FirstName: "Jack", LastName: "Smith", Email: "jack@smith.com", Password: "secret"})
+	deactivated, _ := db.InsertUser(ctx, data.User{FirstName: "Jill", LastName: "Smith", Email: "jill@smith.com", Password: "secret"})
+	_ = db.DeactivateUser(ctx, deactivated)
+
+	// someone who knew the old password is logged in somewhere
+	_, _ = db.InsertRefreshToken(ctx, data.RefreshToken{UserID: id, FamilyID: "stolen", JTI: "stolen", ExpiresAt: time.Now().Add(time.Hour)})
+
+	outbox := &mailer.Outbox{}
+	m := NewManager(db, outbox, []byte("secret"), "http://localhost/password/reset")
+
+	// nothing is sent to emails without an active account
+	for _, email := range []string{"nobody@smith.com", "jill@smith.com"} {
+		user, err := m.Request(ctx, email)
+		if user != nil || err != nil {
+			t.Errorf("%s: expected no user, but got %+v, %v", email, user, err)
+		}
+	}
+	if len(outbox.Messages()) != 0 {
+		t.Fatalf("expected nothing to be sent, but got %+v", outbox.Messages())
+	}
+
+	var tokens []string
+	for i := 0; i < 2; i++ {
+		user, err := m.Request(ctx, "jack@smith.com")
+		if err != nil || user == nil || user.ID != id {
+			t.Fatalf("expected a link to be sent to user %d, but got %+v, %v", id, user, err)
+		}
+		sent, _ := outbox.Last()
+		if sent.To != "jack@smith.com" || !strings.Contains(sent.Body, "within 60 minutes") {
+			t.Errorf("expected a reset link for jack@smith.com, but got %+v", sent)
+		}
+		tokens = append(tokens, tokenFrom(t, sent))
+	}
+
+	forged := strings.Split(tokens[0], ".")[0] + ".forged"
+	var tests = []struct {
+		name        string
+		token       string
+		expectedErr error
+	}{
+		{"forged", forged, ErrInvalidToken},
+		{"the second link", tokens[1], nil},
+		{"the second link again", tokens[1], ErrInvalidToken},
+		{"the first link, used up by the second", tokens[0], ErrInvalidToken},
+	}
+
+	for _, e := range tests {
+		userID, err := m.Reset(ctx, e.token, "new password 1")
+		if !errors.Is(err, e.expectedErr) {
+			t.Errorf("%s: expected %v, but got %v", e.name, e.expectedErr, err)
+		}
+		if err == nil && userID != id {
+			t.Errorf("%s: expected user %d's password to be reset, but got %d", e.name, id, userID)
+		}
+	}
+
+	user, _ := db.GetUser(ctx, id)
+	if ok, _ := user.PasswordMatches("new password 1"); !ok {
+		t.Error("expected the password to have been reset")
+	}
+	if token, _ := db.GetRefreshToken(ctx, "stolen"); !token.Revoked {
+		t.Error("expected the user's refresh tokens to have been revoked")
+	}
+
+	// links stop working once they expire
+	m.Expiry = -time.Minute
+	_, _ = m.Request(ctx, "jack@smith.com")
+	sent, _ := outbox.Last()
+	if _, err := m.Reset(ctx, tokenFrom(t, sent), "new password 2"); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("expected an expired link to be refused, but got %v", err)
+	}
+
+	// a failed reset leaves the link working
+	m.Expiry = DefaultExpiry
+	_, _ = m.Request(ctx, "jack@smith.com")
+	sent, _ = outbox.Last()
+	_ = db.DeleteUser(ctx, id, 0)
+	if _, err := m.Reset(ctx, tokenFrom(t, sent), "new password 2"); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("expected a deleted user's password not to be reset, but got %v", err)
+	}
+	_ = db.RestoreUser(ctx, id)
+	if _, err := m.Reset(ctx, tokenFrom(t, sent), "new password 2"); err != nil {
+		t.Errorf("expected the link to still work, but got %v", err)
+	}
+}
+
+func TestManager_RequestLater(t *testing.T) {
+	db := dbrepo.NewMemoryDBRepo()
+	id, _ := db.InsertUser(context.Background(), data.User{FirstName: "Jack", LastName: "Smith", Email: "jack@smith.com", Password: "secret"})
+	outbox := &mailer.Outbox{}
+	m := NewManager(db, outbox, []byte("secret"), "http://localhost/password/reset")
+
+	// the request is over before the link is sent, which mustn't stop it
+	type key struct{}
+	ctx, cancel := context.WithCancel(context.WithValue(context.Background(), key{}, "request"))
+	var got []int
+	var mu sync.Mutex
+	for _, email := range []string{"jack@smith.com", "nobody@smith.com"} {
+		m.RequestLater(ctx, email, func(ctx context.Context, user *data.User, err error) {
+			if err != nil || ctx.Err() != nil || ctx.Value(key{}) != "request" {
+				t.Errorf("expected a live context with the request's values, and no error, but got %v, %v", ctx.Err(), err)
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			if user != nil {
+				got = append(got, user.ID)
+			}
+		})
+	}
+	cancel()
+	m.Wait()
+
+	if len(got) != 1 || got[0] != id {
+		t.Errorf("expected to be told a link was sent to user %d, but got %v", id, got)
+	}
+	if sent := outbox.Messages(); len(sent) != 1 || sent[0].To != "jack@smith.com" {
+		t.Errorf("expected one link to be sent, to jack@smith.com, but got %+v", sent)
+	}
+}
@@ -0,0 +1,49 @@
+package passwordreset
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"strings"
+)
+
+// tokenSize is how many random bytes a token has, before it is signed.
+const tokenSize = 32
+
+// newToken returns a new token: random bytes, and their HMAC-SHA256 signature with secret, both
+// base64url encoded and joined by a dot.
+func newToken(secret []byte) (string, error) {
+	b := make([]byte, tokenSize)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+
+	id := base64.RawURLEncoding.EncodeToString(b)
+	return id + "." + sign(secret, id), nil
+}
+
+// verifyToken reports whether token was made by newToken with secret, so that tokens which are
+// made up, or mangled in transit, can be turned away without looking them up.
+func verifyToken(secret []byte, token string) bool {
+	id, signature, ok := strings.Cut(token, ".")
+	if !ok {
+		return false
+	}
+	return hmac.Equal([]byte(signature), []byte(sign(secret, id)))
+}
+
+// sign returns the signature of id with secret.
+func sign(secret []byte, id string) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(id))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// HashToken returns the hash of a token which is kept in the database, in place of the token.
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,146 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testingCourserWeb/pkg/data"
+	"testingCourserWeb/pkg/repository"
+	"time"
+)
+
+// Reasons for turning away a login.
+const (
+	ReasonIPLimited      = "too many login attempts from this address"
+	ReasonAccountLimited = "too many login attempts for this account"
+	ReasonAccountLocked  = "account locked after too many failed logins"
+)
+
+// Lockout locks an account out after too many failed logins: after After failures, for Base,
+// doubling with each further failure, up to Max. Failures are forgotten after a successful
+// login, or once there has been none for Reset. The zero Lockout never locks anybody out.
+type Lockout struct {
+	After int
+	Base  time.Duration
+	Max   time.Duration
+	Reset time.Duration
+}
+
+// Duration returns how long to lock an account out for after its nth failure in a row.
+func (l Lockout) Duration(failures int) time.Duration {
+	if l.After <= 0 || failures < l.After {
+		return 0
+	}
+	d := l.Base
+	for i := l.After; i < failures; i++ {
+		d *= 2
+		if l.Max > 0 && d >= l.Max {
+			return l.Max
+		}
+	}
+	if l.Max > 0 && d > l.Max {
+		return l.Max
+	}
+	return d
+}
+
+// FailureStore keeps the failed logins for each account; repository.DatabaseRepo is one.
+type FailureStore interface {
+	GetLoginFailures(ctx context.Context, account string) (*data.LoginFailures, error)
+	RecordLoginFailure(ctx context.Context, account string, resetBefore time.Time) (int, error)
+	LockLogin(ctx context.Context, account string, until time.Time) error
+	ClearLoginFailures(ctx context.Context, account string) error
+}
+
+// Logins guards logins, so that passwords can't be guessed by hammering the login form: each ip
+// address and each account has a token bucket, and accounts are locked out after too many
+// failures in a row. Buckets are in Store, which may be shared by several instances, and failures
+// are in Failures.
+type Logins struct {
+	Store      Store
+	Failures   FailureStore
+	PerIP      Limit
+	PerAccount Limit
+	Lockout    Lockout
+}
+
+// The limits NewLogins guards logins with: bursts of attempts from an address or at an account
+// are fine, but only a few an hour can be kept up, and an account is locked out after five
+// failures in a row, for a minute, then two, and so on up to an hour.
+var (
+	DefaultPerIP      = Limit{Every: 30 * time.Second, Burst: 20}
+	DefaultPerAccount = Limit{Every: 5 * time.Minute, Burst: 10}
+	DefaultLockout    = Lockout{After: 5, Base: time.Minute, Max: time.Hour, Reset: 24 * time.Hour}
+)
+
+// NewLogins returns a guard for logins with the default limits.
+func NewLogins(store Store, failures FailureStore) *Logins {
+	return &Logins{
+		Store:      store,
+		Failures:   failures,
+		PerIP:      DefaultPerIP,
+		PerAccount: DefaultPerAccount,
+		Lockout:    DefaultLockout,
+	}
+}
+
+// Account returns the account an email address logs in to, which we count failures against,
+// whether or not anybody has that email address.
+func Account(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+// Allow checks whether somebody at ip may try to log in to account, which is the email address
+// they gave. It returns a *Denied if not.
+func (g *Logins) Allow(ctx context.Context, ip, account string) error {
+	wait, err := g.Store.Take(ctx, "login:ip:"+ip, g.PerIP)
+	if err != nil {
+		return err
+	}
+	if wait > 0 {
+		return &Denied{Reason: ReasonIPLimited, RetryAfter: wait}
+	}
+
+	account = Account(account)
+	f, err := g.Failures.GetLoginFailures(ctx, account)
+	if err != nil && !errors.Is(err, repository.ErrNotFound) {
+		return err
+	}
+	if now := time.Now(); f != nil && f.IsLocked(now) {
+		return &Denied{Reason: ReasonAccountLocked, RetryAfter: f.LockedUntil.Sub(now)}
+	}
+
+	wait, err = g.Store.Take(ctx, "login:account:"+account, g.PerAccount)
+	if err != nil {
+		return err
+	}
+	if wait > 0 {
+		return &Denied{Reason: ReasonAccountLimited, RetryAfter: wait}
+	}
+	return nil
+}
+
+// Failed counts a failed login to account, locking it out if there have been too many.
+func (g *Logins) Failed(ctx context.Context, account string) error {
+	account = Account(account)
+	now := time.Now()
+
+	resetBefore := time.Time{}
+	if g.Lockout.Reset > 0 {
+		resetBefore = now.Add(-g.Lockout.Reset)
+	}
+	failures, err := g.Failures.RecordLoginFailure(ctx, account, resetBefore)
+	if err != nil {
+		return err
+	}
+
+	if d := g.Lockout.Duration(failures); d > 0 {
+		return g.Failures.LockLogin(ctx, account, now.Add(d))
+	}
+	return nil
+}
+
+// Succeeded forgets the failed logins to account.
+func (g *Logins) Succeeded(ctx context.Context, account string) error {
+	return g.Failures.ClearLoginFailures(ctx, Account(account))
+}
@@ -0,0 +1,132 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"testingCourserWeb/pkg/data"
+	"testingCourserWeb/pkg/repository"
+	"time"
+)
+
+func TestLockout_Duration(t *testing.T) {
+	lockout := Lockout{After: 3, Base: time.Minute, Max: time.Hour}
+
+	var tests = []struct {
+		failures int
+		expected time.Duration
+	}{
+		{1, 0},
+		{2, 0},
+		{3, time.Minute},
+		{4, 2 * time.Minute},
+		{5, 4 * time.Minute},
+		{9, time.Hour},
+		{100, time.Hour},
+	}
+
+	for _, e := range tests {
+		if got := lockout.Duration(e.failures); got != e.expected {
+			t.Errorf("%d failures: expected %s, but got %s", e.failures, e.expected, got)
+		}
+	}
+
+	if got := (Lockout{}).Duration(100); got != 0 {
+		t.Errorf("expected the zero lockout never to lock, but got %s", got)
+	}
+}
+
+// memoryFailures is a FailureStore; the repositories have their own tests.
+type memoryFailures map[string]*data.LoginFailures
+
+func (m memoryFailures) GetLoginFailures(ctx context.Context, account string) (*data.LoginFailures, error) {
+	f, ok := m[account]
+	if !ok {
+		return nil, repository.ErrNotFound
+	}
+	return f, nil
+}
+
+func (m memoryFailures) RecordLoginFailure(ctx context.Context, account string, resetBefore time.Time) (int, error) {
+	f, ok := m[account]
+	if !ok || f.LastFailureAt.Before(resetBefore) {
+		f = &data.LoginFailures{Account: account}
+		m[account] = f
+	}
+	f.Failures++
+	f.LastFailureAt = time.Now()
+	return f.Failures, nil
+}
+
+func (m memoryFailures) LockLogin(ctx context.Context, account string, until time.Time) error {
+	m[account].LockedUntil = &until
+	return nil
+}
+
+func (m memoryFailures) ClearLoginFailures(ctx context.Context, account string) error {
+	delete(m, account)
+	return nil
+}
+
+func TestLogins(t *testing.T) {
+	ctx := context.Background()
+	failures := memoryFailures{}
+	g := &Logins{
+		Store:      NewMemoryStore(),
+		Failures:   failures,
+		PerIP:      Limit{Every: time.Hour, Burst: 10},
+		PerAccount: Limit{Every: time.Hour, Burst: 5},
+		Lockout:    Lockout{After: 2, Base: time.Minute, Max: time.Hour, Reset: time.Hour},
+	}
+
+	denied := func(err error) string {
+		var d *Denied
+		if errors.As(err, &d) {
+			return d.Reason
+		}
+		if err != nil {
+			return err.Error()
+		}
+		return ""
+	}
+
+	// emails are normalised, so these are all the same account
+	if reason := denied(g.Allow(ctx, "10.0.0.1", "Jack@Smith.com ")); reason != "" {
+		t.Fatalf("expected the first attempt to be allowed, but got %s", reason)
+	}
+	_ = g.Failed(ctx, "jack@smith.com")
+	if reason := denied(g.Allow(ctx, "10.0.0.1", "jack@smith.com")); reason != "" {
+		t.Fatalf("expected the second attempt to be allowed, but got %s", reason)
+	}
+	_ = g.Failed(ctx, "JACK@smith.com")
+
+	err := g.Allow(ctx, "10.0.0.2", "jack@smith.com")
+	var d *Denied
+	if !errors.As(err, &d) || d.Reason != ReasonAccountLocked || d.RetryAfter <= 0 || d.RetryAfter > time.Minute {
+		t.Fatalf("expected the account to be locked for a minute, but got %v", err)
+	}
+
+	// a successful login unlocks it
+	_ = g.Succeeded(ctx, "jack@smith.com")
+	if reason := denied(g.Allow(ctx, "10.0.0.2", "jack@smith.com")); reason != "" {
+		t.Errorf("expected the account to be unlocked, but got %s", reason)
+	}
+
+	// the locked out attempt took no token from the account's bucket, so it has had three taken
+	for i := 4; i <= 5; i++ {
+		if reason := denied(g.Allow(ctx, "10.0.0.1", "jack@smith.com")); reason != "" {
+			t.Errorf("expected attempt %d to be allowed, but got %s", i, reason)
+		}
+	}
+	if reason := denied(g.Allow(ctx, "10.0.0.1", "jack@smith.com")); reason != ReasonAccountLimited {
+		t.Errorf("expected the account to be limited, but got %q", reason)
+	}
+
+	// five attempts from 10.0.0.1 so far
+	for i := 0; i < 5; i++ {
+		_ = g.Allow(ctx, "10.0.0.1", "jill@smith.com")
+	}
+	if reason := denied(g.Allow(ctx, "10.0.0.1", "bob@smith.com")); reason != ReasonIPLimited {
+		t.Errorf("expected the ip to be limited, but got %q", reason)
+	}
+}
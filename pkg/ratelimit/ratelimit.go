@@ -0,0 +1,161 @@
+// Package ratelimit limits how often something may be done, with a token bucket for each key,
+// e.g. an ip address, and guards logins with those buckets and a lockout for each account.
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+)
+
+// Limit is a token bucket: it holds up to Burst tokens, one more is added every Every, and each
+// request takes one. The zero Limit allows everything.
+type Limit struct {
+	Every time.Duration
+	Burst int
+}
+
+// Unlimited reports whether l allows everything.
+func (l Limit) Unlimited() bool {
+	return l.Every <= 0
+}
+
+// Tolerance is how far into the future a bucket's theoretical arrival time may be while it
+// still holds a token.
+//
+// Buckets are kept as a theoretical arrival time (TAT), the time at which the bucket will be full
+// again, rather than as a count of tokens, which needs refilling: taking a token moves the TAT on
+// by Every, and a bucket is empty when its TAT is more than Burst-1 tokens' worth ahead of now.
+func (l Limit) Tolerance() time.Duration {
+	burst := l.Burst
+	if burst < 1 {
+		burst = 1
+	}
+	return l.Every * time.Duration(burst-1)
+}
+
+// Take takes a token from a bucket whose TAT is tat, at now. It returns the new TAT, and how long
+// to wait before trying again if the bucket is empty, in which case no token is taken and the TAT
+// is unchanged.
+func (l Limit) Take(tat, now time.Time) (time.Time, time.Duration) {
+	if tat.Before(now) {
+		tat = now
+	}
+	if wait := tat.Sub(now) - l.Tolerance(); wait > 0 {
+		return tat, wait
+	}
+	return tat.Add(l.Every), 0
+}
+
+// Store keeps token buckets. Take takes a token from the bucket for key, and returns how long
+// to wait before trying again if it is empty, or 0 if the token was taken.
+type Store interface {
+	Take(ctx context.Context, key string, l Limit) (time.Duration, error)
+}
+
+// pruneEvery is how many requests a MemoryStore counts between looking for buckets and windows
+// it can drop.
+const pruneEvery = 1000
+
+// MemoryStore keeps token buckets and windows in memory, for a single instance.
+type MemoryStore struct {
+	mu      sync.Mutex
+	tats    map[string]time.Time
+	windows map[string]memoryWindow
+	hits    int
+	// now is time.Now, except in tests
+	now func() time.Time
+}
+
+// memoryWindow is the count for a key's current window, and the one before.
+type memoryWindow struct {
+	start    time.Time
+	period   time.Duration
+	current  int
+	previous int
+}
+
+// NewMemoryStore returns an empty MemoryStore.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		tats:    make(map[string]time.Time),
+		windows: make(map[string]memoryWindow),
+		now:     time.Now,
+	}
+}
+
+// Take takes a token from the bucket for key.
+func (s *MemoryStore) Take(ctx context.Context, key string, l Limit) (time.Duration, error) {
+	if l.Unlimited() {
+		return 0, nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	tat, wait := l.Take(s.tats[key], now)
+	s.tats[key] = tat
+	s.counted(now)
+	return wait, nil
+}
+
+// Hit counts a request for key in the window starting at start.
+func (s *MemoryStore) Hit(ctx context.Context, key string, start time.Time, period time.Duration) (int, int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	w := s.windows[key]
+	switch {
+	case w.start.Equal(start):
+		w.current++
+	case w.start.Add(period).Equal(start):
+		w.previous, w.current = w.current, 1
+	default:
+		w.previous, w.current = 0, 1
+	}
+	w.start, w.period = start, period
+	s.windows[key] = w
+
+	s.counted(s.now())
+	return w.current, w.previous, nil
+}
+
+// counted counts a request, and now and then forgets the buckets which are full, and the windows
+// which are too old to count, which are the same as none at all. The caller must hold the lock.
+func (s *MemoryStore) counted(now time.Time) {
+	s.hits++
+	if s.hits < pruneEvery {
+		return
+	}
+	s.hits = 0
+
+	for k, t := range s.tats {
+		if !t.After(now) {
+			delete(s.tats, k)
+		}
+	}
+	for k, w := range s.windows {
+		if !w.start.Add(2 * w.period).After(now) {
+			delete(s.windows, k)
+		}
+	}
+}
+
+// Denied is the error returned when a request is turned away, saying why, and how long to wait
+// before trying again.
+type Denied struct {
+	Reason     string
+	RetryAfter time.Duration
+}
+
+func (d *Denied) Error() string {
+	return fmt.Sprintf("%s; retry after %s", d.Reason, d.RetryAfter)
+}
+
+// RetryAfterSeconds is RetryAfter in whole seconds, rounded up, for a Retry-After header.
+func (d *Denied) RetryAfterSeconds() int {
+	return int(math.Max(1, math.Ceil(d.RetryAfter.Seconds())))
+}
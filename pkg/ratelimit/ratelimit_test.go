@@ -0,0 +1,93 @@
+package ratelimit
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestLimit_Take(t *testing.T) {
+	limit := Limit{Every: time.Second, Burst: 3}
+	now := time.Now()
+	var tat time.Time
+
+	var tests = []struct {
+		name         string
+		after        time.Duration
+		expectedWait time.Duration
+	}{
+		{"full bucket", 0, 0},
+		{"second token", 0, 0},
+		{"last token", 0, 0},
+		{"empty", 0, time.Second},
+		{"still empty", 500 * time.Millisecond, 500 * time.Millisecond},
+		{"refilled one", 500 * time.Millisecond, 0},
+		{"empty again", 0, time.Second},
+		{"refilled completely", time.Hour, 0},
+		{"then two more", 0, 0},
+		{"and another", 0, 0},
+		{"but no more", 0, time.Second},
+	}
+
+	for _, e := range tests {
+		now = now.Add(e.after)
+		var wait time.Duration
+		tat, wait = limit.Take(tat, now)
+		if wait != e.expectedWait {
+			t.Errorf("%s: expected to wait %s, but got %s", e.name, e.expectedWait, wait)
+		}
+	}
+}
+
+func TestMemoryStore_Take(t *testing.T) {
+	s := NewMemoryStore()
+	now := time.Now()
+	s.now = func() time.Time { return now }
+	ctx := context.Background()
+	limit := Limit{Every: time.Minute, Burst: 2}
+
+	for i, expected := range []time.Duration{0, 0, time.Minute} {
+		wait, err := s.Take(ctx, "a", limit)
+		if err != nil || wait != expected {
+			t.Errorf("take %d: expected to wait %s, but got %s, %v", i+1, expected, wait, err)
+		}
+	}
+	if wait, _ := s.Take(ctx, "b", limit); wait != 0 {
+		t.Errorf("expected another key to have its own bucket, but was told to wait %s", wait)
+	}
+	if wait, _ := s.Take(ctx, "a", Limit{}); wait != 0 {
+		t.Errorf("expected no limit to allow everything, but was told to wait %s", wait)
+	}
+
+	// buckets which have refilled are dropped, eventually
+	now = now.Add(time.Hour)
+	for i := 0; i < pruneEvery; i++ {
+		_, _ = s.Take(ctx, "c", Limit{Every: time.Nanosecond})
+	}
+	now = now.Add(time.Second)
+	for i := 0; i < pruneEvery; i++ {
+		_, _ = s.Take(ctx, "d", Limit{Every: time.Nanosecond})
+	}
+	if len(s.tats) != 1 {
+		t.Errorf("expected only the last bucket to be kept, but got %v", s.tats)
+	}
+}
+
+func TestDenied_RetryAfterSeconds(t *testing.T) {
+	var tests = []struct {
+		retryAfter time.Duration
+		expected   int
+	}{
+		{time.Millisecond, 1},
+		{time.Second, 1},
+		{1500 * time.Millisecond, 2},
+		{time.Hour, 3600},
+	}
+
+	for _, e := range tests {
+		d := &Denied{RetryAfter: e.retryAfter}
+		if got := d.RetryAfterSeconds(); got != e.expected {
+			t.Errorf("%s: expected %d seconds, but got %d", e.retryAfter, e.expected, got)
+		}
+	}
+}
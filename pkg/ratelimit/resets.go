@@ -0,0 +1,58 @@
+package ratelimit
+
+import (
+	"context"
+	"time"
+)
+
+// Reasons for turning away a request for a password reset link.
+const (
+	ReasonResetIPLimited      = "too many password reset requests from this address"
+	ReasonResetAccountLimited = "too many password reset requests for this account"
+)
+
+// Resets guards requests for password reset links, so that they can't be used to flood an inbox:
+// each ip address and each account has a token bucket. The buckets are separate from the ones
+// Logins keeps, so asking for links to somebody's account never uses up their login attempts.
+type Resets struct {
+	Store      Store
+	PerIP      Limit
+	PerAccount Limit
+}
+
+// The limits NewResets guards reset requests with: an address can ask for a few links at once,
+// and a handful an hour after that, and an account gets a few links an hour.
+var (
+	DefaultResetsPerIP      = Limit{Every: 5 * time.Minute, Burst: 10}
+	DefaultResetsPerAccount = Limit{Every: 20 * time.Minute, Burst: 3}
+)
+
+// NewResets returns a guard for reset requests with the default limits.
+func NewResets(store Store) *Resets {
+	return &Resets{
+		Store:      store,
+		PerIP:      DefaultResetsPerIP,
+		PerAccount: DefaultResetsPerAccount,
+	}
+}
+
+// Allow checks whether somebody at ip may ask for a reset link for account, which is the email
+// address they gave. It returns a *Denied if not.
+func (g *Resets) Allow(ctx context.Context, ip, account string) error {
+	wait, err := g.Store.Take(ctx, "reset:ip:"+ip, g.PerIP)
+	if err != nil {
+		return err
+	}
+	if wait > 0 {
+		return &Denied{Reason: ReasonResetIPLimited, RetryAfter: wait}
+	}
+
+	wait, err = g.Store.Take(ctx, "reset:account:"+Account(account), g.PerAccount)
+	if err != nil {
+		return err
+	}
+	if wait > 0 {
+		return &Denied{Reason: ReasonResetAccountLimited, RetryAfter: wait}
+	}
+	return nil
+}
@@ -0,0 +1,54 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestResets(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore()
+	g := &Resets{
+		Store:      store,
+		PerIP:      Limit{Every: time.Hour, Burst: 3},
+		PerAccount: Limit{Every: time.Hour, Burst: 2},
+	}
+	logins := &Logins{Store: store, Failures: memoryFailures{}, PerIP: Limit{Every: time.Hour, Burst: 1}, PerAccount: Limit{Every: time.Hour, Burst: 1}}
+
+	reason := func(err error) string {
+		var d *Denied
+		if errors.As(err, &d) {
+			return d.Reason
+		}
+		if err != nil {
+			return err.Error()
+		}
+		return ""
+	}
+
+	var tests = []struct {
+		name           string
+		ip             string
+		account        string
+		expectedReason string
+	}{
+		{"first", "10.0.0.1", "jack@smith.com", ""},
+		{"second, from elsewhere", "10.0.0.2", "Jack@Smith.com", ""},
+		{"third", "10.0.0.1", "jack@smith.com", ReasonResetAccountLimited},
+		{"another account", "10.0.0.1", "jill@smith.com", ""},
+		{"another account, over the address's limit", "10.0.0.1", "bob@smith.com", ReasonResetIPLimited},
+	}
+
+	for _, e := range tests {
+		if got := reason(g.Allow(ctx, e.ip, e.account)); got != e.expectedReason {
+			t.Errorf("%s: expected %q, but got %q", e.name, e.expectedReason, got)
+		}
+	}
+
+	// none of that used up jack's login attempts, nor the address's
+	if got := reason(logins.Allow(ctx, "10.0.0.1", "jack@smith.com")); got != "" {
+		t.Errorf("expected jack to still be able to log in, but got %q", got)
+	}
+}
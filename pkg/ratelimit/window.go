@@ -0,0 +1,142 @@
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Window is a sliding window limit: at most Limit requests in any Period. The zero Window allows
+// everything.
+//
+// Requests are counted in fixed windows of Period, and the count for the sliding window ending
+// now is estimated from the current fixed window and the one before it, weighting the previous
+// one by how much of it the sliding window still covers. That needs two counters per key, rather
+// than a timestamp for every request, and is exact when requests are spread evenly.
+type Window struct {
+	Limit  int
+	Period time.Duration
+}
+
+// Unlimited reports whether w allows everything.
+func (w Window) Unlimited() bool {
+	return w.Limit <= 0 || w.Period <= 0
+}
+
+// ParseWindow reads a window written like 300/1m: a limit, and a period as time.ParseDuration
+// reads them. "off" is no limit.
+func ParseWindow(s string) (Window, error) {
+	if s == "off" {
+		return Window{}, nil
+	}
+	limit, period, ok := strings.Cut(s, "/")
+	if !ok {
+		return Window{}, fmt.Errorf("rate limit %q should look like 300/1m", s)
+	}
+	w := Window{}
+	var err error
+	if w.Limit, err = strconv.Atoi(limit); err != nil || w.Limit < 1 {
+		return Window{}, fmt.Errorf("rate limit %q should start with a number of requests", s)
+	}
+	if w.Period, err = time.ParseDuration(period); err != nil || w.Period <= 0 {
+		return Window{}, fmt.Errorf("rate limit %q should end with a period, like 1m", s)
+	}
+	return w, nil
+}
+
+// String returns w the way ParseWindow reads it.
+func (w Window) String() string {
+	if w.Unlimited() {
+		return "off"
+	}
+	return fmt.Sprintf("%d/%s", w.Limit, w.Period)
+}
+
+// MarshalText and UnmarshalText let a Window be a flag.TextVar.
+func (w Window) MarshalText() ([]byte, error) {
+	return []byte(w.String()), nil
+}
+
+func (w *Window) UnmarshalText(text []byte) error {
+	parsed, err := ParseWindow(string(text))
+	if err != nil {
+		return err
+	}
+	*w = parsed
+	return nil
+}
+
+// WindowStore counts requests in fixed windows. Hit counts a request for key in the window of
+// period starting at start, and returns the count for that window, and for the one before it.
+type WindowStore interface {
+	Hit(ctx context.Context, key string, start time.Time, period time.Duration) (current, previous int, err error)
+}
+
+// Backend keeps both token buckets and windows; MemoryStore is one.
+type Backend interface {
+	Store
+	WindowStore
+}
+
+// Result is what a Window made of a request. Remaining is how many more requests would be
+// allowed now. Reset is how long until the client may make another request if it has none
+// remaining, and otherwise how long until the current fixed window ends, and the requests in
+// it begin to count for less.
+type Result struct {
+	Allowed   bool
+	Remaining int
+	Reset     time.Duration
+}
+
+// Hit counts a request for key at now in store, and says whether it is allowed. Requests which
+// are turned away are counted too, so a client which keeps trying stays limited.
+func (w Window) Hit(ctx context.Context, store WindowStore, key string, now time.Time) (Result, error) {
+	if w.Unlimited() {
+		return Result{Allowed: true, Remaining: math.MaxInt}, nil
+	}
+
+	start := now.Truncate(w.Period)
+	current, previous, err := store.Hit(ctx, key, start, w.Period)
+	if err != nil {
+		return Result{}, err
+	}
+
+	elapsed := now.Sub(start)
+	estimate := w.estimate(current, previous, elapsed)
+	res := Result{
+		Allowed:   estimate <= float64(w.Limit),
+		Remaining: int(math.Max(0, math.Floor(float64(w.Limit)-estimate))),
+		Reset:     w.Period - elapsed,
+	}
+	if res.Remaining == 0 {
+		res.Reset = w.wait(current, previous, elapsed)
+	}
+	return res, nil
+}
+
+// estimate is the number of requests in the sliding window ending elapsed into the current
+// fixed window.
+func (w Window) estimate(current, previous int, elapsed time.Duration) float64 {
+	weight := 1 - float64(elapsed)/float64(w.Period)
+	return float64(previous)*weight + float64(current)
+}
+
+// wait returns how long until one more request would be allowed, elapsed into the current
+// fixed window.
+func (w Window) wait(current, previous int, elapsed time.Duration) time.Duration {
+	period := float64(w.Period)
+	if current+1 <= w.Limit {
+		// the previous window has to count for less
+		if previous == 0 {
+			return 0
+		}
+		t := period*(1-float64(w.Limit-current-1)/float64(previous)) - float64(elapsed)
+		return time.Duration(math.Max(0, math.Ceil(t)))
+	}
+	// the current window is full on its own, so wait for the next, and for this one to count for less
+	t := period - float64(elapsed) + period*(1-float64(w.Limit-1)/float64(current))
+	return time.Duration(math.Ceil(t))
+}
@@ -0,0 +1,97 @@
+package ratelimit
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestParseWindow(t *testing.T) {
+	var tests = []struct {
+		text          string
+		expected      Window
+		errorExpected bool
+	}{
+		{"300/1m", Window{Limit: 300, Period: time.Minute}, false},
+		{"10/1h30m", Window{Limit: 10, Period: 90 * time.Minute}, false},
+		{"off", Window{}, false},
+		{"300", Window{}, true},
+		{"lots/1m", Window{}, true},
+		{"0/1m", Window{}, true},
+		{"300/minute", Window{}, true},
+		{"300/-1m", Window{}, true},
+	}
+
+	for _, e := range tests {
+		var w Window
+		err := w.UnmarshalText([]byte(e.text))
+		if err != nil && !e.errorExpected {
+			t.Errorf("%s: did not expect error, but got one - %s", e.text, err)
+		}
+		if err == nil && e.errorExpected {
+			t.Errorf("%s: expected error, but did not get one", e.text)
+		}
+		if w != e.expected {
+			t.Errorf("%s: expected %v, but got %v", e.text, e.expected, w)
+		}
+		if err == nil {
+			if again, _ := ParseWindow(w.String()); again != w {
+				t.Errorf("%s: expected %s to read back the same, but got %v", e.text, w, again)
+			}
+		}
+	}
+}
+
+func TestWindow_Hit(t *testing.T) {
+	store := NewMemoryStore()
+	ctx := context.Background()
+	w := Window{Limit: 4, Period: time.Minute}
+	start := time.Now().Truncate(time.Minute)
+
+	var tests = []struct {
+		name              string
+		at                time.Duration
+		expectedAllowed   bool
+		expectedRemaining int
+		expectedReset     time.Duration
+	}{
+		{"first", 0, true, 3, time.Minute},
+		{"second", 10 * time.Second, true, 2, 50 * time.Second},
+		{"third", 20 * time.Second, true, 1, 40 * time.Second},
+		// the window is full, until its four requests count for three: a quarter into the next one
+		{"fourth", 30 * time.Second, true, 0, 45 * time.Second},
+		// requests turned away count too, so now it's until five count for three
+		{"fifth, turned away", 40 * time.Second, false, 0, 44 * time.Second},
+		// at 75s the five in the last window count for 3.75, and this one makes 4.75
+		{"too soon in the next window", 75 * time.Second, false, 0, 0},
+		// at 105s they count for 1.25, with two in this window; at 108s they count for 1
+		{"later in the next window", 105 * time.Second, true, 0, 3 * time.Second},
+		{"an hour later", time.Hour, true, 3, time.Minute},
+	}
+
+	for _, e := range tests {
+		res, err := w.Hit(ctx, store, "key", start.Add(e.at))
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %s", e.name, err)
+		}
+		if res.Allowed != e.expectedAllowed || res.Remaining != e.expectedRemaining {
+			t.Errorf("%s: expected allowed %t with %d remaining, but got %+v", e.name, e.expectedAllowed, e.expectedRemaining, res)
+		}
+		if e.expectedReset != 0 && res.Reset != e.expectedReset {
+			t.Errorf("%s: expected to reset in %s, but got %s", e.name, e.expectedReset, res.Reset)
+		}
+	}
+
+	// other keys have their own windows
+	res, _ := w.Hit(ctx, store, "other", start.Add(40*time.Second))
+	if !res.Allowed || res.Remaining != 3 {
+		t.Errorf("expected another key to have its own window, but got %+v", res)
+	}
+
+	// the zero window allows everything
+	for i := 0; i < 10; i++ {
+		if res, _ := (Window{}).Hit(ctx, store, "key", start); !res.Allowed {
+			t.Fatal("expected no limit to allow everything")
+		}
+	}
+}
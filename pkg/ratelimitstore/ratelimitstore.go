@@ -0,0 +1,50 @@
+// Package ratelimitstore picks where the rate limits of the api and web apps are kept. It is
+// apart from ratelimit, because keeping them in the database needs dbrepo, which needs ratelimit.
+package ratelimitstore
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"testingCourserWeb/pkg/ratelimit"
+	"testingCourserWeb/pkg/repository"
+	"testingCourserWeb/pkg/repository/dbrepo"
+	"time"
+)
+
+// The places rate limit buckets may be kept.
+const (
+	Memory   = "memory"
+	Database = "database"
+)
+
+// pruneInterval is how often the full buckets are deleted from a database store.
+const pruneInterval = time.Hour
+
+// Open returns where to keep rate limits: in memory, for a single instance, or in db, which
+// only Postgres can do, to share them between instances. driver is db's, for the error if it
+// can't. Limits kept in Postgres are pruned every pruneInterval.
+func Open(kind string, db repository.DatabaseRepo, driver string) (ratelimit.Backend, error) {
+	switch kind {
+	case Memory:
+		return ratelimit.NewMemoryStore(), nil
+	case Database:
+		pg, ok := db.(*dbrepo.PostgresDBRepo)
+		if !ok {
+			return nil, fmt.Errorf("rate limits can only be kept in a postgres database, not %s", driver)
+		}
+		go prune(pg, pruneInterval)
+		return pg, nil
+	}
+	return nil, fmt.Errorf("unknown rate limit store %s", kind)
+}
+
+// prune deletes the full rate limit buckets in repo every interval.
+func prune(repo *dbrepo.PostgresDBRepo, interval time.Duration) {
+	for range time.Tick(interval) {
+		_, err := repo.PruneRateLimits(context.Background())
+		if err != nil {
+			log.Println("Error pruning rate limits:", err)
+		}
+	}
+}
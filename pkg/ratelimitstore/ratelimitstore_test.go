@@ -0,0 +1,29 @@
+package ratelimitstore
+
+import (
+	"testing"
+	"testingCourserWeb/pkg/ratelimit"
+	"testingCourserWeb/pkg/repository/dbrepo"
+)
+
+func TestOpen(t *testing.T) {
+	var tests = []struct {
+		name          string
+		kind          string
+		expectedError bool
+	}{
+		{"memory", Memory, false},
+		{"database, but not postgres", Database, true},
+		{"unknown", "redis", true},
+	}
+
+	for _, e := range tests {
+		store, err := Open(e.kind, &dbrepo.SQLiteDBRepo{}, dbrepo.SQLite)
+		if (err != nil) != e.expectedError {
+			t.Errorf("%s: expected error to be %v, but got %v", e.name, e.expectedError, err)
+		}
+		if _, ok := store.(*ratelimit.MemoryStore); !e.expectedError && !ok {
+			t.Errorf("%s: expected a memory store, but got %T", e.name, store)
+		}
+	}
+}
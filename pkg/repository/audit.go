@@ -0,0 +1,70 @@
+package repository
+
+import (
+	"errors"
+	"fmt"
+	"testingCourserWeb/pkg/data"
+	"time"
+)
+
+// The page sizes ListAuditEvents accepts. Exports read the log a page of MaxAuditLimit at a time.
+const (
+	DefaultAuditLimit = 50
+	MaxAuditLimit     = 1000
+)
+
+// AuditFilter picks the audit events ListAuditEvents returns, newest first. Events are paged by
+// id: Before is the NextBefore of the previous page, and only events older than it are returned.
+type AuditFilter struct {
+	Event  string
+	UserID *int
+	Email  string
+	IP     string
+	// Since and Until keep events at or after Since, and before Until
+	Since  *time.Time
+	Until  *time.Time
+	Before int
+	Limit  int
+}
+
+// Normalize fills in defaults, and checks the filter is usable.
+func (f *AuditFilter) Normalize() error {
+	if f.Limit == 0 {
+		f.Limit = DefaultAuditLimit
+	}
+	if f.Limit < 1 || f.Limit > MaxAuditLimit {
+		return fmt.Errorf("limit must be between 1 and %d", MaxAuditLimit)
+	}
+	if f.Before < 0 {
+		return errors.New("before must be an event id")
+	}
+
+	if f.Event != "" {
+		for _, e := range data.AuditEvents {
+			if e == f.Event {
+				return nil
+			}
+		}
+		return fmt.Errorf("unknown event %s", f.Event)
+	}
+	return nil
+}
+
+// AuditPage describes a page of audit events.
+type AuditPage struct {
+	Limit      int  `json:"limit"`
+	NextBefore int  `json:"next_before,omitempty"`
+	HasMore    bool `json:"has_more"`
+}
+
+// NewAuditPage returns the page for events, which were fetched with f. Like NewPage, it expects
+// one more event than the limit if there is another page, and drops it.
+func NewAuditPage(events []*data.AuditEvent, f AuditFilter) ([]*data.AuditEvent, AuditPage) {
+	page := AuditPage{Limit: f.Limit}
+	if len(events) > f.Limit {
+		events = events[:f.Limit]
+		page.HasMore = true
+		page.NextBefore = events[len(events)-1].ID
+	}
+	return events, page
+}
@@ -0,0 +1,51 @@
+package repository
+
+import (
+	"testing"
+	"testingCourserWeb/pkg/data"
+)
+
+func TestAuditFilter_Normalize(t *testing.T) {
+	var tests = []struct {
+		name          string
+		filter        AuditFilter
+		errorExpected bool
+	}{
+		{"defaults", AuditFilter{}, false},
+		{"valid", AuditFilter{Event: data.AuditLoginFailed, IP: "127.0.0.1", Before: 10, Limit: MaxAuditLimit}, false},
+		{"negative limit", AuditFilter{Limit: -1}, true},
+		{"limit too big", AuditFilter{Limit: MaxAuditLimit + 1}, true},
+		{"negative before", AuditFilter{Before: -1}, true},
+		{"unknown event", AuditFilter{Event: "login"}, true},
+	}
+
+	for _, e := range tests {
+		err := e.filter.Normalize()
+		if err != nil && !e.errorExpected {
+			t.Errorf("%s: did not expect error, but got one - %s", e.name, err)
+		}
+		if err == nil && e.errorExpected {
+			t.Errorf("%s: expected error, but did not get one", e.name)
+		}
+	}
+
+	f := AuditFilter{}
+	_ = f.Normalize()
+	if f.Limit != DefaultAuditLimit {
+		t.Errorf("expected the default limit, but got %d", f.Limit)
+	}
+}
+
+func TestNewAuditPage(t *testing.T) {
+	events := []*data.AuditEvent{{ID: 9}, {ID: 7}, {ID: 4}}
+
+	got, page := NewAuditPage(events, AuditFilter{Limit: 2})
+	if len(got) != 2 || !page.HasMore || page.NextBefore != 7 {
+		t.Errorf("expected 2 events, and the next page before 7, but got %d events and %+v", len(got), page)
+	}
+
+	got, page = NewAuditPage(events, AuditFilter{Limit: 3})
+	if len(got) != 3 || page.HasMore || page.NextBefore != 0 {
+		t.Errorf("expected 3 events, and no more, but got %d events and %+v", len(got), page)
+	}
+}
@@ -0,0 +1,51 @@
+package dbrepo
+
+import (
+	"context"
+	"strings"
+	"testingCourserWeb/pkg/data"
+	"testingCourserWeb/pkg/repository"
+)
+
+// InsertAuditEvent adds an event to the security audit log, and returns its id.
+func (m *MemoryDBRepo) InsertAuditEvent(ctx context.Context, e data.AuditEvent) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	e.ID = m.state.nextID("audit_events")
+	if e.CreatedAt.IsZero() {
+		e.CreatedAt = memoryNow()
+	}
+	m.state.auditEvents = append(m.state.auditEvents, e)
+	return e.ID, nil
+}
+
+// ListAuditEvents returns one page of the audit log, newest first, like
+// PostgresDBRepo.ListAuditEvents.
+func (m *MemoryDBRepo) ListAuditEvents(ctx context.Context, f repository.AuditFilter) ([]*data.AuditEvent, repository.AuditPage, error) {
+	if err := f.Normalize(); err != nil {
+		return nil, repository.AuditPage{}, err
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var events []*data.AuditEvent
+	for i := len(m.state.auditEvents) - 1; i >= 0 && len(events) <= f.Limit; i-- {
+		e := m.state.auditEvents[i]
+		switch {
+		case f.Event != "" && e.Event != f.Event,
+			f.UserID != nil && (e.UserID == nil || *e.UserID != *f.UserID),
+			f.Email != "" && !strings.EqualFold(e.Email, f.Email),
+			f.IP != "" && e.IP != f.IP,
+			f.Since != nil && e.CreatedAt.Before(*f.Since),
+			f.Until != nil && !e.CreatedAt.Before(*f.Until),
+			f.Before != 0 && e.ID >= f.Before:
+			continue
+		}
+		events = append(events, &e)
+	}
+
+	events, page := repository.NewAuditPage(events, f)
+	return events, page, nil
+}
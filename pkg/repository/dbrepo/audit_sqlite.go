@@ -0,0 +1,121 @@
+package dbrepo
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testingCourserWeb/pkg/data"
+	"testingCourserWeb/pkg/repository"
+	"time"
+)
+
+// InsertAuditEvent adds an event to the security audit log, and returns its id.
+func (m *SQLiteDBRepo) InsertAuditEvent(ctx context.Context, e data.AuditEvent) (int, error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	if e.CreatedAt.IsZero() {
+		e.CreatedAt = time.Now()
+	}
+
+	var newID int
+	stmt := `insert into audit_events (event, user_id, email, reason, ip, user_agent, request_id, created_at)
+		values ($1, $2, $3, $4, $5, $6, $7, $8) returning id`
+
+	err := m.db().QueryRowContext(ctx, stmt,
+		e.Event,
+		e.UserID,
+		e.Email,
+		e.Reason,
+		e.IP,
+		e.UserAgent,
+		e.RequestID,
+		sqliteTime(e.CreatedAt),
+	).Scan(&newID)
+
+	if err != nil {
+		return 0, translateSQLiteError(err)
+	}
+
+	return newID, nil
+}
+
+// ListAuditEvents returns one page of the audit log, newest first, like
+// PostgresDBRepo.ListAuditEvents. Times are compared as the text we store them as.
+func (m *SQLiteDBRepo) ListAuditEvents(ctx context.Context, f repository.AuditFilter) ([]*data.AuditEvent, repository.AuditPage, error) {
+	if err := f.Normalize(); err != nil {
+		return nil, repository.AuditPage{}, err
+	}
+
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	var args []any
+	arg := func(v any) string {
+		args = append(args, v)
+		return fmt.Sprintf("$%d", len(args))
+	}
+
+	where := []string{"true"}
+	if f.Event != "" {
+		where = append(where, "event = "+arg(f.Event))
+	}
+	if f.UserID != nil {
+		where = append(where, "user_id = "+arg(*f.UserID))
+	}
+	if f.Email != "" {
+		where = append(where, "lower(email) = lower("+arg(f.Email)+")")
+	}
+	if f.IP != "" {
+		where = append(where, "ip = "+arg(f.IP))
+	}
+	if f.Since != nil {
+		where = append(where, "created_at >= "+arg(sqliteTime(*f.Since)))
+	}
+	if f.Until != nil {
+		where = append(where, "created_at < "+arg(sqliteTime(*f.Until)))
+	}
+	if f.Before != 0 {
+		where = append(where, "id < "+arg(f.Before))
+	}
+
+	query := `select id, event, user_id, email, reason, ip, user_agent, request_id, created_at
+	from audit_events where ` + strings.Join(where, " and ")
+	// fetch one more than the limit, so that we know whether there is another page
+	query += " order by id desc limit " + arg(f.Limit+1)
+
+	rows, err := m.db().QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, repository.AuditPage{}, err
+	}
+	defer rows.Close()
+
+	var events []*data.AuditEvent
+
+	for rows.Next() {
+		var e data.AuditEvent
+		err := rows.Scan(
+			&e.ID,
+			&e.Event,
+			&e.UserID,
+			&e.Email,
+			&e.Reason,
+			&e.IP,
+			&e.UserAgent,
+			&e.RequestID,
+			&e.CreatedAt,
+		)
+		if err != nil {
+			return nil, repository.AuditPage{}, err
+		}
+
+		events = append(events, &e)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, repository.AuditPage{}, err
+	}
+
+	events, page := repository.NewAuditPage(events, f)
+	return events, page, nil
+}
@@ -0,0 +1,59 @@
+package dbrepo
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/jackc/pgconn"
+	"testingCourserWeb/pkg/repository"
+)
+
+// Postgres error codes we translate; see https://www.postgresql.org/docs/current/errcodes-appendix.html
+const (
+	pgUniqueViolation      = "23505"
+	pgForeignKeyViolation  = "23503"
+	pgExclusionViolation   = "23P01"
+	pgSerializationFailure = "40001"
+)
+
+// usersEmailKey is the unique constraint on users.email.
+const usersEmailKey = "users_email_key"
+
+// translateError turns the errors from database/sql and pgx which callers care about into
+// the errors in pkg/repository. The original error is kept in the message, for logging.
+func translateError(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	if errors.Is(err, sql.ErrNoRows) {
+		return repository.ErrNotFound
+	}
+
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		switch pgErr.Code {
+		case pgUniqueViolation:
+			if pgErr.ConstraintName == usersEmailKey {
+				return fmt.Errorf("%w (%s)", repository.ErrDuplicateEmail, err)
+			}
+			return fmt.Errorf("%w (%s)", repository.ErrConflict, err)
+		case pgForeignKeyViolation, pgExclusionViolation, pgSerializationFailure:
+			return fmt.Errorf("%w (%s)", repository.ErrConflict, err)
+		}
+	}
+
+	return err
+}
+
+// expectRows returns ErrNotFound if an update or delete did not touch any rows.
+func expectRows(result sql.Result) error {
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return repository.ErrNotFound
+	}
+	return nil
+}
@@ -0,0 +1,39 @@
+package dbrepo
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
+	"strings"
+	"testingCourserWeb/pkg/repository"
+)
+
+// translateSQLiteError is translateError for SQLite: it turns the errors callers care about into
+// the errors in pkg/repository, keeping the original in the message.
+func translateSQLiteError(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	if errors.Is(err, sql.ErrNoRows) {
+		return repository.ErrNotFound
+	}
+
+	var sqliteErr *sqlite.Error
+	if errors.As(err, &sqliteErr) {
+		switch sqliteErr.Code() {
+		case sqlite3.SQLITE_CONSTRAINT_UNIQUE, sqlite3.SQLITE_CONSTRAINT_PRIMARYKEY:
+			// SQLite names the columns, not the constraint
+			if strings.Contains(sqliteErr.Error(), "users.email") {
+				return fmt.Errorf("%w (%s)", repository.ErrDuplicateEmail, err)
+			}
+			return fmt.Errorf("%w (%s)", repository.ErrConflict, err)
+		case sqlite3.SQLITE_CONSTRAINT_FOREIGNKEY, sqlite3.SQLITE_BUSY:
+			return fmt.Errorf("%w (%s)", repository.ErrConflict, err)
+		}
+	}
+
+	return err
+}
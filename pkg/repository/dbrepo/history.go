@@ -0,0 +1,65 @@
+package dbrepo
+
+import (
+	"context"
+	"encoding/json"
+	"testingCourserWeb/pkg/data"
+	"testingCourserWeb/pkg/repository"
+)
+
+// encodeSnapshot returns s as the JSON we store in user_history, or nil if there is no s. The
+// JSON is a string, which both jsonb and text columns take.
+func encodeSnapshot(s *data.UserSnapshot) (any, error) {
+	if s == nil {
+		return nil, nil
+	}
+	b, err := json.Marshal(s)
+	if err != nil {
+		return nil, err
+	}
+	return string(b), nil
+}
+
+// decodeSnapshot reads a snapshot scanned from user_history; NULL is no snapshot.
+func decodeSnapshot(b []byte) (*data.UserSnapshot, error) {
+	if b == nil {
+		return nil, nil
+	}
+	var s data.UserSnapshot
+	err := json.Unmarshal(b, &s)
+	if err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+// actorID returns the actor repository.WithActor put in ctx, as a column value.
+func actorID(ctx context.Context) any {
+	if id, ok := repository.ActorFrom(ctx); ok {
+		return id
+	}
+	return nil
+}
+
+// scanner is what *sql.Row and *sql.Rows have in common.
+type scanner interface {
+	Scan(dest ...any) error
+}
+
+// scanHistory scans a row of user_history, selected in the order the history queries use.
+func scanHistory(row scanner) (*data.UserHistory, error) {
+	var h data.UserHistory
+	var before, after []byte
+	err := row.Scan(&h.Revision, &h.UserID, &h.Action, &h.ActorID, &before, &after, &h.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	if h.Before, err = decodeSnapshot(before); err != nil {
+		return nil, err
+	}
+	if h.After, err = decodeSnapshot(after); err != nil {
+		return nil, err
+	}
+	return &h, nil
+}
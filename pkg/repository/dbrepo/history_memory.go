@@ -0,0 +1,73 @@
+package dbrepo
+
+import (
+	"context"
+	"testingCourserWeb/pkg/data"
+	"testingCourserWeb/pkg/repository"
+)
+
+// GetUserHistory returns every change recorded for a user, newest first. Deleted users still
+// have their history; ErrNotFound means there is no such user at all.
+func (m *MemoryDBRepo) GetUserHistory(ctx context.Context, userID int) ([]*data.UserHistory, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if _, ok := m.state.users[userID]; !ok {
+		return nil, repository.ErrNotFound
+	}
+
+	var history []*data.UserHistory
+	for i := len(m.state.history) - 1; i >= 0; i-- {
+		if h := m.state.history[i]; h.UserID == userID {
+			history = append(history, &h)
+		}
+	}
+	return history, nil
+}
+
+// GetUserHistoryEntry returns one revision of a user's history.
+func (m *MemoryDBRepo) GetUserHistoryEntry(ctx context.Context, userID, revision int) (*data.UserHistory, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	for _, h := range m.state.history {
+		if h.UserID == userID && h.Revision == revision {
+			return &h, nil
+		}
+	}
+	return nil, repository.ErrNotFound
+}
+
+// snapshot returns what we record of user id, deleted or not, or nil if there is no such user.
+func (m *MemoryDBRepo) snapshot(id int) *data.UserSnapshot {
+	u, ok := m.state.users[id]
+	if !ok {
+		return nil
+	}
+	return &data.UserSnapshot{
+		FirstName:  u.FirstName,
+		LastName:   u.LastName,
+		Email:      u.Email,
+		IsAdmin:    u.IsAdmin,
+		ProfilePic: m.state.images[id].FileName,
+		DeletedAt:  u.DeletedAt,
+		DisabledAt: u.DisabledAt,
+	}
+}
+
+// recordChange adds a change to user id's history, with before, what they looked like before it,
+// and what they look like now. The caller must hold the write lock.
+func (m *MemoryDBRepo) recordChange(ctx context.Context, id int, action string, before *data.UserSnapshot) {
+	h := data.UserHistory{
+		Revision:  m.state.nextID("user_history"),
+		UserID:    id,
+		Action:    action,
+		Before:    before,
+		After:     m.snapshot(id),
+		CreatedAt: memoryNow(),
+	}
+	if actor, ok := repository.ActorFrom(ctx); ok {
+		h.ActorID = &actor
+	}
+	m.state.history = append(m.state.history, h)
+}
@@ -0,0 +1,114 @@
+package dbrepo
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"testingCourserWeb/pkg/data"
+	"time"
+)
+
+// GetUserHistory returns every change recorded for a user, newest first, like
+// PostgresDBRepo.GetUserHistory.
+func (m *SQLiteDBRepo) GetUserHistory(ctx context.Context, userID int) ([]*data.UserHistory, error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	query := `select id, user_id, action, actor_id, before_state, after_state, created_at
+		from user_history where user_id = $1 order by id desc`
+
+	rows, err := m.db().QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, translateSQLiteError(err)
+	}
+	defer rows.Close()
+
+	var history []*data.UserHistory
+	for rows.Next() {
+		h, err := scanHistory(rows)
+		if err != nil {
+			return nil, err
+		}
+		history = append(history, h)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(history) == 0 {
+		var exists int
+		err := m.db().QueryRowContext(ctx, `select 1 from users where id = $1`, userID).Scan(&exists)
+		if err != nil {
+			return nil, translateSQLiteError(err)
+		}
+	}
+	return history, nil
+}
+
+// GetUserHistoryEntry returns one revision of a user's history.
+func (m *SQLiteDBRepo) GetUserHistoryEntry(ctx context.Context, userID, revision int) (*data.UserHistory, error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	query := `select id, user_id, action, actor_id, before_state, after_state, created_at
+		from user_history where user_id = $1 and id = $2`
+
+	h, err := scanHistory(m.db().QueryRowContext(ctx, query, userID, revision))
+	if err != nil {
+		return nil, translateSQLiteError(err)
+	}
+	return h, nil
+}
+
+// snapshot returns what we record of user id, deleted or not, or nil if there is no such user.
+func (m *SQLiteDBRepo) snapshot(ctx context.Context, id int) (*data.UserSnapshot, error) {
+	query := `
+		select
+			u.first_name, u.last_name, u.email, u.is_admin, coalesce(ui.file_name, ''), u.deleted_at, u.disabled_at
+		from
+			users u
+			left join user_images ui on (ui.user_id = u.id)
+		where
+			u.id = $1`
+
+	var s data.UserSnapshot
+	err := m.db().QueryRowContext(ctx, query, id).Scan(
+		&s.FirstName,
+		&s.LastName,
+		&s.Email,
+		&s.IsAdmin,
+		&s.ProfilePic,
+		&s.DeletedAt,
+		&s.DisabledAt,
+	)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+// recordChange adds a change to user id's history, like PostgresDBRepo.recordChange.
+func (m *SQLiteDBRepo) recordChange(ctx context.Context, id int, action string, before *data.UserSnapshot) error {
+	after, err := m.snapshot(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	beforeState, err := encodeSnapshot(before)
+	if err != nil {
+		return err
+	}
+	afterState, err := encodeSnapshot(after)
+	if err != nil {
+		return err
+	}
+
+	stmt := `insert into user_history (user_id, action, actor_id, before_state, after_state, created_at)
+		values ($1, $2, $3, $4, $5, $6)`
+
+	_, err = m.db().ExecContext(ctx, stmt, id, action, actorID(ctx), beforeState, afterState, sqliteTime(time.Now()))
+	return translateSQLiteError(err)
+}
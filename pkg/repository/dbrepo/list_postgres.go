@@ -0,0 +1,99 @@
+package dbrepo
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"testingCourserWeb/pkg/data"
+	"testingCourserWeb/pkg/repository"
+)
+
+// ListUsers returns one page of users, filtered and sorted as asked. We page with a keyset
+// (the sort column and id of the last user on the previous page) rather than an offset,
+// so that every page is as cheap as the first, and pages do not shift as users are added.
+func (m *PostgresDBRepo) ListUsers(ctx context.Context, opts repository.ListOptions) ([]*data.User, repository.Page, error) {
+	if err := opts.Normalize(); err != nil {
+		return nil, repository.Page{}, err
+	}
+
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	var args []any
+	arg := func(v any) string {
+		args = append(args, v)
+		return fmt.Sprintf("$%d", len(args))
+	}
+
+	where := []string{"deleted_at is null"}
+	if opts.Deleted {
+		where[0] = "deleted_at is not null"
+	}
+	if opts.Email != "" {
+		where = append(where, "lower(email) = lower("+arg(opts.Email)+")")
+	}
+	if opts.IsAdmin != nil {
+		isAdmin := 0
+		if *opts.IsAdmin {
+			isAdmin = 1
+		}
+		where = append(where, "is_admin = "+arg(isAdmin))
+	}
+	if opts.CreatedAfter != nil {
+		where = append(where, "created_at > "+arg(*opts.CreatedAfter))
+	}
+
+	// opts.Sort has been checked against repository.SortColumns, so it is safe to use in the query
+	direction, compare := "asc", ">"
+	if opts.Desc {
+		direction, compare = "desc", "<"
+	}
+	if opts.After != "" {
+		cursor, _ := opts.Cursor()
+		value, _ := cursor.SortValue()
+		where = append(where, fmt.Sprintf("(%s, id) %s (%s, %s)", opts.Sort, compare, arg(value), arg(cursor.ID)))
+	}
+
+	query := `select id, email, first_name, last_name, password, is_admin, created_at, updated_at, deleted_at, disabled_at
+	from users where ` + strings.Join(where, " and ")
+	// fetch one more than the limit, so that we know whether there is another page
+	query += fmt.Sprintf(" order by %s %s, id %s limit %s", opts.Sort, direction, direction, arg(opts.Limit+1))
+
+	rows, err := m.db().QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, repository.Page{}, err
+	}
+	defer rows.Close()
+
+	var users []*data.User
+
+	for rows.Next() {
+		var user data.User
+		err := rows.Scan(
+			&user.ID,
+			&user.Email,
+			&user.FirstName,
+			&user.LastName,
+			&user.Password,
+			&user.IsAdmin,
+			&user.CreatedAt,
+			&user.UpdatedAt,
+			&user.DeletedAt,
+			&user.DisabledAt,
+		)
+		if err != nil {
+			log.Println("Error scanning", err)
+			return nil, repository.Page{}, err
+		}
+
+		users = append(users, &user)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, repository.Page{}, err
+	}
+
+	users, page := repository.NewPage(users, opts)
+	return users, page, nil
+}
@@ -0,0 +1,61 @@
+package dbrepo
+
+import (
+	"context"
+	"testingCourserWeb/pkg/data"
+	"testingCourserWeb/pkg/repository"
+	"time"
+)
+
+// GetLoginFailures returns the failed logins counted for an account.
+func (m *MemoryDBRepo) GetLoginFailures(ctx context.Context, account string) (*data.LoginFailures, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	f, ok := m.state.loginFailures[account]
+	if !ok {
+		return nil, repository.ErrNotFound
+	}
+	return &f, nil
+}
+
+// RecordLoginFailure counts another failed login for an account, and returns the count. If the
+// last failure was before resetBefore, the count starts again.
+func (m *MemoryDBRepo) RecordLoginFailure(ctx context.Context, account string, resetBefore time.Time) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	f, ok := m.state.loginFailures[account]
+	if !ok || f.LastFailureAt.Before(resetBefore) {
+		f.Failures = 0
+	}
+	f.Account = account
+	f.Failures++
+	f.LastFailureAt = memoryNow()
+	m.state.loginFailures[account] = f
+
+	return f.Failures, nil
+}
+
+// LockLogin locks an account out until a time.
+func (m *MemoryDBRepo) LockLogin(ctx context.Context, account string, until time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	f, ok := m.state.loginFailures[account]
+	if !ok {
+		return repository.ErrNotFound
+	}
+	f.LockedUntil = &until
+	m.state.loginFailures[account] = f
+	return nil
+}
+
+// ClearLoginFailures forgets an account's failed logins, and any lock.
+func (m *MemoryDBRepo) ClearLoginFailures(ctx context.Context, account string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.state.loginFailures, account)
+	return nil
+}
@@ -0,0 +1,70 @@
+package dbrepo
+
+import (
+	"context"
+	"testingCourserWeb/pkg/data"
+	"time"
+)
+
+// GetLoginFailures returns the failed logins counted for an account.
+func (m *SQLiteDBRepo) GetLoginFailures(ctx context.Context, account string) (*data.LoginFailures, error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	query := `select account, failures, last_failure_at, locked_until from login_failures where account = $1`
+
+	var f data.LoginFailures
+	err := m.db().QueryRowContext(ctx, query, account).Scan(
+		&f.Account,
+		&f.Failures,
+		&f.LastFailureAt,
+		&f.LockedUntil,
+	)
+	if err != nil {
+		return nil, translateSQLiteError(err)
+	}
+	return &f, nil
+}
+
+// RecordLoginFailure counts another failed login for an account, like
+// PostgresDBRepo.RecordLoginFailure. Times are compared as the text we store them as.
+func (m *SQLiteDBRepo) RecordLoginFailure(ctx context.Context, account string, resetBefore time.Time) (int, error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	stmt := `insert into login_failures as f (account, failures, last_failure_at) values ($1, 1, $2)
+		on conflict (account) do update set
+			failures = case when f.last_failure_at < $3 then 1 else f.failures + 1 end,
+			last_failure_at = excluded.last_failure_at
+		returning failures`
+
+	var failures int
+	err := m.db().QueryRowContext(ctx, stmt, account, sqliteTime(time.Now()), sqliteTime(resetBefore)).Scan(&failures)
+	if err != nil {
+		return 0, translateSQLiteError(err)
+	}
+	return failures, nil
+}
+
+// LockLogin locks an account out until a time.
+func (m *SQLiteDBRepo) LockLogin(ctx context.Context, account string, until time.Time) error {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	stmt := `update login_failures set locked_until = $2 where account = $1`
+
+	result, err := m.db().ExecContext(ctx, stmt, account, sqliteTime(until))
+	if err != nil {
+		return translateSQLiteError(err)
+	}
+	return expectRows(result)
+}
+
+// ClearLoginFailures forgets an account's failed logins, and any lock.
+func (m *SQLiteDBRepo) ClearLoginFailures(ctx context.Context, account string) error {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	_, err := m.db().ExecContext(ctx, `delete from login_failures where account = $1`, account)
+	return translateSQLiteError(err)
+}
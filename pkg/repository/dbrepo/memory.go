@@ -0,0 +1,268 @@
+package dbrepo
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"golang.org/x/crypto/bcrypt"
+	"os"
+	"sync"
+	"testingCourserWeb/pkg/authz"
+	"testingCourserWeb/pkg/data"
+	"testingCourserWeb/pkg/repository"
+	"time"
+)
+
+// MemoryDBRepo is a repository which keeps everything in memory. It behaves like the database
+// repositories (unique emails, hashed passwords, cascading deletes, typed errors), so that tests
+// of the apps can check what their handlers actually stored. It is safe for concurrent use.
+type MemoryDBRepo struct {
+	mu    *sync.RWMutex
+	state *memoryState
+	// inTx is set on the copy of the repository WithTx hands out
+	inTx bool
+}
+
+// memoryState is everything a MemoryDBRepo stores; WithTx works on a copy of it.
+type memoryState struct {
+	users           map[int]data.User
+	images          map[int]data.UserImage
+	refreshTokens   map[string]data.RefreshToken
+	userRoles       map[int]map[string]time.Time
+	rolePermissions map[string][]string
+	history         []data.UserHistory
+	auditEvents     []data.AuditEvent
+	loginFailures   map[string]data.LoginFailures
+	mfa             map[int]data.UserMFA
+	// recoveryCodes maps each user's recovery code hashes to whether they have been used
+	recoveryCodes map[int]map[string]bool
+	// passwordResets are keyed by the hash of their token
+	passwordResets map[string]data.PasswordReset
+	lastID         map[string]int
+}
+
+// NewMemoryDBRepo returns an empty repository, with the roles and permissions the migrations create.
+func NewMemoryDBRepo() *MemoryDBRepo {
+	return &MemoryDBRepo{
+		mu: &sync.RWMutex{},
+		state: &memoryState{
+			users:          make(map[int]data.User),
+			images:         make(map[int]data.UserImage),
+			refreshTokens:  make(map[string]data.RefreshToken),
+			userRoles:      make(map[int]map[string]time.Time),
+			loginFailures:  make(map[string]data.LoginFailures),
+			mfa:            make(map[int]data.UserMFA),
+			recoveryCodes:  make(map[int]map[string]bool),
+			passwordResets: make(map[string]data.PasswordReset),
+			rolePermissions: map[string][]string{
+				authz.RoleAdmin:   {authz.AuditRead, authz.UsersCreate, authz.UsersDelete, authz.UsersRead, authz.UsersUpdate},
+				authz.RoleSupport: {authz.UsersRead, authz.UsersUpdate},
+				authz.RoleAuditor: {authz.AuditRead, authz.UsersRead},
+			},
+			lastID: make(map[string]int),
+		},
+	}
+}
+
+// clone returns a deep copy of s.
+func (s *memoryState) clone() *memoryState {
+	c := &memoryState{
+		users:           make(map[int]data.User, len(s.users)),
+		images:          make(map[int]data.UserImage, len(s.images)),
+		refreshTokens:   make(map[string]data.RefreshToken, len(s.refreshTokens)),
+		userRoles:       make(map[int]map[string]time.Time, len(s.userRoles)),
+		rolePermissions: s.rolePermissions,
+		// entries are never changed once recorded, so they can be shared
+		history:        append([]data.UserHistory(nil), s.history...),
+		auditEvents:    append([]data.AuditEvent(nil), s.auditEvents...),
+		loginFailures:  make(map[string]data.LoginFailures, len(s.loginFailures)),
+		mfa:            make(map[int]data.UserMFA, len(s.mfa)),
+		recoveryCodes:  make(map[int]map[string]bool, len(s.recoveryCodes)),
+		passwordResets: make(map[string]data.PasswordReset, len(s.passwordResets)),
+		lastID:         make(map[string]int, len(s.lastID)),
+	}
+	for k, v := range s.users {
+		c.users[k] = v
+	}
+	for k, v := range s.images {
+		c.images[k] = v
+	}
+	for k, v := range s.refreshTokens {
+		c.refreshTokens[k] = v
+	}
+	for k, v := range s.loginFailures {
+		c.loginFailures[k] = v
+	}
+	for k, v := range s.mfa {
+		c.mfa[k] = v
+	}
+	for k, v := range s.passwordResets {
+		c.passwordResets[k] = v
+	}
+	for k, v := range s.recoveryCodes {
+		codes := make(map[string]bool, len(v))
+		for hash, used := range v {
+			codes[hash] = used
+		}
+		c.recoveryCodes[k] = codes
+	}
+	for k, v := range s.userRoles {
+		roles := make(map[string]time.Time, len(v))
+		for role, at := range v {
+			roles[role] = at
+		}
+		c.userRoles[k] = roles
+	}
+	for k, v := range s.lastID {
+		c.lastID[k] = v
+	}
+	return c
+}
+
+// nextID returns the next id for table; like identity columns, ids are never reused.
+func (s *memoryState) nextID(table string) int {
+	s.lastID[table]++
+	return s.lastID[table]
+}
+
+// useID makes sure an id given by a fixture is never handed out again.
+func (s *memoryState) useID(table string, id int) {
+	if id > s.lastID[table] {
+		s.lastID[table] = id
+	}
+}
+
+// Connection returns nil; there is no database.
+func (m *MemoryDBRepo) Connection() *sql.DB {
+	return nil
+}
+
+// WithTx runs fn against a copy of the repository's contents, which replaces them if fn returns
+// nil, and is thrown away otherwise. Everything else waits until the transaction is done, so
+// fn must only use repo.
+func (m *MemoryDBRepo) WithTx(ctx context.Context, fn func(repo repository.DatabaseRepo) error) error {
+	if m.inTx {
+		return fn(m)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	tx := &MemoryDBRepo{mu: &sync.RWMutex{}, state: m.state.clone(), inTx: true}
+	err := fn(tx)
+	if err != nil {
+		return err
+	}
+
+	*m.state = *tx.state
+	return nil
+}
+
+// Fixtures are the rows a MemoryDBRepo is seeded with, usually read from a JSON file with ReadFixtures.
+type Fixtures struct {
+	Users         []FixtureUser       `json:"users"`
+	RefreshTokens []data.RefreshToken `json:"refresh_tokens"`
+}
+
+// FixtureUser is a user to seed, with the fields data.User keeps out of JSON. Password may be
+// plain text, which is hashed, or a bcrypt hash, which is stored as it is; hashing is slow, and
+// fixtures are loaded for every test run.
+type FixtureUser struct {
+	ID         int       `json:"id"`
+	FirstName  string    `json:"first_name"`
+	LastName   string    `json:"last_name"`
+	Email      string    `json:"email"`
+	Password   string    `json:"password"`
+	IsAdmin    int       `json:"is_admin"`
+	CreatedAt  time.Time `json:"created_at"`
+	ProfilePic string    `json:"profile_pic"`
+	Roles      []string  `json:"roles"`
+}
+
+// ReadFixtures reads fixtures from a JSON file.
+func ReadFixtures(path string) (*Fixtures, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var f Fixtures
+	err = json.Unmarshal(b, &f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return &f, nil
+}
+
+// Seed adds the fixtures to the repository, keeping their ids. It goes through the same checks
+// as everything else, so fixtures can't seed anything the database would refuse.
+func (m *MemoryDBRepo) Seed(f Fixtures) error {
+	return m.WithTx(context.Background(), func(repo repository.DatabaseRepo) error {
+		tx := repo.(*MemoryDBRepo)
+
+		for _, fu := range f.Users {
+			if _, exists := tx.state.users[fu.ID]; exists || fu.ID < 1 {
+				return fmt.Errorf("%w: fixture user %d", repository.ErrConflict, fu.ID)
+			}
+			if tx.emailTaken(fu.Email, 0) {
+				return fmt.Errorf("%w: %s", repository.ErrDuplicateEmail, fu.Email)
+			}
+
+			password := fu.Password
+			if _, err := bcrypt.Cost([]byte(password)); err != nil {
+				hashed, err := bcrypt.GenerateFromPassword([]byte(password), 12)
+				if err != nil {
+					return err
+				}
+				password = string(hashed)
+			}
+
+			if fu.CreatedAt.IsZero() {
+				fu.CreatedAt = time.Now()
+			}
+			tx.state.useID("users", fu.ID)
+			tx.state.users[fu.ID] = data.User{
+				ID:        fu.ID,
+				FirstName: fu.FirstName,
+				LastName:  fu.LastName,
+				Email:     fu.Email,
+				Password:  password,
+				IsAdmin:   fu.IsAdmin,
+				CreatedAt: fu.CreatedAt,
+				UpdatedAt: fu.CreatedAt,
+				Version:   1,
+			}
+
+			// fixtures are where the history starts, so this isn't recorded as a change
+			if fu.ProfilePic != "" {
+				tx.state.images[fu.ID] = data.UserImage{
+					ID:        tx.state.nextID("user_images"),
+					UserID:    fu.ID,
+					FileName:  fu.ProfilePic,
+					CreatedAt: fu.CreatedAt,
+					UpdatedAt: fu.CreatedAt,
+				}
+			}
+			for _, role := range fu.Roles {
+				err := tx.AssignRole(context.Background(), fu.ID, role)
+				if err != nil {
+					return err
+				}
+			}
+		}
+
+		for _, t := range f.RefreshTokens {
+			id, err := tx.InsertRefreshToken(context.Background(), t)
+			if err != nil {
+				return err
+			}
+			if t.Revoked {
+				token := tx.state.refreshTokens[t.JTI]
+				token.ID, token.Revoked = id, true
+				tx.state.refreshTokens[t.JTI] = token
+			}
+		}
+		return nil
+	})
+}
@@ -0,0 +1,123 @@
+package dbrepo
+
+import (
+	"context"
+	"fmt"
+	"testingCourserWeb/pkg/data"
+	"testingCourserWeb/pkg/repository"
+)
+
+// GetUserMFA returns a user's two-factor authentication, with how many recovery codes they have left.
+func (m *MemoryDBRepo) GetUserMFA(ctx context.Context, userID int) (*data.UserMFA, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	mfa, ok := m.state.mfa[userID]
+	if !ok {
+		return nil, repository.ErrNotFound
+	}
+	for _, used := range m.state.recoveryCodes[userID] {
+		if !used {
+			mfa.RecoveryCodesLeft++
+		}
+	}
+	return &mfa, nil
+}
+
+// SetMFASecret starts setting up two-factor authentication for a user with a new secret, unless
+// they have it on already.
+func (m *MemoryDBRepo) SetMFASecret(ctx context.Context, userID int, secret string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.state.users[userID]; !ok {
+		return fmt.Errorf("%w: no user %d", repository.ErrConflict, userID)
+	}
+	if mfa, ok := m.state.mfa[userID]; ok && mfa.Enabled() {
+		return repository.ErrConflict
+	}
+
+	m.state.mfa[userID] = data.UserMFA{UserID: userID, Secret: secret, CreatedAt: memoryNow()}
+	return nil
+}
+
+// EnableMFA turns on two-factor authentication for a user part way through setting it up, and
+// gives them their recovery codes.
+func (m *MemoryDBRepo) EnableMFA(ctx context.Context, userID int, step int64, recoveryCodes []string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	mfa, ok := m.state.mfa[userID]
+	if !ok || mfa.Enabled() {
+		return repository.ErrNotFound
+	}
+
+	now := memoryNow()
+	mfa.EnabledAt, mfa.LastStep = &now, step
+	m.state.mfa[userID] = mfa
+	m.setRecoveryCodes(userID, recoveryCodes)
+	return nil
+}
+
+// DeleteUserMFA turns off two-factor authentication for a user, forgetting their secret and
+// recovery codes.
+func (m *MemoryDBRepo) DeleteUserMFA(ctx context.Context, userID int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.state.mfa[userID]; !ok {
+		return repository.ErrNotFound
+	}
+	delete(m.state.mfa, userID)
+	delete(m.state.recoveryCodes, userID)
+	return nil
+}
+
+// UseMFAStep records that the code for step has been used, unless it, or a later one, already
+// has been.
+func (m *MemoryDBRepo) UseMFAStep(ctx context.Context, userID int, step int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	mfa, ok := m.state.mfa[userID]
+	if !ok || !mfa.Enabled() || mfa.LastStep >= step {
+		return repository.ErrConflict
+	}
+	mfa.LastStep = step
+	m.state.mfa[userID] = mfa
+	return nil
+}
+
+// UseRecoveryCode uses up one of a user's recovery codes, by its hash.
+func (m *MemoryDBRepo) UseRecoveryCode(ctx context.Context, userID int, hash string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	used, ok := m.state.recoveryCodes[userID][hash]
+	if !ok || used {
+		return repository.ErrNotFound
+	}
+	m.state.recoveryCodes[userID][hash] = true
+	return nil
+}
+
+// SetRecoveryCodes replaces a user's recovery codes with new ones, by their hashes.
+func (m *MemoryDBRepo) SetRecoveryCodes(ctx context.Context, userID int, hashes []string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.state.mfa[userID]; !ok {
+		return fmt.Errorf("%w: user %d has no two-factor authentication", repository.ErrConflict, userID)
+	}
+	m.setRecoveryCodes(userID, hashes)
+	return nil
+}
+
+// setRecoveryCodes replaces a user's recovery codes; the caller must hold the lock.
+func (m *MemoryDBRepo) setRecoveryCodes(userID int, hashes []string) {
+	codes := make(map[string]bool, len(hashes))
+	for _, hash := range hashes {
+		codes[hash] = false
+	}
+	m.state.recoveryCodes[userID] = codes
+}
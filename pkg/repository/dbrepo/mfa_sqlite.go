@@ -0,0 +1,148 @@
+package dbrepo
+
+import (
+	"context"
+	"errors"
+	"testingCourserWeb/pkg/data"
+	"testingCourserWeb/pkg/repository"
+	"time"
+)
+
+// GetUserMFA returns a user's two-factor authentication, with how many recovery codes they have left.
+func (m *SQLiteDBRepo) GetUserMFA(ctx context.Context, userID int) (*data.UserMFA, error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	query := `select user_id, secret, last_step, enabled_at, created_at,
+			(select count(*) from recovery_codes c where c.user_id = m.user_id and c.used_at is null)
+		from user_mfa m where user_id = $1`
+
+	var mfa data.UserMFA
+	err := m.db().QueryRowContext(ctx, query, userID).Scan(
+		&mfa.UserID,
+		&mfa.Secret,
+		&mfa.LastStep,
+		&mfa.EnabledAt,
+		&mfa.CreatedAt,
+		&mfa.RecoveryCodesLeft,
+	)
+	if err != nil {
+		return nil, translateSQLiteError(err)
+	}
+	return &mfa, nil
+}
+
+// SetMFASecret starts setting up two-factor authentication for a user with a new secret, like
+// PostgresDBRepo.SetMFASecret.
+func (m *SQLiteDBRepo) SetMFASecret(ctx context.Context, userID int, secret string) error {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	stmt := `insert into user_mfa as m (user_id, secret, created_at) values ($1, $2, $3)
+		on conflict (user_id) do update set
+			secret = excluded.secret,
+			last_step = 0,
+			created_at = excluded.created_at
+		where m.enabled_at is null`
+
+	result, err := m.db().ExecContext(ctx, stmt, userID, secret, sqliteTime(time.Now()))
+	if err != nil {
+		return translateSQLiteError(err)
+	}
+	if err = expectRows(result); errors.Is(err, repository.ErrNotFound) {
+		return repository.ErrConflict
+	}
+	return err
+}
+
+// EnableMFA turns on two-factor authentication for a user, like PostgresDBRepo.EnableMFA.
+func (m *SQLiteDBRepo) EnableMFA(ctx context.Context, userID int, step int64, recoveryCodes []string) error {
+	return m.inTx(ctx, func(tx *SQLiteDBRepo) error {
+		ctx, cancel := withTimeout(ctx)
+		defer cancel()
+
+		stmt := `update user_mfa set enabled_at = $2, last_step = $3 where user_id = $1 and enabled_at is null`
+
+		result, err := tx.db().ExecContext(ctx, stmt, userID, sqliteTime(time.Now()), step)
+		if err != nil {
+			return translateSQLiteError(err)
+		}
+		if err = expectRows(result); err != nil {
+			return err
+		}
+		return tx.SetRecoveryCodes(ctx, userID, recoveryCodes)
+	})
+}
+
+// DeleteUserMFA turns off two-factor authentication for a user, forgetting their secret and
+// recovery codes.
+func (m *SQLiteDBRepo) DeleteUserMFA(ctx context.Context, userID int) error {
+	return m.inTx(ctx, func(tx *SQLiteDBRepo) error {
+		ctx, cancel := withTimeout(ctx)
+		defer cancel()
+
+		_, err := tx.db().ExecContext(ctx, `delete from recovery_codes where user_id = $1`, userID)
+		if err != nil {
+			return translateSQLiteError(err)
+		}
+
+		result, err := tx.db().ExecContext(ctx, `delete from user_mfa where user_id = $1`, userID)
+		if err != nil {
+			return translateSQLiteError(err)
+		}
+		return expectRows(result)
+	})
+}
+
+// UseMFAStep records that the code for step has been used, like PostgresDBRepo.UseMFAStep.
+func (m *SQLiteDBRepo) UseMFAStep(ctx context.Context, userID int, step int64) error {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	stmt := `update user_mfa set last_step = $2 where user_id = $1 and enabled_at is not null and last_step < $2`
+
+	result, err := m.db().ExecContext(ctx, stmt, userID, step)
+	if err != nil {
+		return translateSQLiteError(err)
+	}
+	if err = expectRows(result); errors.Is(err, repository.ErrNotFound) {
+		return repository.ErrConflict
+	}
+	return err
+}
+
+// UseRecoveryCode uses up one of a user's recovery codes, by its hash.
+func (m *SQLiteDBRepo) UseRecoveryCode(ctx context.Context, userID int, hash string) error {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	stmt := `update recovery_codes set used_at = $3 where user_id = $1 and code_hash = $2 and used_at is null`
+
+	result, err := m.db().ExecContext(ctx, stmt, userID, hash, sqliteTime(time.Now()))
+	if err != nil {
+		return translateSQLiteError(err)
+	}
+	return expectRows(result)
+}
+
+// SetRecoveryCodes replaces a user's recovery codes with new ones, by their hashes, in one transaction.
+func (m *SQLiteDBRepo) SetRecoveryCodes(ctx context.Context, userID int, hashes []string) error {
+	return m.inTx(ctx, func(tx *SQLiteDBRepo) error {
+		ctx, cancel := withTimeout(ctx)
+		defer cancel()
+
+		_, err := tx.db().ExecContext(ctx, `delete from recovery_codes where user_id = $1`, userID)
+		if err != nil {
+			return translateSQLiteError(err)
+		}
+
+		stmt := `insert into recovery_codes (user_id, code_hash, created_at) values ($1, $2, $3)`
+		for _, hash := range hashes {
+			_, err = tx.db().ExecContext(ctx, stmt, userID, hash, sqliteTime(time.Now()))
+			if err != nil {
+				return translateSQLiteError(err)
+			}
+		}
+		return nil
+	})
+}
@@ -0,0 +1,79 @@
+package dbrepo
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	_ "github.com/jackc/pgconn"
+	_ "github.com/jackc/pgx/v4/stdlib"
+	_ "modernc.org/sqlite"
+	"net/url"
+	"testingCourserWeb/pkg/repository"
+	"time"
+)
+
+// The databases we have repositories for. The names are the same as the dialects in pkg/migrations.
+const (
+	Postgres = "postgres"
+	SQLite   = "sqlite"
+)
+
+// Open opens a database. For Postgres, dsn is a connection string; for SQLite, it is the path
+// of the database file, which is created if it doesn't exist.
+func Open(driver, dsn string) (*sql.DB, error) {
+	switch driver {
+	case Postgres:
+		return sql.Open("pgx", dsn)
+	case SQLite:
+		db, err := sql.Open("sqlite", sqliteDSN(dsn))
+		if err != nil {
+			return nil, err
+		}
+		// SQLite lets one connection write at a time; with more, writers just wait on each other
+		db.SetMaxOpenConns(1)
+		return db, nil
+	}
+	return nil, fmt.Errorf("unknown database driver %s", driver)
+}
+
+// New returns the repository for a database opened with Open.
+func New(driver string, db *sql.DB) (repository.DatabaseRepo, error) {
+	switch driver {
+	case Postgres:
+		return &PostgresDBRepo{DB: db}, nil
+	case SQLite:
+		return &SQLiteDBRepo{DB: db}, nil
+	}
+	return nil, fmt.Errorf("unknown database driver %s", driver)
+}
+
+// sqliteDSN turns the path of a SQLite database into a DSN which sets up every connection the
+// way the repository expects: foreign keys enforced (SQLite leaves them off by default), and
+// waiting for locks rather than failing straight away.
+func sqliteDSN(path string) string {
+	q := url.Values{}
+	q.Add("_pragma", "foreign_keys(1)")
+	q.Add("_pragma", "busy_timeout(5000)")
+	q.Add("_pragma", "journal_mode(WAL)")
+	return "file:" + path + "?" + q.Encode()
+}
+
+// dbTimeout is how long a query may take, unless the caller's context says otherwise.
+const dbTimeout = time.Second * 3
+
+// withTimeout returns a context for a query. If the caller has already set a deadline, we
+// keep to it; otherwise the query gets dbTimeout. Either way, the query is cancelled when
+// the caller's context is, e.g. when the client of a request goes away.
+func withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if _, ok := ctx.Deadline(); ok {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, dbTimeout)
+}
+
+// dbtx is what *sql.DB and *sql.Tx have in common, and all our queries need.
+type dbtx interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
@@ -0,0 +1,47 @@
+package dbrepo
+
+import (
+	"context"
+	"fmt"
+	"testingCourserWeb/pkg/data"
+	"testingCourserWeb/pkg/repository"
+)
+
+// InsertPasswordReset stores a password reset, by the hash of its token, and returns its ID.
+func (m *MemoryDBRepo) InsertPasswordReset(ctx context.Context, r data.PasswordReset) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.state.users[r.UserID]; !ok {
+		return 0, fmt.Errorf("%w: no user %d", repository.ErrConflict, r.UserID)
+	}
+	if _, ok := m.state.passwordResets[r.TokenHash]; ok {
+		return 0, fmt.Errorf("%w: token is already in use", repository.ErrConflict)
+	}
+
+	r.ID = m.state.nextID("password_resets")
+	r.UsedAt, r.CreatedAt = nil, memoryNow()
+	m.state.passwordResets[r.TokenHash] = r
+	return r.ID, nil
+}
+
+// UsePasswordReset uses up a password reset, and every other one its user has outstanding, and
+// returns the user it was for.
+func (m *MemoryDBRepo) UsePasswordReset(ctx context.Context, tokenHash string) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := memoryNow()
+	r, ok := m.state.passwordResets[tokenHash]
+	if !ok || r.UsedAt != nil || !r.ExpiresAt.After(now) {
+		return 0, repository.ErrNotFound
+	}
+
+	for hash, other := range m.state.passwordResets {
+		if other.UserID == r.UserID && other.UsedAt == nil {
+			other.UsedAt = &now
+			m.state.passwordResets[hash] = other
+		}
+	}
+	return r.UserID, nil
+}
@@ -0,0 +1,53 @@
+package dbrepo
+
+import (
+	"context"
+	"testingCourserWeb/pkg/data"
+	"time"
+)
+
+// InsertPasswordReset stores a password reset, by the hash of its token, and returns its ID.
+func (m *PostgresDBRepo) InsertPasswordReset(ctx context.Context, r data.PasswordReset) (int, error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	var newID int
+	stmt := `insert into password_resets (user_id, token_hash, expires_at, created_at)
+		values ($1, $2, $3, $4) returning id`
+
+	err := m.db().QueryRowContext(ctx, stmt, r.UserID, r.TokenHash, r.ExpiresAt, time.Now()).Scan(&newID)
+	if err != nil {
+		return 0, translateError(err)
+	}
+	return newID, nil
+}
+
+// UsePasswordReset uses up a password reset, and every other one its user has outstanding, in one
+// transaction, and returns the user it was for. Using the reset is one statement, so that two
+// requests can't use the same token at the same time.
+func (m *PostgresDBRepo) UsePasswordReset(ctx context.Context, tokenHash string) (int, error) {
+	var userID int
+
+	err := m.inTx(ctx, func(tx *PostgresDBRepo) error {
+		ctx, cancel := withTimeout(ctx)
+		defer cancel()
+
+		now := time.Now()
+		stmt := `update password_resets set used_at = $2
+			where token_hash = $1 and used_at is null and expires_at > $2
+			returning user_id`
+
+		err := tx.db().QueryRowContext(ctx, stmt, tokenHash, now).Scan(&userID)
+		if err != nil {
+			return translateError(err)
+		}
+
+		stmt = `update password_resets set used_at = $2 where user_id = $1 and used_at is null`
+		_, err = tx.db().ExecContext(ctx, stmt, userID, now)
+		return translateError(err)
+	})
+	if err != nil {
+		return 0, err
+	}
+	return userID, nil
+}
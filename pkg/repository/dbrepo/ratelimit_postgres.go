@@ -0,0 +1,105 @@
+package dbrepo
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"testingCourserWeb/pkg/ratelimit"
+	"time"
+)
+
+// Take takes a token from the rate limit bucket for key. With Hit, this makes PostgresDBRepo a
+// ratelimit.Backend which every instance using the database shares. The bucket is updated in one statement, which
+// only changes it if it has a token to take, so concurrent requests can't take the same token.
+func (m *PostgresDBRepo) Take(ctx context.Context, key string, l ratelimit.Limit) (time.Duration, error) {
+	if l.Unlimited() {
+		return 0, nil
+	}
+
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	now := time.Now().UnixMicro()
+	every := l.Every.Microseconds()
+
+	stmt := `insert into rate_limit_buckets as b (key, tat) values ($1, $2)
+		on conflict (key) do update set tat = greatest(b.tat, $3) + $4
+		where greatest(b.tat, $3) - $3 <= $5
+		returning tat`
+
+	var tat int64
+	err := m.db().QueryRowContext(ctx, stmt, key, now+every, now, every, l.Tolerance().Microseconds()).Scan(&tat)
+	if err == nil {
+		return 0, nil
+	}
+	// no row means the bucket is empty, so wait until it has a token again
+	if !errors.Is(err, sql.ErrNoRows) {
+		return 0, translateError(err)
+	}
+
+	err = m.db().QueryRowContext(ctx, `select tat from rate_limit_buckets where key = $1`, key).Scan(&tat)
+	if err != nil {
+		return 0, translateError(err)
+	}
+	_, wait := l.Take(time.UnixMicro(tat), time.UnixMicro(now))
+	if wait <= 0 {
+		// a token arrived in between; it's fine to make the client wait a moment anyway
+		wait = time.Microsecond
+	}
+	return wait, nil
+}
+
+// Hit counts a request for key in the rate limit window of period starting at start, and returns
+// the count for that window and the one before it.
+func (m *PostgresDBRepo) Hit(ctx context.Context, key string, start time.Time, period time.Duration) (int, int, error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	query := `
+		with hit as (
+			insert into rate_limit_windows as w (key, window_start, hits, expires_at) values ($1, $2, 1, $3)
+			on conflict (key, window_start) do update set hits = w.hits + 1
+			returning hits
+		)
+		select hit.hits, coalesce((select hits from rate_limit_windows where key = $1 and window_start = $4), 0)
+		from hit`
+
+	// a window counts until the end of the one after it
+	expires := start.Add(2 * period)
+	var current, previous int
+	err := m.db().QueryRowContext(ctx, query,
+		key,
+		start.UnixMicro(),
+		expires.UnixMicro(),
+		start.Add(-period).UnixMicro(),
+	).Scan(&current, &previous)
+	if err != nil {
+		return 0, 0, translateError(err)
+	}
+	return current, previous, nil
+}
+
+// PruneRateLimits deletes the rate limit buckets which are full, and the windows which have
+// expired, which are the same as none at all, and returns how many it deleted.
+func (m *PostgresDBRepo) PruneRateLimits(ctx context.Context) (int64, error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	now := time.Now().UnixMicro()
+	var pruned int64
+	for _, stmt := range []string{
+		`delete from rate_limit_buckets where tat <= $1`,
+		`delete from rate_limit_windows where expires_at <= $1`,
+	} {
+		result, err := m.db().ExecContext(ctx, stmt, now)
+		if err != nil {
+			return pruned, translateError(err)
+		}
+		n, err := result.RowsAffected()
+		if err != nil {
+			return pruned, err
+		}
+		pruned += n
+	}
+	return pruned, nil
+}
@@ -0,0 +1,91 @@
+package dbrepo
+
+import (
+	"context"
+	"fmt"
+	"testingCourserWeb/pkg/data"
+	"testingCourserWeb/pkg/repository"
+	"time"
+)
+
+// InsertRefreshToken stores a newly issued refresh token, and returns its ID
+func (m *MemoryDBRepo) InsertRefreshToken(ctx context.Context, t data.RefreshToken) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.state.users[t.UserID]; !ok {
+		return 0, fmt.Errorf("%w: no user %d", repository.ErrConflict, t.UserID)
+	}
+	if _, ok := m.state.refreshTokens[t.JTI]; ok {
+		return 0, fmt.Errorf("%w: jti %s is already in use", repository.ErrConflict, t.JTI)
+	}
+
+	now := memoryNow()
+	t.ID = m.state.nextID("refresh_tokens")
+	t.CreatedAt, t.UpdatedAt = now, now
+	m.state.refreshTokens[t.JTI] = t
+
+	return t.ID, nil
+}
+
+// GetRefreshToken returns one refresh token by its jti (token id)
+func (m *MemoryDBRepo) GetRefreshToken(ctx context.Context, jti string) (*data.RefreshToken, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	t, ok := m.state.refreshTokens[jti]
+	if !ok {
+		return nil, repository.ErrNotFound
+	}
+	return &t, nil
+}
+
+// RevokeRefreshToken marks one refresh token as revoked, and returns how many tokens it revoked:
+// none if it was unknown, or already revoked.
+func (m *MemoryDBRepo) RevokeRefreshToken(ctx context.Context, jti string) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	t, ok := m.state.refreshTokens[jti]
+	if !ok || t.Revoked {
+		return 0, nil
+	}
+	t.Revoked = true
+	t.UpdatedAt = memoryNow()
+	m.state.refreshTokens[jti] = t
+	return 1, nil
+}
+
+// RevokeRefreshTokenFamily revokes every refresh token in a family
+func (m *MemoryDBRepo) RevokeRefreshTokenFamily(ctx context.Context, familyID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for jti, t := range m.state.refreshTokens {
+		if t.FamilyID == familyID && !t.Revoked {
+			t.Revoked = true
+			t.UpdatedAt = memoryNow()
+			m.state.refreshTokens[jti] = t
+		}
+	}
+	return nil
+}
+
+// RevokeUserRefreshTokens revokes every refresh token a user has.
+func (m *MemoryDBRepo) RevokeUserRefreshTokens(ctx context.Context, userID int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.revokeUserRefreshTokens(userID, memoryNow())
+	return nil
+}
+
+// revokeUserRefreshTokens revokes every refresh token a user has; the caller must hold the lock.
+func (m *MemoryDBRepo) revokeUserRefreshTokens(userID int, now time.Time) {
+	for jti, t := range m.state.refreshTokens {
+		if t.UserID == userID && !t.Revoked {
+			t.Revoked, t.UpdatedAt = true, now
+			m.state.refreshTokens[jti] = t
+		}
+	}
+}
@@ -0,0 +1,116 @@
+package dbrepo
+
+import (
+	"context"
+	"testingCourserWeb/pkg/data"
+	"time"
+)
+
+// InsertRefreshToken stores a newly issued refresh token, and returns the ID of the newly inserted row
+func (m *PostgresDBRepo) InsertRefreshToken(ctx context.Context, t data.RefreshToken) (int, error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	var newID int
+	stmt := `insert into refresh_tokens (user_id, family_id, jti, expires_at, revoked, created_at, updated_at)
+		values ($1, $2, $3, $4, $5, $6, $7) returning id`
+
+	err := m.db().QueryRowContext(ctx, stmt,
+		t.UserID,
+		t.FamilyID,
+		t.JTI,
+		t.ExpiresAt,
+		t.Revoked,
+		time.Now(),
+		time.Now(),
+	).Scan(&newID)
+
+	if err != nil {
+		return 0, translateError(err)
+	}
+
+	return newID, nil
+}
+
+// GetRefreshToken returns one refresh token by its jti (token id)
+func (m *PostgresDBRepo) GetRefreshToken(ctx context.Context, jti string) (*data.RefreshToken, error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	query := `
+		select
+			id, user_id, family_id, jti, expires_at, revoked, created_at, updated_at
+		from
+			refresh_tokens
+		where
+		    jti = $1`
+
+	var t data.RefreshToken
+	row := m.db().QueryRowContext(ctx, query, jti)
+
+	err := row.Scan(
+		&t.ID,
+		&t.UserID,
+		&t.FamilyID,
+		&t.JTI,
+		&t.ExpiresAt,
+		&t.Revoked,
+		&t.CreatedAt,
+		&t.UpdatedAt,
+	)
+
+	if err != nil {
+		return nil, translateError(err)
+	}
+
+	return &t, nil
+}
+
+// RevokeRefreshToken marks one refresh token as revoked, so that it can never be used again, and
+// returns how many tokens it revoked. None means it was unknown, or someone else has already
+// revoked it, so that two refreshes racing with the same token can't both rotate it.
+func (m *PostgresDBRepo) RevokeRefreshToken(ctx context.Context, jti string) (int64, error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	stmt := `update refresh_tokens set revoked = true, updated_at = $1 where jti = $2 and revoked = false`
+
+	result, err := m.db().ExecContext(ctx, stmt, time.Now(), jti)
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
+
+// RevokeRefreshTokenFamily revokes every refresh token in a family. We do this on logout, and
+// when an already rotated token is presented, since that means the token has been stolen.
+func (m *PostgresDBRepo) RevokeRefreshTokenFamily(ctx context.Context, familyID string) error {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	stmt := `update refresh_tokens set revoked = true, updated_at = $1 where family_id = $2 and revoked = false`
+
+	_, err := m.db().ExecContext(ctx, stmt, time.Now(), familyID)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// RevokeUserRefreshTokens revokes every refresh token a user has, in every family, so that
+// wherever they are logged in, they have to log in again.
+func (m *PostgresDBRepo) RevokeUserRefreshTokens(ctx context.Context, userID int) error {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	stmt := `update refresh_tokens set revoked = true, updated_at = $1 where user_id = $2 and not revoked`
+
+	_, err := m.db().ExecContext(ctx, stmt, time.Now(), userID)
+	if err != nil {
+		return translateError(err)
+	}
+
+	return nil
+}
@@ -0,0 +1,113 @@
+package dbrepo
+
+import (
+	"context"
+	"testingCourserWeb/pkg/data"
+	"time"
+)
+
+// InsertRefreshToken stores a newly issued refresh token, and returns the ID of the newly inserted row
+func (m *SQLiteDBRepo) InsertRefreshToken(ctx context.Context, t data.RefreshToken) (int, error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	var newID int
+	stmt := `insert into refresh_tokens (user_id, family_id, jti, expires_at, revoked, created_at, updated_at)
+		values ($1, $2, $3, $4, $5, $6, $7) returning id`
+
+	err := m.db().QueryRowContext(ctx, stmt,
+		t.UserID,
+		t.FamilyID,
+		t.JTI,
+		sqliteTime(t.ExpiresAt),
+		t.Revoked,
+		sqliteTime(time.Now()),
+		sqliteTime(time.Now()),
+	).Scan(&newID)
+
+	if err != nil {
+		return 0, translateSQLiteError(err)
+	}
+
+	return newID, nil
+}
+
+// GetRefreshToken returns one refresh token by its jti (token id)
+func (m *SQLiteDBRepo) GetRefreshToken(ctx context.Context, jti string) (*data.RefreshToken, error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	query := `
+		select
+			id, user_id, family_id, jti, expires_at, revoked, created_at, updated_at
+		from
+			refresh_tokens
+		where
+		    jti = $1`
+
+	var t data.RefreshToken
+	row := m.db().QueryRowContext(ctx, query, jti)
+
+	err := row.Scan(
+		&t.ID,
+		&t.UserID,
+		&t.FamilyID,
+		&t.JTI,
+		&t.ExpiresAt,
+		&t.Revoked,
+		&t.CreatedAt,
+		&t.UpdatedAt,
+	)
+
+	if err != nil {
+		return nil, translateSQLiteError(err)
+	}
+
+	return &t, nil
+}
+
+// RevokeRefreshToken marks one refresh token as revoked, like PostgresDBRepo.RevokeRefreshToken
+func (m *SQLiteDBRepo) RevokeRefreshToken(ctx context.Context, jti string) (int64, error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	stmt := `update refresh_tokens set revoked = true, updated_at = $1 where jti = $2 and revoked = false`
+
+	result, err := m.db().ExecContext(ctx, stmt, sqliteTime(time.Now()), jti)
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
+
+// RevokeRefreshTokenFamily revokes every refresh token in a family
+func (m *SQLiteDBRepo) RevokeRefreshTokenFamily(ctx context.Context, familyID string) error {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	stmt := `update refresh_tokens set revoked = true, updated_at = $1 where family_id = $2 and revoked = false`
+
+	_, err := m.db().ExecContext(ctx, stmt, sqliteTime(time.Now()), familyID)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// RevokeUserRefreshTokens revokes every refresh token a user has, like
+// PostgresDBRepo.RevokeUserRefreshTokens.
+func (m *SQLiteDBRepo) RevokeUserRefreshTokens(ctx context.Context, userID int) error {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	stmt := `update refresh_tokens set revoked = true, updated_at = $1 where user_id = $2 and not revoked`
+
+	_, err := m.db().ExecContext(ctx, stmt, sqliteTime(time.Now()), userID)
+	if err != nil {
+		return translateSQLiteError(err)
+	}
+
+	return nil
+}
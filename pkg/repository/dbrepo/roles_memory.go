@@ -0,0 +1,73 @@
+package dbrepo
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"testingCourserWeb/pkg/repository"
+	"time"
+)
+
+// AssignRole gives a user a role, by role name. Assigning a role the user already has does nothing.
+func (m *MemoryDBRepo) AssignRole(ctx context.Context, userID int, role string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.state.rolePermissions[role]; !ok {
+		return fmt.Errorf("%w: unknown role %s", repository.ErrNotFound, role)
+	}
+	if _, ok := m.state.users[userID]; !ok {
+		return fmt.Errorf("%w: no user %d", repository.ErrConflict, userID)
+	}
+
+	roles, ok := m.state.userRoles[userID]
+	if !ok {
+		roles = make(map[string]time.Time)
+		m.state.userRoles[userID] = roles
+	}
+	if _, ok := roles[role]; !ok {
+		roles[role] = memoryNow()
+	}
+	return nil
+}
+
+// RevokeRole takes a role away from a user, by role name.
+func (m *MemoryDBRepo) RevokeRole(ctx context.Context, userID int, role string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.state.userRoles[userID], role)
+	return nil
+}
+
+// GetUserRoles returns the names of the roles a user has.
+func (m *MemoryDBRepo) GetUserRoles(ctx context.Context, userID int) ([]string, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	roles := []string{}
+	for role := range m.state.userRoles[userID] {
+		roles = append(roles, role)
+	}
+	sort.Strings(roles)
+	return roles, nil
+}
+
+// GetUserPermissions returns the names of every permission a user has, through any of their roles.
+func (m *MemoryDBRepo) GetUserPermissions(ctx context.Context, userID int) ([]string, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	seen := make(map[string]bool)
+	permissions := []string{}
+	for role := range m.state.userRoles[userID] {
+		for _, permission := range m.state.rolePermissions[role] {
+			if !seen[permission] {
+				seen[permission] = true
+				permissions = append(permissions, permission)
+			}
+		}
+	}
+	sort.Strings(permissions)
+	return permissions, nil
+}
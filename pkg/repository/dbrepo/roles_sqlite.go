@@ -0,0 +1,106 @@
+package dbrepo
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"testingCourserWeb/pkg/repository"
+	"time"
+)
+
+// AssignRole gives a user a role, by role name. Assigning a role the user already has does nothing.
+func (m *SQLiteDBRepo) AssignRole(ctx context.Context, userID int, role string) error {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	var roleID int
+	err := m.db().QueryRowContext(ctx, `select id from roles where name = $1`, role).Scan(&roleID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return fmt.Errorf("%w: unknown role %s", repository.ErrNotFound, role)
+	}
+	if err != nil {
+		return err
+	}
+
+	stmt := `insert into user_roles (user_id, role_id, created_at)
+		values ($1, $2, $3) on conflict do nothing`
+
+	_, err = m.db().ExecContext(ctx, stmt, userID, roleID, sqliteTime(time.Now()))
+	if err != nil {
+		return translateSQLiteError(err)
+	}
+
+	return nil
+}
+
+// RevokeRole takes a role away from a user, by role name.
+func (m *SQLiteDBRepo) RevokeRole(ctx context.Context, userID int, role string) error {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	stmt := `delete from user_roles
+		where user_id = $1 and role_id in (select id from roles where name = $2)`
+
+	_, err := m.db().ExecContext(ctx, stmt, userID, role)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// GetUserRoles returns the names of the roles a user has.
+func (m *SQLiteDBRepo) GetUserRoles(ctx context.Context, userID int) ([]string, error) {
+	query := `
+		select
+			r.name
+		from
+			roles r
+			inner join user_roles ur on (ur.role_id = r.id)
+		where
+			ur.user_id = $1
+		order by r.name`
+
+	return m.selectNames(ctx, query, userID)
+}
+
+// GetUserPermissions returns the names of every permission a user has, through any of their roles.
+func (m *SQLiteDBRepo) GetUserPermissions(ctx context.Context, userID int) ([]string, error) {
+	query := `
+		select distinct
+			p.name
+		from
+			permissions p
+			inner join role_permissions rp on (rp.permission_id = p.id)
+			inner join user_roles ur on (ur.role_id = rp.role_id)
+		where
+			ur.user_id = $1
+		order by p.name`
+
+	return m.selectNames(ctx, query, userID)
+}
+
+// selectNames runs a query which returns a single text column, and returns the values.
+func (m *SQLiteDBRepo) selectNames(ctx context.Context, query string, args ...any) ([]string, error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	rows, err := m.db().QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	names := []string{}
+	for rows.Next() {
+		var name string
+		err := rows.Scan(&name)
+		if err != nil {
+			return nil, err
+		}
+		names = append(names, name)
+	}
+
+	return names, rows.Err()
+}
@@ -0,0 +1,97 @@
+package dbrepo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"testingCourserWeb/pkg/data"
+	"testingCourserWeb/pkg/repository"
+	"unicode"
+)
+
+// SearchUsers finds users whose name or email match q, best match first. Whole words and
+// word prefixes are found with the users.search tsvector; anything else that appears
+// somewhere in the name or email is found with the trigram index on users.search_text.
+func (m *PostgresDBRepo) SearchUsers(ctx context.Context, q string, limit int) ([]*data.UserSearchResult, error) {
+	terms := repository.SearchTerms(q)
+	if len(terms) == 0 {
+		return nil, errors.New("nothing to search for")
+	}
+	if limit < 1 || limit > repository.MaxListLimit {
+		return nil, fmt.Errorf("limit must be between 1 and %d", repository.MaxListLimit)
+	}
+
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	text := strings.Join(terms, " ")
+
+	query := `
+		select
+			id, email, first_name, last_name, is_admin, created_at, updated_at, disabled_at,
+			ts_rank(search, to_tsquery('simple', $1)) + similarity(search_text, $2) as rank
+		from
+			users
+		where
+			deleted_at is null and (search @@ to_tsquery('simple', $1) or search_text like $3)
+		order by
+			rank desc, id
+		limit $4`
+
+	rows, err := m.db().QueryContext(ctx, query, prefixTSQuery(terms), text, "%"+escapeLike(text)+"%", limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var results []*data.UserSearchResult
+
+	for rows.Next() {
+		var result data.UserSearchResult
+		err := rows.Scan(
+			&result.ID,
+			&result.Email,
+			&result.FirstName,
+			&result.LastName,
+			&result.IsAdmin,
+			&result.CreatedAt,
+			&result.UpdatedAt,
+			&result.DisabledAt,
+			&result.Rank,
+		)
+		if err != nil {
+			log.Println("Error scanning", err)
+			return nil, err
+		}
+
+		result.Highlights = repository.HighlightUser(&result.User, terms)
+		results = append(results, &result)
+	}
+
+	return results, rows.Err()
+}
+
+// prefixTSQuery builds a tsquery which matches words starting with every one of terms. Anything
+// which means something in tsquery syntax is dropped, so that user input can't break the query.
+func prefixTSQuery(terms []string) string {
+	var parts []string
+	for _, term := range terms {
+		term = strings.Map(func(r rune) rune {
+			if unicode.IsLetter(r) || unicode.IsDigit(r) || strings.ContainsRune("@._-", r) {
+				return r
+			}
+			return -1
+		}, term)
+		if term != "" {
+			parts = append(parts, "'"+term+"':*")
+		}
+	}
+	return strings.Join(parts, " & ")
+}
+
+// escapeLike escapes the characters which are special in a like pattern.
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
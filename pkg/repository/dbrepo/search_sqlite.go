@@ -0,0 +1,85 @@
+package dbrepo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"testingCourserWeb/pkg/data"
+	"testingCourserWeb/pkg/repository"
+)
+
+// SearchUsers finds users whose name or email contain every word of q, best match first.
+// SQLite has neither tsvector nor trigrams, so we match with like on users.search_text, and
+// rank a word which starts a name or email above one found in the middle of it.
+func (m *SQLiteDBRepo) SearchUsers(ctx context.Context, q string, limit int) ([]*data.UserSearchResult, error) {
+	terms := repository.SearchTerms(q)
+	if len(terms) == 0 {
+		return nil, errors.New("nothing to search for")
+	}
+	if limit < 1 || limit > repository.MaxListLimit {
+		return nil, fmt.Errorf("limit must be between 1 and %d", repository.MaxListLimit)
+	}
+
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	var args []any
+	arg := func(v any) string {
+		args = append(args, v)
+		return fmt.Sprintf("$%d", len(args))
+	}
+
+	var ranks []string
+	where := []string{"deleted_at is null"}
+	for _, term := range terms {
+		escaped := escapeLike(term)
+		ranks = append(ranks, fmt.Sprintf(`(case when (' ' || search_text) like %s escape '\' then 1.0 else 0.5 end)`, arg("% "+escaped+"%")))
+		where = append(where, fmt.Sprintf(`search_text like %s escape '\'`, arg("%"+escaped+"%")))
+	}
+
+	query := `
+		select
+			id, email, first_name, last_name, is_admin, created_at, updated_at, disabled_at,
+			` + strings.Join(ranks, " + ") + ` as rank
+		from
+			users
+		where
+			` + strings.Join(where, " and ") + `
+		order by
+			rank desc, id
+		limit ` + arg(limit)
+
+	rows, err := m.db().QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var results []*data.UserSearchResult
+
+	for rows.Next() {
+		var result data.UserSearchResult
+		err := rows.Scan(
+			&result.ID,
+			&result.Email,
+			&result.FirstName,
+			&result.LastName,
+			&result.IsAdmin,
+			&result.CreatedAt,
+			&result.UpdatedAt,
+			&result.DisabledAt,
+			&result.Rank,
+		)
+		if err != nil {
+			log.Println("Error scanning", err)
+			return nil, err
+		}
+
+		result.Highlights = repository.HighlightUser(&result.User, terms)
+		results = append(results, &result)
+	}
+
+	return results, rows.Err()
+}
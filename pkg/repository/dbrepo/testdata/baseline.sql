@@ -1,6 +1,7 @@
---
--- PostgreSQL database dump
---
+-- The schema and data of the first sql/users.sql, which docker-compose loaded into new databases,
+-- for testing that the migrations can take such a database over. The COPY of the admin user is an
+-- INSERT here, and the session settings pg_dump starts with are left out.
+
 CREATE TABLE public.user_images (
     id integer NOT NULL,
     user_id integer,
@@ -9,11 +10,6 @@ CREATE TABLE public.user_images (
     updated_at timestamp without time zone
 );
 
-
---
--- Name: user_images_id_seq; Type: SEQUENCE; Schema: public; Owner: -
---
-
 ALTER TABLE public.user_images ALTER COLUMN id ADD GENERATED ALWAYS AS IDENTITY (
     SEQUENCE NAME public.user_images_id_seq
     START WITH 1
@@ -23,11 +19,6 @@ ALTER TABLE public.user_images ALTER COLUMN id ADD GENERATED ALWAYS AS IDENTITY
     CACHE 1
 );
 
-
---
--- Name: users; Type: TABLE; Schema: public; Owner: -
---
-
 CREATE TABLE public.users (
     id integer NOT NULL,
     first_name character varying(255),
@@ -39,11 +30,6 @@ CREATE TABLE public.users (
     updated_at timestamp without time zone
 );
 
-
---
--- Name: users_id_seq; Type: SEQUENCE; Schema: public; Owner: -
---
-
 ALTER TABLE public.users ALTER COLUMN id ADD GENERATED ALWAYS AS IDENTITY (
     SEQUENCE NAME public.users_id_seq
     START WITH 1
@@ -53,30 +39,17 @@ ALTER TABLE public.users ALTER COLUMN id ADD GENERATED ALWAYS AS IDENTITY (
     CACHE 1
 );
 
--- Name: user_images user_images_pkey; Type: CONSTRAINT; Schema: public; Owner: -
---
+INSERT INTO public.users (id, first_name, last_name, email, password, is_admin, created_at, updated_at) OVERRIDING SYSTEM VALUE VALUES
+    (1, 'Admin', 'User', 'admin@example.com', '$2a$14$ajq8Q7fbtFRQvXpdCq7Jcuy.Rx1h/L4J60Otx.gyNLbAYctGMJ9tK', 1, '2022-08-19 00:00:00', '2022-08-19 00:00:00');
+
+SELECT pg_catalog.setval('public.user_images_id_seq', 1, false);
+SELECT pg_catalog.setval('public.users_id_seq', 1, true);
 
 ALTER TABLE ONLY public.user_images
     ADD CONSTRAINT user_images_pkey PRIMARY KEY (id);
 
-
---
--- Name: users users_pkey; Type: CONSTRAINT; Schema: public; Owner: -
---
-
 ALTER TABLE ONLY public.users
     ADD CONSTRAINT users_pkey PRIMARY KEY (id);
 
-
---
--- Name: user_images user_images_user_id_fkey; Type: FK CONSTRAINT; Schema: public; Owner: -
---
-
 ALTER TABLE ONLY public.user_images
     ADD CONSTRAINT user_images_user_id_fkey FOREIGN KEY (user_id) REFERENCES public.users(id) ON UPDATE CASCADE ON DELETE CASCADE;
-
-
---
--- PostgreSQL database dump complete
---
-
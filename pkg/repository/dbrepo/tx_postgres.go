@@ -0,0 +1,59 @@
+package dbrepo
+
+import (
+	"context"
+	"fmt"
+	"testingCourserWeb/pkg/repository"
+)
+
+// db returns what queries should run against: the transaction, if we are in one.
+func (m *PostgresDBRepo) db() dbtx {
+	if m.tx != nil {
+		return m.tx
+	}
+	return m.DB
+}
+
+// WithTx runs fn in a transaction. Everything fn does through repo is committed if fn returns
+// nil, and rolled back if it returns an error or panics. Calling WithTx on a repository which
+// is already in a transaction runs fn in that same transaction, so that operations which use
+// a transaction themselves can be part of a bigger one.
+func (m *PostgresDBRepo) WithTx(ctx context.Context, fn func(repo repository.DatabaseRepo) error) error {
+	return m.inTx(ctx, func(tx *PostgresDBRepo) error {
+		return fn(tx)
+	})
+}
+
+// inTx is WithTx for our own methods, which want the concrete repository.
+func (m *PostgresDBRepo) inTx(ctx context.Context, fn func(tx *PostgresDBRepo) error) (err error) {
+	if m.tx != nil {
+		return fn(m)
+	}
+
+	// the transaction lives as long as ctx; each query in it still gets its own timeout
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		if p := recover(); p != nil {
+			_ = tx.Rollback()
+			panic(p)
+		}
+		if err != nil {
+			_ = tx.Rollback()
+		}
+	}()
+
+	err = fn(&PostgresDBRepo{DB: m.DB, tx: tx})
+	if err != nil {
+		return err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return fmt.Errorf("committing transaction: %w", translateError(err))
+	}
+	return nil
+}
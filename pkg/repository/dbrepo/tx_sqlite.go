@@ -0,0 +1,56 @@
+package dbrepo
+
+import (
+	"context"
+	"fmt"
+	"testingCourserWeb/pkg/repository"
+)
+
+// db returns what queries should run against: the transaction, if we are in one.
+func (m *SQLiteDBRepo) db() dbtx {
+	if m.tx != nil {
+		return m.tx
+	}
+	return m.DB
+}
+
+// WithTx runs fn in a transaction, like PostgresDBRepo.WithTx. SQLite databases are opened with
+// a single connection, which the transaction holds until it is done, so fn must only use repo.
+func (m *SQLiteDBRepo) WithTx(ctx context.Context, fn func(repo repository.DatabaseRepo) error) error {
+	return m.inTx(ctx, func(tx *SQLiteDBRepo) error {
+		return fn(tx)
+	})
+}
+
+// inTx is WithTx for our own methods, which want the concrete repository.
+func (m *SQLiteDBRepo) inTx(ctx context.Context, fn func(tx *SQLiteDBRepo) error) (err error) {
+	if m.tx != nil {
+		return fn(m)
+	}
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		if p := recover(); p != nil {
+			_ = tx.Rollback()
+			panic(p)
+		}
+		if err != nil {
+			_ = tx.Rollback()
+		}
+	}()
+
+	err = fn(&SQLiteDBRepo{DB: m.DB, tx: tx})
+	if err != nil {
+		return err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return fmt.Errorf("committing transaction: %w", translateSQLiteError(err))
+	}
+	return nil
+}
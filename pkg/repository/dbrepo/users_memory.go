@@ -0,0 +1,462 @@
+package dbrepo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"golang.org/x/crypto/bcrypt"
+	"sort"
+	"strings"
+	"testingCourserWeb/pkg/data"
+	"testingCourserWeb/pkg/repository"
+	"time"
+)
+
+// memoryNow returns the current time without its monotonic clock reading, so that times
+// survive being compared with ones parsed back from a cursor.
+func memoryNow() time.Time {
+	return time.Now().Round(0)
+}
+
+func (m *MemoryDBRepo) AllUsers(ctx context.Context) ([]*data.User, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	users := m.users(func(u *data.User) bool { return u.DeletedAt == nil })
+	sort.SliceStable(users, func(i, j int) bool {
+		return users[i].LastName < users[j].LastName
+	})
+	return users, nil
+}
+
+// users returns a copy of every user keep returns true for, in id order.
+func (m *MemoryDBRepo) users(keep func(u *data.User) bool) []*data.User {
+	var users []*data.User
+	for _, u := range m.state.users {
+		u := u
+		if keep(&u) {
+			users = append(users, &u)
+		}
+	}
+	sort.Slice(users, func(i, j int) bool {
+		return users[i].ID < users[j].ID
+	})
+	return users
+}
+
+// ListUsers returns one page of users, filtered and sorted as asked, paging with a keyset like
+// PostgresDBRepo.ListUsers.
+func (m *MemoryDBRepo) ListUsers(ctx context.Context, opts repository.ListOptions) ([]*data.User, repository.Page, error) {
+	if err := opts.Normalize(); err != nil {
+		return nil, repository.Page{}, err
+	}
+
+	var after *data.User
+	if opts.After != "" {
+		cursor, _ := opts.Cursor()
+		after = cursorUser(cursor)
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	// before reports whether a comes before b in the order asked for
+	before := func(a, b *data.User) bool {
+		c := compareUsers(a, b, opts.Sort)
+		if c == 0 {
+			c = compareUsers(a, b, "id")
+		}
+		if opts.Desc {
+			return c > 0
+		}
+		return c < 0
+	}
+
+	users := m.users(func(u *data.User) bool {
+		if (u.DeletedAt != nil) != opts.Deleted {
+			return false
+		}
+		if opts.Email != "" && !strings.EqualFold(u.Email, opts.Email) {
+			return false
+		}
+		if opts.IsAdmin != nil && (u.IsAdmin == 1) != *opts.IsAdmin {
+			return false
+		}
+		if opts.CreatedAfter != nil && !u.CreatedAt.After(*opts.CreatedAfter) {
+			return false
+		}
+		return after == nil || before(after, u)
+	})
+	sort.Slice(users, func(i, j int) bool {
+		return before(users[i], users[j])
+	})
+
+	// keep one more than the limit, so that NewPage knows whether there is another page
+	if len(users) > opts.Limit+1 {
+		users = users[:opts.Limit+1]
+	}
+
+	users, page := repository.NewPage(users, opts)
+	return users, page, nil
+}
+
+// cursorUser returns a user holding the cursor's position, to compare other users with.
+func cursorUser(c *repository.Cursor) *data.User {
+	u := &data.User{ID: c.ID}
+	value, _ := c.SortValue()
+
+	switch c.Sort {
+	case "id":
+		u.ID = value.(int)
+	case "email":
+		u.Email = c.Value
+	case "first_name":
+		u.FirstName = c.Value
+	case "last_name":
+		u.LastName = c.Value
+	case "is_admin":
+		u.IsAdmin = value.(int)
+	case "created_at":
+		u.CreatedAt = value.(time.Time)
+	case "updated_at":
+		u.UpdatedAt = value.(time.Time)
+	}
+	return u
+}
+
+// compareUsers compares a and b by column, returning -1, 0 or 1.
+func compareUsers(a, b *data.User, column string) int {
+	switch column {
+	case "id":
+		return compareInts(a.ID, b.ID)
+	case "email":
+		return strings.Compare(a.Email, b.Email)
+	case "first_name":
+		return strings.Compare(a.FirstName, b.FirstName)
+	case "last_name":
+		return strings.Compare(a.LastName, b.LastName)
+	case "is_admin":
+		return compareInts(a.IsAdmin, b.IsAdmin)
+	case "created_at":
+		return compareTimes(a.CreatedAt, b.CreatedAt)
+	case "updated_at":
+		return compareTimes(a.UpdatedAt, b.UpdatedAt)
+	}
+	return 0
+}
+
+func compareInts(a, b int) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+func compareTimes(a, b time.Time) int {
+	switch {
+	case a.Before(b):
+		return -1
+	case a.After(b):
+		return 1
+	}
+	return 0
+}
+
+// SearchUsers finds users whose name or email contain every word of q, best match first,
+// ranking them the way SQLiteDBRepo.SearchUsers does.
+func (m *MemoryDBRepo) SearchUsers(ctx context.Context, q string, limit int) ([]*data.UserSearchResult, error) {
+	terms := repository.SearchTerms(q)
+	if len(terms) == 0 {
+		return nil, errors.New("nothing to search for")
+	}
+	if limit < 1 || limit > repository.MaxListLimit {
+		return nil, fmt.Errorf("limit must be between 1 and %d", repository.MaxListLimit)
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var results []*data.UserSearchResult
+	for _, u := range m.users(func(u *data.User) bool { return u.DeletedAt == nil }) {
+		text := strings.ToLower(u.FirstName + " " + u.LastName + " " + u.Email)
+
+		result := data.UserSearchResult{User: *u}
+		for _, term := range terms {
+			if !strings.Contains(text, term) {
+				result.Rank = 0
+				break
+			}
+			if strings.Contains(" "+text, " "+term) {
+				result.Rank += 1
+			} else {
+				result.Rank += 0.5
+			}
+		}
+		if result.Rank == 0 {
+			continue
+		}
+
+		result.Password = ""
+		result.Highlights = repository.HighlightUser(&result.User, terms)
+		results = append(results, &result)
+	}
+
+	sort.SliceStable(results, func(i, j int) bool {
+		return results[i].Rank > results[j].Rank
+	})
+	if len(results) > limit {
+		results = results[:limit]
+	}
+	return results, nil
+}
+
+// GetUser returns one user by id
+func (m *MemoryDBRepo) GetUser(ctx context.Context, id int) (*data.User, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	u, ok := m.user(id)
+	if !ok {
+		return nil, repository.ErrNotFound
+	}
+	return m.withProfilePic(u), nil
+}
+
+// GetUserByEmail returns one user by email address
+func (m *MemoryDBRepo) GetUserByEmail(ctx context.Context, email string) (*data.User, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	for _, u := range m.state.users {
+		if u.Email == email && u.DeletedAt == nil {
+			return m.withProfilePic(u), nil
+		}
+	}
+	return nil, repository.ErrNotFound
+}
+
+// user returns the user with id, unless there is none, or they have been deleted.
+func (m *MemoryDBRepo) user(id int) (data.User, bool) {
+	u, ok := m.state.users[id]
+	if !ok || u.DeletedAt != nil {
+		return data.User{}, false
+	}
+	return u, true
+}
+
+// withProfilePic returns a copy of u, with the file name of their profile image filled in.
+func (m *MemoryDBRepo) withProfilePic(u data.User) *data.User {
+	if image, ok := m.state.images[u.ID]; ok {
+		u.ProfilePic.FileName = image.FileName
+	}
+	return &u
+}
+
+// emailTaken reports whether a user other than id has email. Deleted users keep their email,
+// as they do in the database.
+func (m *MemoryDBRepo) emailTaken(email string, id int) bool {
+	for _, u := range m.state.users {
+		if u.Email == email && u.ID != id {
+			return true
+		}
+	}
+	return false
+}
+
+// UpdateUser updates one user
+func (m *MemoryDBRepo) UpdateUser(ctx context.Context, u data.User) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	existing, ok := m.user(u.ID)
+	if !ok {
+		return repository.ErrNotFound
+	}
+	if u.Version != 0 && u.Version != existing.Version {
+		return repository.ErrVersionMismatch
+	}
+	if m.emailTaken(u.Email, u.ID) {
+		return repository.ErrDuplicateEmail
+	}
+
+	before := m.snapshot(u.ID)
+	existing.Email = u.Email
+	existing.FirstName = u.FirstName
+	existing.LastName = u.LastName
+	existing.IsAdmin = u.IsAdmin
+	existing.UpdatedAt = memoryNow()
+	existing.Version++
+	m.state.users[u.ID] = existing
+	m.recordChange(ctx, u.ID, data.ActionUpdate, before)
+	return nil
+}
+
+// DeleteUser soft deletes one user, by id, and revokes their refresh tokens.
+func (m *MemoryDBRepo) DeleteUser(ctx context.Context, id int, version int) error {
+	return m.setUserStatus(ctx, data.ActionDelete, id, version, func(u *data.User, now time.Time) {
+		u.DeletedAt = &now
+	})
+}
+
+// DeactivateUser stops one user from logging in, and revokes their refresh tokens.
+func (m *MemoryDBRepo) DeactivateUser(ctx context.Context, id int) error {
+	return m.setUserStatus(ctx, data.ActionDeactivate, id, 0, func(u *data.User, now time.Time) {
+		if u.DisabledAt == nil {
+			u.DisabledAt = &now
+		}
+	})
+}
+
+// setUserStatus changes the user with id with set, if they are at version (or version is 0),
+// revokes their refresh tokens, and records action in their history.
+func (m *MemoryDBRepo) setUserStatus(ctx context.Context, action string, id int, version int, set func(u *data.User, now time.Time)) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	u, ok := m.user(id)
+	if !ok {
+		return repository.ErrNotFound
+	}
+	if version != 0 && version != u.Version {
+		return repository.ErrVersionMismatch
+	}
+
+	before := m.snapshot(id)
+	now := memoryNow()
+	set(&u, now)
+	u.UpdatedAt = now
+	u.Version++
+	m.state.users[id] = u
+
+	m.revokeUserRefreshTokens(id, now)
+	m.recordChange(ctx, id, action, before)
+	return nil
+}
+
+// RestoreUser undoes DeleteUser and DeactivateUser.
+func (m *MemoryDBRepo) RestoreUser(ctx context.Context, id int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	u, ok := m.state.users[id]
+	if !ok || (u.DeletedAt == nil && u.DisabledAt == nil) {
+		return repository.ErrNotFound
+	}
+
+	before := m.snapshot(id)
+	u.DeletedAt, u.DisabledAt = nil, nil
+	u.UpdatedAt = memoryNow()
+	u.Version++
+	m.state.users[id] = u
+	m.recordChange(ctx, id, data.ActionRestore, before)
+	return nil
+}
+
+// PurgeUser deletes one user for good, by id, along with their refresh tokens, roles, profile
+// image, history, two-factor authentication and password resets.
+func (m *MemoryDBRepo) PurgeUser(ctx context.Context, id int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.state.users[id]; !ok {
+		return repository.ErrNotFound
+	}
+
+	for jti, t := range m.state.refreshTokens {
+		if t.UserID == id {
+			delete(m.state.refreshTokens, jti)
+		}
+	}
+	delete(m.state.userRoles, id)
+	delete(m.state.images, id)
+	delete(m.state.mfa, id)
+	delete(m.state.recoveryCodes, id)
+	for hash, r := range m.state.passwordResets {
+		if r.UserID == id {
+			delete(m.state.passwordResets, hash)
+		}
+	}
+	delete(m.state.users, id)
+
+	var history []data.UserHistory
+	for _, h := range m.state.history {
+		if h.UserID != id {
+			history = append(history, h)
+		}
+	}
+	m.state.history = history
+	return nil
+}
+
+// InsertUser inserts a new user, and returns their ID. The password is hashed, as it would be
+// by the database repositories.
+func (m *MemoryDBRepo) InsertUser(ctx context.Context, user data.User) (int, error) {
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(user.Password), 12)
+	if err != nil {
+		return 0, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.emailTaken(user.Email, 0) {
+		return 0, repository.ErrDuplicateEmail
+	}
+
+	now := memoryNow()
+	user.ID = m.state.nextID("users")
+	user.Password = string(hashedPassword)
+	user.CreatedAt, user.UpdatedAt = now, now
+	user.ProfilePic = data.UserImage{}
+	user.DeletedAt, user.DisabledAt = nil, nil
+	user.Version = 1
+	m.state.users[user.ID] = user
+	m.recordChange(ctx, user.ID, data.ActionInsert, nil)
+
+	return user.ID, nil
+}
+
+// ResetPassword is the method we will use to change a user's password.
+func (m *MemoryDBRepo) ResetPassword(ctx context.Context, id int, password string) error {
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), 12)
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	u, ok := m.user(id)
+	if !ok {
+		return repository.ErrNotFound
+	}
+	before := m.snapshot(id)
+	u.Password = string(hashedPassword)
+	u.UpdatedAt = memoryNow()
+	u.Version++
+	m.state.users[id] = u
+	m.recordChange(ctx, id, data.ActionResetPassword, before)
+	return nil
+}
+
+// InsertUserImage stores a user profile image, replacing the one the user already has.
+func (m *MemoryDBRepo) InsertUserImage(ctx context.Context, i data.UserImage) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.state.users[i.UserID]; !ok {
+		return 0, fmt.Errorf("%w: no user %d", repository.ErrConflict, i.UserID)
+	}
+
+	before := m.snapshot(i.UserID)
+	now := memoryNow()
+	i.ID = m.state.nextID("user_images")
+	i.CreatedAt, i.UpdatedAt = now, now
+	m.state.images[i.UserID] = i
+	m.recordChange(ctx, i.UserID, data.ActionImage, before)
+
+	return i.ID, nil
+}
@@ -0,0 +1,139 @@
+package dbrepo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"testingCourserWeb/pkg/data"
+	"testingCourserWeb/pkg/repository"
+	"testingCourserWeb/pkg/repository/repotest"
+)
+
+// insertMemoryUser inserts a user, and fails the test if it can't.
+func insertMemoryUser(t *testing.T, repo *MemoryDBRepo, first, last, email string) int {
+	t.Helper()
+
+	id, err := repo.InsertUser(context.Background(), data.User{FirstName: first, LastName: last, Email: email, Password: "secret"})
+	if err != nil {
+		t.Fatalf("inserting %s: %s", email, err)
+	}
+	return id
+}
+
+func TestMemoryDBRepoConformance(t *testing.T) {
+	repotest.RunConformance(t, func() repository.DatabaseRepo {
+		return NewMemoryDBRepo()
+	})
+}
+
+func TestMemoryDBRepoSeed(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "fixtures.json")
+	err := os.WriteFile(path, []byte(`{
+		"users": [
+			{"id": 1, "first_name": "Admin", "last_name": "User", "email": "admin@example.com", "password": "secret", "is_admin": 1, "roles": ["admin"]},
+			{"id": 5, "first_name": "Jack", "last_name": "Smith", "email": "jack@smith.com", "password": "$2a$14$ajq8Q7fbtFRQvXpdCq7Jcuy.Rx1h/L4J60Otx.gyNLbAYctGMJ9tK", "profile_pic": "jack.png"}
+		],
+		"refresh_tokens": [
+			{"user_id": 5, "family_id": "family", "jti": "rotated", "expires_at": "2100-01-01T00:00:00Z", "revoked": true}
+		]
+	}`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fixtures, err := ReadFixtures(path)
+	if err != nil {
+		t.Fatalf("unexpected error reading fixtures: %s", err)
+	}
+	repo := NewMemoryDBRepo()
+	err = repo.Seed(*fixtures)
+	if err != nil {
+		t.Fatalf("unexpected error seeding: %s", err)
+	}
+
+	admin, _ := repo.GetUser(ctx, 1)
+	if matches, _ := admin.PasswordMatches("secret"); !matches {
+		t.Error("expected the plain text password to be hashed and stored")
+	}
+	permissions, _ := repo.GetUserPermissions(ctx, 1)
+	if len(permissions) != 5 {
+		t.Errorf("expected the admin to have every permission, but got %v", permissions)
+	}
+
+	jack, err := repo.GetUser(ctx, 5)
+	if err != nil {
+		t.Fatalf("expected the fixture's id to be kept, but got %s", err)
+	}
+	if jack.Password != "$2a$14$ajq8Q7fbtFRQvXpdCq7Jcuy.Rx1h/L4J60Otx.gyNLbAYctGMJ9tK" {
+		t.Errorf("expected the hashed password to be stored as it is, but got %s", jack.Password)
+	}
+	if jack.ProfilePic.FileName != "jack.png" {
+		t.Errorf("expected a profile pic of jack.png, but got %q", jack.ProfilePic.FileName)
+	}
+	token, err := repo.GetRefreshToken(ctx, "rotated")
+	if err != nil || !token.Revoked {
+		t.Errorf("expected a revoked token, but got %v, %v", token, err)
+	}
+
+	if id := insertMemoryUser(t, repo, "Jill", "Smith", "jill@smith.com"); id != 6 {
+		t.Errorf("expected ids to carry on after the fixtures, but got %d", id)
+	}
+
+	// seeding is all or nothing
+	err = repo.Seed(Fixtures{Users: []FixtureUser{
+		{ID: 10, FirstName: "New", LastName: "User", Email: "new@example.com", Password: "secret"},
+		{ID: 11, FirstName: "Admin", LastName: "Again", Email: "admin@example.com", Password: "secret"},
+	}})
+	if !errors.Is(err, repository.ErrDuplicateEmail) {
+		t.Errorf("expected ErrDuplicateEmail, but got %v", err)
+	}
+	_, err = repo.GetUser(ctx, 10)
+	if !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("expected nothing to be seeded, but got %v", err)
+	}
+}
+
+func TestMemoryDBRepoConcurrentWrites(t *testing.T) {
+	repo := NewMemoryDBRepo()
+	ctx := context.Background()
+	id := insertMemoryUser(t, repo, "Admin", "User", "admin@example.com")
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	duplicates := 0
+
+	// everybody tries for the same email; hashing is slow, so only a few of these
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := repo.InsertUser(ctx, data.User{FirstName: "Same", LastName: "User", Email: "same@example.com", Password: "secret"})
+			if errors.Is(err, repository.ErrDuplicateEmail) {
+				mu.Lock()
+				duplicates++
+				mu.Unlock()
+			}
+		}()
+	}
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_ = repo.UpdateUser(ctx, data.User{ID: id, FirstName: fmt.Sprintf("Admin %d", i), LastName: "User", Email: "admin@example.com"})
+			_ = repo.AssignRole(ctx, id, "support")
+			_, _ = repo.GetUserPermissions(ctx, id)
+			_, _, _ = repo.ListUsers(ctx, repository.ListOptions{})
+		}(i)
+	}
+	wg.Wait()
+
+	users, _ := repo.AllUsers(ctx)
+	if len(users) != 2 || duplicates != 2 {
+		t.Errorf("expected one insert to win, but got %d users and %d duplicates", len(users), duplicates)
+	}
+}
@@ -3,30 +3,32 @@ package dbrepo
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"golang.org/x/crypto/bcrypt"
 	"log"
 	"testingCourserWeb/pkg/data"
+	"testingCourserWeb/pkg/repository"
 	"time"
 )
 
-const dbTimeout = time.Second * 3
-
 type PostgresDBRepo struct {
 	DB *sql.DB
+	// tx is set on the copy of the repository WithTx hands out
+	tx *sql.Tx
 }
 
 func (m *PostgresDBRepo) Connection() *sql.DB {
 	return m.DB
 }
 
-func (m *PostgresDBRepo) AllUsers() ([]*data.User, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+func (m *PostgresDBRepo) AllUsers(ctx context.Context) ([]*data.User, error) {
+	ctx, cancel := withTimeout(ctx)
 	defer cancel()
 
-	query := `select id, email, first_name, last_name, password, is_admin, created_at, updated_at
-	from users order by last_name`
+	query := `select id, email, first_name, last_name, password, is_admin, created_at, updated_at, disabled_at
+	from users where deleted_at is null order by last_name`
 
-	rows, err := m.DB.QueryContext(ctx, query)
+	rows, err := m.db().QueryContext(ctx, query)
 	if err != nil {
 		return nil, err
 	}
@@ -45,6 +47,7 @@ func (m *PostgresDBRepo) AllUsers() ([]*data.User, error) {
 			&user.IsAdmin,
 			&user.CreatedAt,
 			&user.UpdatedAt,
+			&user.DisabledAt,
 		)
 		if err != nil {
 			log.Println("Error scanning", err)
@@ -58,22 +61,22 @@ func (m *PostgresDBRepo) AllUsers() ([]*data.User, error) {
 }
 
 // GetUser returns one user by id
-func (m *PostgresDBRepo) GetUser(id int) (*data.User, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+func (m *PostgresDBRepo) GetUser(ctx context.Context, id int) (*data.User, error) {
+	ctx, cancel := withTimeout(ctx)
 	defer cancel()
 
 	query := `
 		select 
-			u.id, u.email, u.first_name, u.last_name, u.password, u.is_admin, u.created_at, u.updated_at,
+			u.id, u.email, u.first_name, u.last_name, u.password, u.is_admin, u.created_at, u.updated_at, u.disabled_at, u.version,
 			coalesce(ui.file_name, '')
 		from 
 			users u
 			left join user_images ui on (ui.user_id = u.id)
 		where 
-		    u.id = $1`
+		    u.id = $1 and u.deleted_at is null`
 
 	var user data.User
-	row := m.DB.QueryRowContext(ctx, query, id)
+	row := m.db().QueryRowContext(ctx, query, id)
 
 	err := row.Scan(
 		&user.ID,
@@ -84,33 +87,35 @@ func (m *PostgresDBRepo) GetUser(id int) (*data.User, error) {
 		&user.IsAdmin,
 		&user.CreatedAt,
 		&user.UpdatedAt,
+		&user.DisabledAt,
+		&user.Version,
 		&user.ProfilePic.FileName,
 	)
 
 	if err != nil {
-		return nil, err
+		return nil, translateError(err)
 	}
 
 	return &user, nil
 }
 
 // GetUserByEmail returns one user by email address
-func (m *PostgresDBRepo) GetUserByEmail(email string) (*data.User, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+func (m *PostgresDBRepo) GetUserByEmail(ctx context.Context, email string) (*data.User, error) {
+	ctx, cancel := withTimeout(ctx)
 	defer cancel()
 
 	query := `
 		select 
-			u.id, u.email, u.first_name, u.last_name, u.password, u.is_admin, u.created_at, u.updated_at,
+			u.id, u.email, u.first_name, u.last_name, u.password, u.is_admin, u.created_at, u.updated_at, u.disabled_at, u.version,
 			coalesce(ui.file_name, '')
 		from 
 			users u
 			left join user_images ui on (ui.user_id = u.id)
 		where 
-		    u.email = $1`
+		    u.email = $1 and u.deleted_at is null`
 
 	var user data.User
-	row := m.DB.QueryRowContext(ctx, query, email)
+	row := m.db().QueryRowContext(ctx, query, email)
 
 	err := row.Scan(
 		&user.ID,
@@ -121,84 +126,215 @@ func (m *PostgresDBRepo) GetUserByEmail(email string) (*data.User, error) {
 		&user.IsAdmin,
 		&user.CreatedAt,
 		&user.UpdatedAt,
+		&user.DisabledAt,
+		&user.Version,
 		&user.ProfilePic.FileName,
 	)
 
 	if err != nil {
-		return nil, err
+		return nil, translateError(err)
 	}
 
 	return &user, nil
 }
 
-// UpdateUser updates one user in the database
-func (m *PostgresDBRepo) UpdateUser(u data.User) error {
-	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
-	defer cancel()
+// UpdateUser updates one user in the database, and records the change in their history
+func (m *PostgresDBRepo) UpdateUser(ctx context.Context, u data.User) error {
+	return m.inTx(ctx, func(tx *PostgresDBRepo) error {
+		ctx, cancel := withTimeout(ctx)
+		defer cancel()
 
-	stmt := `update users set
-		email = $1,
-		first_name = $2,
-		last_name = $3,
-		is_admin = $4,
-		updated_at = $5
-		where id = $6
-	`
-
-	_, err := m.DB.ExecContext(ctx, stmt,
-		u.Email,
-		u.FirstName,
-		u.LastName,
-		u.IsAdmin,
-		time.Now(),
-		u.ID,
-	)
+		before, err := tx.snapshot(ctx, u.ID)
+		if err != nil {
+			return err
+		}
+
+		stmt := `update users set
+			email = $1,
+			first_name = $2,
+			last_name = $3,
+			is_admin = $4,
+			updated_at = $5,
+			version = version + 1
+			where id = $6 and deleted_at is null and ($7 = 0 or version = $7)
+		`
+
+		result, err := tx.db().ExecContext(ctx, stmt,
+			u.Email,
+			u.FirstName,
+			u.LastName,
+			u.IsAdmin,
+			time.Now(),
+			u.ID,
+			u.Version,
+		)
+
+		if err != nil {
+			return translateError(err)
+		}
+
+		err = expectRows(result)
+		if errors.Is(err, repository.ErrNotFound) && u.Version != 0 {
+			return tx.notUpdated(ctx, u.ID)
+		}
+		if err != nil {
+			return err
+		}
 
+		return tx.recordChange(ctx, u.ID, data.ActionUpdate, before)
+	})
+}
+
+// notUpdated works out why an update of user id which expected a version changed nothing:
+// either there is no such user, or they are at another version.
+func (m *PostgresDBRepo) notUpdated(ctx context.Context, id int) error {
+	var version int
+	err := m.db().QueryRowContext(ctx, `select version from users where id = $1 and deleted_at is null`, id).Scan(&version)
 	if err != nil {
-		return err
+		return translateError(err)
 	}
+	return repository.ErrVersionMismatch
+}
 
-	return nil
+// DeleteUser soft deletes one user, by id: they stay in the database, with deleted_at set, so
+// that they can be restored, but nothing finds them any more. Their refresh tokens are revoked
+// in the same transaction, so that they are logged out everywhere.
+func (m *PostgresDBRepo) DeleteUser(ctx context.Context, id int, version int) error {
+	return m.setUserStatus(ctx, data.ActionDelete, `update users set deleted_at = $1, updated_at = $1, version = version + 1
+		where id = $2 and deleted_at is null and ($3 = 0 or version = $3)`, id, version)
 }
 
-// DeleteUser deletes one user from the database, by id
-func (m *PostgresDBRepo) DeleteUser(id int) error {
-	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
-	defer cancel()
+// DeactivateUser stops one user from logging in, and revokes their refresh tokens. Deactivating
+// a user who already is keeps the time they were first deactivated.
+func (m *PostgresDBRepo) DeactivateUser(ctx context.Context, id int) error {
+	return m.setUserStatus(ctx, data.ActionDeactivate, `update users set disabled_at = coalesce(disabled_at, $1), updated_at = $1, version = version + 1
+		where id = $2 and deleted_at is null and ($3 = 0 or version = $3)`, id, 0)
+}
 
-	stmt := `delete from users where id = $1`
+// setUserStatus runs stmt, which takes the time, the user's id and the version expected, revokes
+// the user's refresh tokens, and records action in their history, in one transaction.
+func (m *PostgresDBRepo) setUserStatus(ctx context.Context, action string, stmt string, id int, version int) error {
+	return m.inTx(ctx, func(tx *PostgresDBRepo) error {
+		ctx, cancel := withTimeout(ctx)
+		defer cancel()
 
-	_, err := m.DB.ExecContext(ctx, stmt, id)
-	if err != nil {
-		return err
-	}
+		before, err := tx.snapshot(ctx, id)
+		if err != nil {
+			return err
+		}
+
+		result, err := tx.db().ExecContext(ctx, stmt, time.Now(), id, version)
+		if err != nil {
+			return translateError(err)
+		}
+		err = expectRows(result)
+		if errors.Is(err, repository.ErrNotFound) && version != 0 {
+			return tx.notUpdated(ctx, id)
+		}
+		if err != nil {
+			return err
+		}
 
-	return nil
+		err = tx.RevokeUserRefreshTokens(ctx, id)
+		if err != nil {
+			return err
+		}
+
+		return tx.recordChange(ctx, id, action, before)
+	})
 }
 
-// InsertUser inserts a new user into the database, and returns the ID of the newly inserted row
-func (m *PostgresDBRepo) InsertUser(user data.User) (int, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
-	defer cancel()
+// RestoreUser undoes DeleteUser and DeactivateUser. Refresh tokens stay revoked, so the user has
+// to log in again.
+func (m *PostgresDBRepo) RestoreUser(ctx context.Context, id int) error {
+	return m.inTx(ctx, func(tx *PostgresDBRepo) error {
+		ctx, cancel := withTimeout(ctx)
+		defer cancel()
+
+		before, err := tx.snapshot(ctx, id)
+		if err != nil {
+			return err
+		}
+
+		stmt := `update users set deleted_at = null, disabled_at = null, updated_at = $1, version = version + 1
+			where id = $2 and (deleted_at is not null or disabled_at is not null)`
+
+		result, err := tx.db().ExecContext(ctx, stmt, time.Now(), id)
+		if err != nil {
+			return translateError(err)
+		}
+		if err := expectRows(result); err != nil {
+			return err
+		}
+
+		return tx.recordChange(ctx, id, data.ActionRestore, before)
+	})
+}
+
+// PurgeUser deletes one user from the database for good, by id, along with their refresh tokens,
+// roles, profile image, history, two-factor authentication and password resets. The foreign keys
+// would cascade anyway, but we delete them ourselves, in one transaction, so that nothing about
+// the user is left behind if the schema ever changes.
+func (m *PostgresDBRepo) PurgeUser(ctx context.Context, id int) error {
+	return m.inTx(ctx, func(tx *PostgresDBRepo) error {
+		ctx, cancel := withTimeout(ctx)
+		defer cancel()
+
+		for _, stmt := range []string{
+			`delete from refresh_tokens where user_id = $1`,
+			`delete from user_roles where user_id = $1`,
+			`delete from user_images where user_id = $1`,
+			`delete from user_history where user_id = $1`,
+			`delete from recovery_codes where user_id = $1`,
+			`delete from user_mfa where user_id = $1`,
+			`delete from password_resets where user_id = $1`,
+		} {
+			_, err := tx.db().ExecContext(ctx, stmt, id)
+			if err != nil {
+				return translateError(err)
+			}
+		}
+
+		result, err := tx.db().ExecContext(ctx, `delete from users where id = $1`, id)
+		if err != nil {
+			return translateError(err)
+		}
+
+		return expectRows(result)
+	})
+}
 
+// InsertUser inserts a new user into the database, and returns the ID of the newly inserted row
+func (m *PostgresDBRepo) InsertUser(ctx context.Context, user data.User) (int, error) {
 	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(user.Password), 12)
 	if err != nil {
 		return 0, err
 	}
 
 	var newID int
-	stmt := `insert into users (email, first_name, last_name, password, is_admin, created_at, updated_at)
-		values ($1, $2, $3, $4, $5, $6, $7) returning id`
-
-	err = m.DB.QueryRowContext(ctx, stmt,
-		user.Email,
-		user.FirstName,
-		user.LastName,
-		hashedPassword,
-		user.IsAdmin,
-		time.Now(),
-		time.Now(),
-	).Scan(&newID)
+	err = m.inTx(ctx, func(tx *PostgresDBRepo) error {
+		ctx, cancel := withTimeout(ctx)
+		defer cancel()
+
+		stmt := `insert into users (email, first_name, last_name, password, is_admin, created_at, updated_at)
+			values ($1, $2, $3, $4, $5, $6, $7) returning id`
+
+		err := tx.db().QueryRowContext(ctx, stmt,
+			user.Email,
+			user.FirstName,
+			user.LastName,
+			hashedPassword,
+			user.IsAdmin,
+			time.Now(),
+			time.Now(),
+		).Scan(&newID)
+
+		if err != nil {
+			return translateError(err)
+		}
+
+		return tx.recordChange(ctx, newID, data.ActionInsert, nil)
+	})
 
 	if err != nil {
 		return 0, err
@@ -207,43 +343,73 @@ func (m *PostgresDBRepo) InsertUser(user data.User) (int, error) {
 	return newID, nil
 }
 
-// ResetPassword is the method we will use to change a user's password.
-func (m *PostgresDBRepo) ResetPassword(id int, password string) error {
-	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
-	defer cancel()
-
+// ResetPassword is the method we will use to change a user's password. The history records
+// that it was reset, but not what to.
+func (m *PostgresDBRepo) ResetPassword(ctx context.Context, id int, password string) error {
 	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), 12)
 	if err != nil {
 		return err
 	}
 
-	stmt := `update users set password = $1 where id = $2`
-	_, err = m.DB.ExecContext(ctx, stmt, hashedPassword, id)
-	if err != nil {
-		return err
-	}
+	return m.inTx(ctx, func(tx *PostgresDBRepo) error {
+		ctx, cancel := withTimeout(ctx)
+		defer cancel()
 
-	return nil
-}
+		before, err := tx.snapshot(ctx, id)
+		if err != nil {
+			return err
+		}
 
-// InsertUserImage inserts a user profile image into the database.
-func (m *PostgresDBRepo) InsertUserImage(i data.UserImage) (int, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
-	defer cancel()
+		stmt := `update users set password = $1, updated_at = $2, version = version + 1 where id = $3 and deleted_at is null`
+		result, err := tx.db().ExecContext(ctx, stmt, hashedPassword, time.Now(), id)
+		if err != nil {
+			return translateError(err)
+		}
+		if err := expectRows(result); err != nil {
+			return err
+		}
 
-	stmt := `delete from user_images where user_id = $1`
-	_, err := m.DB.ExecContext(ctx, stmt, i.UserID)
+		return tx.recordChange(ctx, id, data.ActionResetPassword, before)
+	})
+}
 
+// InsertUserImage inserts a user profile image into the database, replacing the one the user
+// already has. The delete and insert happen in one transaction, so a failed insert leaves the
+// old image in place.
+func (m *PostgresDBRepo) InsertUserImage(ctx context.Context, i data.UserImage) (int, error) {
 	var newID int
-	stmt = `insert into user_images (user_id, file_name, created_at, updated_at)
-		values ($1, $2, $3, $4) returning id`
-
-	err = m.DB.QueryRowContext(ctx, stmt,
-		i.UserID,
-		i.FileName,
-		time.Now(),
-		time.Now(),
-	).Scan(&newID)
+
+	err := m.inTx(ctx, func(tx *PostgresDBRepo) error {
+		ctx, cancel := withTimeout(ctx)
+		defer cancel()
+
+		before, err := tx.snapshot(ctx, i.UserID)
+		if err != nil {
+			return err
+		}
+
+		stmt := `delete from user_images where user_id = $1`
+		_, err = tx.db().ExecContext(ctx, stmt, i.UserID)
+		if err != nil {
+			return translateError(err)
+		}
+
+		stmt = `insert into user_images (user_id, file_name, created_at, updated_at)
+			values ($1, $2, $3, $4) returning id`
+
+		err = tx.db().QueryRowContext(ctx, stmt,
+			i.UserID,
+			i.FileName,
+			time.Now(),
+			time.Now(),
+		).Scan(&newID)
+
+		if err != nil {
+			return translateError(err)
+		}
+
+		return tx.recordChange(ctx, i.UserID, data.ActionImage, before)
+	})
 
 	if err != nil {
 		return 0, err
@@ -3,7 +3,9 @@
 package dbrepo
 
 import (
+	"context"
 	"database/sql"
+	"errors"
 	"fmt"
 	_ "github.com/jackc/pgconn"
 	_ "github.com/jackc/pgx/v4"
@@ -14,7 +16,10 @@ import (
 	"os"
 	"testing"
 	"testingCourserWeb/pkg/data"
+	"testingCourserWeb/pkg/migrations"
+	"testingCourserWeb/pkg/ratelimit"
 	"testingCourserWeb/pkg/repository"
+	"testingCourserWeb/pkg/repository/repotest"
 	"time"
 )
 
@@ -79,7 +84,7 @@ func TestMain(m *testing.M) {
 		log.Fatalf("could not connect to database: %s", err)
 	}
 
-	// populate the database with empty tables
+	// populate the database with empty tables, by applying every migration
 	err = createTables()
 	if err != nil {
 		log.Fatalf("error creating tables: %s", err)
@@ -99,13 +104,12 @@ func TestMain(m *testing.M) {
 }
 
 func createTables() error {
-	tableSQL, err := os.ReadFile("./testdata/users.sql")
+	m, err := migrations.New(testDB, migrations.Postgres)
 	if err != nil {
-		fmt.Println(err)
 		return err
 	}
 
-	_, err = testDB.Exec(string(tableSQL))
+	_, err = m.Up(context.Background())
 	if err != nil {
 		fmt.Println(err)
 		return err
@@ -114,6 +118,13 @@ func createTables() error {
 	return nil
 }
 
+// emptyTables deletes every user, and everything belonging to them, leaving the roles and
+// permissions the migrations created.
+func emptyTables() error {
+	_, err := testDB.Exec(`truncate users, user_images, refresh_tokens, user_roles, user_history, audit_events, login_failures, rate_limit_buckets, rate_limit_windows, user_mfa, recovery_codes, password_resets restart identity cascade`)
+	return err
+}
+
 func Test_pingDB(t *testing.T) {
 	err := testDB.Ping()
 	if err != nil {
@@ -121,149 +132,204 @@ func Test_pingDB(t *testing.T) {
 	}
 }
 
-func TestPostgresDBRepoInsertUser(t *testing.T) {
-	testUser := data.User{
-		FirstName: "Admin",
-		LastName:  "User",
-		Email:     "admin@example.com",
-		Password:  "secret",
-		IsAdmin:   1,
-		CreatedAt: time.Now(),
-	}
+func TestPostgresDBRepoConformance(t *testing.T) {
+	repotest.RunConformance(t, func() repository.DatabaseRepo {
+		err := emptyTables()
+		if err != nil {
+			t.Fatalf("error emptying tables: %s", err)
+		}
+		return testRepo
+	})
+}
 
-	id, err := testRepo.InsertUser(testUser)
-	if err != nil {
-		t.Errorf("insert user returned an error: %s", err)
-	}
+func TestPostgresDBRepoContext(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
 
-	if id != 1 {
-		t.Errorf("insert user returned wrond id: expected 1, but got %d", id)
+	_, err := testRepo.GetUser(ctx, 1)
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("expected a cancelled context to stop the query, but got %v", err)
 	}
 
+	// a deadline set by the caller wins over the default timeout
+	ctx, cancel = context.WithTimeout(context.Background(), time.Nanosecond)
+	defer cancel()
+	time.Sleep(time.Millisecond)
+
+	_, err = testRepo.GetUser(ctx, 1)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected the caller's deadline to apply, but got %v", err)
+	}
 }
 
-func TestPostgresDBRepoAllUsers(t *testing.T) {
-	users, err := testRepo.AllUsers()
-	if err != nil {
-		t.Errorf("all users reports an error %s", err)
+func TestPostgresRateLimits(t *testing.T) {
+	repo := testRepo.(*PostgresDBRepo)
+	ctx := context.Background()
+	_ = emptyTables()
+	limit := ratelimit.Limit{Every: time.Hour, Burst: 3}
+
+	// the bucket is full, so we can take three tokens, and then have to wait about an hour
+	for i := 1; i <= 4; i++ {
+		wait, err := repo.Take(ctx, "test", limit)
+		if err != nil {
+			t.Fatalf("take %d: unexpected error: %s", i, err)
+		}
+		if i < 4 && wait != 0 {
+			t.Errorf("take %d: expected a token, but was told to wait %s", i, wait)
+		}
+		if i == 4 && (wait < 59*time.Minute || wait > time.Hour) {
+			t.Errorf("take %d: expected to wait about an hour, but got %s", i, wait)
+		}
 	}
-	if len(users) != 1 {
-		t.Errorf("all users reports wrong size; expected 1, but got %d", len(users))
+
+	// other keys have buckets of their own
+	if wait, _ := repo.Take(ctx, "other", limit); wait != 0 {
+		t.Errorf("expected another key to have a token, but was told to wait %s", wait)
+	}
+
+	// windows count each request, and remember the window before
+	start := time.Now().Truncate(time.Minute)
+	for _, window := range []struct {
+		start             time.Time
+		current, previous int
+	}{
+		{start, 1, 0},
+		{start, 2, 0},
+		{start.Add(time.Minute), 1, 2},
+		{start.Add(3 * time.Minute), 1, 0},
+	} {
+		current, previous, err := repo.Hit(ctx, "test", window.start, time.Minute)
+		if err != nil || current != window.current || previous != window.previous {
+			t.Errorf("expected %d and %d hits, but got %d and %d, %v", window.current, window.previous, current, previous, err)
+		}
 	}
 
-	testUser := data.User{
-		FirstName: "Jack",
-		LastName:  "Smith",
-		Email:     "jack@smith.com",
-		Password:  "secret",
-		IsAdmin:   1,
-		CreatedAt: time.Now(),
+	// a bucket that has refilled, and windows that have expired, are pruned
+	if wait, _ := repo.Take(ctx, "quick", ratelimit.Limit{Every: time.Microsecond}); wait != 0 {
+		t.Errorf("expected a token, but was told to wait %s", wait)
+	}
+	time.Sleep(time.Millisecond)
+	_, _, _ = repo.Hit(ctx, "old", start.Add(-time.Hour), time.Minute)
+	n, err := repo.PruneRateLimits(ctx)
+	if err != nil || n != 2 {
+		t.Errorf("expected a bucket and a window to be pruned, but got %d, %v", n, err)
 	}
+}
 
-	_, _ = testRepo.InsertUser(testUser)
+func TestPostgresMigrations(t *testing.T) {
+	ctx := context.Background()
 
-	users, err = testRepo.AllUsers()
+	// the embedded migrations were applied by TestMain; add some of our own on top
+	m, err := migrations.New(testDB, migrations.Postgres)
 	if err != nil {
-		t.Errorf("all users reports an error %s", err)
+		t.Fatal(err)
 	}
+	m.Migrations = append(m.Migrations,
+		migrations.Migration{Version: 9001, Name: "test_table", Up: "create table migration_test (id integer)", Down: "drop table migration_test"},
+	)
 
-	if len(users) != 2 {
-		t.Errorf("all users reports wrong size after insert; expected 2, but got %d", len(users))
+	done, err := m.Up(ctx)
+	if err != nil {
+		t.Fatalf("unexpected error migrating up: %s", err)
 	}
-
-}
-
-func TestPostgresDBRepoGetUser(t *testing.T) {
-	user, err := testRepo.GetUser(1)
+	if len(done) != 1 || done[0].Version != 9001 {
+		t.Fatalf("expected only 9001 to be applied, but got %v", done)
+	}
+	_, err = testDB.Exec("insert into migration_test (id) values (1)")
 	if err != nil {
-		t.Errorf("error getting user by id: %s", err)
+		t.Errorf("expected migration_test to exist: %s", err)
 	}
 
-	if user.Email != "admin@example.com" {
-		t.Errorf("wrong email returned by user, expected admin@example.com, but got %s", user.Email)
+	statuses, err := m.Status(ctx)
+	if err != nil {
+		t.Fatalf("unexpected error getting status: %s", err)
+	}
+	for _, s := range statuses {
+		if s.AppliedAt == nil {
+			t.Errorf("expected %04d_%s to be applied", s.Version, s.Name)
+		}
 	}
-}
 
-func TestPostgresDBRepoGetUserByEmail(t *testing.T) {
-	user, err := testRepo.GetUserByEmail("jack@smith.com")
+	done, err = m.Down(ctx, 1)
 	if err != nil {
-		t.Errorf("error getting user by id: %s", err)
+		t.Fatalf("unexpected error migrating down: %s", err)
 	}
-
-	if user.Email != "admin@example.com" {
-		t.Errorf("wrong email returned by email, expected 2, but got %d", user.ID)
+	if len(done) != 1 || done[0].Version != 9001 {
+		t.Fatalf("expected only 9001 to be rolled back, but got %v", done)
 	}
-
-	_, err = testRepo.GetUser(3)
+	_, err = testDB.Exec("insert into migration_test (id) values (1)")
 	if err == nil {
-		t.Error("no error reported when getting non existent user by id")
+		t.Error("expected migration_test to have been dropped")
 	}
 
+	// a failed migration leaves nothing behind, and is not recorded
+	m.Migrations = append(m.Migrations,
+		migrations.Migration{Version: 9002, Name: "broken", Up: "create table half_done (id integer); select no_such_column from users"},
+	)
+	_, err = m.Up(ctx)
+	if err == nil {
+		t.Error("expected a broken migration to fail")
+	}
+	_, err = testDB.Exec("insert into half_done (id) values (1)")
+	if err == nil {
+		t.Error("expected the broken migration to be rolled back")
+	}
+	statuses, _ = m.Status(ctx)
+	if last := statuses[len(statuses)-1]; last.AppliedAt != nil {
+		t.Errorf("expected %04d_%s to be pending", last.Version, last.Name)
+	}
 }
 
-func TestPostgresDBRepoUpdateUser(t *testing.T) {
-	user, _ := testRepo.GetUser(2)
-	user.FirstName = "Jane"
-	user.Email = "jane@smith.com"
-	err := testRepo.UpdateUser(*user)
+// a database docker-compose created from the first sql/users.sql is brought up to date by the
+// migrations, search columns and unique emails included
+func TestPostgresMigrationsFromBaseline(t *testing.T) {
+	ctx := context.Background()
+
+	_, err := testDB.Exec("create database users_baseline")
 	if err != nil {
-		t.Errorf("error updating user %d, %s", 2, err)
+		t.Fatalf("error creating database: %s", err)
 	}
-
-	user, _ = testRepo.GetUser(2)
-	if user.FirstName != "Jane" || user.Email != "jane@smith.com" {
-		t.Errorf("expected updated record to have first name Jane and email jane@smith.com, but got %s %s", user.FirstName, user.Email)
+	db, err := sql.Open("pgx", fmt.Sprintf(dsn, host, port, user, password, "users_baseline"))
+	if err != nil {
+		t.Fatalf("error connecting to database: %s", err)
 	}
+	t.Cleanup(func() {
+		_ = db.Close()
+		_, _ = testDB.Exec("drop database users_baseline")
+	})
 
-}
-
-func TestPostgresDBRepoDeleteUser(t *testing.T) {
-	err := testRepo.DeleteUser(2)
+	dump, err := os.ReadFile("testdata/baseline.sql")
 	if err != nil {
-		t.Errorf("error deleting user id 2: %s", err)
+		t.Fatal(err)
 	}
-	_, err = testRepo.GetUser(2)
-	if err == nil {
-		t.Error("retrieved user id 2, who should have been deleted")
+	_, err = db.Exec(string(dump))
+	if err != nil {
+		t.Fatalf("error loading the dump: %s", err)
 	}
-}
 
-func TestPostgresDBResetPassword(t *testing.T) {
-	err := testRepo.ResetPassword(1, "password")
+	m, err := migrations.New(db, migrations.Postgres)
 	if err != nil {
-		t.Error("error resetting user's password", err)
+		t.Fatal(err)
 	}
-
-	user, _ := testRepo.GetUser(1)
-	matches, err := user.PasswordMatches("password")
+	done, err := m.Up(ctx)
 	if err != nil {
-		t.Error(err)
+		t.Fatalf("unexpected error migrating: %s", err)
 	}
-	if !matches {
-		t.Errorf("password should match 'password', but does not")
+	if len(done) != len(m.Migrations) {
+		t.Errorf("expected all %d migrations to be applied, but got %d", len(m.Migrations), len(done))
 	}
 
-}
-
-func TestPostgresDBRepoInsertUserImage(t *testing.T) {
-	var image data.UserImage
-	image.UserID = 1
-	image.FileName = "test.jpg"
-	image.CreatedAt = time.Now()
-	image.UpdatedAt = time.Now()
-
-	newID, err := testRepo.InsertUserImage(image)
-	if err != nil {
-		t.Error("isnerting user image failed: ", err)
+	repo := &PostgresDBRepo{DB: db}
+	results, err := repo.SearchUsers(ctx, "admin", 10)
+	if err != nil || len(results) != 1 || results[0].ID != 1 {
+		t.Errorf("expected to find the admin user from the dump, but got %v, %v", results, err)
 	}
-
-	if newID != 1 {
-		t.Errorf("got wrong id for image; should be 1, but got %d", newID)
+	_, err = repo.InsertUser(ctx, data.User{FirstName: "Again", LastName: "User", Email: "admin@example.com", Password: "secret"})
+	if !errors.Is(err, repository.ErrDuplicateEmail) {
+		t.Errorf("expected ErrDuplicateEmail, but got %v", err)
 	}
-
-	image.UserID = 100
-	_, err = testRepo.InsertUserImage(image)
-	if err == nil {
-		t.Error("inserted a user image with non-existent userID")
+	id, err := repo.InsertUser(ctx, data.User{FirstName: "Jack", LastName: "Smith", Email: "jack@smith.com", Password: "secret"})
+	if err != nil || id != 2 {
+		t.Errorf("expected a new user to get id 2, but got %d, %v", id, err)
 	}
 }
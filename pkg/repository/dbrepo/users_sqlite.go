@@ -0,0 +1,395 @@
+package dbrepo
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"golang.org/x/crypto/bcrypt"
+	"log"
+	"testingCourserWeb/pkg/data"
+	"testingCourserWeb/pkg/repository"
+	"time"
+)
+
+// SQLiteDBRepo is a repository backed by SQLite, so that the apps and their tests can run
+// without a Postgres server. It behaves like PostgresDBRepo, except that search is simpler.
+type SQLiteDBRepo struct {
+	DB *sql.DB
+	// tx is set on the copy of the repository WithTx hands out
+	tx *sql.Tx
+}
+
+// sqliteTimeFormat is how we store times. SQLite has no time type, and compares times as text,
+// so every time is stored in UTC with the same number of digits, and sorts in time order.
+const sqliteTimeFormat = "2006-01-02 15:04:05.000000000"
+
+// sqliteTime returns t as we store it.
+func sqliteTime(t time.Time) string {
+	return t.UTC().Format(sqliteTimeFormat)
+}
+
+func (m *SQLiteDBRepo) Connection() *sql.DB {
+	return m.DB
+}
+
+func (m *SQLiteDBRepo) AllUsers(ctx context.Context) ([]*data.User, error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	query := `select id, email, first_name, last_name, password, is_admin, created_at, updated_at, disabled_at
+	from users where deleted_at is null order by last_name`
+
+	rows, err := m.db().QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var users []*data.User
+
+	for rows.Next() {
+		var user data.User
+		err := rows.Scan(
+			&user.ID,
+			&user.Email,
+			&user.FirstName,
+			&user.LastName,
+			&user.Password,
+			&user.IsAdmin,
+			&user.CreatedAt,
+			&user.UpdatedAt,
+			&user.DisabledAt,
+		)
+		if err != nil {
+			log.Println("Error scanning", err)
+			return nil, err
+		}
+
+		users = append(users, &user)
+	}
+
+	return users, rows.Err()
+}
+
+// GetUser returns one user by id
+func (m *SQLiteDBRepo) GetUser(ctx context.Context, id int) (*data.User, error) {
+	return m.getUser(ctx, "u.id = $1", id)
+}
+
+// GetUserByEmail returns one user by email address
+func (m *SQLiteDBRepo) GetUserByEmail(ctx context.Context, email string) (*data.User, error) {
+	return m.getUser(ctx, "u.email = $1", email)
+}
+
+// getUser returns the one user matching where.
+func (m *SQLiteDBRepo) getUser(ctx context.Context, where string, arg any) (*data.User, error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	query := `
+		select
+			u.id, u.email, u.first_name, u.last_name, u.password, u.is_admin, u.created_at, u.updated_at, u.disabled_at, u.version,
+			coalesce(ui.file_name, '')
+		from
+			users u
+			left join user_images ui on (ui.user_id = u.id)
+		where
+			u.deleted_at is null and ` + where
+
+	var user data.User
+	row := m.db().QueryRowContext(ctx, query, arg)
+
+	err := row.Scan(
+		&user.ID,
+		&user.Email,
+		&user.FirstName,
+		&user.LastName,
+		&user.Password,
+		&user.IsAdmin,
+		&user.CreatedAt,
+		&user.UpdatedAt,
+		&user.DisabledAt,
+		&user.Version,
+		&user.ProfilePic.FileName,
+	)
+
+	if err != nil {
+		return nil, translateSQLiteError(err)
+	}
+
+	return &user, nil
+}
+
+// UpdateUser updates one user in the database, and records the change in their history
+func (m *SQLiteDBRepo) UpdateUser(ctx context.Context, u data.User) error {
+	return m.inTx(ctx, func(tx *SQLiteDBRepo) error {
+		ctx, cancel := withTimeout(ctx)
+		defer cancel()
+
+		before, err := tx.snapshot(ctx, u.ID)
+		if err != nil {
+			return err
+		}
+
+		stmt := `update users set
+			email = $1,
+			first_name = $2,
+			last_name = $3,
+			is_admin = $4,
+			updated_at = $5,
+			version = version + 1
+			where id = $6 and deleted_at is null and ($7 = 0 or version = $7)
+		`
+
+		result, err := tx.db().ExecContext(ctx, stmt,
+			u.Email,
+			u.FirstName,
+			u.LastName,
+			u.IsAdmin,
+			sqliteTime(time.Now()),
+			u.ID,
+			u.Version,
+		)
+
+		if err != nil {
+			return translateSQLiteError(err)
+		}
+
+		err = expectRows(result)
+		if errors.Is(err, repository.ErrNotFound) && u.Version != 0 {
+			return tx.notUpdated(ctx, u.ID)
+		}
+		if err != nil {
+			return err
+		}
+
+		return tx.recordChange(ctx, u.ID, data.ActionUpdate, before)
+	})
+}
+
+// notUpdated works out why an update of user id which expected a version changed nothing:
+// either there is no such user, or they are at another version.
+func (m *SQLiteDBRepo) notUpdated(ctx context.Context, id int) error {
+	var version int
+	err := m.db().QueryRowContext(ctx, `select version from users where id = $1 and deleted_at is null`, id).Scan(&version)
+	if err != nil {
+		return translateSQLiteError(err)
+	}
+	return repository.ErrVersionMismatch
+}
+
+// DeleteUser soft deletes one user, by id, and revokes their refresh tokens, like
+// PostgresDBRepo.DeleteUser.
+func (m *SQLiteDBRepo) DeleteUser(ctx context.Context, id int, version int) error {
+	return m.setUserStatus(ctx, data.ActionDelete, `update users set deleted_at = $1, updated_at = $1, version = version + 1
+		where id = $2 and deleted_at is null and ($3 = 0 or version = $3)`, id, version)
+}
+
+// DeactivateUser stops one user from logging in, and revokes their refresh tokens.
+func (m *SQLiteDBRepo) DeactivateUser(ctx context.Context, id int) error {
+	return m.setUserStatus(ctx, data.ActionDeactivate, `update users set disabled_at = coalesce(disabled_at, $1), updated_at = $1, version = version + 1
+		where id = $2 and deleted_at is null and ($3 = 0 or version = $3)`, id, 0)
+}
+
+// setUserStatus runs stmt, which takes the time, the user's id and the version expected, revokes
+// the user's refresh tokens, and records action in their history, in one transaction.
+func (m *SQLiteDBRepo) setUserStatus(ctx context.Context, action string, stmt string, id int, version int) error {
+	return m.inTx(ctx, func(tx *SQLiteDBRepo) error {
+		ctx, cancel := withTimeout(ctx)
+		defer cancel()
+
+		before, err := tx.snapshot(ctx, id)
+		if err != nil {
+			return err
+		}
+
+		result, err := tx.db().ExecContext(ctx, stmt, sqliteTime(time.Now()), id, version)
+		if err != nil {
+			return translateSQLiteError(err)
+		}
+		err = expectRows(result)
+		if errors.Is(err, repository.ErrNotFound) && version != 0 {
+			return tx.notUpdated(ctx, id)
+		}
+		if err != nil {
+			return err
+		}
+
+		err = tx.RevokeUserRefreshTokens(ctx, id)
+		if err != nil {
+			return err
+		}
+
+		return tx.recordChange(ctx, id, action, before)
+	})
+}
+
+// RestoreUser undoes DeleteUser and DeactivateUser.
+func (m *SQLiteDBRepo) RestoreUser(ctx context.Context, id int) error {
+	return m.inTx(ctx, func(tx *SQLiteDBRepo) error {
+		ctx, cancel := withTimeout(ctx)
+		defer cancel()
+
+		before, err := tx.snapshot(ctx, id)
+		if err != nil {
+			return err
+		}
+
+		stmt := `update users set deleted_at = null, disabled_at = null, updated_at = $1, version = version + 1
+			where id = $2 and (deleted_at is not null or disabled_at is not null)`
+
+		result, err := tx.db().ExecContext(ctx, stmt, sqliteTime(time.Now()), id)
+		if err != nil {
+			return translateSQLiteError(err)
+		}
+		if err := expectRows(result); err != nil {
+			return err
+		}
+
+		return tx.recordChange(ctx, id, data.ActionRestore, before)
+	})
+}
+
+// PurgeUser deletes one user from the database for good, by id, along with their refresh tokens,
+// roles, profile image, history, two-factor authentication and password resets, in one transaction.
+func (m *SQLiteDBRepo) PurgeUser(ctx context.Context, id int) error {
+	return m.inTx(ctx, func(tx *SQLiteDBRepo) error {
+		ctx, cancel := withTimeout(ctx)
+		defer cancel()
+
+		for _, stmt := range []string{
+			`delete from refresh_tokens where user_id = $1`,
+			`delete from user_roles where user_id = $1`,
+			`delete from user_images where user_id = $1`,
+			`delete from user_history where user_id = $1`,
+			`delete from recovery_codes where user_id = $1`,
+			`delete from user_mfa where user_id = $1`,
+			`delete from password_resets where user_id = $1`,
+		} {
+			_, err := tx.db().ExecContext(ctx, stmt, id)
+			if err != nil {
+				return translateSQLiteError(err)
+			}
+		}
+
+		result, err := tx.db().ExecContext(ctx, `delete from users where id = $1`, id)
+		if err != nil {
+			return translateSQLiteError(err)
+		}
+
+		return expectRows(result)
+	})
+}
+
+// InsertUser inserts a new user into the database, and returns the ID of the newly inserted row
+func (m *SQLiteDBRepo) InsertUser(ctx context.Context, user data.User) (int, error) {
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(user.Password), 12)
+	if err != nil {
+		return 0, err
+	}
+
+	var newID int
+	err = m.inTx(ctx, func(tx *SQLiteDBRepo) error {
+		ctx, cancel := withTimeout(ctx)
+		defer cancel()
+
+		stmt := `insert into users (email, first_name, last_name, password, is_admin, created_at, updated_at)
+			values ($1, $2, $3, $4, $5, $6, $7) returning id`
+
+		err := tx.db().QueryRowContext(ctx, stmt,
+			user.Email,
+			user.FirstName,
+			user.LastName,
+			string(hashedPassword),
+			user.IsAdmin,
+			sqliteTime(time.Now()),
+			sqliteTime(time.Now()),
+		).Scan(&newID)
+
+		if err != nil {
+			return translateSQLiteError(err)
+		}
+
+		return tx.recordChange(ctx, newID, data.ActionInsert, nil)
+	})
+
+	if err != nil {
+		return 0, err
+	}
+
+	return newID, nil
+}
+
+// ResetPassword is the method we will use to change a user's password. The history records
+// that it was reset, but not what to.
+func (m *SQLiteDBRepo) ResetPassword(ctx context.Context, id int, password string) error {
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), 12)
+	if err != nil {
+		return err
+	}
+
+	return m.inTx(ctx, func(tx *SQLiteDBRepo) error {
+		ctx, cancel := withTimeout(ctx)
+		defer cancel()
+
+		before, err := tx.snapshot(ctx, id)
+		if err != nil {
+			return err
+		}
+
+		stmt := `update users set password = $1, updated_at = $2, version = version + 1 where id = $3 and deleted_at is null`
+		result, err := tx.db().ExecContext(ctx, stmt, string(hashedPassword), sqliteTime(time.Now()), id)
+		if err != nil {
+			return translateSQLiteError(err)
+		}
+		if err := expectRows(result); err != nil {
+			return err
+		}
+
+		return tx.recordChange(ctx, id, data.ActionResetPassword, before)
+	})
+}
+
+// InsertUserImage inserts a user profile image into the database, replacing the one the user
+// already has, in one transaction.
+func (m *SQLiteDBRepo) InsertUserImage(ctx context.Context, i data.UserImage) (int, error) {
+	var newID int
+
+	err := m.inTx(ctx, func(tx *SQLiteDBRepo) error {
+		ctx, cancel := withTimeout(ctx)
+		defer cancel()
+
+		before, err := tx.snapshot(ctx, i.UserID)
+		if err != nil {
+			return err
+		}
+
+		stmt := `delete from user_images where user_id = $1`
+		_, err = tx.db().ExecContext(ctx, stmt, i.UserID)
+		if err != nil {
+			return translateSQLiteError(err)
+		}
+
+		stmt = `insert into user_images (user_id, file_name, created_at, updated_at)
+			values ($1, $2, $3, $4) returning id`
+
+		err = tx.db().QueryRowContext(ctx, stmt,
+			i.UserID,
+			i.FileName,
+			sqliteTime(time.Now()),
+			sqliteTime(time.Now()),
+		).Scan(&newID)
+
+		if err != nil {
+			return translateSQLiteError(err)
+		}
+
+		return tx.recordChange(ctx, i.UserID, data.ActionImage, before)
+	})
+
+	if err != nil {
+		return 0, err
+	}
+
+	return newID, nil
+}
@@ -0,0 +1,76 @@
+package dbrepo
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"testingCourserWeb/pkg/migrations"
+	"testingCourserWeb/pkg/repository"
+	"testingCourserWeb/pkg/repository/repotest"
+)
+
+// newSQLiteRepo returns a repository for a new, fully migrated SQLite database, which is
+// thrown away at the end of the test.
+func newSQLiteRepo(t *testing.T) *SQLiteDBRepo {
+	t.Helper()
+
+	db, err := Open(SQLite, filepath.Join(t.TempDir(), "users.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = db.Close() })
+
+	m, err := migrations.New(db, migrations.SQLite)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = m.Up(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &SQLiteDBRepo{DB: db}
+}
+
+func TestSQLiteDBRepoConformance(t *testing.T) {
+	repotest.RunConformance(t, func() repository.DatabaseRepo {
+		return newSQLiteRepo(t)
+	})
+}
+
+func TestSQLiteMigrations(t *testing.T) {
+	repo := newSQLiteRepo(t)
+	ctx := context.Background()
+
+	m, err := migrations.New(repo.DB, migrations.SQLite)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	done, err := m.Down(ctx, len(m.Migrations))
+	if err != nil {
+		t.Fatalf("unexpected error migrating down: %s", err)
+	}
+	if len(done) != len(m.Migrations) {
+		t.Errorf("expected every migration to be rolled back, but got %d of %d", len(done), len(m.Migrations))
+	}
+	_, err = repo.AllUsers(ctx)
+	if err == nil {
+		t.Error("expected the users table to be gone")
+	}
+
+	done, err = m.Up(ctx)
+	if err != nil {
+		t.Fatalf("unexpected error migrating up again: %s", err)
+	}
+	if len(done) != len(m.Migrations) {
+		t.Errorf("expected every migration to be applied again, but got %d of %d", len(done), len(m.Migrations))
+	}
+
+	statuses, _ := m.Status(ctx)
+	for _, s := range statuses {
+		if s.AppliedAt == nil {
+			t.Errorf("expected %04d_%s to be applied", s.Version, s.Name)
+		}
+	}
+}
@@ -0,0 +1,18 @@
+package repository
+
+import "errors"
+
+// The errors repositories return, whatever database is behind them, so that callers can tell
+// what went wrong without knowing anything about the database. Repositories wrap these,
+// so check for them with errors.Is.
+var (
+	// ErrNotFound means there is no record matching what was asked for.
+	ErrNotFound = errors.New("record not found")
+	// ErrDuplicateEmail means another user already has the email address.
+	ErrDuplicateEmail = errors.New("email address is already in use")
+	// ErrConflict means the change clashes with the current state of the data, e.g. it
+	// refers to a record which does not exist, or breaks some other constraint.
+	ErrConflict = errors.New("conflicting change")
+	// ErrVersionMismatch means the record has changed since the version the caller expected.
+	ErrVersionMismatch = errors.New("record has been changed since it was read")
+)
@@ -0,0 +1,18 @@
+package repository
+
+import "context"
+
+type actorKey struct{}
+
+// WithActor returns a copy of ctx which says that changes made with it are made by the user
+// with id userID. Repositories record the actor in each user's history.
+func WithActor(ctx context.Context, userID int) context.Context {
+	return context.WithValue(ctx, actorKey{}, userID)
+}
+
+// ActorFrom returns the id of the user WithActor put in ctx, if there is one. Changes made
+// without an actor, e.g. by the command line tools, are recorded as made by nobody.
+func ActorFrom(ctx context.Context) (int, bool) {
+	id, ok := ctx.Value(actorKey{}).(int)
+	return id, ok
+}
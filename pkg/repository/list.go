@@ -0,0 +1,175 @@
+package repository
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"testingCourserWeb/pkg/data"
+	"time"
+)
+
+// The page sizes ListUsers accepts.
+const (
+	DefaultListLimit = 20
+	MaxListLimit     = 100
+)
+
+// DefaultSort is the column users are listed by when no sort is given.
+const DefaultSort = "last_name"
+
+// SortColumns are the columns users may be sorted by. Since the sort column ends up
+// in the query, only these are ever allowed.
+var SortColumns = map[string]bool{
+	"id":         true,
+	"email":      true,
+	"first_name": true,
+	"last_name":  true,
+	"is_admin":   true,
+	"created_at": true,
+	"updated_at": true,
+}
+
+// ErrInvalidCursor is returned when a cursor cannot be decoded, or was issued for a different sort.
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// ListOptions controls which users ListUsers returns, and in what order. Results are
+// always ordered by Sort and then by id, so that every user has a unique position.
+type ListOptions struct {
+	Limit        int
+	After        string
+	Sort         string
+	Desc         bool
+	Email        string
+	IsAdmin      *bool
+	CreatedAfter *time.Time
+	// Deleted lists deleted users instead of everybody else
+	Deleted bool
+}
+
+// Normalize fills in defaults, and checks the options are usable.
+func (o *ListOptions) Normalize() error {
+	if o.Limit == 0 {
+		o.Limit = DefaultListLimit
+	}
+	if o.Limit < 1 || o.Limit > MaxListLimit {
+		return fmt.Errorf("limit must be between 1 and %d", MaxListLimit)
+	}
+
+	if o.Sort == "" {
+		o.Sort = DefaultSort
+	}
+	if !SortColumns[o.Sort] {
+		return fmt.Errorf("cannot sort by %s", o.Sort)
+	}
+
+	if o.After != "" {
+		if _, err := o.Cursor(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Cursor decodes the After cursor.
+func (o *ListOptions) Cursor() (*Cursor, error) {
+	b, err := base64.RawURLEncoding.DecodeString(o.After)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+
+	var c Cursor
+	err = json.Unmarshal(b, &c)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+
+	// a cursor is a position in one particular ordering, and means nothing in any other
+	if c.Sort != o.Sort || c.Desc != o.Desc {
+		return nil, ErrInvalidCursor
+	}
+	if _, err := c.SortValue(); err != nil {
+		return nil, ErrInvalidCursor
+	}
+	return &c, nil
+}
+
+// Page describes where a page of results sits in the full list.
+type Page struct {
+	Limit      int    `json:"limit"`
+	Sort       string `json:"sort"`
+	Order      string `json:"order"`
+	NextCursor string `json:"next_cursor,omitempty"`
+	HasMore    bool   `json:"has_more"`
+}
+
+// NewPage returns the page for users, which were fetched with opts. Repositories fetch one
+// more user than the limit, so that we can tell whether there is another page; NewPage
+// drops that extra user, and returns the users to send back.
+func NewPage(users []*data.User, opts ListOptions) ([]*data.User, Page) {
+	page := Page{
+		Limit: opts.Limit,
+		Sort:  opts.Sort,
+		Order: "asc",
+	}
+	if opts.Desc {
+		page.Order = "desc"
+	}
+
+	if len(users) > opts.Limit {
+		users = users[:opts.Limit]
+		page.HasMore = true
+		page.NextCursor = NewCursor(users[len(users)-1], opts).String()
+	}
+	return users, page
+}
+
+// Cursor is the position of a user in a sorted list: the value of the sort column, plus
+// the id to break ties. It is sent to clients as an opaque string.
+type Cursor struct {
+	Sort  string `json:"s"`
+	Desc  bool   `json:"d,omitempty"`
+	Value string `json:"v"`
+	ID    int    `json:"id"`
+}
+
+// NewCursor returns the cursor pointing just past u.
+func NewCursor(u *data.User, opts ListOptions) Cursor {
+	c := Cursor{Sort: opts.Sort, Desc: opts.Desc, ID: u.ID}
+
+	switch opts.Sort {
+	case "id":
+		c.Value = strconv.Itoa(u.ID)
+	case "email":
+		c.Value = u.Email
+	case "first_name":
+		c.Value = u.FirstName
+	case "last_name":
+		c.Value = u.LastName
+	case "is_admin":
+		c.Value = strconv.Itoa(u.IsAdmin)
+	case "created_at":
+		c.Value = u.CreatedAt.Format(time.RFC3339Nano)
+	case "updated_at":
+		c.Value = u.UpdatedAt.Format(time.RFC3339Nano)
+	}
+	return c
+}
+
+// String encodes the cursor.
+func (c Cursor) String() string {
+	b, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// SortValue returns the cursor's value as the type of its sort column.
+func (c Cursor) SortValue() (any, error) {
+	switch c.Sort {
+	case "id", "is_admin":
+		return strconv.Atoi(c.Value)
+	case "created_at", "updated_at":
+		return time.Parse(time.RFC3339Nano, c.Value)
+	}
+	return c.Value, nil
+}
@@ -0,0 +1,75 @@
+package repository
+
+import (
+	"testing"
+	"testingCourserWeb/pkg/data"
+	"time"
+)
+
+func TestListOptions_Normalize(t *testing.T) {
+	var tests = []struct {
+		name          string
+		opts          ListOptions
+		errorExpected bool
+	}{
+		{"defaults", ListOptions{}, false},
+		{"valid", ListOptions{Limit: 10, Sort: "email", Desc: true}, false},
+		{"negative limit", ListOptions{Limit: -1}, true},
+		{"limit too big", ListOptions{Limit: MaxListLimit + 1}, true},
+		{"unknown sort", ListOptions{Sort: "password"}, true},
+		{"bad cursor", ListOptions{After: "!!!"}, true},
+	}
+
+	for _, e := range tests {
+		err := e.opts.Normalize()
+		if err != nil && !e.errorExpected {
+			t.Errorf("%s: did not expect error, but got one - %s", e.name, err)
+		}
+		if err == nil && e.errorExpected {
+			t.Errorf("%s: expected error, but did not get one", e.name)
+		}
+	}
+
+	opts := ListOptions{}
+	_ = opts.Normalize()
+	if opts.Limit != DefaultListLimit || opts.Sort != DefaultSort {
+		t.Errorf("expected default limit and sort, but got %d and %s", opts.Limit, opts.Sort)
+	}
+}
+
+func TestNewPage(t *testing.T) {
+	created := time.Date(2022, 3, 4, 5, 6, 7, 8000, time.UTC)
+	users := []*data.User{
+		{ID: 1, LastName: "Adams", CreatedAt: created},
+		{ID: 2, LastName: "Brown", CreatedAt: created.Add(time.Hour)},
+		{ID: 3, LastName: "Clark", CreatedAt: created.Add(2 * time.Hour)},
+	}
+
+	opts := ListOptions{Limit: 2, Sort: "created_at", Desc: true}
+	got, page := NewPage(users, opts)
+	if len(got) != 2 || !page.HasMore || page.Order != "desc" {
+		t.Fatalf("expected 2 users and another page, but got %d users and %+v", len(got), page)
+	}
+
+	// the cursor should point at the last user on the page, and only work for the same ordering
+	opts.After = page.NextCursor
+	cursor, err := opts.Cursor()
+	if err != nil {
+		t.Fatal(err)
+	}
+	value, _ := cursor.SortValue()
+	if cursor.ID != 2 || !value.(time.Time).Equal(users[1].CreatedAt) {
+		t.Errorf("expected cursor at user 2, but got %+v", cursor)
+	}
+
+	opts.Desc = false
+	if _, err := opts.Cursor(); err == nil {
+		t.Error("a cursor should not be usable with a different order")
+	}
+
+	// nothing more to fetch
+	got, page = NewPage(users, ListOptions{Limit: 3, Sort: "last_name"})
+	if len(got) != 3 || page.HasMore || page.NextCursor != "" {
+		t.Errorf("expected the last page, but got %+v", page)
+	}
+}
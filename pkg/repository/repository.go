@@ -1,18 +1,88 @@
 package repository
 
 import (
+	"context"
 	"database/sql"
 	"testingCourserWeb/pkg/data"
+	"time"
 )
 
+// DatabaseRepo is everything the applications need from a database. Every method that touches
+// the database takes the context of the request it is serving, so that the query is cancelled
+// along with the request; implementations apply a default timeout if the context has no deadline.
 type DatabaseRepo interface {
 	Connection() *sql.DB
-	AllUsers() ([]*data.User, error)
-	GetUser(id int) (*data.User, error)
-	GetUserByEmail(email string) (*data.User, error)
-	InsertUser(user data.User) (int, error)
-	UpdateUser(u data.User) error
-	DeleteUser(id int) error
-	ResetPassword(id int, password string) error
-	InsertUserImage(i data.UserImage) (int, error)
+	// WithTx runs fn in a transaction, committing if it returns nil and rolling back otherwise.
+	// Only the repository passed to fn is part of the transaction.
+	WithTx(ctx context.Context, fn func(repo DatabaseRepo) error) error
+	AllUsers(ctx context.Context) ([]*data.User, error)
+	ListUsers(ctx context.Context, opts ListOptions) ([]*data.User, Page, error)
+	SearchUsers(ctx context.Context, q string, limit int) ([]*data.UserSearchResult, error)
+	GetUser(ctx context.Context, id int) (*data.User, error)
+	GetUserByEmail(ctx context.Context, email string) (*data.User, error)
+	InsertUser(ctx context.Context, user data.User) (int, error)
+	// UpdateUser updates a user. If u.Version is set, the user is only updated if it is still
+	// their version, and ErrVersionMismatch is returned otherwise.
+	UpdateUser(ctx context.Context, u data.User) error
+	// DeleteUser soft deletes a user: they are kept, but no longer found by anything except
+	// ListUsers with ListOptions.Deleted, until RestoreUser brings them back. Like UpdateUser,
+	// it checks the user's version, unless version is 0.
+	DeleteUser(ctx context.Context, id int, version int) error
+	// DeactivateUser stops a user from logging in, without hiding them.
+	DeactivateUser(ctx context.Context, id int) error
+	RestoreUser(ctx context.Context, id int) error
+	// PurgeUser deletes a user, deleted or not, and everything of theirs for good.
+	PurgeUser(ctx context.Context, id int) error
+	ResetPassword(ctx context.Context, id int, password string) error
+	InsertUserImage(ctx context.Context, i data.UserImage) (int, error)
+	// GetUserHistory returns the changes made to a user through the methods above, newest
+	// first, each recorded with the actor WithActor put in its context.
+	GetUserHistory(ctx context.Context, userID int) ([]*data.UserHistory, error)
+	GetUserHistoryEntry(ctx context.Context, userID, revision int) (*data.UserHistory, error)
+	// InsertAuditEvent adds an event to the security audit log; if e.CreatedAt is not set, the
+	// event happened now. ListAuditEvents reads the log back, newest first.
+	InsertAuditEvent(ctx context.Context, e data.AuditEvent) (int, error)
+	ListAuditEvents(ctx context.Context, f AuditFilter) ([]*data.AuditEvent, AuditPage, error)
+	// GetLoginFailures returns the failed logins counted for an account, or ErrNotFound if there
+	// are none. RecordLoginFailure counts another, starting again from one if the last was before
+	// resetBefore, and returns the count. LockLogin locks the account until a time, and
+	// ClearLoginFailures forgets its failures and any lock, e.g. after a successful login.
+	GetLoginFailures(ctx context.Context, account string) (*data.LoginFailures, error)
+	RecordLoginFailure(ctx context.Context, account string, resetBefore time.Time) (int, error)
+	LockLogin(ctx context.Context, account string, until time.Time) error
+	ClearLoginFailures(ctx context.Context, account string) error
+	// GetUserMFA returns a user's two-factor authentication, or ErrNotFound if they have never
+	// set it up. SetMFASecret starts setting it up, or starts over, with a new secret; it returns
+	// ErrConflict if the user has it on already. EnableMFA turns it on, with the step of the
+	// code which confirmed the secret and the hashes of the user's recovery codes, and returns
+	// ErrNotFound unless the user is part way through setting it up. DeleteUserMFA turns it off.
+	GetUserMFA(ctx context.Context, userID int) (*data.UserMFA, error)
+	SetMFASecret(ctx context.Context, userID int, secret string) error
+	EnableMFA(ctx context.Context, userID int, step int64, recoveryCodes []string) error
+	DeleteUserMFA(ctx context.Context, userID int) error
+	// UseMFAStep records that the code for a time step has been used, and returns ErrConflict
+	// unless step is later than the last one, so that no code works twice. UseRecoveryCode uses
+	// up a recovery code, by its hash, and returns ErrNotFound if the user has no such code left.
+	// SetRecoveryCodes replaces all of a user's recovery codes.
+	UseMFAStep(ctx context.Context, userID int, step int64) error
+	UseRecoveryCode(ctx context.Context, userID int, hash string) error
+	SetRecoveryCodes(ctx context.Context, userID int, hashes []string) error
+	// InsertPasswordReset stores a password reset, by the hash of its token. UsePasswordReset
+	// uses one up, along with every other reset the same user has outstanding, and returns the
+	// user it was for; it returns ErrNotFound if there is no such reset, or it has been used or
+	// has expired.
+	InsertPasswordReset(ctx context.Context, r data.PasswordReset) (int, error)
+	UsePasswordReset(ctx context.Context, tokenHash string) (int, error)
+	InsertRefreshToken(ctx context.Context, t data.RefreshToken) (int, error)
+	GetRefreshToken(ctx context.Context, jti string) (*data.RefreshToken, error)
+	// RevokeRefreshToken revokes one refresh token, unless it already is, and returns how many it
+	// revoked, so that only one of two refreshes racing with the same token can rotate it.
+	RevokeRefreshToken(ctx context.Context, jti string) (int64, error)
+	RevokeRefreshTokenFamily(ctx context.Context, familyID string) error
+	// RevokeUserRefreshTokens revokes every refresh token a user has, logging them out everywhere.
+	RevokeUserRefreshTokens(ctx context.Context, userID int) error
+	AssignRole(ctx context.Context, userID int, role string) error
+	RevokeRole(ctx context.Context, userID int, role string) error
+	GetUserRoles(ctx context.Context, userID int) ([]string, error)
+	GetUserPermissions(ctx context.Context, userID int) ([]string, error)
 }
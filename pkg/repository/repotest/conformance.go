@@ -0,0 +1,1368 @@
+// Package repotest holds the tests every repository.DatabaseRepo has to pass, so that each
+// implementation can show it behaves like the others.
+package repotest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"testingCourserWeb/pkg/authz"
+	"testingCourserWeb/pkg/data"
+	"testingCourserWeb/pkg/repository"
+	"time"
+)
+
+// RunConformance runs the conformance suite against the repositories newRepo returns. Every
+// subtest gets a new repository, which must hold no users, but must have the roles and
+// permissions the migrations create, so that subtests don't depend on each other.
+func RunConformance(t *testing.T, newRepo func() repository.DatabaseRepo) {
+	var tests = []struct {
+		name string
+		test func(t *testing.T, repo repository.DatabaseRepo)
+	}{
+		{"InsertUser", testInsertUser},
+		{"GetUser", testGetUser},
+		{"UpdateUser", testUpdateUser},
+		{"DeleteUser", testDeleteUser},
+		{"DeactivateUser", testDeactivateUser},
+		{"PurgeUser", testPurgeUser},
+		{"UserVersions", testUserVersions},
+		{"UserHistory", testUserHistory},
+		{"ResetPassword", testResetPassword},
+		{"AllUsers", testAllUsers},
+		{"InsertUserImage", testInsertUserImage},
+		{"ListUsers", testListUsers},
+		{"SearchUsers", testSearchUsers},
+		{"RefreshTokens", testRefreshTokens},
+		{"ConcurrentRefresh", testConcurrentRefresh},
+		{"AuditEvents", testAuditEvents},
+		{"LoginFailures", testLoginFailures},
+		{"MFA", testMFA},
+		{"PasswordResets", testPasswordResets},
+		{"Roles", testRoles},
+		{"WithTx", testWithTx},
+	}
+
+	for _, e := range tests {
+		e := e
+		t.Run(e.name, func(t *testing.T) {
+			e.test(t, newRepo())
+		})
+	}
+}
+
+// insertUser inserts a user with the password "secret", and fails the test if it can't.
+func insertUser(t *testing.T, repo repository.DatabaseRepo, first, last, email string) int {
+	t.Helper()
+
+	id, err := repo.InsertUser(context.Background(), data.User{FirstName: first, LastName: last, Email: email, Password: "secret"})
+	if err != nil {
+		t.Fatalf("inserting %s: %s", email, err)
+	}
+	return id
+}
+
+// getUser gets a user which has to exist, and fails the test if it can't.
+func getUser(t *testing.T, repo repository.DatabaseRepo, id int) *data.User {
+	t.Helper()
+
+	user, err := repo.GetUser(context.Background(), id)
+	if err != nil {
+		t.Fatalf("getting user %d: %s", id, err)
+	}
+	return user
+}
+
+func testInsertUser(t *testing.T, repo repository.DatabaseRepo) {
+	ctx := context.Background()
+
+	id, err := repo.InsertUser(ctx, data.User{FirstName: "Admin", LastName: "User", Email: "admin@example.com", Password: "secret", IsAdmin: 1})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	other := insertUser(t, repo, "Jack", "Smith", "jack@smith.com")
+	if id < 1 || other < 1 || id == other {
+		t.Errorf("expected two different ids, but got %d and %d", id, other)
+	}
+
+	user := getUser(t, repo, id)
+	if user.FirstName != "Admin" || user.LastName != "User" || user.Email != "admin@example.com" || user.IsAdmin != 1 {
+		t.Errorf("stored user doesn't match what was inserted: %+v", user)
+	}
+	if user.Password == "secret" {
+		t.Error("expected the password to be hashed")
+	}
+	if matches, _ := user.PasswordMatches("secret"); !matches {
+		t.Error("expected the stored password to match")
+	}
+	if user.CreatedAt.IsZero() || user.UpdatedAt.IsZero() {
+		t.Errorf("expected created_at and updated_at to be set, but got %s and %s", user.CreatedAt, user.UpdatedAt)
+	}
+
+	_, err = repo.InsertUser(ctx, data.User{FirstName: "Again", LastName: "User", Email: "admin@example.com", Password: "secret"})
+	if !errors.Is(err, repository.ErrDuplicateEmail) {
+		t.Errorf("expected ErrDuplicateEmail, but got %v", err)
+	}
+	users, _ := repo.AllUsers(ctx)
+	if len(users) != 2 {
+		t.Errorf("expected the duplicate not to be inserted, but there are %d users", len(users))
+	}
+}
+
+func testGetUser(t *testing.T, repo repository.DatabaseRepo) {
+	ctx := context.Background()
+	id := insertUser(t, repo, "Admin", "User", "admin@example.com")
+
+	user, err := repo.GetUserByEmail(ctx, "admin@example.com")
+	if err != nil {
+		t.Fatalf("unexpected error getting user by email: %s", err)
+	}
+	if user.ID != id {
+		t.Errorf("expected user %d, but got %d", id, user.ID)
+	}
+	if user.ProfilePic.FileName != "" {
+		t.Errorf("expected no profile pic, but got %q", user.ProfilePic.FileName)
+	}
+
+	// changing the user we got back doesn't change what is stored
+	user.FirstName = "Changed"
+	if user = getUser(t, repo, id); user.FirstName != "Admin" {
+		t.Errorf("expected the stored user to be unchanged, but got %s", user.FirstName)
+	}
+
+	_, err = repo.GetUser(ctx, id+100)
+	if !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("expected ErrNotFound getting a user who doesn't exist, but got %v", err)
+	}
+	_, err = repo.GetUserByEmail(ctx, "nobody@example.com")
+	if !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("expected ErrNotFound getting an email nobody has, but got %v", err)
+	}
+}
+
+func testUpdateUser(t *testing.T, repo repository.DatabaseRepo) {
+	ctx := context.Background()
+	id := insertUser(t, repo, "Jack", "Smith", "jack@smith.com")
+	insertUser(t, repo, "Admin", "User", "admin@example.com")
+
+	user := getUser(t, repo, id)
+	user.FirstName = "Jane"
+	user.Email = "jane@smith.com"
+	user.IsAdmin = 1
+	// the password is changed by ResetPassword, never by UpdateUser
+	user.Password = "ignored"
+	err := repo.UpdateUser(ctx, *user)
+	if err != nil {
+		t.Fatalf("unexpected error updating user: %s", err)
+	}
+
+	user = getUser(t, repo, id)
+	if user.FirstName != "Jane" || user.Email != "jane@smith.com" || user.IsAdmin != 1 {
+		t.Errorf("expected the update to be stored, but got %+v", user)
+	}
+	if matches, _ := user.PasswordMatches("secret"); !matches {
+		t.Error("expected the password to be left alone")
+	}
+	if user.UpdatedAt.Before(user.CreatedAt) {
+		t.Errorf("expected updated_at to be after created_at, but got %s and %s", user.UpdatedAt, user.CreatedAt)
+	}
+
+	user.Email = "admin@example.com"
+	user.Version = 0
+	err = repo.UpdateUser(ctx, *user)
+	if !errors.Is(err, repository.ErrDuplicateEmail) {
+		t.Errorf("expected ErrDuplicateEmail taking another user's email, but got %v", err)
+	}
+
+	err = repo.UpdateUser(ctx, data.User{ID: id + 100, FirstName: "No", LastName: "Body", Email: "nobody@example.com"})
+	if !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("expected ErrNotFound updating a user who doesn't exist, but got %v", err)
+	}
+}
+
+// giveUserThings gives the user a profile image, a refresh token with the jti "jti-<id>", and
+// the support role.
+func giveUserThings(t *testing.T, repo repository.DatabaseRepo, id int) {
+	t.Helper()
+	ctx := context.Background()
+
+	_, err := repo.InsertUserImage(ctx, data.UserImage{UserID: id, FileName: "pic.jpg"})
+	if err != nil {
+		t.Fatalf("unexpected error inserting image: %s", err)
+	}
+	_, err = repo.InsertRefreshToken(ctx, data.RefreshToken{UserID: id, FamilyID: "family", JTI: fmt.Sprintf("jti-%d", id), ExpiresAt: time.Now().UTC().Add(time.Hour)})
+	if err != nil {
+		t.Fatalf("unexpected error inserting refresh token: %s", err)
+	}
+	err = repo.AssignRole(ctx, id, authz.RoleSupport)
+	if err != nil {
+		t.Fatalf("unexpected error assigning role: %s", err)
+	}
+	err = repo.SetMFASecret(ctx, id, "SECRET")
+	if err == nil {
+		err = repo.EnableMFA(ctx, id, 1, []string{"hash"})
+	}
+	if err != nil {
+		t.Fatalf("unexpected error enabling mfa: %s", err)
+	}
+	_, err = repo.InsertPasswordReset(ctx, data.PasswordReset{UserID: id, TokenHash: fmt.Sprintf("reset-%d", id), ExpiresAt: time.Now().UTC().Add(time.Hour)})
+	if err != nil {
+		t.Fatalf("unexpected error inserting password reset: %s", err)
+	}
+}
+
+func testDeleteUser(t *testing.T, repo repository.DatabaseRepo) {
+	ctx := context.Background()
+	id := insertUser(t, repo, "Jack", "Smith", "jack@smith.com")
+	other := insertUser(t, repo, "Admin", "User", "admin@example.com")
+	giveUserThings(t, repo, id)
+	giveUserThings(t, repo, other)
+
+	err := repo.DeleteUser(ctx, id, 0)
+	if err != nil {
+		t.Fatalf("unexpected error deleting user: %s", err)
+	}
+
+	for name, err := range map[string]error{
+		"get":        func() error { _, err := repo.GetUser(ctx, id); return err }(),
+		"get email":  func() error { _, err := repo.GetUserByEmail(ctx, "jack@smith.com"); return err }(),
+		"update":     repo.UpdateUser(ctx, data.User{ID: id, FirstName: "Jack", LastName: "Smith", Email: "jack@smith.com"}),
+		"delete":     repo.DeleteUser(ctx, id, 0),
+		"deactivate": repo.DeactivateUser(ctx, id),
+		"reset":      repo.ResetPassword(ctx, id, "password"),
+	} {
+		if !errors.Is(err, repository.ErrNotFound) {
+			t.Errorf("%s: expected ErrNotFound for a deleted user, but got %v", name, err)
+		}
+	}
+
+	// a deleted user is only found by listing deleted users
+	users, _ := repo.AllUsers(ctx)
+	if len(users) != 1 || users[0].ID != other {
+		t.Errorf("expected only the other user, but got %d users", len(users))
+	}
+	results, _ := repo.SearchUsers(ctx, "jack", 10)
+	if len(results) != 0 {
+		t.Errorf("expected search not to find a deleted user, but got %d results", len(results))
+	}
+	users, _, _ = repo.ListUsers(ctx, repository.ListOptions{})
+	if len(users) != 1 || users[0].ID != other || users[0].DeletedAt != nil {
+		t.Errorf("expected only the other user to be listed, but got %d users", len(users))
+	}
+	users, _, _ = repo.ListUsers(ctx, repository.ListOptions{Deleted: true})
+	if len(users) != 1 || users[0].ID != id || users[0].DeletedAt == nil {
+		t.Fatalf("expected the deleted user to be listed with a deleted_at, but got %d users", len(users))
+	}
+
+	// they are logged out, but everything else of theirs is kept, and so is their email
+	token, err := repo.GetRefreshToken(ctx, fmt.Sprintf("jti-%d", id))
+	if err != nil || !token.Revoked {
+		t.Errorf("expected the deleted user's refresh token to be revoked, but got %v, %v", token, err)
+	}
+	if token, _ = repo.GetRefreshToken(ctx, fmt.Sprintf("jti-%d", other)); token.Revoked {
+		t.Error("expected the other user's refresh token to be left alone")
+	}
+	_, err = repo.InsertUser(ctx, data.User{FirstName: "Jack", LastName: "Again", Email: "jack@smith.com", Password: "secret"})
+	if !errors.Is(err, repository.ErrDuplicateEmail) {
+		t.Errorf("expected a deleted user's email to stay taken, but got %v", err)
+	}
+
+	err = repo.RestoreUser(ctx, id)
+	if err != nil {
+		t.Fatalf("unexpected error restoring user: %s", err)
+	}
+	user := getUser(t, repo, id)
+	if user.DeletedAt != nil || user.ProfilePic.FileName != "pic.jpg" {
+		t.Errorf("expected the user to come back as they were, but got %+v", user)
+	}
+	roles, _ := repo.GetUserRoles(ctx, id)
+	if len(roles) != 1 {
+		t.Errorf("expected the restored user's roles to be kept, but got %v", roles)
+	}
+	if token, _ = repo.GetRefreshToken(ctx, fmt.Sprintf("jti-%d", id)); !token.Revoked {
+		t.Error("expected the refresh token to stay revoked")
+	}
+
+	for name, userID := range map[string]int{"active user": id, "missing user": id + 100} {
+		err = repo.RestoreUser(ctx, userID)
+		if !errors.Is(err, repository.ErrNotFound) {
+			t.Errorf("%s: expected ErrNotFound restoring, but got %v", name, err)
+		}
+	}
+}
+
+func testDeactivateUser(t *testing.T, repo repository.DatabaseRepo) {
+	ctx := context.Background()
+	id := insertUser(t, repo, "Jack", "Smith", "jack@smith.com")
+	giveUserThings(t, repo, id)
+
+	err := repo.DeactivateUser(ctx, id)
+	if err != nil {
+		t.Fatalf("unexpected error deactivating user: %s", err)
+	}
+
+	// a deactivated user is still found, but marked as such
+	user := getUser(t, repo, id)
+	if user.DisabledAt == nil {
+		t.Fatal("expected disabled_at to be set")
+	}
+	if user, _ := repo.GetUserByEmail(ctx, "jack@smith.com"); user == nil || user.DisabledAt == nil {
+		t.Errorf("expected to get the deactivated user by email, with disabled_at set, but got %v", user)
+	}
+	users, _, _ := repo.ListUsers(ctx, repository.ListOptions{})
+	if len(users) != 1 || users[0].DisabledAt == nil {
+		t.Errorf("expected the deactivated user to be listed, with disabled_at set, but got %d users", len(users))
+	}
+	token, _ := repo.GetRefreshToken(ctx, fmt.Sprintf("jti-%d", id))
+	if token == nil || !token.Revoked {
+		t.Error("expected the deactivated user's refresh token to be revoked")
+	}
+
+	// deactivating again keeps the first time
+	time.Sleep(time.Millisecond)
+	err = repo.DeactivateUser(ctx, id)
+	if err != nil {
+		t.Errorf("unexpected error deactivating again: %s", err)
+	}
+	if again := getUser(t, repo, id); again.DisabledAt == nil || !again.DisabledAt.Equal(*user.DisabledAt) {
+		t.Errorf("expected disabled_at to stay %s, but got %v", user.DisabledAt, again.DisabledAt)
+	}
+
+	err = repo.RestoreUser(ctx, id)
+	if err != nil {
+		t.Fatalf("unexpected error restoring user: %s", err)
+	}
+	if user = getUser(t, repo, id); user.DisabledAt != nil {
+		t.Errorf("expected disabled_at to be cleared, but got %s", user.DisabledAt)
+	}
+
+	err = repo.DeactivateUser(ctx, id+100)
+	if !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("expected ErrNotFound deactivating a user who doesn't exist, but got %v", err)
+	}
+}
+
+func testPurgeUser(t *testing.T, repo repository.DatabaseRepo) {
+	ctx := context.Background()
+	id := insertUser(t, repo, "Jack", "Smith", "jack@smith.com")
+	other := insertUser(t, repo, "Admin", "User", "admin@example.com")
+	giveUserThings(t, repo, id)
+	giveUserThings(t, repo, other)
+
+	// deleted users can be purged too
+	_ = repo.DeleteUser(ctx, id, 0)
+	err := repo.PurgeUser(ctx, id)
+	if err != nil {
+		t.Fatalf("unexpected error purging user: %s", err)
+	}
+
+	// everything hanging off a user goes with them, and nobody else's
+	for name, err := range map[string]error{
+		"purge":   repo.PurgeUser(ctx, id),
+		"restore": repo.RestoreUser(ctx, id),
+		"token":   func() error { _, err := repo.GetRefreshToken(ctx, fmt.Sprintf("jti-%d", id)); return err }(),
+		"mfa":     func() error { _, err := repo.GetUserMFA(ctx, id); return err }(),
+		"reset":   func() error { _, err := repo.UsePasswordReset(ctx, fmt.Sprintf("reset-%d", id)); return err }(),
+	} {
+		if !errors.Is(err, repository.ErrNotFound) {
+			t.Errorf("%s: expected ErrNotFound for a purged user, but got %v", name, err)
+		}
+	}
+	roles, _ := repo.GetUserRoles(ctx, id)
+	if len(roles) != 0 {
+		t.Errorf("expected the purged user's roles to go, but got %v", roles)
+	}
+	users, _, _ := repo.ListUsers(ctx, repository.ListOptions{Deleted: true})
+	if len(users) != 0 {
+		t.Errorf("expected the purged user not to be listed as deleted, but got %d users", len(users))
+	}
+
+	user := getUser(t, repo, other)
+	if user.ProfilePic.FileName != "pic.jpg" {
+		t.Errorf("expected the other user's image to be left alone, but got %q", user.ProfilePic.FileName)
+	}
+	_, err = repo.GetRefreshToken(ctx, fmt.Sprintf("jti-%d", other))
+	if err != nil {
+		t.Errorf("expected the other user's refresh token to be left alone, but got %s", err)
+	}
+	roles, _ = repo.GetUserRoles(ctx, other)
+	if len(roles) != 1 {
+		t.Errorf("expected the other user's roles to be left alone, but got %v", roles)
+	}
+	if mfa, err := repo.GetUserMFA(ctx, other); err != nil || mfa.RecoveryCodesLeft != 1 {
+		t.Errorf("expected the other user's mfa to be left alone, but got %+v, %v", mfa, err)
+	}
+	if userID, err := repo.UsePasswordReset(ctx, fmt.Sprintf("reset-%d", other)); err != nil || userID != other {
+		t.Errorf("expected the other user's password reset to be left alone, but got %d, %v", userID, err)
+	}
+
+	// the email is free again, but ids are never reused
+	if newID := insertUser(t, repo, "Jack", "Smith", "jack@smith.com"); newID == id {
+		t.Errorf("expected a new id, but got the purged user's id %d again", id)
+	}
+}
+
+func testUserVersions(t *testing.T, repo repository.DatabaseRepo) {
+	ctx := context.Background()
+	id := insertUser(t, repo, "Jack", "Smith", "jack@smith.com")
+
+	user := getUser(t, repo, id)
+	if user.Version != 1 {
+		t.Fatalf("expected a new user to be at version 1, but got %d", user.Version)
+	}
+
+	// every change moves the version on; in order, since a user can only be restored once deactivated
+	for _, e := range []struct {
+		name   string
+		change func() error
+	}{
+		{"update", func() error { return repo.UpdateUser(ctx, *user) }},
+		{"unchecked", func() error { u := *user; u.Version = 0; return repo.UpdateUser(ctx, u) }},
+		{"reset", func() error { return repo.ResetPassword(ctx, id, "password") }},
+		{"deactivate", func() error { return repo.DeactivateUser(ctx, id) }},
+		{"restore", func() error { return repo.RestoreUser(ctx, id) }},
+	} {
+		before := getUser(t, repo, id).Version
+		user.Version = before
+		err := e.change()
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %s", e.name, err)
+		}
+		if after := getUser(t, repo, id).Version; after != before+1 {
+			t.Errorf("%s: expected version %d, but got %d", e.name, before+1, after)
+		}
+	}
+
+	// an old version changes nothing
+	current := getUser(t, repo, id)
+	stale := *current
+	stale.Version--
+	stale.FirstName = "Stale"
+	err := repo.UpdateUser(ctx, stale)
+	if !errors.Is(err, repository.ErrVersionMismatch) {
+		t.Errorf("expected ErrVersionMismatch updating an old version, but got %v", err)
+	}
+	err = repo.DeleteUser(ctx, id, stale.Version)
+	if !errors.Is(err, repository.ErrVersionMismatch) {
+		t.Errorf("expected ErrVersionMismatch deleting an old version, but got %v", err)
+	}
+	if user = getUser(t, repo, id); user.FirstName != "Jack" || user.Version != current.Version {
+		t.Errorf("expected the user to be left alone, but got %s at version %d", user.FirstName, user.Version)
+	}
+
+	// a missing user is still missing, whatever the version
+	err = repo.UpdateUser(ctx, data.User{ID: id + 100, FirstName: "No", LastName: "Body", Email: "nobody@example.com", Version: 1})
+	if !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("expected ErrNotFound updating a user who doesn't exist, but got %v", err)
+	}
+	err = repo.DeleteUser(ctx, id+100, 1)
+	if !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("expected ErrNotFound deleting a user who doesn't exist, but got %v", err)
+	}
+
+	err = repo.DeleteUser(ctx, id, current.Version)
+	if err != nil {
+		t.Errorf("unexpected error deleting the current version: %s", err)
+	}
+}
+
+func testUserHistory(t *testing.T, repo repository.DatabaseRepo) {
+	ctx := repository.WithActor(context.Background(), 42)
+	id, err := repo.InsertUser(ctx, data.User{FirstName: "Jack", LastName: "Smith", Email: "jack@smith.com", Password: "secret"})
+	if err != nil {
+		t.Fatalf("unexpected error inserting user: %s", err)
+	}
+	other := insertUser(t, repo, "Admin", "User", "admin@example.com")
+
+	user := getUser(t, repo, id)
+	user.Email, user.IsAdmin = "jack@example.com", 1
+	// in order, since the history is
+	for i, change := range []func() error{
+		func() error { return repo.UpdateUser(ctx, *user) },
+		func() error { return repo.ResetPassword(ctx, id, "password") },
+		func() error {
+			_, err := repo.InsertUserImage(ctx, data.UserImage{UserID: id, FileName: "jack.jpg"})
+			return err
+		},
+		func() error { return repo.DeactivateUser(ctx, id) },
+		func() error { return repo.RestoreUser(ctx, id) },
+		func() error { return repo.DeleteUser(ctx, id, 0) },
+	} {
+		err = change()
+		if err != nil {
+			t.Fatalf("change %d: unexpected error: %s", i, err)
+		}
+	}
+
+	// failed and rolled back changes leave no trace
+	_ = repo.UpdateUser(ctx, data.User{ID: other, FirstName: "Admin", LastName: "User", Email: "jack@example.com"})
+	_ = repo.WithTx(ctx, func(repo repository.DatabaseRepo) error {
+		_ = repo.DeactivateUser(ctx, other)
+		return errors.New("roll back")
+	})
+
+	// deleted users keep their history
+	history, err := repo.GetUserHistory(ctx, id)
+	if err != nil {
+		t.Fatalf("unexpected error getting history: %s", err)
+	}
+	var actions []string
+	for _, h := range history {
+		actions = append(actions, h.Action)
+		if h.UserID != id || h.ActorID == nil || *h.ActorID != 42 {
+			t.Errorf("%s: expected a change to user %d by user 42, but got user %d by %v", h.Action, id, h.UserID, h.ActorID)
+		}
+		if h.After == nil || h.CreatedAt.IsZero() {
+			t.Errorf("%s: expected a snapshot after the change, and a time", h.Action)
+		}
+	}
+	if len(history) != 7 || history[6].Action != data.ActionInsert || history[0].Action != data.ActionDelete {
+		t.Fatalf("expected 7 changes, from insert to delete, newest first, but got %v", actions)
+	}
+	for i := 1; i < len(history); i++ {
+		if history[i].Revision >= history[i-1].Revision {
+			t.Errorf("expected revisions to go down, but got %d after %d", history[i].Revision, history[i-1].Revision)
+		}
+	}
+
+	if history[6].Before != nil || history[6].After.Email != "jack@smith.com" {
+		t.Errorf("expected an insert to have only an after, but got %+v, %+v", history[6].Before, history[6].After)
+	}
+	if history[0].After.DeletedAt == nil {
+		t.Error("expected the delete to be recorded")
+	}
+
+	entry, err := repo.GetUserHistoryEntry(ctx, id, history[5].Revision)
+	if err != nil {
+		t.Fatalf("unexpected error getting one entry: %s", err)
+	}
+	if entry.Action != data.ActionUpdate || fmt.Sprint(entry.Changes()) != "map[email:{jack@smith.com jack@example.com} is_admin:{0 1}]" {
+		t.Errorf("expected the update of email and is_admin, but got %s %v", entry.Action, entry.Changes())
+	}
+	for _, h := range history {
+		if h.Action == data.ActionResetPassword && len(h.Changes()) != 0 {
+			t.Errorf("expected a password reset to show no changes, but got %v", h.Changes())
+		}
+		if h.Action == data.ActionImage && fmt.Sprint(h.Changes()) != "map[profile_pic:{ jack.jpg}]" {
+			t.Errorf("expected the image to be recorded, but got %v", h.Changes())
+		}
+	}
+
+	// each user has their own history
+	if _, err = repo.GetUserHistoryEntry(ctx, other, history[5].Revision); !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("expected ErrNotFound for another user's revision, but got %v", err)
+	}
+	otherHistory, _ := repo.GetUserHistory(ctx, other)
+	if len(otherHistory) != 1 {
+		t.Errorf("expected only the insert for the other user, but got %d changes", len(otherHistory))
+	}
+	if _, err = repo.GetUserHistory(ctx, id+100); !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("expected ErrNotFound for the history of a user who doesn't exist, but got %v", err)
+	}
+
+	// changes made without an actor are made by nobody
+	_ = repo.RestoreUser(context.Background(), id)
+	history, _ = repo.GetUserHistory(ctx, id)
+	if history[0].ActorID != nil {
+		t.Errorf("expected no actor, but got %d", *history[0].ActorID)
+	}
+
+	// and purging a user takes their history with them
+	_ = repo.PurgeUser(ctx, id)
+	if _, err = repo.GetUserHistory(ctx, id); !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("expected ErrNotFound for the history of a purged user, but got %v", err)
+	}
+}
+
+func testResetPassword(t *testing.T, repo repository.DatabaseRepo) {
+	ctx := context.Background()
+	id := insertUser(t, repo, "Admin", "User", "admin@example.com")
+	before := getUser(t, repo, id)
+	// updated_at has to have somewhere to move on to
+	time.Sleep(time.Millisecond)
+
+	err := repo.ResetPassword(ctx, id, "password")
+	if err != nil {
+		t.Fatalf("unexpected error resetting password: %s", err)
+	}
+
+	user := getUser(t, repo, id)
+	if user.Version != before.Version+1 || !user.UpdatedAt.After(before.UpdatedAt) {
+		t.Errorf("expected the version and updated_at to move on from %d, %s, but got %d, %s", before.Version, before.UpdatedAt, user.Version, user.UpdatedAt)
+	}
+	if matches, _ := user.PasswordMatches("password"); !matches {
+		t.Error("expected the new password to match")
+	}
+	if matches, _ := user.PasswordMatches("secret"); matches {
+		t.Error("expected the old password not to match")
+	}
+
+	err = repo.ResetPassword(ctx, id+100, "password")
+	if !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("expected ErrNotFound resetting the password of a user who doesn't exist, but got %v", err)
+	}
+}
+
+func testAllUsers(t *testing.T, repo repository.DatabaseRepo) {
+	ctx := context.Background()
+
+	users, err := repo.AllUsers(ctx)
+	if err != nil || len(users) != 0 {
+		t.Fatalf("expected no users, but got %d, %v", len(users), err)
+	}
+
+	insertUser(t, repo, "Jack", "Smith", "jack@smith.com")
+	insertUser(t, repo, "Admin", "User", "admin@example.com")
+	insertUser(t, repo, "Jill", "Baker", "jill@baker.com")
+
+	users, err = repo.AllUsers(ctx)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	var got []string
+	for _, u := range users {
+		got = append(got, u.LastName)
+	}
+	if fmt.Sprint(got) != "[Baker Smith User]" {
+		t.Errorf("expected users ordered by last name, but got %v", got)
+	}
+}
+
+func testInsertUserImage(t *testing.T, repo repository.DatabaseRepo) {
+	ctx := context.Background()
+	id := insertUser(t, repo, "Admin", "User", "admin@example.com")
+
+	first, err := repo.InsertUserImage(ctx, data.UserImage{UserID: id, FileName: "first.jpg"})
+	if err != nil || first < 1 {
+		t.Fatalf("expected an id for the image, but got %d, %v", first, err)
+	}
+	if user := getUser(t, repo, id); user.ProfilePic.FileName != "first.jpg" {
+		t.Errorf("expected a profile pic of first.jpg, but got %q", user.ProfilePic.FileName)
+	}
+
+	second, err := repo.InsertUserImage(ctx, data.UserImage{UserID: id, FileName: "second.jpg"})
+	if err != nil {
+		t.Fatalf("unexpected error replacing image: %s", err)
+	}
+	if second == first {
+		t.Errorf("expected the replacement to get a new id, but got %d again", first)
+	}
+	if user := getUser(t, repo, id); user.ProfilePic.FileName != "second.jpg" {
+		t.Errorf("expected the image to be replaced by second.jpg, but got %q", user.ProfilePic.FileName)
+	}
+
+	_, err = repo.InsertUserImage(ctx, data.UserImage{UserID: id + 100, FileName: "nobody.jpg"})
+	if !errors.Is(err, repository.ErrConflict) {
+		t.Errorf("expected ErrConflict for an image for a user who doesn't exist, but got %v", err)
+	}
+}
+
+func testListUsers(t *testing.T, repo repository.DatabaseRepo) {
+	ctx := context.Background()
+
+	for _, name := range []string{"Casey", "Avery", "Blake"} {
+		insertUser(t, repo, name, "Lister", name+"@lister.com")
+		// created_at must differ between users for the created_at ordering to mean anything
+		time.Sleep(time.Millisecond)
+	}
+	admin, _ := repo.GetUserByEmail(ctx, "Blake@lister.com")
+	admin.IsAdmin = 1
+	_ = repo.UpdateUser(ctx, *admin)
+
+	isAdmin, notAdmin := true, false
+	yesterday, tomorrow := time.Now().Add(-24*time.Hour), time.Now().Add(24*time.Hour)
+
+	var tests = []struct {
+		name     string
+		opts     repository.ListOptions
+		expected []string
+	}{
+		{"by last name, then id", repository.ListOptions{Limit: 1}, []string{"Casey", "Avery", "Blake"}},
+		{"by first name", repository.ListOptions{Limit: 2, Sort: "first_name"}, []string{"Avery", "Blake", "Casey"}},
+		{"by first name desc", repository.ListOptions{Limit: 2, Sort: "first_name", Desc: true}, []string{"Casey", "Blake", "Avery"}},
+		{"by email desc", repository.ListOptions{Limit: 2, Sort: "email", Desc: true}, []string{"Casey", "Blake", "Avery"}},
+		{"by id desc", repository.ListOptions{Limit: 2, Sort: "id", Desc: true}, []string{"Blake", "Avery", "Casey"}},
+		{"by created_at", repository.ListOptions{Limit: 1, Sort: "created_at"}, []string{"Casey", "Avery", "Blake"}},
+		{"by is_admin", repository.ListOptions{Limit: 1, Sort: "is_admin", Desc: true}, []string{"Blake", "Avery", "Casey"}},
+		{"by email, ignoring case", repository.ListOptions{Email: "BLAKE@lister.com"}, []string{"Blake"}},
+		{"admins", repository.ListOptions{IsAdmin: &isAdmin}, []string{"Blake"}},
+		{"not admins", repository.ListOptions{IsAdmin: &notAdmin, Sort: "first_name"}, []string{"Avery", "Casey"}},
+		{"created since yesterday", repository.ListOptions{CreatedAfter: &yesterday, Sort: "created_at"}, []string{"Casey", "Avery", "Blake"}},
+		{"created after tomorrow", repository.ListOptions{CreatedAfter: &tomorrow}, nil},
+	}
+
+	for _, e := range tests {
+		var got []string
+		opts := e.opts
+		for pages := 0; pages < 10; pages++ {
+			users, page, err := repo.ListUsers(ctx, opts)
+			if err != nil {
+				t.Fatalf("%s: unexpected error: %s", e.name, err)
+			}
+			for _, u := range users {
+				got = append(got, u.FirstName)
+			}
+			if !page.HasMore {
+				break
+			}
+			opts.After = page.NextCursor
+		}
+
+		if fmt.Sprint(got) != fmt.Sprint(e.expected) {
+			t.Errorf("%s: expected %v, but got %v", e.name, e.expected, got)
+		}
+	}
+
+	_, page, _ := repo.ListUsers(ctx, repository.ListOptions{Limit: 3})
+	if page.HasMore || page.NextCursor != "" {
+		t.Errorf("expected an exactly full page to be the last, but got %+v", page)
+	}
+
+	for name, opts := range map[string]repository.ListOptions{
+		"limit too big": {Limit: repository.MaxListLimit + 1},
+		"unknown sort":  {Sort: "password"},
+		"bad cursor":    {After: "nonsense"},
+	} {
+		_, _, err := repo.ListUsers(ctx, opts)
+		if err == nil {
+			t.Errorf("%s: expected an error, but got none", name)
+		}
+	}
+}
+
+func testSearchUsers(t *testing.T, repo repository.DatabaseRepo) {
+	ctx := context.Background()
+
+	insertUser(t, repo, "Jill", "Blacksmith", "jill@example.com")
+	insertUser(t, repo, "Jack", "Smith", "jack@smith.com")
+	insertUser(t, repo, "Casey", "Lister", "casey@lister.com")
+
+	results, err := repo.SearchUsers(ctx, "smith", 10)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(results) != 2 {
+		t.Fatalf("expected 2 results, but got %d", len(results))
+	}
+	// a name starting with smith beats one with smith in the middle
+	if results[0].FirstName != "Jack" || results[0].Rank <= results[1].Rank {
+		t.Errorf("expected Jack Smith to rank above Jill Blacksmith, but got %s first", results[0].FirstName)
+	}
+	if results[0].Highlights["last_name"] != "<mark>Smith</mark>" {
+		t.Errorf("expected the last name to be highlighted, but got %q", results[0].Highlights["last_name"])
+	}
+	if results[0].Password != "" {
+		t.Error("expected search results not to carry password hashes")
+	}
+
+	results, _ = repo.SearchUsers(ctx, "smith", 1)
+	if len(results) != 1 {
+		t.Errorf("expected the limit to apply, but got %d results", len(results))
+	}
+
+	var tests = []struct {
+		name     string
+		q        string
+		expected []string
+	}{
+		{"every word", "jill black", []string{"Jill"}},
+		{"ignoring case", "CASEY", []string{"Casey"}},
+		{"middle of a name", "ase", []string{"Casey"}},
+		{"no match", "nobody", nil},
+		{"wildcards", "100%_", nil},
+	}
+
+	for _, e := range tests {
+		results, err := repo.SearchUsers(ctx, e.q, 10)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", e.name, err)
+		}
+		var got []string
+		for _, r := range results {
+			got = append(got, r.FirstName)
+		}
+		if fmt.Sprint(got) != fmt.Sprint(e.expected) {
+			t.Errorf("%s: expected %v, but got %v", e.name, e.expected, got)
+		}
+	}
+
+	_, err = repo.SearchUsers(ctx, " ", 10)
+	if err == nil {
+		t.Error("expected an error for an empty search")
+	}
+	_, err = repo.SearchUsers(ctx, "smith", 0)
+	if err == nil {
+		t.Error("expected an error for a limit of 0")
+	}
+}
+
+func testRefreshTokens(t *testing.T, repo repository.DatabaseRepo) {
+	ctx := context.Background()
+	id := insertUser(t, repo, "Admin", "User", "admin@example.com")
+
+	// timestamp columns keep no time zone, so stick to UTC
+	expires := time.Now().UTC().Add(time.Hour)
+	token := data.RefreshToken{UserID: id, FamilyID: "family", JTI: "first", ExpiresAt: expires}
+	tokenID, err := repo.InsertRefreshToken(ctx, token)
+	if err != nil || tokenID < 1 {
+		t.Fatalf("expected an id for the token, but got %d, %v", tokenID, err)
+	}
+	token.JTI = "second"
+	_, _ = repo.InsertRefreshToken(ctx, token)
+	_, _ = repo.InsertRefreshToken(ctx, data.RefreshToken{UserID: id, FamilyID: "other", JTI: "third", ExpiresAt: expires})
+
+	got, err := repo.GetRefreshToken(ctx, "first")
+	if err != nil {
+		t.Fatalf("unexpected error getting token: %s", err)
+	}
+	if got.ID != tokenID || got.UserID != id || got.FamilyID != "family" || got.Revoked {
+		t.Errorf("stored token doesn't match what was inserted: %+v", got)
+	}
+	if d := got.ExpiresAt.Sub(expires); d < -time.Second || d > time.Second {
+		t.Errorf("expected the token to expire at %s, but got %s", expires, got.ExpiresAt)
+	}
+
+	revoked, err := repo.RevokeRefreshToken(ctx, "first")
+	if err != nil || revoked != 1 {
+		t.Errorf("expected to revoke the token, but got %d, %v", revoked, err)
+	}
+	if got, _ = repo.GetRefreshToken(ctx, "first"); !got.Revoked {
+		t.Error("expected the token to be revoked")
+	}
+	for _, jti := range []string{"first", "unknown"} {
+		revoked, err = repo.RevokeRefreshToken(ctx, jti)
+		if err != nil || revoked != 0 {
+			t.Errorf("%s: expected nothing to be revoked, but got %d, %v", jti, revoked, err)
+		}
+	}
+
+	err = repo.RevokeRefreshTokenFamily(ctx, "family")
+	if err != nil {
+		t.Errorf("unexpected error revoking family: %s", err)
+	}
+	if got, _ = repo.GetRefreshToken(ctx, "second"); !got.Revoked {
+		t.Error("expected the token to be revoked along with its family")
+	}
+	if got, _ = repo.GetRefreshToken(ctx, "third"); got.Revoked {
+		t.Error("expected a token in another family to be left alone")
+	}
+	err = repo.RevokeRefreshTokenFamily(ctx, "no-such-family")
+	if err != nil {
+		t.Errorf("expected revoking an empty family to do nothing, but got %s", err)
+	}
+
+	otherID := insertUser(t, repo, "Jack", "Smith", "jack@smith.com")
+	_, _ = repo.InsertRefreshToken(ctx, data.RefreshToken{UserID: otherID, FamilyID: "jack", JTI: "fourth", ExpiresAt: expires})
+	err = repo.RevokeUserRefreshTokens(ctx, id)
+	if err != nil {
+		t.Errorf("unexpected error revoking the user's tokens: %s", err)
+	}
+	if got, _ = repo.GetRefreshToken(ctx, "third"); !got.Revoked {
+		t.Error("expected every family of the user's to be revoked")
+	}
+	if got, _ = repo.GetRefreshToken(ctx, "fourth"); got.Revoked {
+		t.Error("expected another user's token to be left alone")
+	}
+
+	_, err = repo.GetRefreshToken(ctx, "unknown")
+	if !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("expected ErrNotFound getting an unknown token, but got %v", err)
+	}
+	_, err = repo.InsertRefreshToken(ctx, data.RefreshToken{UserID: id, FamilyID: "family", JTI: "first", ExpiresAt: expires})
+	if !errors.Is(err, repository.ErrConflict) {
+		t.Errorf("expected ErrConflict reusing a jti, but got %v", err)
+	}
+	_, err = repo.InsertRefreshToken(ctx, data.RefreshToken{UserID: id + 100, FamilyID: "family", JTI: "nobody", ExpiresAt: expires})
+	if !errors.Is(err, repository.ErrConflict) {
+		t.Errorf("expected ErrConflict for a token for a user who doesn't exist, but got %v", err)
+	}
+}
+
+// testConcurrentRefresh rotates the same refresh token from several goroutines at once, the way
+// the api does, and checks that only one of them gets to.
+func testConcurrentRefresh(t *testing.T, repo repository.DatabaseRepo) {
+	ctx := context.Background()
+	id := insertUser(t, repo, "Admin", "User", "admin@example.com")
+	expires := time.Now().UTC().Add(time.Hour)
+	_, err := repo.InsertRefreshToken(ctx, data.RefreshToken{UserID: id, FamilyID: "family", JTI: "stolen", ExpiresAt: expires})
+	if err != nil {
+		t.Fatalf("unexpected error inserting token: %s", err)
+	}
+
+	const refreshes = 10
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	rotated := 0
+	for i := 0; i < refreshes; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			err := repo.WithTx(ctx, func(tx repository.DatabaseRepo) error {
+				revoked, err := tx.RevokeRefreshToken(ctx, "stolen")
+				if err != nil || revoked == 0 {
+					return err
+				}
+				_, err = tx.InsertRefreshToken(ctx, data.RefreshToken{UserID: id, FamilyID: "family", JTI: fmt.Sprintf("rotated-%d", i), ExpiresAt: expires})
+				if err != nil {
+					return err
+				}
+				mu.Lock()
+				rotated++
+				mu.Unlock()
+				return nil
+			})
+			if err != nil {
+				t.Errorf("unexpected error rotating: %s", err)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	if rotated != 1 {
+		t.Errorf("expected the token to be rotated once, but it was rotated %d times", rotated)
+	}
+}
+
+func testAuditEvents(t *testing.T, repo repository.DatabaseRepo) {
+	ctx := context.Background()
+	userID := 7
+	// the times go in and come out of the database, which keeps microseconds
+	start := time.Now().UTC().Add(-time.Hour).Truncate(time.Microsecond)
+
+	for i, e := range []data.AuditEvent{
+		{Event: data.AuditLoginFailed, Email: "jack@smith.com", Reason: "unknown email", IP: "10.0.0.1", UserAgent: "curl/7.79.1"},
+		{Event: data.AuditLoginFailed, UserID: &userID, Email: "jill@smith.com", Reason: "wrong password", IP: "10.0.0.1"},
+		{Event: data.AuditLoginSucceeded, UserID: &userID, Email: "jill@smith.com", IP: "10.0.0.2", RequestID: "request"},
+		{Event: data.AuditTokenRejected, Reason: "expired token", IP: "10.0.0.2"},
+	} {
+		e.CreatedAt = start.Add(time.Duration(i) * time.Minute)
+		id, err := repo.InsertAuditEvent(ctx, e)
+		if err != nil || id < 1 {
+			t.Fatalf("expected an id for the event, but got %d, %v", id, err)
+		}
+	}
+	// with no time, the event happens now
+	_, _ = repo.InsertAuditEvent(ctx, data.AuditEvent{Event: data.AuditLogout, UserID: &userID, IP: "10.0.0.2"})
+
+	events, page, err := repo.ListAuditEvents(ctx, repository.AuditFilter{})
+	if err != nil {
+		t.Fatalf("unexpected error listing events: %s", err)
+	}
+	if len(events) != 5 || page.HasMore {
+		t.Fatalf("expected all 5 events on one page, but got %d and %+v", len(events), page)
+	}
+	if events[0].Event != data.AuditLogout || time.Since(events[0].CreatedAt) > time.Minute {
+		t.Errorf("expected the logout, just now, to be first, but got %s at %s", events[0].Event, events[0].CreatedAt)
+	}
+	got := events[2]
+	if got.Event != data.AuditLoginSucceeded || got.UserID == nil || *got.UserID != userID || got.Email != "jill@smith.com" ||
+		got.IP != "10.0.0.2" || got.RequestID != "request" || !got.CreatedAt.Equal(start.Add(2*time.Minute)) {
+		t.Errorf("expected the login to be stored as it was, but got %+v", got)
+	}
+	if got = events[4]; got.UserID != nil || got.Reason != "unknown email" || got.UserAgent != "curl/7.79.1" {
+		t.Errorf("expected the failed login of nobody to be stored as it was, but got %+v", got)
+	}
+
+	since, until := start.Add(time.Minute), start.Add(3*time.Minute)
+	var tests = []struct {
+		name     string
+		filter   repository.AuditFilter
+		expected []string
+	}{
+		{"by event", repository.AuditFilter{Event: data.AuditLoginFailed}, []string{"wrong password", "unknown email"}},
+		{"by user", repository.AuditFilter{UserID: &userID, Limit: 1}, []string{"", "", "wrong password"}},
+		{"by email, ignoring case", repository.AuditFilter{Email: "JACK@smith.com"}, []string{"unknown email"}},
+		{"by ip", repository.AuditFilter{IP: "10.0.0.1", Limit: 1}, []string{"wrong password", "unknown email"}},
+		{"between times", repository.AuditFilter{Since: &since, Until: &until}, []string{"", "wrong password"}},
+		{"nothing", repository.AuditFilter{Event: data.AuditPasswordReset}, nil},
+	}
+
+	for _, e := range tests {
+		var got []string
+		f := e.filter
+		for pages := 0; pages < 10; pages++ {
+			events, page, err := repo.ListAuditEvents(ctx, f)
+			if err != nil {
+				t.Fatalf("%s: unexpected error: %s", e.name, err)
+			}
+			for _, event := range events {
+				got = append(got, event.Reason)
+			}
+			if !page.HasMore {
+				break
+			}
+			f.Before = page.NextBefore
+		}
+
+		if fmt.Sprint(got) != fmt.Sprint(e.expected) {
+			t.Errorf("%s: expected %q, but got %q", e.name, e.expected, got)
+		}
+	}
+
+	_, _, err = repo.ListAuditEvents(ctx, repository.AuditFilter{Event: "nonsense"})
+	if err == nil {
+		t.Error("expected an error for an unknown event")
+	}
+}
+
+func testLoginFailures(t *testing.T, repo repository.DatabaseRepo) {
+	ctx := context.Background()
+	account := "jack@smith.com"
+
+	_, err := repo.GetLoginFailures(ctx, account)
+	if !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("expected ErrNotFound for an account with no failures, but got %v", err)
+	}
+	err = repo.LockLogin(ctx, account, time.Now().Add(time.Hour))
+	if !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("expected ErrNotFound locking an account with no failures, but got %v", err)
+	}
+
+	for i := 1; i <= 3; i++ {
+		failures, err := repo.RecordLoginFailure(ctx, account, time.Now().Add(-time.Hour))
+		if err != nil || failures != i {
+			t.Fatalf("expected failure %d, but got %d, %v", i, failures, err)
+		}
+	}
+
+	until := time.Now().UTC().Add(time.Hour).Truncate(time.Microsecond)
+	err = repo.LockLogin(ctx, account, until)
+	if err != nil {
+		t.Fatalf("unexpected error locking: %s", err)
+	}
+	f, err := repo.GetLoginFailures(ctx, account)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if f.Account != account || f.Failures != 3 || time.Since(f.LastFailureAt) > time.Minute ||
+		f.LockedUntil == nil || !f.LockedUntil.Equal(until) || !f.IsLocked(time.Now()) {
+		t.Errorf("expected 3 failures, locked until %s, but got %+v", until, f)
+	}
+
+	// failures before resetBefore are forgotten, but the lock is left alone
+	failures, _ := repo.RecordLoginFailure(ctx, account, time.Now().Add(time.Minute))
+	if failures != 1 {
+		t.Errorf("expected the count to start again, but got %d", failures)
+	}
+	if f, _ = repo.GetLoginFailures(ctx, account); f == nil || !f.IsLocked(time.Now()) {
+		t.Errorf("expected the account to still be locked, but got %+v", f)
+	}
+
+	// other accounts are counted separately
+	failures, _ = repo.RecordLoginFailure(ctx, "jill@smith.com", time.Time{})
+	if failures != 1 {
+		t.Errorf("expected another account to have 1 failure, but got %d", failures)
+	}
+
+	err = repo.ClearLoginFailures(ctx, account)
+	if err != nil {
+		t.Fatalf("unexpected error clearing: %s", err)
+	}
+	_, err = repo.GetLoginFailures(ctx, account)
+	if !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("expected the failures to be cleared, but got %v", err)
+	}
+	if err = repo.ClearLoginFailures(ctx, account); err != nil {
+		t.Errorf("expected clearing again to be fine, but got %s", err)
+	}
+}
+
+func testMFA(t *testing.T, repo repository.DatabaseRepo) {
+	ctx := context.Background()
+	id := insertUser(t, repo, "Jack", "Smith", "jack@smith.com")
+
+	_, err := repo.GetUserMFA(ctx, id)
+	if !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("expected ErrNotFound for a user who never set up mfa, but got %v", err)
+	}
+	for name, err := range map[string]error{
+		"enable":        repo.EnableMFA(ctx, id, 1, nil),
+		"delete":        repo.DeleteUserMFA(ctx, id),
+		"recovery code": repo.UseRecoveryCode(ctx, id, "hash"),
+	} {
+		if !errors.Is(err, repository.ErrNotFound) {
+			t.Errorf("%s: expected ErrNotFound without mfa, but got %v", name, err)
+		}
+	}
+	if err = repo.UseMFAStep(ctx, id, 1); !errors.Is(err, repository.ErrConflict) {
+		t.Errorf("expected ErrConflict using a step without mfa, but got %v", err)
+	}
+	if err = repo.SetMFASecret(ctx, 1000, "SECRET"); !errors.Is(err, repository.ErrConflict) {
+		t.Errorf("expected ErrConflict setting up mfa for nobody, but got %v", err)
+	}
+
+	// setting up again before enabling starts over
+	for _, secret := range []string{"FIRST", "SECOND"} {
+		err = repo.SetMFASecret(ctx, id, secret)
+		if err != nil {
+			t.Fatalf("unexpected error setting secret %s: %s", secret, err)
+		}
+	}
+	mfa, err := repo.GetUserMFA(ctx, id)
+	if err != nil || mfa.Secret != "SECOND" || mfa.Enabled() || mfa.RecoveryCodesLeft != 0 {
+		t.Fatalf("expected a second secret, not enabled, but got %+v, %v", mfa, err)
+	}
+	// steps aren't used until it is enabled
+	if err = repo.UseMFAStep(ctx, id, 100); !errors.Is(err, repository.ErrConflict) {
+		t.Errorf("expected ErrConflict using a step before enabling, but got %v", err)
+	}
+
+	err = repo.EnableMFA(ctx, id, 100, []string{"a", "b", "c"})
+	if err != nil {
+		t.Fatalf("unexpected error enabling: %s", err)
+	}
+	mfa, _ = repo.GetUserMFA(ctx, id)
+	if mfa == nil || !mfa.Enabled() || mfa.LastStep != 100 || mfa.RecoveryCodesLeft != 3 || time.Since(*mfa.EnabledAt) > time.Minute {
+		t.Errorf("expected mfa enabled at step 100, with 3 codes, but got %+v", mfa)
+	}
+	if err = repo.EnableMFA(ctx, id, 101, nil); !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("expected ErrNotFound enabling again, but got %v", err)
+	}
+	if err = repo.SetMFASecret(ctx, id, "THIRD"); !errors.Is(err, repository.ErrConflict) {
+		t.Errorf("expected ErrConflict setting a secret once enabled, but got %v", err)
+	}
+
+	// each step works once, and never before the last one
+	var steps = []struct {
+		step        int64
+		expectedErr error
+	}{
+		{100, repository.ErrConflict},
+		{101, nil},
+		{101, repository.ErrConflict},
+		{99, repository.ErrConflict},
+		{105, nil},
+	}
+	for _, e := range steps {
+		err = repo.UseMFAStep(ctx, id, e.step)
+		if !errors.Is(err, e.expectedErr) {
+			t.Errorf("step %d: expected %v, but got %v", e.step, e.expectedErr, err)
+		}
+	}
+
+	// and so does each recovery code
+	if err = repo.UseRecoveryCode(ctx, id, "b"); err != nil {
+		t.Errorf("unexpected error using a recovery code: %s", err)
+	}
+	for _, hash := range []string{"b", "d"} {
+		if err = repo.UseRecoveryCode(ctx, id, hash); !errors.Is(err, repository.ErrNotFound) {
+			t.Errorf("%s: expected ErrNotFound, but got %v", hash, err)
+		}
+	}
+	if mfa, _ = repo.GetUserMFA(ctx, id); mfa == nil || mfa.RecoveryCodesLeft != 2 {
+		t.Errorf("expected 2 codes left, but got %+v", mfa)
+	}
+
+	err = repo.SetRecoveryCodes(ctx, id, []string{"b", "e"})
+	if err != nil {
+		t.Fatalf("unexpected error setting recovery codes: %s", err)
+	}
+	if mfa, _ = repo.GetUserMFA(ctx, id); mfa == nil || mfa.RecoveryCodesLeft != 2 {
+		t.Errorf("expected 2 new codes, but got %+v", mfa)
+	}
+	if err = repo.UseRecoveryCode(ctx, id, "a"); !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("expected an old code to be gone, but got %v", err)
+	}
+	if err = repo.UseRecoveryCode(ctx, id, "b"); err != nil {
+		t.Errorf("expected a new code to work, even if an old one was the same, but got %v", err)
+	}
+
+	err = repo.DeleteUserMFA(ctx, id)
+	if err != nil {
+		t.Fatalf("unexpected error deleting: %s", err)
+	}
+	if _, err = repo.GetUserMFA(ctx, id); !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("expected mfa to be gone, but got %v", err)
+	}
+	if err = repo.UseRecoveryCode(ctx, id, "e"); !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("expected the recovery codes to be gone, but got %v", err)
+	}
+}
+
+func testPasswordResets(t *testing.T, repo repository.DatabaseRepo) {
+	ctx := context.Background()
+	id := insertUser(t, repo, "Jack", "Smith", "jack@smith.com")
+	other := insertUser(t, repo, "Jill", "Smith", "jill@smith.com")
+
+	later, earlier := time.Now().UTC().Add(time.Hour), time.Now().UTC().Add(-time.Hour)
+	for _, r := range []data.PasswordReset{
+		{UserID: id, TokenHash: "first", ExpiresAt: later},
+		{UserID: id, TokenHash: "second", ExpiresAt: later},
+		{UserID: id, TokenHash: "expired", ExpiresAt: earlier},
+		{UserID: other, TokenHash: "other", ExpiresAt: later},
+	} {
+		newID, err := repo.InsertPasswordReset(ctx, r)
+		if err != nil || newID == 0 {
+			t.Fatalf("unexpected error inserting reset %s: %d, %v", r.TokenHash, newID, err)
+		}
+	}
+	for name, r := range map[string]data.PasswordReset{
+		"for nobody":    {UserID: id + 100, TokenHash: "nobody", ExpiresAt: later},
+		"the same hash": {UserID: other, TokenHash: "first", ExpiresAt: later},
+	} {
+		if _, err := repo.InsertPasswordReset(ctx, r); !errors.Is(err, repository.ErrConflict) {
+			t.Errorf("%s: expected ErrConflict, but got %v", name, err)
+		}
+	}
+
+	// each reset works once, before it expires, and using one uses up the user's others
+	var tests = []struct {
+		hash           string
+		expectedUserID int
+		expectedErr    error
+	}{
+		{"unknown", 0, repository.ErrNotFound},
+		{"expired", 0, repository.ErrNotFound},
+		{"first", id, nil},
+		{"first", 0, repository.ErrNotFound},
+		{"second", 0, repository.ErrNotFound},
+		{"other", other, nil},
+	}
+	for _, e := range tests {
+		userID, err := repo.UsePasswordReset(ctx, e.hash)
+		if userID != e.expectedUserID || !errors.Is(err, e.expectedErr) {
+			t.Errorf("%s: expected user %d and %v, but got %d and %v", e.hash, e.expectedUserID, e.expectedErr, userID, err)
+		}
+	}
+}
+
+func testRoles(t *testing.T, repo repository.DatabaseRepo) {
+	ctx := context.Background()
+	id := insertUser(t, repo, "Admin", "User", "admin@example.com")
+
+	roles, err := repo.GetUserRoles(ctx, id)
+	if err != nil || roles == nil || len(roles) != 0 {
+		t.Errorf("expected an empty list of roles, but got %#v, %v", roles, err)
+	}
+	permissions, err := repo.GetUserPermissions(ctx, id)
+	if err != nil || permissions == nil || len(permissions) != 0 {
+		t.Errorf("expected an empty list of permissions, but got %#v, %v", permissions, err)
+	}
+
+	for _, role := range []string{authz.RoleSupport, authz.RoleAuditor, authz.RoleSupport} {
+		err = repo.AssignRole(ctx, id, role)
+		if err != nil {
+			t.Errorf("unexpected error assigning %s: %s", role, err)
+		}
+	}
+	err = repo.AssignRole(ctx, id, "no-such-role")
+	if !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("expected ErrNotFound for an unknown role, but got %v", err)
+	}
+	err = repo.AssignRole(ctx, id+100, authz.RoleSupport)
+	if !errors.Is(err, repository.ErrConflict) {
+		t.Errorf("expected ErrConflict giving a role to a user who doesn't exist, but got %v", err)
+	}
+
+	roles, _ = repo.GetUserRoles(ctx, id)
+	if fmt.Sprint(roles) != fmt.Sprint([]string{authz.RoleAuditor, authz.RoleSupport}) {
+		t.Errorf("expected auditor and support, in order, but got %v", roles)
+	}
+	// support and auditor both grant users:read, which is only listed once
+	permissions, _ = repo.GetUserPermissions(ctx, id)
+	if fmt.Sprint(permissions) != fmt.Sprint([]string{authz.AuditRead, authz.UsersRead, authz.UsersUpdate}) {
+		t.Errorf("expected support's and auditor's permissions, in order, but got %v", permissions)
+	}
+
+	_ = repo.AssignRole(ctx, id, authz.RoleAdmin)
+	permissions, _ = repo.GetUserPermissions(ctx, id)
+	if len(permissions) != 5 {
+		t.Errorf("expected an admin to have every permission, but got %v", permissions)
+	}
+
+	for _, role := range []string{authz.RoleAdmin, authz.RoleSupport, authz.RoleSupport, "no-such-role"} {
+		err = repo.RevokeRole(ctx, id, role)
+		if err != nil {
+			t.Errorf("expected revoking %s to succeed, but got %s", role, err)
+		}
+	}
+	roles, _ = repo.GetUserRoles(ctx, id)
+	if fmt.Sprint(roles) != fmt.Sprint([]string{authz.RoleAuditor}) {
+		t.Errorf("expected only auditor to be left, but got %v", roles)
+	}
+}
+
+func testWithTx(t *testing.T, repo repository.DatabaseRepo) {
+	ctx := context.Background()
+	failed := errors.New("something went wrong")
+
+	// an error rolls back everything done in the transaction
+	err := repo.WithTx(ctx, func(tx repository.DatabaseRepo) error {
+		id, err := tx.InsertUser(ctx, data.User{FirstName: "Rolled", LastName: "Back", Email: "rolled@back.com", Password: "secret"})
+		if err != nil {
+			return err
+		}
+		if _, err := tx.GetUser(ctx, id); err != nil {
+			return fmt.Errorf("expected the transaction to see its own insert: %w", err)
+		}
+		return failed
+	})
+	if !errors.Is(err, failed) {
+		t.Errorf("expected the error from fn, but got %v", err)
+	}
+	_, err = repo.GetUserByEmail(ctx, "rolled@back.com")
+	if !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("expected the rolled back user to be gone, but got %v", err)
+	}
+
+	// a failed step rolls back the steps before it
+	err = repo.WithTx(ctx, func(tx repository.DatabaseRepo) error {
+		id, err := tx.InsertUser(ctx, data.User{FirstName: "Half", LastName: "Done", Email: "half@done.com", Password: "secret"})
+		if err != nil {
+			return err
+		}
+		return tx.AssignRole(ctx, id, "no-such-role")
+	})
+	if !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("expected an unknown role to fail, but got %v", err)
+	}
+	_, err = repo.GetUserByEmail(ctx, "half@done.com")
+	if !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("expected the user to be rolled back along with the role, but got %v", err)
+	}
+
+	// nil commits, and a nested transaction is part of the outer one
+	var id int
+	err = repo.WithTx(ctx, func(tx repository.DatabaseRepo) error {
+		var err error
+		id, err = tx.InsertUser(ctx, data.User{FirstName: "Committed", LastName: "User", Email: "committed@user.com", Password: "secret"})
+		if err != nil {
+			return err
+		}
+		return tx.WithTx(ctx, func(tx repository.DatabaseRepo) error {
+			return tx.AssignRole(ctx, id, authz.RoleAuditor)
+		})
+	})
+	if err != nil {
+		t.Fatalf("unexpected error committing: %s", err)
+	}
+	roles, _ := repo.GetUserRoles(ctx, id)
+	if len(roles) != 1 || roles[0] != authz.RoleAuditor {
+		t.Errorf("expected the committed user to be an auditor, but got %v", roles)
+	}
+
+	// a panic rolls back, and carries on up
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Error("expected the panic to be re-raised")
+			}
+		}()
+		_ = repo.WithTx(ctx, func(tx repository.DatabaseRepo) error {
+			_ = tx.RevokeRole(ctx, id, authz.RoleAuditor)
+			panic("oops")
+		})
+	}()
+	roles, _ = repo.GetUserRoles(ctx, id)
+	if len(roles) != 1 {
+		t.Errorf("expected the panicking transaction to be rolled back, but got roles %v", roles)
+	}
+
+	// the repository is still usable afterwards
+	_, err = repo.GetUser(ctx, id)
+	if err != nil {
+		t.Errorf("unexpected error after the transactions: %s", err)
+	}
+}
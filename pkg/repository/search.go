@@ -0,0 +1,82 @@
+package repository
+
+import (
+	"html"
+	"regexp"
+	"sort"
+	"strings"
+	"testingCourserWeb/pkg/data"
+)
+
+// maxSearchTerms is the most words of a search we pay attention to.
+const maxSearchTerms = 10
+
+// SearchTerms splits a search into lower case words, dropping repeats.
+func SearchTerms(q string) []string {
+	var terms []string
+	seen := make(map[string]bool)
+
+	for _, term := range strings.Fields(strings.ToLower(q)) {
+		if seen[term] {
+			continue
+		}
+		seen[term] = true
+		terms = append(terms, term)
+		if len(terms) == maxSearchTerms {
+			break
+		}
+	}
+	return terms
+}
+
+// Highlight escapes text for use in HTML, and wraps every case insensitive match of any
+// of terms in <mark> tags. It also reports whether there were any matches.
+func Highlight(text string, terms []string) (string, bool) {
+	if len(terms) == 0 || text == "" {
+		return html.EscapeString(text), false
+	}
+
+	// try longer terms first, so that a term which contains another is marked as a whole
+	sorted := append([]string(nil), terms...)
+	sort.Slice(sorted, func(i, j int) bool { return len(sorted[i]) > len(sorted[j]) })
+
+	quoted := make([]string, len(sorted))
+	for i, term := range sorted {
+		quoted[i] = regexp.QuoteMeta(term)
+	}
+	re := regexp.MustCompile("(?i)" + strings.Join(quoted, "|"))
+
+	matches := re.FindAllStringIndex(text, -1)
+	if len(matches) == 0 {
+		return html.EscapeString(text), false
+	}
+
+	var b strings.Builder
+	last := 0
+	for _, m := range matches {
+		b.WriteString(html.EscapeString(text[last:m[0]]))
+		b.WriteString("<mark>")
+		b.WriteString(html.EscapeString(text[m[0]:m[1]]))
+		b.WriteString("</mark>")
+		last = m[1]
+	}
+	b.WriteString(html.EscapeString(text[last:]))
+	return b.String(), true
+}
+
+// HighlightUser returns the highlighted name and email fields of u which match any of terms.
+func HighlightUser(u *data.User, terms []string) map[string]string {
+	highlights := make(map[string]string)
+
+	fields := map[string]string{
+		"first_name": u.FirstName,
+		"last_name":  u.LastName,
+		"email":      u.Email,
+	}
+	for name, value := range fields {
+		if highlighted, ok := Highlight(value, terms); ok {
+			highlights[name] = highlighted
+		}
+	}
+	return highlights
+}
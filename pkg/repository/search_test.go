@@ -0,0 +1,56 @@
+package repository
+
+import (
+	"testing"
+	"testingCourserWeb/pkg/data"
+)
+
+func TestSearchTerms(t *testing.T) {
+	terms := SearchTerms("  Jack  SMITH jack ")
+	if len(terms) != 2 || terms[0] != "jack" || terms[1] != "smith" {
+		t.Errorf("expected [jack smith], but got %v", terms)
+	}
+
+	if len(SearchTerms("   ")) != 0 {
+		t.Error("expected no terms for a blank search")
+	}
+}
+
+func TestHighlight(t *testing.T) {
+	var tests = []struct {
+		name            string
+		text            string
+		terms           []string
+		expected        string
+		expectedMatched bool
+	}{
+		{"no match", "Jack", []string{"bob"}, "Jack", false},
+		{"case insensitive", "Jack Jackson", []string{"jack"}, "<mark>Jack</mark> <mark>Jack</mark>son", true},
+		{"longest term first", "jackson", []string{"jack", "jackson"}, "<mark>jackson</mark>", true},
+		{"escapes html", "<b>jack</b>", []string{"jack"}, "&lt;b&gt;<mark>jack</mark>&lt;/b&gt;", true},
+		{"escapes matched html", "a<b", []string{"<"}, "a<mark>&lt;</mark>b", true},
+		{"regexp characters", "jack.smith@example.com", []string{"k.s"}, "jac<mark>k.s</mark>mith@example.com", true},
+	}
+
+	for _, e := range tests {
+		got, matched := Highlight(e.text, e.terms)
+		if got != e.expected {
+			t.Errorf("%s: expected %q, but got %q", e.name, e.expected, got)
+		}
+		if matched != e.expectedMatched {
+			t.Errorf("%s: expected matched to be %t, but got %t", e.name, e.expectedMatched, matched)
+		}
+	}
+}
+
+func TestHighlightUser(t *testing.T) {
+	u := data.User{FirstName: "Jack", LastName: "Smith", Email: "jack@smith.com"}
+
+	highlights := HighlightUser(&u, SearchTerms("smi"))
+	if len(highlights) != 2 {
+		t.Errorf("expected last_name and email to be highlighted, but got %v", highlights)
+	}
+	if _, ok := highlights["first_name"]; ok {
+		t.Error("first_name should not be highlighted")
+	}
+}
@@ -0,0 +1,79 @@
+package signing
+
+import (
+	"sync"
+	"time"
+)
+
+// Keyring holds the key we sign new tokens with (the active key), along with every other
+// key that may still verify tokens: keys that are about to be promoted, and retired keys
+// whose tokens have not all expired yet. It is safe for concurrent use, and its contents
+// can be swapped out while the application is running.
+type Keyring struct {
+	mu          sync.RWMutex
+	active      *Key
+	keys        []*Key
+	verifyUntil map[string]time.Time
+}
+
+// NewKeyring returns a keyring which signs with active, and also verifies with others.
+func NewKeyring(active *Key, others ...*Key) *Keyring {
+	kr := &Keyring{
+		active:      active,
+		keys:        []*Key{active},
+		verifyUntil: make(map[string]time.Time),
+	}
+	kr.keys = append(kr.keys, others...)
+	return kr
+}
+
+// Active returns the key new tokens should be signed with.
+func (kr *Keyring) Active() *Key {
+	kr.mu.RLock()
+	defer kr.mu.RUnlock()
+	return kr.active
+}
+
+// Lookup returns the key with the given id, if it may still verify tokens.
+func (kr *Keyring) Lookup(kid string) (*Key, bool) {
+	kr.mu.RLock()
+	defer kr.mu.RUnlock()
+
+	for _, k := range kr.keys {
+		if k.ID == kid {
+			if until, ok := kr.verifyUntil[kid]; ok && time.Now().After(until) {
+				return nil, false
+			}
+			return k, true
+		}
+	}
+	return nil, false
+}
+
+// VerifyingKeys returns every key that may still verify tokens, active key first.
+func (kr *Keyring) VerifyingKeys() []*Key {
+	kr.mu.RLock()
+	defer kr.mu.RUnlock()
+
+	var keys []*Key
+	for _, k := range kr.keys {
+		if until, ok := kr.verifyUntil[k.ID]; ok && time.Now().After(until) {
+			continue
+		}
+		keys = append(keys, k)
+	}
+	return keys
+}
+
+// Replace swaps the contents of the keyring for those of other. We use this to pick up
+// changes to the keyring file without restarting.
+func (kr *Keyring) Replace(other *Keyring) {
+	other.mu.RLock()
+	defer other.mu.RUnlock()
+	kr.mu.Lock()
+	defer kr.mu.Unlock()
+
+	kr.active = other.active
+	kr.keys = other.keys
+	kr.verifyUntil = other.verifyUntil
+}
@@ -0,0 +1,273 @@
+package signing
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// The states a key moves through during rotation. A new key starts out pending, which means it
+// is published and trusted but not used for signing yet, giving everyone who caches our JWKS time
+// to pick it up. Promoting it makes it active, and retires the previously active key; a retired key
+// keeps verifying tokens until they have all expired, and can then be pruned.
+const (
+	StatePending = "pending"
+	StateActive  = "active"
+	StateRetired = "retired"
+)
+
+// KeyEntry is one key, as stored in a keyring file.
+type KeyEntry struct {
+	ID          string     `json:"kid"`
+	Algorithm   string     `json:"alg"`
+	State       string     `json:"state"`
+	PrivateKey  string     `json:"private_key"`
+	CreatedAt   time.Time  `json:"created_at"`
+	ActivatedAt *time.Time `json:"activated_at,omitempty"`
+	RetiredAt   *time.Time `json:"retired_at,omitempty"`
+	VerifyUntil *time.Time `json:"verify_until,omitempty"`
+}
+
+// KeyringFile is the on disk form of a keyring. It is managed with the cli, and read by the api.
+type KeyringFile struct {
+	Keys []*KeyEntry `json:"keys"`
+}
+
+// ReadKeyringFile reads a keyring file. A file that does not exist yet is an empty keyring.
+func ReadKeyringFile(path string) (*KeyringFile, error) {
+	var f KeyringFile
+
+	b, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return &f, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	err = json.Unmarshal(b, &f)
+	if err != nil {
+		return nil, fmt.Errorf("invalid keyring file %s: %w", path, err)
+	}
+	return &f, nil
+}
+
+// LoadKeyring reads a keyring file, and returns the keyring it describes.
+func LoadKeyring(path string) (*Keyring, error) {
+	f, err := ReadKeyringFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return f.Keyring()
+}
+
+// Write saves the keyring file. We write to a temporary file and rename it, so that
+// a running api never reads a half written keyring.
+func (f *KeyringFile) Write(path string) error {
+	b, err := json.MarshalIndent(f, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".keyring-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	_, err = tmp.Write(b)
+	if err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	err = tmp.Close()
+	if err != nil {
+		return err
+	}
+
+	err = os.Chmod(tmp.Name(), 0600)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// Generate creates a new key and adds it to the file. If there is no active key yet, the new key
+// becomes the active one; otherwise it is pending until promoted.
+func (f *KeyringFile) Generate(alg string) (*KeyEntry, error) {
+	key, err := GenerateKey(alg)
+	if err != nil {
+		return nil, err
+	}
+
+	privateKey, err := key.MarshalPrivateKey()
+	if err != nil {
+		return nil, err
+	}
+	if key.IsSymmetric() {
+		privateKey = []byte(base64.StdEncoding.EncodeToString(privateKey))
+	}
+
+	now := time.Now().UTC()
+	entry := &KeyEntry{
+		ID:         key.ID,
+		Algorithm:  alg,
+		State:      StatePending,
+		PrivateKey: string(privateKey),
+		CreatedAt:  now,
+	}
+	if f.active() == nil {
+		entry.State = StateActive
+		entry.ActivatedAt = &now
+	}
+
+	f.Keys = append(f.Keys, entry)
+	return entry, nil
+}
+
+// Promote makes the key with the given id the active key. The previously active key is retired,
+// and keeps verifying tokens for overlap, which should be at least as long as our longest lived token.
+func (f *KeyringFile) Promote(kid string, overlap time.Duration) error {
+	entry := f.find(kid)
+	if entry == nil {
+		return fmt.Errorf("no key with id %s", kid)
+	}
+	if entry.State == StateActive {
+		return nil
+	}
+	if entry.State == StateRetired {
+		return fmt.Errorf("key %s has been retired, and cannot be promoted", kid)
+	}
+
+	now := time.Now().UTC()
+	if current := f.active(); current != nil {
+		current.retire(now, overlap)
+	}
+	entry.State = StateActive
+	entry.ActivatedAt = &now
+	return nil
+}
+
+// Retire stops a pending key from ever being used, or shortens the overlap of an already retired key.
+// The active key cannot be retired; promote another key instead. A key which was never active has
+// signed nothing, so it gets no overlap, whatever is asked for, and stops verifying at once.
+func (f *KeyringFile) Retire(kid string, overlap time.Duration) error {
+	entry := f.find(kid)
+	if entry == nil {
+		return fmt.Errorf("no key with id %s", kid)
+	}
+	if entry.State == StateActive {
+		return fmt.Errorf("key %s is active; promote another key first", kid)
+	}
+	if entry.ActivatedAt == nil {
+		overlap = 0
+	}
+
+	entry.retire(time.Now().UTC(), overlap)
+	return nil
+}
+
+// Prune removes retired keys that no longer verify anything, and returns their ids.
+func (f *KeyringFile) Prune() []string {
+	var pruned []string
+	var keep []*KeyEntry
+
+	now := time.Now()
+	for _, entry := range f.Keys {
+		if entry.State == StateRetired && entry.VerifyUntil != nil && now.After(*entry.VerifyUntil) {
+			pruned = append(pruned, entry.ID)
+			continue
+		}
+		keep = append(keep, entry)
+	}
+
+	f.Keys = keep
+	return pruned
+}
+
+// Keyring builds a keyring from the file. There must be exactly one active key.
+func (f *KeyringFile) Keyring() (*Keyring, error) {
+	var active *Key
+	var others []*Key
+	verifyUntil := make(map[string]time.Time)
+
+	for _, entry := range f.Keys {
+		key, err := entry.key()
+		if err != nil {
+			return nil, err
+		}
+
+		switch entry.State {
+		case StateActive:
+			if active != nil {
+				return nil, fmt.Errorf("keyring has more than one active key: %s and %s", active.ID, key.ID)
+			}
+			active = key
+		case StateRetired:
+			if entry.VerifyUntil != nil {
+				verifyUntil[key.ID] = *entry.VerifyUntil
+			}
+			others = append(others, key)
+		default:
+			others = append(others, key)
+		}
+	}
+
+	if active == nil {
+		return nil, errors.New("keyring has no active key")
+	}
+
+	kr := NewKeyring(active, others...)
+	kr.verifyUntil = verifyUntil
+	return kr, nil
+}
+
+func (f *KeyringFile) find(kid string) *KeyEntry {
+	for _, entry := range f.Keys {
+		if entry.ID == kid {
+			return entry
+		}
+	}
+	return nil
+}
+
+func (f *KeyringFile) active() *KeyEntry {
+	for _, entry := range f.Keys {
+		if entry.State == StateActive {
+			return entry
+		}
+	}
+	return nil
+}
+
+func (e *KeyEntry) retire(now time.Time, overlap time.Duration) {
+	until := now.Add(overlap)
+	e.State = StateRetired
+	if e.RetiredAt == nil {
+		e.RetiredAt = &now
+	}
+	if e.VerifyUntil == nil || until.Before(*e.VerifyUntil) {
+		e.VerifyUntil = &until
+	}
+}
+
+func (e *KeyEntry) key() (*Key, error) {
+	material := []byte(e.PrivateKey)
+	if e.Algorithm == AlgHS256 {
+		secret, err := base64.StdEncoding.DecodeString(e.PrivateKey)
+		if err != nil {
+			return nil, fmt.Errorf("key %s: invalid secret: %w", e.ID, err)
+		}
+		material = secret
+	}
+
+	key, err := NewKey(e.Algorithm, e.ID, material)
+	if err != nil {
+		return nil, fmt.Errorf("key %s: %w", e.ID, err)
+	}
+	return key, nil
+}
@@ -0,0 +1,128 @@
+package signing
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestKeyringFile_rotation(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keyring.json")
+
+	f, err := ReadKeyringFile(path)
+	if err != nil {
+		t.Fatalf("reading a missing keyring file should not fail: %s", err)
+	}
+	if _, err := f.Keyring(); err == nil {
+		t.Error("an empty keyring should not be usable")
+	}
+
+	// the first key becomes active straight away
+	first, _ := f.Generate(AlgHS256)
+	if first.State != StateActive {
+		t.Errorf("expected first key to be %s, but got %s", StateActive, first.State)
+	}
+
+	// later keys wait to be promoted
+	second, _ := f.Generate(AlgRS256)
+	if second.State != StatePending {
+		t.Errorf("expected second key to be %s, but got %s", StatePending, second.State)
+	}
+
+	if err := f.Retire(first.ID, time.Hour); err == nil {
+		t.Error("retiring the active key should fail")
+	}
+
+	if err := f.Promote(second.ID, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if first.State != StateRetired || first.VerifyUntil == nil {
+		t.Errorf("expected previously active key to be retired with an overlap, but got %s", first.State)
+	}
+	if err := f.Promote(first.ID, time.Hour); err == nil {
+		t.Error("promoting a retired key should fail")
+	}
+
+	// write it out, and read it back
+	if err := f.Write(path); err != nil {
+		t.Fatal(err)
+	}
+	kr, err := LoadKeyring(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if kr.Active().ID != second.ID {
+		t.Errorf("expected active key %s, but got %s", second.ID, kr.Active().ID)
+	}
+	if _, ok := kr.Lookup(first.ID); !ok {
+		t.Error("retired key should still verify during the overlap")
+	}
+	if len(kr.VerifyingKeys()) != 2 {
+		t.Errorf("expected 2 verifying keys, but got %d", len(kr.VerifyingKeys()))
+	}
+
+	// nothing to prune while the overlap lasts
+	if pruned := f.Prune(); len(pruned) != 0 {
+		t.Errorf("expected nothing to be pruned, but got %v", pruned)
+	}
+
+	// end the overlap early
+	_ = f.Retire(first.ID, 0)
+	kr, _ = f.Keyring()
+	if _, ok := kr.Lookup(first.ID); ok {
+		t.Error("retired key should not verify once its overlap has ended")
+	}
+
+	pruned := f.Prune()
+	if len(pruned) != 1 || pruned[0] != first.ID {
+		t.Errorf("expected %s to be pruned, but got %v", first.ID, pruned)
+	}
+	if len(f.Keys) != 1 {
+		t.Errorf("expected 1 key left, but got %d", len(f.Keys))
+	}
+}
+
+func TestKeyringFile_retirePending(t *testing.T) {
+	f := &KeyringFile{}
+	first, _ := f.Generate(AlgHS256)
+	pending, _ := f.Generate(AlgEdDSA)
+
+	// a key which never signed anything has nothing to verify, so it gets no overlap
+	if err := f.Retire(pending.ID, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if pending.State != StateRetired || pending.VerifyUntil == nil || pending.VerifyUntil.After(time.Now()) {
+		t.Errorf("expected the pending key to be retired without an overlap, but got %s until %v", pending.State, pending.VerifyUntil)
+	}
+	kr, err := f.Keyring()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := kr.Lookup(pending.ID); ok {
+		t.Error("a retired key which was never active should not verify")
+	}
+	if _, ok := kr.Lookup(first.ID); !ok {
+		t.Error("the active key should still verify")
+	}
+
+	pruned := f.Prune()
+	if len(pruned) != 1 || pruned[0] != pending.ID {
+		t.Errorf("expected %s to be pruned, but got %v", pending.ID, pruned)
+	}
+}
+
+func TestKeyring_Replace(t *testing.T) {
+	first, _ := GenerateKey(AlgHS256)
+	second, _ := GenerateKey(AlgEdDSA)
+
+	kr := NewKeyring(first)
+	kr.Replace(NewKeyring(second, first))
+
+	if kr.Active().ID != second.ID {
+		t.Errorf("expected active key %s after replace, but got %s", second.ID, kr.Active().ID)
+	}
+	if _, ok := kr.Lookup(first.ID); !ok {
+		t.Error("expected first key to still verify after replace")
+	}
+}
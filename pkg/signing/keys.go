@@ -0,0 +1,184 @@
+package signing
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// The signing algorithms we support.
+const (
+	AlgHS256 = "HS256"
+	AlgRS256 = "RS256"
+	AlgEdDSA = "EdDSA"
+)
+
+// Key is a key used to sign and verify JWTs. Every key has an ID, which is sent in the
+// kid header of the tokens it signs, so that whoever verifies a token knows which key to use.
+type Key struct {
+	ID           string
+	Method       jwt.SigningMethod
+	signingKey   interface{}
+	verifyingKey interface{}
+}
+
+// NewHMACKey returns a key which signs tokens with HS256 and a shared secret. If id is empty,
+// one is derived from the secret.
+func NewHMACKey(id string, secret []byte) *Key {
+	if id == "" {
+		sum := sha256.Sum256(secret)
+		id = "hs-" + hex.EncodeToString(sum[:8])
+	}
+	return &Key{
+		ID:           id,
+		Method:       jwt.SigningMethodHS256,
+		signingKey:   secret,
+		verifyingKey: secret,
+	}
+}
+
+// NewRSAKey returns a key which signs tokens with RS256. If id is empty, the
+// JWK thumbprint of the public key is used.
+func NewRSAKey(id string, privateKey *rsa.PrivateKey) *Key {
+	k := &Key{
+		ID:           id,
+		Method:       jwt.SigningMethodRS256,
+		signingKey:   privateKey,
+		verifyingKey: &privateKey.PublicKey,
+	}
+	if k.ID == "" {
+		k.ID = k.thumbprint()
+	}
+	return k
+}
+
+// NewEdDSAKey returns a key which signs tokens with EdDSA (Ed25519). If id is empty, the
+// JWK thumbprint of the public key is used.
+func NewEdDSAKey(id string, privateKey ed25519.PrivateKey) *Key {
+	k := &Key{
+		ID:           id,
+		Method:       jwt.SigningMethodEdDSA,
+		signingKey:   privateKey,
+		verifyingKey: privateKey.Public(),
+	}
+	if k.ID == "" {
+		k.ID = k.thumbprint()
+	}
+	return k
+}
+
+// NewKey builds a key for the given algorithm. For HS256, material is the shared secret; for
+// RS256 and EdDSA it is a PEM encoded private key (PKCS #8, or PKCS #1 for RSA).
+func NewKey(alg, id string, material []byte) (*Key, error) {
+	switch alg {
+	case AlgHS256:
+		if len(material) == 0 {
+			return nil, errors.New("HS256 requires a secret")
+		}
+		return NewHMACKey(id, material), nil
+	case AlgRS256, AlgEdDSA:
+		privateKey, err := parsePrivateKeyPEM(material)
+		if err != nil {
+			return nil, err
+		}
+		switch pk := privateKey.(type) {
+		case *rsa.PrivateKey:
+			if alg == AlgRS256 {
+				return NewRSAKey(id, pk), nil
+			}
+		case ed25519.PrivateKey:
+			if alg == AlgEdDSA {
+				return NewEdDSAKey(id, pk), nil
+			}
+		}
+		return nil, fmt.Errorf("private key does not match algorithm %s", alg)
+	}
+	return nil, fmt.Errorf("unsupported signing algorithm: %s", alg)
+}
+
+// GenerateKey creates a brand new key for the given algorithm, with an id derived from the key.
+func GenerateKey(alg string) (*Key, error) {
+	switch alg {
+	case AlgHS256:
+		secret := make([]byte, 64)
+		_, err := rand.Read(secret)
+		if err != nil {
+			return nil, err
+		}
+		return NewHMACKey("", secret), nil
+	case AlgRS256:
+		privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+		if err != nil {
+			return nil, err
+		}
+		return NewRSAKey("", privateKey), nil
+	case AlgEdDSA:
+		_, privateKey, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return nil, err
+		}
+		return NewEdDSAKey("", privateKey), nil
+	}
+	return nil, fmt.Errorf("unsupported signing algorithm: %s", alg)
+}
+
+// Algorithm returns the JWT alg name for the key, e.g. RS256.
+func (k *Key) Algorithm() string {
+	return k.Method.Alg()
+}
+
+// VerifyingKey returns the key used to verify signatures; this is what a jwt.Keyfunc should return.
+func (k *Key) VerifyingKey() interface{} {
+	return k.verifyingKey
+}
+
+// IsSymmetric returns true if the same secret is used to sign and verify, which means the
+// key must never be published.
+func (k *Key) IsSymmetric() bool {
+	_, ok := k.signingKey.([]byte)
+	return ok
+}
+
+// Sign signs claims with the key, setting the kid header.
+func (k *Key) Sign(claims jwt.Claims) (string, error) {
+	token := jwt.NewWithClaims(k.Method, claims)
+	token.Header["kid"] = k.ID
+	return token.SignedString(k.signingKey)
+}
+
+// MarshalPrivateKey returns the private part of the key in the form NewKey expects: the raw
+// secret for HS256, and a PKCS #8 PEM block for everything else.
+func (k *Key) MarshalPrivateKey() ([]byte, error) {
+	if secret, ok := k.signingKey.([]byte); ok {
+		return secret, nil
+	}
+
+	der, err := x509.MarshalPKCS8PrivateKey(k.signingKey)
+	if err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), nil
+}
+
+// parsePrivateKeyPEM decodes the first PEM block in b, and parses the private key in it.
+func parsePrivateKeyPEM(b []byte) (interface{}, error) {
+	block, _ := pem.Decode(b)
+	if block == nil {
+		return nil, errors.New("no PEM data found")
+	}
+
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		return x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		return x509.ParsePKCS8PrivateKey(block.Bytes)
+	}
+	return nil, fmt.Errorf("unsupported PEM block type: %s", block.Type)
+}
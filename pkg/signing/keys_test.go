@@ -0,0 +1,115 @@
+package signing
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"github.com/golang-jwt/jwt/v4"
+	"testing"
+	"time"
+)
+
+func TestNewKey(t *testing.T) {
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	_, edKey, _ := ed25519.GenerateKey(rand.Reader)
+
+	rsaPKCS1 := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(rsaKey)})
+	rsaDER, _ := x509.MarshalPKCS8PrivateKey(rsaKey)
+	rsaPKCS8 := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: rsaDER})
+	edDER, _ := x509.MarshalPKCS8PrivateKey(edKey)
+	edPKCS8 := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: edDER})
+
+	var tests = []struct {
+		name          string
+		alg           string
+		material      []byte
+		errorExpected bool
+	}{
+		{"hs256", AlgHS256, []byte("secret"), false},
+		{"hs256 without secret", AlgHS256, nil, true},
+		{"rs256 pkcs1", AlgRS256, rsaPKCS1, false},
+		{"rs256 pkcs8", AlgRS256, rsaPKCS8, false},
+		{"eddsa", AlgEdDSA, edPKCS8, false},
+		{"rs256 with ed25519 key", AlgRS256, edPKCS8, true},
+		{"eddsa with rsa key", AlgEdDSA, rsaPKCS8, true},
+		{"not pem", AlgRS256, []byte("not a key"), true},
+		{"unknown algorithm", "none", []byte("secret"), true},
+	}
+
+	for _, e := range tests {
+		key, err := NewKey(e.alg, "", e.material)
+		if err != nil && !e.errorExpected {
+			t.Errorf("%s: did not expect error, but got one - %s", e.name, err)
+		}
+		if err == nil && e.errorExpected {
+			t.Errorf("%s: expected error, but did not get one", e.name)
+		}
+		if err == nil && key.Algorithm() != e.alg {
+			t.Errorf("%s: expected algorithm %s, but got %s", e.name, e.alg, key.Algorithm())
+		}
+	}
+}
+
+func TestKey_Sign(t *testing.T) {
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	_, edKey, _ := ed25519.GenerateKey(rand.Reader)
+
+	keys := []*Key{
+		NewHMACKey("", []byte("secret")),
+		NewRSAKey("", rsaKey),
+		NewEdDSAKey("my-key", edKey),
+	}
+
+	for _, k := range keys {
+		signed, err := k.Sign(jwt.RegisteredClaims{
+			Subject:   "1",
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Minute)),
+		})
+		if err != nil {
+			t.Errorf("%s: error signing token: %s", k.Algorithm(), err)
+		}
+
+		token, err := jwt.Parse(signed, func(token *jwt.Token) (interface{}, error) {
+			return k.VerifyingKey(), nil
+		})
+		if err != nil {
+			t.Errorf("%s: error verifying token: %s", k.Algorithm(), err)
+			continue
+		}
+
+		if token.Header["kid"] != k.ID {
+			t.Errorf("%s: expected kid %s, but got %v", k.Algorithm(), k.ID, token.Header["kid"])
+		}
+	}
+}
+
+func TestKey_PublicJWK(t *testing.T) {
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	_, edKey, _ := ed25519.GenerateKey(rand.Reader)
+
+	if _, ok := NewHMACKey("", []byte("secret")).PublicJWK(); ok {
+		t.Error("symmetric key should not have a public JWK")
+	}
+
+	jwk, ok := NewRSAKey("", rsaKey).PublicJWK()
+	if !ok || jwk.KeyType != "RSA" || jwk.N == "" || jwk.E != "AQAB" {
+		t.Errorf("unexpected RSA JWK: %+v", jwk)
+	}
+
+	jwk, ok = NewEdDSAKey("", edKey).PublicJWK()
+	if !ok || jwk.KeyType != "OKP" || jwk.Curve != "Ed25519" || jwk.X == "" {
+		t.Errorf("unexpected Ed25519 JWK: %+v", jwk)
+	}
+
+	// kid defaults to the thumbprint, so the same key always gets the same id
+	if NewRSAKey("", rsaKey).ID != NewRSAKey("", rsaKey).ID {
+		t.Error("key id derived from the same key should be stable")
+	}
+
+	set := NewJWKS(NewHMACKey("", []byte("secret")), NewRSAKey("", rsaKey), NewEdDSAKey("", edKey))
+	if len(set.Keys) != 2 {
+		t.Errorf("expected 2 keys in the set, but got %d", len(set.Keys))
+	}
+}
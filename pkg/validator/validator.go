@@ -0,0 +1,143 @@
+package validator
+
+import (
+	"context"
+	"errors"
+	"net/mail"
+	"strconv"
+	"strings"
+	"testingCourserWeb/pkg/data"
+	"testingCourserWeb/pkg/repository"
+	"unicode"
+	"unicode/utf8"
+)
+
+// The limits on user fields. Names and emails are varchar(255) in the database, and bcrypt
+// ignores everything after the first 72 bytes of a password.
+const (
+	MaxFieldLength    = 255
+	MinPasswordLength = 8
+	MaxPasswordBytes  = 72
+)
+
+// Errors holds the first problem found with each field, keyed by field name.
+type Errors map[string]string
+
+// Validator collects problems with the fields of a request.
+type Validator struct {
+	Errors Errors
+}
+
+// New returns a validator with no errors.
+func New() *Validator {
+	return &Validator{Errors: make(Errors)}
+}
+
+// Valid returns true if no problems have been found.
+func (v *Validator) Valid() bool {
+	return len(v.Errors) == 0
+}
+
+// Add records a problem with field, unless one has already been recorded; the first
+// problem is the one worth fixing first.
+func (v *Validator) Add(field, message string) {
+	if _, exists := v.Errors[field]; !exists {
+		v.Errors[field] = message
+	}
+}
+
+// Check records a problem with field if ok is false.
+func (v *Validator) Check(ok bool, field, message string) {
+	if !ok {
+		v.Add(field, message)
+	}
+}
+
+// Required checks that value is not blank.
+func (v *Validator) Required(field, value string) {
+	v.Check(strings.TrimSpace(value) != "", field, "must be provided")
+}
+
+// MaxLength checks that value is at most n characters long.
+func (v *Validator) MaxLength(field, value string, n int) {
+	v.Check(utf8.RuneCountInString(value) <= n, field, "must not be more than "+strconv.Itoa(n)+" characters long")
+}
+
+// Email checks that value is a plain email address, like jack@example.com.
+func (v *Validator) Email(field, value string) {
+	v.Check(IsEmail(value), field, "must be a valid email address")
+}
+
+// Password checks that value is long enough to be hard to guess, short enough for bcrypt,
+// and not made of just one kind of character.
+func (v *Validator) Password(field, value string) {
+	switch {
+	case utf8.RuneCountInString(value) < MinPasswordLength:
+		v.Add(field, "must be at least "+strconv.Itoa(MinPasswordLength)+" characters long")
+	case len(value) > MaxPasswordBytes:
+		v.Add(field, "must not be more than "+strconv.Itoa(MaxPasswordBytes)+" bytes long")
+	case !hasLetterAndNonLetter(value):
+		v.Add(field, "must contain a letter, and a number or symbol")
+	}
+}
+
+// UniqueEmail checks that no user other than the one with id exceptID has email. lookup
+// finds a user by email; repository.DatabaseRepo's GetUserByEmail fits. An error from
+// lookup, other than the user not being found, is returned.
+func (v *Validator) UniqueEmail(ctx context.Context, field, email string, exceptID int, lookup func(context.Context, string) (*data.User, error)) error {
+	user, err := lookup(ctx, email)
+	if errors.Is(err, repository.ErrNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	v.Check(user.ID == exceptID, field, "is already in use")
+	return nil
+}
+
+// User checks the fields every user must have.
+func (v *Validator) User(u *data.User) {
+	v.Required("first_name", u.FirstName)
+	v.MaxLength("first_name", u.FirstName, MaxFieldLength)
+	v.Required("last_name", u.LastName)
+	v.MaxLength("last_name", u.LastName, MaxFieldLength)
+	v.Required("email", u.Email)
+	v.MaxLength("email", u.Email, MaxFieldLength)
+	v.Email("email", u.Email)
+	v.Check(PermittedValue(u.IsAdmin, 0, 1), "is_admin", "must be 0 or 1")
+}
+
+// IsEmail returns true if s is a plain email address: no display name, no angle brackets.
+func IsEmail(s string) bool {
+	address, err := mail.ParseAddress(s)
+	if err != nil || address.Address != s {
+		return false
+	}
+
+	// ParseAddress is happy with a domain like localhost, which no user of ours will have
+	domain := s[strings.LastIndex(s, "@")+1:]
+	return strings.Contains(domain, ".")
+}
+
+// PermittedValue returns true if value is one of permitted.
+func PermittedValue[T comparable](value T, permitted ...T) bool {
+	for _, p := range permitted {
+		if value == p {
+			return true
+		}
+	}
+	return false
+}
+
+func hasLetterAndNonLetter(s string) bool {
+	var letter, other bool
+	for _, r := range s {
+		if unicode.IsLetter(r) {
+			letter = true
+		} else {
+			other = true
+		}
+	}
+	return letter && other
+}
@@ -0,0 +1,123 @@
+package validator
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"testingCourserWeb/pkg/data"
+	"testingCourserWeb/pkg/repository"
+)
+
+func TestIsEmail(t *testing.T) {
+	var tests = []struct {
+		email    string
+		expected bool
+	}{
+		{"jack@example.com", true},
+		{"jack.smith+test@mail.example.co.uk", true},
+		{"", false},
+		{"jack", false},
+		{"jack@", false},
+		{"jack@localhost", false},
+		{"Jack <jack@example.com>", false},
+		{"<jack@example.com>", false},
+		{" jack@example.com", false},
+	}
+
+	for _, e := range tests {
+		if got := IsEmail(e.email); got != e.expected {
+			t.Errorf("%q: expected %t, but got %t", e.email, e.expected, got)
+		}
+	}
+}
+
+func TestValidator_Password(t *testing.T) {
+	var tests = []struct {
+		name     string
+		password string
+		valid    bool
+	}{
+		{"good", "correct horse 1", true},
+		{"too short", "abc123", false},
+		{"too long", strings.Repeat("a1", 37), false},
+		{"letters only", "passwordpassword", false},
+		{"numbers only", "1234567890", false},
+	}
+
+	for _, e := range tests {
+		v := New()
+		v.Password("password", e.password)
+		if v.Valid() != e.valid {
+			t.Errorf("%s: expected valid to be %t, but got errors %v", e.name, e.valid, v.Errors)
+		}
+	}
+}
+
+func TestValidator_User(t *testing.T) {
+	v := New()
+	v.User(&data.User{FirstName: "Jack", LastName: "Smith", Email: "jack@example.com", IsAdmin: 1})
+	if !v.Valid() {
+		t.Errorf("expected user to be valid, but got %v", v.Errors)
+	}
+
+	v = New()
+	v.User(&data.User{FirstName: " ", LastName: strings.Repeat("x", MaxFieldLength+1), Email: "jack", IsAdmin: 7})
+	for _, field := range []string{"first_name", "last_name", "email", "is_admin"} {
+		if v.Errors[field] == "" {
+			t.Errorf("expected an error for %s, but got %v", field, v.Errors)
+		}
+	}
+
+	// only the first problem with a field is kept
+	v = New()
+	v.User(&data.User{FirstName: "Jack", LastName: "Smith"})
+	if v.Errors["email"] != "must be provided" {
+		t.Errorf("expected the first email error to be kept, but got %q", v.Errors["email"])
+	}
+}
+
+func TestValidator_UniqueEmail(t *testing.T) {
+	lookup := func(ctx context.Context, email string) (*data.User, error) {
+		switch email {
+		case "jack@example.com":
+			return &data.User{ID: 1, Email: email}, nil
+		case "broken@example.com":
+			return nil, errors.New("connection refused")
+		}
+		return nil, repository.ErrNotFound
+	}
+
+	var tests = []struct {
+		name          string
+		email         string
+		exceptID      int
+		valid         bool
+		errorExpected bool
+	}{
+		{"unused", "jill@example.com", 0, true, false},
+		{"used by someone else", "jack@example.com", 0, false, false},
+		{"used by the same user", "jack@example.com", 1, true, false},
+		{"lookup fails", "broken@example.com", 0, true, true},
+	}
+
+	for _, e := range tests {
+		v := New()
+		err := v.UniqueEmail(context.Background(), "email", e.email, e.exceptID, lookup)
+		if (err != nil) != e.errorExpected {
+			t.Errorf("%s: unexpected error %v", e.name, err)
+		}
+		if v.Valid() != e.valid {
+			t.Errorf("%s: expected valid to be %t, but got errors %v", e.name, e.valid, v.Errors)
+		}
+	}
+}
+
+func TestPermittedValue(t *testing.T) {
+	if !PermittedValue("asc", "asc", "desc") {
+		t.Error("asc should be permitted")
+	}
+	if PermittedValue(2, 0, 1) {
+		t.Error("2 should not be permitted")
+	}
+}
@@ -0,0 +1,14 @@
+-- Development data: the admin user (admin@example.com, password "secret") we log in to the web app
+-- and api with. Load it into a migrated Postgres or SQLite database, e.g.
+-- psql "host=localhost port=5433 user=postgres password=postgres dbname=users" -f sql/seed.sql
+-- sqlite3 users.db < sql/seed.sql
+
+INSERT INTO users (first_name, last_name, email, password, is_admin, created_at, updated_at)
+VALUES ('Admin', 'User', 'admin@example.com', '$2a$14$ajq8Q7fbtFRQvXpdCq7Jcuy.Rx1h/L4J60Otx.gyNLbAYctGMJ9tK', 1, '2022-08-19 00:00:00', '2022-08-19 00:00:00')
+ON CONFLICT (email) DO NOTHING;
+
+INSERT INTO user_roles (user_id, role_id, created_at)
+SELECT u.id, r.id, '2022-08-19 00:00:00'
+FROM users u, roles r
+WHERE u.email = 'admin@example.com' AND r.name = 'admin'
+ON CONFLICT DO NOTHING;